@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Laura470/bookings/internal/driver"
+	"github.com/Laura470/bookings/internal/mailer"
+)
+
+//health answers the probes of the load balancer: healthz if the process is alive,
+//readyz if it can serve the requests
+type health struct {
+	db       *driver.DB
+	inMemory bool
+	mailer   mailer.Mailer
+	timeout  time.Duration
+	//mailEvery is how long the result of the check of the mail server is kept
+	mailEvery time.Duration
+	draining  int32
+
+	mu          sync.Mutex
+	mailChecked time.Time
+	mailErr     error
+}
+
+var errNoMailer = errors.New("no mailer")
+
+//probes is set up by run, once the database and the mailer are ready
+var probes = &health{timeout: 2 * time.Second, mailEvery: time.Minute}
+
+//Healthz answers ok as long as the process is running
+func (h *health) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+//Readyz checks the database and answers 503 when it's down or the server is shutting down.
+//When the mail server is down the site is still ready, the mails wait in the outbox, so it
+//answers 200 with the status degraded
+func (h *health) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	checks := map[string]string{}
+	ready := true
+	check := func(name string, err error) {
+		checks[name] = "ok"
+		if err != nil {
+			checks[name] = err.Error()
+			ready = false
+		}
+	}
+
+	//durante lo spegnimento il load balancer deve smettere di mandarci richieste
+	if atomic.LoadInt32(&h.draining) == 1 {
+		checks["server"] = "shutting down"
+		ready = false
+	}
+	if h.inMemory {
+		checks["database"] = "in memory"
+	} else {
+		check("database", h.db.Ping(ctx))
+	}
+	degraded := false
+	checks["mail"] = "ok"
+	if err := h.checkMail(ctx); err != nil {
+		checks["mail"] = err.Error()
+		degraded = true
+	}
+
+	status := http.StatusOK
+	out := map[string]interface{}{"status": "ok", "checks": checks}
+	if degraded {
+		out["status"] = "degraded"
+	}
+	if !ready {
+		status = http.StatusServiceUnavailable
+		out["status"] = "unavailable"
+		app.Logger.Ctx(r.Context()).Warn("not ready", "checks", checks)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(out)
+}
+
+//checkMail checks the mail server at most once every mailEvery, every check opens a connection
+func (h *health) checkMail(ctx context.Context) error {
+	if h.mailer == nil {
+		return errNoMailer
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.mailChecked.IsZero() && time.Since(h.mailChecked) < h.mailEvery {
+		return h.mailErr
+	}
+	h.mailErr = h.mailer.Check(ctx)
+	h.mailChecked = time.Now()
+	if h.mailErr != nil {
+		app.Logger.Ctx(ctx).Warn("mail server down, the mails wait in the outbox", "error", h.mailErr)
+	}
+	return h.mailErr
+}
+
+//drain makes readyz fail, so no new requests arrive while the server shuts down
+func (h *health) drain() {
+	atomic.StoreInt32(&h.draining, 1)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	sqldriver "database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Laura470/bookings/internal/driver"
+	"github.com/Laura470/bookings/internal/logger"
+	"github.com/Laura470/bookings/internal/mailer"
+)
+
+//fakeDriver is a database that only answers the ping
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (sqldriver.Conn, error) {
+	return fakeConn{}, nil
+}
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (sqldriver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (fakeConn) Close() error {
+	return nil
+}
+
+func (fakeConn) Begin() (sqldriver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+func init() {
+	sql.Register("fake", fakeDriver{})
+}
+
+func TestHealthz(t *testing.T) {
+	rr := httptest.NewRecorder()
+	(&health{}).Healthz(rr, httptest.NewRequest("GET", "/healthz", nil))
+
+	if rr.Code != http.StatusOK || rr.Body.String() != "ok\n" {
+		t.Errorf("expected 200 ok but got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	app.Logger = logger.Discard()
+	defer func() { app.Logger = nil }()
+
+	db, err := sql.Open("fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name               string
+		db                 *driver.DB
+		mailer             mailer.Mailer
+		draining           bool
+		expectedStatusCode int
+		expectedInBody     string
+	}{
+		{"ready", &driver.DB{SQL: db}, &mailer.Memory{}, false, http.StatusOK, `"database":"ok"`},
+		{"no database", nil, &mailer.Memory{}, false, http.StatusServiceUnavailable, `"database":"not connected to the database"`},
+		//senza il server di posta le mail aspettano nella coda, il sito è pronto lo stesso
+		{"mail server down", &driver.DB{SQL: db}, &mailer.Memory{Err: errors.New("connection refused")}, false, http.StatusOK, `"mail":"connection refused"`},
+		{"no mailer", &driver.DB{SQL: db}, nil, false, http.StatusOK, `"status":"degraded"`},
+		{"shutting down", &driver.DB{SQL: db}, &mailer.Memory{}, true, http.StatusServiceUnavailable, `"server":"shutting down"`},
+	}
+
+	for _, e := range tests {
+		h := &health{db: e.db, mailer: e.mailer, timeout: time.Second}
+		if e.draining {
+			h.drain()
+		}
+
+		rr := httptest.NewRecorder()
+		h.Readyz(rr, httptest.NewRequest("GET", "/readyz", nil))
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), e.expectedInBody) {
+			t.Errorf("%s: expected %s in the body but got %s", e.name, e.expectedInBody, rr.Body.String())
+		}
+	}
+
+	//il server di posta si controlla al massimo una volta ogni mailEvery
+	m := &mailer.Memory{}
+	h := &health{db: &driver.DB{SQL: db}, mailer: m, timeout: time.Second, mailEvery: time.Hour}
+	readyz := func() string {
+		rr := httptest.NewRecorder()
+		h.Readyz(rr, httptest.NewRequest("GET", "/readyz", nil))
+		return rr.Body.String()
+	}
+	if body := readyz(); !strings.Contains(body, `"status":"ok"`) {
+		t.Errorf("expected ok but got %s", body)
+	}
+	m.Err = errors.New("connection refused")
+	if body := readyz(); !strings.Contains(body, `"mail":"ok"`) {
+		t.Errorf("expected the check of the mail server to be kept but got %s", body)
+	}
+	h.mailEvery = 0
+	if body := readyz(); !strings.Contains(body, `"status":"degraded"`) {
+		t.Errorf("expected degraded after a new check but got %s", body)
+	}
+
+	//con -db=memory non c'è un database da controllare
+	h = &health{inMemory: true, mailer: &mailer.Memory{}, timeout: time.Second}
+	rr := httptest.NewRecorder()
+	h.Readyz(rr, httptest.NewRequest("GET", "/readyz", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"database":"in memory"`) {
+		t.Errorf("in memory: expected ready but got %d %s", rr.Code, rr.Body.String())
+	}
+}
@@ -1,39 +1,54 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"time"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/Laura470/bookings/internal/config"
 	"github.com/Laura470/bookings/internal/driver"
 	"github.com/Laura470/bookings/internal/handlers"
 	"github.com/Laura470/bookings/internal/helpers"
+	"github.com/Laura470/bookings/internal/logger"
+	"github.com/Laura470/bookings/internal/metrics"
 	"github.com/Laura470/bookings/internal/models"
 	"github.com/Laura470/bookings/internal/render"
+	"github.com/Laura470/bookings/internal/sessionstore"
+	"github.com/Laura470/bookings/internal/tokens"
 	"github.com/alexedwards/scs/v2"
 )
 
-const portNumber = ":8080"
-
 var app config.AppConfig
 var session *scs.SessionManager
 
-var infoLog *log.Logger
-var errorLog *log.Logger
-
 // main is the main application function
 func main() {
 
-	db, err := run()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil && !stopped(err) {
+			fatal(err)
+		}
+		return
+	}
+
+	db, err := run(os.Args)
+	if stopped(err) {
+		return
+	}
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+	if db != nil {
+		defer db.SQL.Close()
 	}
-	defer db.SQL.Close()
 
 	/*
 		//setting an email to be send whne the app startswith standar library
@@ -44,22 +59,89 @@ func main() {
 			log.Println(err)
 		}*/
 
-	defer close(app.MailChan)
-	fmt.Println("Starting mail listener...")
-	listenForMail()
+	//a ogni deploy arriva un SIGTERM: prima di uscire finisco le richieste e mando le mail in coda
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	fmt.Printf("Starting application on port %s\n", portNumber)
+	app.Logger.Info("starting mail worker", "workers", app.MailWorkers)
+	drainMail := listenForMail(ctx)
+
+	if app.ICalSyncInterval > 0 {
+		app.Logger.Info("starting calendar sync", "interval", app.ICalSyncInterval)
+		syncCalendars(ctx, app.ICalSyncInterval)
+	}
+
+	if app.ReminderBefore > 0 {
+		app.Logger.Info("starting reminders", "before", app.ReminderBefore)
+		sendReminders(ctx, app.ReminderBefore)
+	}
+
+	app.Logger.Info("starting application", "port", app.Settings.Port)
 
 	srv := &http.Server{
-		Addr:    portNumber,
-		Handler: routes(&app),
+		Addr:     fmt.Sprintf(":%d", app.Settings.Port),
+		Handler:  routes(&app),
+		ErrorLog: app.Logger.StdLogger(logger.LevelError),
 	}
-	err = srv.ListenAndServe()
-	log.Fatal(err)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- srv.ListenAndServe()
+	}()
 
+	select {
+	case err := <-serverErr:
+		fatal(err)
+	case <-ctx.Done():
+	}
+	//un secondo segnale chiude subito
+	stop()
+	shutdown(srv, drainMail)
 }
 
-func run() (*driver.DB, error) {
+//shutdown stops taking requests, waits for the ones being served and sends the mails due,
+//for at most app.ShutdownTimeout
+func shutdown(srv *http.Server, drainMail func(context.Context) error) {
+	app.Logger.Info("shutting down", "timeout", app.ShutdownTimeout)
+	probes.drain()
+
+	ctx, cancel := context.WithTimeout(context.Background(), app.ShutdownTimeout)
+	defer cancel()
+
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		app.Logger.Error("can't finish the requests being served", "error", err)
+	}
+
+	err = drainMail(ctx)
+	if err != nil {
+		app.Logger.Error("can't send the queued mails", "error", err)
+	}
+
+	if store, ok := session.Store.(*sessionstore.DBStore); ok {
+		store.StopCleanup()
+	}
+	app.Logger.Info("stopped")
+}
+
+//fatal logs err and stops the application, with the standard log if the logger isn't ready yet
+func fatal(err error) {
+	if app.Logger == nil {
+		log.Fatal(err)
+	}
+	app.Logger.Error(err.Error())
+	os.Exit(1)
+}
+
+//errPrinted is returned by loadSettings after writing the settings with -print-config
+var errPrinted = errors.New("settings printed")
+
+//stopped tells if err only means that the usage or the settings have been written and there is nothing else to do
+func stopped(err error) bool {
+	return errors.Is(err, flag.ErrHelp) || errors.Is(err, errPrinted)
+}
+
+//run sets up the site with the command line args, args[0] is the name of the program
+func run(args []string) (*driver.DB, error) {
 
 	//what i'm going to put in the session
 	gob.Register(models.Reservation{})
@@ -68,39 +150,59 @@ func run() (*driver.DB, error) {
 	gob.Register(models.Restriction{})
 	gob.Register(map[string]int{})
 
-	//read flags
-	inProduction := flag.Bool("production", true, "Application is in production")
-	useCache := flag.Bool("cache", true, "Use template cache")
-	dbName := flag.String("dbname", "", "Database name")
-	dbHost := flag.String("dbhost", "localhost", "Database host")
-	dbUser := flag.String("dbuser", "", "Database user")
-	dbPass := flag.String("dbpass", "", "Database password")
-	dbPort := flag.String("dbport", "5432", "Database port")
-	dbSSL := flag.String("dbssl", "disable", "Database ssl settings (disable, prefer, require")
-
-	//per potere usare le flag
-	flag.Parse()
-	if *dbName == "" || *dbUser == "" || *dbPass == "" {
-		fmt.Println("Missing required flags")
-		os.Exit(1)
+	settings, err := loadSettings(args[0], args[1:])
+	if err != nil {
+		return nil, err
 	}
 
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-
 	//change this to true when in production
 	//in here so it is available outside the main for the main package (middleware is in the main package)
-	app.InProduction = *inProduction
-	app.UseCache = *useCache
+	app.InProduction = settings.Production
+	app.UseCache = settings.UseCache
+	app.DBTimeout = settings.DB.Timeout
+	app.BaseURL = strings.TrimSuffix(settings.BaseURL, "/")
+	app.ICalSyncInterval = settings.Jobs.ICalSync
+	app.ReminderBefore = settings.Jobs.RemindBefore
+	app.MailWorkers = settings.Mail.Workers
+	app.MailMaxAttempts = settings.Mail.MaxAttempts
+	app.ShutdownTimeout = settings.ShutdownTimeout
+	app.MetricsToken = settings.MetricsToken
+	app.Metrics = metrics.NewSite()
 
-	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
+	app.TrustedProxies, err = config.ParseTrustedProxies(settings.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
 
-	errorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errorLog
+	if settings.Require2FA != "" {
+		role, err := models.ParseRole(settings.Require2FA)
+		if err != nil {
+			return nil, err
+		}
+		app.Require2FA = role
+	}
+
+	m, err := newMailer(settings.Mail)
+	if err != nil {
+		return nil, err
+	}
+	app.Mailer = m
+
+	//senza una chiave fissa i link mandati per email non funzionano più dopo un riavvio,
+	//in produzione Validate la chiede, qui senza chiave si arriva solo in sviluppo o in memoria
+	secretKey := []byte(settings.Secret)
+	if len(secretKey) == 0 {
+		app.Logger.Warn("no secret key given, using a random one: links sent by email will stop working after a restart")
+		key, err := tokens.RandomKey(32)
+		if err != nil {
+			return nil, err
+		}
+		secretKey = key
+	}
+	app.Signer = tokens.NewSigner(secretKey)
 
 	session = scs.New() //tolto il due punti
-	session.Lifetime = 24 * time.Hour
+	session.Lifetime = settings.Session.Lifetime
 	session.Cookie.Persist = true //anche dopo il browser è chiuso
 	// abbiamo fatto la stessa cosa in middleware con NoSurf package
 	session.Cookie.SameSite = http.SameSiteLaxMode //  quanto tight ??
@@ -109,32 +211,164 @@ func run() (*driver.DB, error) {
 	//inizializzo la session in config
 	app.Session = session
 
-	//inizializzo il db
-	// connect to database
-	log.Println("Connecting to database...")
-	connectionString := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s", *dbHost, *dbPort, *dbName, *dbUser, *dbPass, *dbSSL)
-	db, err := driver.ConnectSQL(connectionString)
-	if err != nil {
-		log.Fatal("Cannot connect to database! Dying...")
-	}
-
-	log.Println("Connected to database!")
-
 	//chiamo la funzione CreateTemplateCache dal package render
 	tc, err := render.CreateTemplateCache()
 	if err != nil {
 		//log.Fatal("cannot create template cache")
-		log.Fatal(err)
 		return nil, err
 	}
 
 	//prende il suo valore da render, fare attenzione all'import
 	app.TemplateCache = tc
 
-	repo := handlers.NewRepo(&app, db)
+	mtc, err := render.CreateMailTemplateCache()
+	if err != nil {
+		return nil, err
+	}
+	app.MailTemplateCache = mtc
+
+	var db *driver.DB
+	var repo *handlers.Repository
+	if settings.DB.Kind == "memory" {
+		repo, err = memoryRepo(settings)
+		if err != nil {
+			return nil, err
+		}
+		probes.inMemory = true
+	} else {
+		//inizializzo il db
+		// connect to database
+		db, err = connectDB(settings.DB)
+		if err != nil {
+			return nil, err
+		}
+
+		//con più istanze che partono insieme il lock fa applicare le migration a una sola
+		if settings.DB.AutoMigrate {
+			if _, err := migrateUp(context.Background(), db); err != nil {
+				return nil, err
+			}
+		}
+
+		//in memoria a ogni riavvio gli admin devono rifare il login e le prenotazioni in corso si perdono
+		if settings.Session.Store == "database" {
+			session.Store = sessionstore.New(db.SQL, app.DBTimeout, settings.Session.Cleanup, app.Logger.With("job", "session-cleanup"))
+		}
+
+		repo = handlers.NewRepo(&app, db)
+		app.Metrics.AddDBStats(db.SQL.Stats)
+		probes.db = db
+	}
 	handlers.NewHandlers(repo)
+	probes.mailer = app.Mailer
+	app.Metrics.AddMailQueue(mailQueueDepth)
 	render.NewRenderer(&app)
 	helpers.NewHelpers(&app)
 
 	return db, nil
 }
+
+//memoryRepo returns the repository in memory, with an owner to log in with a random password
+func memoryRepo(settings config.Settings) (*handlers.Repository, error) {
+	app.Logger.Warn("the data is kept in memory, it will be lost when the site stops")
+	if settings.Session.Store == "database" {
+		app.Logger.Info("sessions kept in memory, there is no database")
+	}
+
+	repo := handlers.NewMemoryRepo(&app)
+	ctx := context.Background()
+	id, err := repo.DB.InsertUser(ctx, models.User{
+		FirstName:   "Admin",
+		LastName:    "User",
+		Email:       "admin@example.com",
+		AccessLevel: int(models.RoleOwner),
+		Active:      1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	password, err := tokens.Generate()
+	if err != nil {
+		return nil, err
+	}
+	if err := repo.DB.UpdatePassword(ctx, id, password); err != nil {
+		return nil, err
+	}
+	app.Logger.Warn("log in to the admin with this user", "email", "admin@example.com", "password", password)
+	return repo, nil
+}
+
+//loadSettings reads and validates the settings and creates the logger. With -print-config
+//it writes the settings and returns errPrinted, like flag.ErrHelp after the usage with -h
+func loadSettings(name string, args []string) (config.Settings, error) {
+	//leggo la configurazione: file, poi variabili d'ambiente, poi flag
+	settings, opts, err := config.LoadSettings(name, args, os.LookupEnv)
+	if err != nil {
+		return settings, err
+	}
+	if opts.PrintConfig {
+		if err := config.PrintSettings(os.Stdout, settings); err != nil {
+			return settings, err
+		}
+		return settings, errPrinted
+	}
+	if err := settings.Validate(); err != nil {
+		return settings, err
+	}
+	app.Settings = settings
+
+	l, err := newLogger(settings.Log.Format, settings.Log.Level, settings.Production)
+	if err != nil {
+		return settings, err
+	}
+	app.Logger = l
+	if opts.File != "" {
+		app.Logger.Info("configuration read", "file", opts.File)
+	}
+	return settings, nil
+}
+
+//connectDB opens the pool of connections to the database of s
+func connectDB(s config.DBSettings) (*driver.DB, error) {
+	pool := driver.Pool{
+		MaxOpenConns: s.MaxOpenConns,
+		MaxIdleConns: s.MaxIdleConns,
+		MaxLifetime:  s.MaxLifetime,
+	}
+
+	var db *driver.DB
+	var err error
+	if s.Driver == driver.SQLite {
+		app.Logger.Info("opening sqlite database", "file", s.Name)
+		db, err = driver.ConnectSQL(driver.SQLite, driver.SQLiteDSN(s.Name), pool)
+	} else {
+		app.Logger.Info("connecting to database", "host", s.Host, "port", s.Port, "dbname", s.Name)
+		connectionString := fmt.Sprintf("host=%s port=%d dbname=%s user=%s password=%s sslmode=%s", s.Host, s.Port, s.Name, s.User, s.Password, s.SSLMode)
+		db, err = driver.ConnectSQL(driver.Postgres, connectionString, pool)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to database: %w", err)
+	}
+
+	app.Logger.Info("connected to database")
+	return db, nil
+}
+
+//newLogger returns the logger chosen in the settings, without a format it's json in production and text in development
+func newLogger(format, level string, inProduction bool) (*logger.Logger, error) {
+	if format == "" {
+		format = "text"
+		if inProduction {
+			format = "json"
+		}
+	}
+	f, err := logger.ParseFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	l, err := logger.ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	return logger.New(os.Stdout, f, l), nil
+}
@@ -1,10 +1,22 @@
 package main
 
-import "testing"
+import (
+	"errors"
+	"flag"
+	"testing"
+)
 
 func TestRun(t *testing.T) {
-	_, err := run()
+	//in memoria non serve un database
+	_, err := run([]string{"bookings", "-db=memory", "-production=false", "-sessionstore=memory", "-mailer=file", "-maildir=" + t.TempDir()})
 	if err != nil {
-		t.Errorf("failed run()")
+		t.Errorf("failed run(): %s", err)
+	}
+
+	if _, err := run([]string{"bookings", "-h"}); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("expected flag.ErrHelp for -h but got %v", err)
+	}
+	if _, err := run([]string{"bookings", "-db=memory", "-print-config"}); !errors.Is(err, errPrinted) {
+		t.Errorf("expected errPrinted for -print-config but got %v", err)
 	}
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Laura470/bookings/internal/config"
+	"github.com/Laura470/bookings/internal/handlers"
+	"github.com/go-chi/chi"
+)
+
+//Metrics counts the requests and how long they take, by chi route pattern
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		//il pattern c'è solo dopo il routing, le pagine che non esistono finiscono tutte insieme
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		app.Metrics.ObserveRequest(r.Method, route, sw.statusCode(), time.Since(start))
+	})
+}
+
+//metricsHandler serves the metrics, when a token is set only to who sends it as bearer token
+func metricsHandler(app *config.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.Metrics == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		if app.MetricsToken != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(app.MetricsToken)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+		}
+
+		app.Metrics.Handler().ServeHTTP(w, r)
+	}
+}
+
+//mailQueueDepth reads the mails waiting in the outbox, NaN when the database doesn't answer
+func mailQueueDepth() float64 {
+	n, err := handlers.Repo.DB.CountQueuedMail(context.Background())
+	if err != nil {
+		app.Logger.Error("can't count the queued mails", "error", err)
+		return math.NaN()
+	}
+	return float64(n)
+}
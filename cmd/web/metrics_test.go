@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Laura470/bookings/internal/config"
+	"github.com/Laura470/bookings/internal/metrics"
+	"github.com/go-chi/chi"
+)
+
+func TestMetrics(t *testing.T) {
+	app.Metrics = metrics.NewSite()
+	defer func() { app.Metrics = nil }()
+
+	mux := chi.NewRouter()
+	mux.Use(Metrics)
+	mux.Get("/rooms/{slug}", func(w http.ResponseWriter, r *http.Request) {})
+	mux.Route("/admin", func(mux chi.Router) {
+		mux.Get("/rooms/{id}", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "no", http.StatusForbidden)
+		})
+	})
+
+	for _, path := range []string{"/rooms/generals-quarters", "/rooms/majors-suite", "/admin/rooms/1", "/nowhere"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	//le serie sono per pattern, non per indirizzo
+	tests := []struct {
+		route    string
+		status   string
+		expected float64
+	}{
+		{"/rooms/{slug}", "200", 2},
+		{"/admin/rooms/{id}", "403", 1},
+		{"unmatched", "404", 1},
+	}
+	for _, e := range tests {
+		if got := app.Metrics.Requests.Value("GET", e.route, e.status); got != e.expected {
+			t.Errorf("%s %s: expected %v requests but got %v", e.route, e.status, e.expected, got)
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	a := config.AppConfig{Metrics: metrics.NewSite(), MetricsToken: "secret"}
+
+	tests := []struct {
+		name               string
+		authorization      string
+		expectedStatusCode int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer wrong", http.StatusUnauthorized},
+		{"token", "Bearer secret", http.StatusOK},
+	}
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if e.authorization != "" {
+			req.Header.Set("Authorization", e.authorization)
+		}
+		rr := httptest.NewRecorder()
+		metricsHandler(&a).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if rr.Code == http.StatusOK && !strings.Contains(rr.Body.String(), "# TYPE bookings_http_requests_total counter") {
+			t.Errorf("%s: no metrics in the body: %s", e.name, rr.Body.String())
+		}
+	}
+
+	//senza metriche la pagina non c'è
+	rr := httptest.NewRecorder()
+	metricsHandler(&config.AppConfig{}).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without metrics but got %d", rr.Code)
+	}
+}
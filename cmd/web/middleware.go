@@ -2,11 +2,97 @@ package main
 
 import (
 	"net/http"
+	"regexp"
+	"runtime/debug"
+	"time"
 
 	"github.com/Laura470/bookings/internal/helpers"
+	"github.com/Laura470/bookings/internal/logger"
 	"github.com/justinas/nosurf"
 )
 
+//validRequestID is an id sent by a proxy in front of us that can go in the log as it is
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+//probePaths are the pages of the probes of the load balancer
+var probePaths = map[string]bool{"/healthz": true, "/readyz": true}
+
+//RequestLogger gives every request an id, in the context and in the X-Request-ID header,
+//and logs method, path, status, latency and user of the request when it's done
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		//se il proxy ha già dato un id alla richiesta uso il suo, così si trova in tutti e due i log
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			id = logger.NewRequestID()
+		}
+		ctx := logger.WithRequestID(r.Context(), id)
+		w.Header().Set("X-Request-ID", id)
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		//le sonde arrivano ogni pochi secondi, nel log servono solo quando falliscono
+		log := app.Logger.Ctx(ctx).Info
+		if probePaths[r.URL.Path] && sw.statusCode() < 400 {
+			log = app.Logger.Ctx(ctx).Debug
+		}
+		log("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.statusCode(),
+			"latency", time.Since(start),
+			"user_id", logger.UserID(ctx),
+		)
+	})
+}
+
+//statusWriter remembers the status written, for the log and the metrics
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+//statusCode returns the status written, 200 if the handler wrote nothing
+func (w *statusWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+//Recoverer logs a panic with the stack and the request id and answers with a 500
+func Recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				app.Logger.Ctx(r.Context()).Error("panic", "error", rec, "stack", string(debug.Stack()))
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
 //NoSurf adds CSRF protection to all Post request
 func NoSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
@@ -20,9 +106,12 @@ func NoSurf(next http.Handler) http.Handler {
 	return csrfHandler
 }
 
-//SessionLoad loads and saves the session on every request
+//SessionLoad loads and saves the session on every request, and tells the log who is the user
 func SessionLoad(next http.Handler) http.Handler {
-	return session.LoadAndSave(next)
+	return session.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.SetUserID(r.Context(), session.GetInt(r.Context(), "user_id"))
+		next.ServeHTTP(w, r)
+	}))
 }
 
 //qui devo essere in grado di accedere alla request
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/Laura470/bookings/internal/logger"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -31,3 +36,68 @@ func TestSessionLoad(t *testing.T) {
 
 	}
 }
+
+func TestRequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	app.Logger = logger.New(&buf, logger.Text, logger.LevelInfo)
+	defer func() { app.Logger = nil }()
+
+	var id string
+	h := RequestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id = logger.RequestID(r.Context())
+		logger.SetUserID(r.Context(), 3)
+		http.NotFound(w, r)
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/nowhere", nil))
+
+	if id == "" || rr.Header().Get("X-Request-ID") != id {
+		t.Errorf("expected the request id %q in the header but got %q", id, rr.Header().Get("X-Request-ID"))
+	}
+	for _, s := range []string{"INFO request", "request_id=" + id, "method=GET", "path=/nowhere", "status=404", "latency=", "user_id=3"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("expected %q in the log but got %q", s, buf.String())
+		}
+	}
+
+	//l'id del proxy resta, quello che non va bene no
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-ID", "proxy-42")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Header().Get("X-Request-ID") != "proxy-42" {
+		t.Errorf("expected the request id of the proxy but got %q", rr.Header().Get("X-Request-ID"))
+	}
+
+	req.Header.Set("X-Request-ID", "bad id\nwith a new line")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if strings.Contains(rr.Header().Get("X-Request-ID"), "bad") {
+		t.Errorf("the invalid request id of the proxy has been used")
+	}
+}
+
+func TestRecoverer(t *testing.T) {
+	var buf bytes.Buffer
+	app.Logger = logger.New(&buf, logger.Text, logger.LevelInfo)
+	defer func() { app.Logger = nil }()
+
+	h := RequestLogger(Recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("something broke")
+	})))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500 but got %d", rr.Code)
+	}
+	id := rr.Header().Get("X-Request-ID")
+	if !strings.Contains(buf.String(), "ERROR panic request_id="+id+` error="something broke"`) {
+		t.Errorf("the panic is not in the log: %q", buf.String())
+	}
+	if !strings.Contains(buf.String(), "status=500") {
+		t.Errorf("the request is not logged with status 500: %q", buf.String())
+	}
+}
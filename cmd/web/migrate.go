@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Laura470/bookings/internal/driver"
+	"github.com/Laura470/bookings/internal/migrate"
+	"github.com/Laura470/bookings/migrations"
+)
+
+const migrateUsage = `usage: bookings migrate up|down [steps]|status|create <name> [flags]
+
+  up       apply the migrations not applied yet
+  down     undo the last migration applied, or the last steps
+  status   list the migrations and when they have been applied
+  create   write the empty files of a new migration in migrations/postgres and migrations/sqlite
+
+The flags are the ones of the site, only the database settings are used`
+
+//runMigrate runs the subcommand "bookings migrate"
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	command, args := args[0], args[1:]
+
+	switch command {
+	case "create":
+		if len(args) != 1 {
+			return errors.New("usage: bookings migrate create <name>")
+		}
+		//i file nuovi vanno nei sorgenti, non nel binario, e in tutti e due i database con la stessa versione
+		now := time.Now()
+		for _, dir := range []string{migrations.Postgres, migrations.SQLite} {
+			paths, err := migrate.Create(filepath.Join("migrations", dir), args[0], now)
+			for _, p := range paths {
+				fmt.Println("created", p)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "up", "down", "status":
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", command, migrateUsage)
+	}
+
+	steps := 1
+	if command == "down" && len(args) > 0 {
+		if n, err := strconv.Atoi(args[0]); err == nil {
+			if n < 1 {
+				return errors.New("the steps to undo must be at least 1")
+			}
+			steps = n
+			args = args[1:]
+		}
+	}
+
+	settings, err := loadSettings(os.Args[0]+" migrate "+command, args)
+	if err != nil {
+		return err
+	}
+	db, err := connectDB(settings.DB)
+	if err != nil {
+		return err
+	}
+	defer db.SQL.Close()
+
+	ctx := context.Background()
+	switch command {
+	case "up":
+		_, err = migrateUp(ctx, db)
+		return err
+	case "down":
+		m, err := newMigrator(db)
+		if err != nil {
+			return err
+		}
+		n, err := m.Down(ctx, steps)
+		app.Logger.Info("migrations undone", "count", n)
+		return err
+	default:
+		m, err := newMigrator(db)
+		if err != nil {
+			return err
+		}
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		printStatus(statuses)
+		return nil
+	}
+}
+
+//newMigrator returns the migrator of db with the migrations of its database built in the binary
+func newMigrator(db *driver.DB) (*migrate.Migrator, error) {
+	dir := migrations.Postgres
+	if db.Driver == driver.SQLite {
+		dir = migrations.SQLite
+	}
+	all, err := migrate.Load(migrations.FS, dir)
+	if err != nil {
+		return nil, err
+	}
+	return migrate.New(db.SQL, db.Driver, all, app.Logger.With("job", "migrate")), nil
+}
+
+//migrateUp applies the migrations not applied yet to db
+func migrateUp(ctx context.Context, db *driver.DB) (int, error) {
+	m, err := newMigrator(db)
+	if err != nil {
+		return 0, err
+	}
+	n, err := m.Up(ctx)
+	if err != nil {
+		return n, fmt.Errorf("cannot migrate the database: %w", err)
+	}
+	app.Logger.Info("database migrated", "applied", n)
+	return n, nil
+}
+
+func printStatus(statuses []migrate.Status) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, s := range statuses {
+		applied := "pending"
+		switch {
+		case s.Unknown:
+			applied = s.AppliedAt.Format("2006-01-02 15:04:05") + " (not in this version of the site)"
+		case s.Applied:
+			applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	w.Flush()
+}
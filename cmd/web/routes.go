@@ -5,58 +5,168 @@ import (
 
 	"github.com/Laura470/bookings/internal/config"
 	"github.com/Laura470/bookings/internal/handlers"
+	"github.com/Laura470/bookings/internal/models"
 	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
 )
 
 func routes(app *config.AppConfig) http.Handler {
 
 	mux := chi.NewRouter()
-	mux.Use(middleware.Recoverer)
-	mux.Use(NoSurf)
-	mux.Use(SessionLoad)
-
-	mux.Get("/", handlers.Repo.Home)
-	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/generals-quarters", handlers.Repo.Generals)
-	mux.Get("/majors-suite", handlers.Repo.Majors)
-
-	mux.Get("/search-availibility", handlers.Repo.Availibility)
-	mux.Post("/search-availibility", handlers.Repo.PostAvailibility)
-	mux.Post("/search-availibility-json", handlers.Repo.AvailibilityJSON)
-	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
-	mux.Get("/book-room", handlers.Repo.BookRoom)
-
-	mux.Get("/contact", handlers.Repo.Contact)
-
-	mux.Get("/make-reservation", handlers.Repo.Reservation)
-	mux.Post("/make-reservation", handlers.Repo.PostReservation)
-	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
-
-	mux.Get("/user/login", handlers.Repo.ShowLogin)
-	mux.Post("/user/login", handlers.Repo.PostShowLogin)
-	mux.Get("/user/logout", handlers.Repo.Logout)
-
-	//per potere visualizzare i file statici nelle mie pagine html
-	fileServer := http.FileServer(http.Dir("./static/"))
-	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
-
-	mux.Route("/admin", func(mux chi.Router) {
-		//da decommentare in produzione
-		mux.Use(Auth)
-		mux.Get("/dashboard", handlers.Repo.AdminDashBoard)
-		mux.Get("/all-reservations", handlers.Repo.AdminAllReservations)
-		mux.Get("/new-reservations", handlers.Repo.AdminNewReservations)
-		mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
-		mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
-		mux.Get("/process-reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
-		mux.Get("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
-
-		//scrivo il path creato con la pagina???? il path è deiverso dal nome del mio template
-		//questa cosa mi genera confusione!!!
-		mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
-		mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+	mux.Use(RequestLogger)
+	mux.Use(Metrics)
+	mux.Use(Recoverer)
 
+	mux.Get("/metrics", metricsHandler(app))
+	mux.Get("/healthz", probes.Healthz)
+	mux.Get("/readyz", probes.Readyz)
+
+	//le api usano i token e non la sessione, quindi niente csrf
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.Use(handlers.Repo.APIAuth)
+		mux.NotFound(handlers.Repo.APINotFound)
+		mux.MethodNotAllowed(handlers.Repo.APIMethodNotAllowed)
+
+		mux.Get("/rooms", handlers.Repo.APIRooms)
+		mux.Get("/rooms/{id}", handlers.Repo.APIRoom)
+		mux.Get("/availability", handlers.Repo.APIAvailability)
+		mux.Post("/reservations", handlers.Repo.APIPostReservation)
+		mux.Get("/reservations/{ref}", handlers.Repo.APIReservation)
+		mux.Delete("/reservations/{ref}", handlers.Repo.APICancelReservation)
+
+		mux.Route("/admin", func(mux chi.Router) {
+			mux.Use(handlers.Repo.RequireAPIScope(models.ScopeAdmin))
+			mux.Get("/reservations", handlers.Repo.APIAdminReservations)
+			mux.Get("/reservations/{id}", handlers.Repo.APIAdminReservation)
+			mux.Post("/reservations/{id}/processed", handlers.Repo.APIAdminProcessReservation)
+			mux.Delete("/reservations/{id}", handlers.Repo.APIAdminDeleteReservation)
+			mux.Get("/blocks", handlers.Repo.APIAdminBlocks)
+			mux.Post("/blocks", handlers.Repo.APIAdminPostBlock)
+			mux.Delete("/blocks/{id}", handlers.Repo.APIAdminDeleteBlock)
+		})
+	})
+
+	mux.Group(func(mux chi.Router) {
+		mux.Use(NoSurf)
+		mux.Use(SessionLoad)
+
+		mux.Get("/", handlers.Repo.Home)
+		mux.Get("/about", handlers.Repo.About)
+		mux.Get("/rooms", handlers.Repo.Rooms)
+		mux.Get("/rooms/{slug}", handlers.Repo.Room)
+		mux.Get("/rooms/{id}/calendar.ics", handlers.Repo.RoomCalendar)
+		//i vecchi indirizzi delle stanze, per i link che ci sono già in giro
+		mux.Handle("/generals-quarters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+		mux.Handle("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
+
+		mux.Get("/search-availibility", handlers.Repo.Availibility)
+		mux.Post("/search-availibility", handlers.Repo.PostAvailibility)
+		mux.Post("/search-availibility-json", handlers.Repo.AvailibilityJSON)
+		mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
+		mux.Get("/book-room", handlers.Repo.BookRoom)
+
+		mux.Get("/contact", handlers.Repo.Contact)
+
+		mux.Get("/make-reservation", handlers.Repo.Reservation)
+		mux.Post("/make-reservation", handlers.Repo.PostReservation)
+		mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
+
+		mux.Get("/my-reservation/{ref}", handlers.Repo.ManageReservation)
+		mux.Post("/my-reservation/{ref}/change", handlers.Repo.PostChangeReservation)
+		mux.Post("/my-reservation/{ref}/cancel", handlers.Repo.PostCancelReservation)
+
+		mux.Get("/user/login", handlers.Repo.ShowLogin)
+		mux.Post("/user/login", handlers.Repo.PostShowLogin)
+		mux.Get("/user/login/2fa", handlers.Repo.TwoFactor)
+		mux.Post("/user/login/2fa", handlers.Repo.PostTwoFactor)
+		mux.Get("/user/logout", handlers.Repo.Logout)
+		mux.Get("/user/invitation/{token}", handlers.Repo.Invitation)
+		mux.Post("/user/invitation/{token}", handlers.Repo.PostInvitation)
+		mux.Get("/user/forgot-password", handlers.Repo.ForgotPassword)
+		mux.Post("/user/forgot-password", handlers.Repo.PostForgotPassword)
+		mux.Get("/user/reset-password/{token}", handlers.Repo.ResetPassword)
+		mux.Post("/user/reset-password/{token}", handlers.Repo.PostResetPassword)
+
+		//per potere visualizzare i file statici nelle mie pagine html
+		fileServer := http.FileServer(http.Dir("./static/"))
+		mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
+
+		mux.Route("/admin", func(mux chi.Router) {
+			//da decommentare in produzione
+			mux.Use(Auth)
+			mux.Use(handlers.Repo.RequireRole(models.RoleViewer))
+
+			//il profilo resta aperto, è lì che si attiva la verifica in due passaggi
+			mux.Get("/profile", handlers.Repo.AdminProfile)
+			mux.Get("/profile/2fa", handlers.Repo.AdminTwoFactor)
+			mux.Post("/profile/2fa", handlers.Repo.AdminPostTwoFactor)
+			mux.Post("/profile/2fa/disable", handlers.Repo.AdminPostDisableTwoFactor)
+
+			mux.Group(func(mux chi.Router) {
+				mux.Use(handlers.Repo.RequireTwoFactor)
+				mux.Get("/dashboard", handlers.Repo.AdminDashBoard)
+				mux.Get("/all-reservations", handlers.Repo.AdminAllReservations)
+				mux.Get("/new-reservations", handlers.Repo.AdminNewReservations)
+				mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
+
+				//scrivo il path creato con la pagina???? il path è deiverso dal nome del mio template
+				//questa cosa mi genera confusione!!!
+				mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
+
+				//la reception lavora sulle prenotazioni ma non le cancella
+				mux.Group(func(mux chi.Router) {
+					mux.Use(handlers.Repo.RequireRole(models.RoleFrontDesk))
+					mux.Get("/process-reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
+					mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+				})
+
+				mux.Group(func(mux chi.Router) {
+					mux.Use(handlers.Repo.RequireRole(models.RoleManager))
+					mux.Get("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
+					mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
+					mux.Get("/blocks", handlers.Repo.AdminBlocks)
+					mux.Post("/blocks", handlers.Repo.AdminPostBlock)
+					mux.Get("/blocks/{id}/delete/do", handlers.Repo.AdminDeleteBlock)
+
+					mux.Get("/rooms", handlers.Repo.AdminRooms)
+					mux.Get("/rooms/new", handlers.Repo.AdminNewRoom)
+					mux.Post("/rooms/new", handlers.Repo.AdminPostNewRoom)
+					mux.Get("/rooms/{id}", handlers.Repo.AdminShowRoom)
+					mux.Post("/rooms/{id}", handlers.Repo.AdminPostShowRoom)
+					mux.Get("/rooms/{id}/activate/do", handlers.Repo.AdminActivateRoom)
+					mux.Get("/rooms/{id}/deactivate/do", handlers.Repo.AdminDeactivateRoom)
+					mux.Get("/rooms/{id}/delete/do", handlers.Repo.AdminDeleteRoom)
+					mux.Get("/rooms/{id}/rates", handlers.Repo.AdminRoomRates)
+					mux.Post("/rooms/{id}/rates", handlers.Repo.AdminPostRoomRate)
+					mux.Get("/rooms/{id}/rates/{rate}/delete/do", handlers.Repo.AdminDeleteRoomRate)
+					mux.Get("/rooms/{id}/calendars", handlers.Repo.AdminRoomCalendars)
+					mux.Post("/rooms/{id}/calendars", handlers.Repo.AdminPostRoomCalendar)
+					mux.Get("/rooms/{id}/calendars/sync/do", handlers.Repo.AdminSyncRoomCalendars)
+					mux.Get("/rooms/{id}/calendars/{feed}/delete/do", handlers.Repo.AdminDeleteRoomCalendar)
+
+					mux.Get("/mail", handlers.Repo.AdminMail)
+					mux.Get("/mail/{id}/resend/do", handlers.Repo.AdminResendMail)
+				})
+
+				//i token delle api e gli utenti danno accesso a tutto, solo l'owner li gestisce
+				mux.Group(func(mux chi.Router) {
+					mux.Use(handlers.Repo.RequireRole(models.RoleOwner))
+					mux.Get("/api-tokens", handlers.Repo.AdminAPITokens)
+					mux.Post("/api-tokens", handlers.Repo.AdminPostAPIToken)
+					mux.Get("/api-tokens/{id}/delete/do", handlers.Repo.AdminDeleteAPIToken)
+
+					mux.Get("/users", handlers.Repo.AdminUsers)
+					mux.Get("/users/new", handlers.Repo.AdminNewUser)
+					mux.Post("/users/new", handlers.Repo.AdminPostNewUser)
+					mux.Get("/users/{id}", handlers.Repo.AdminShowUser)
+					mux.Post("/users/{id}", handlers.Repo.AdminPostShowUser)
+					mux.Get("/users/{id}/enable/do", handlers.Repo.AdminEnableUser)
+					mux.Get("/users/{id}/disable/do", handlers.Repo.AdminDisableUser)
+					mux.Get("/users/{id}/invite/do", handlers.Repo.AdminInviteUser)
+					mux.Get("/users/{id}/unlock/do", handlers.Repo.AdminUnlockUser)
+					mux.Get("/users/{id}/2fa/reset/do", handlers.Repo.AdminResetUserTwoFactor)
+				})
+			})
+		})
 	})
 
 	return mux
@@ -1,63 +1,67 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"strings"
-	"time"
 
-	"github.com/Laura470/bookings/internal/models"
-	mail "github.com/xhit/go-simple-mail"
+	"github.com/Laura470/bookings/internal/config"
+	"github.com/Laura470/bookings/internal/handlers"
+	"github.com/Laura470/bookings/internal/mailer"
+	"github.com/Laura470/bookings/internal/outbox"
 )
 
-func listenForMail() {
-	//faccio partire una routine con una funzione anonima che funziona in back ground
-	//è un for loop infinito
+//listenForMail starts the worker that sends the mails of the outbox, in back ground until ctx is done.
+//The function returned waits for the worker to stop and then sends the mails still due
+func listenForMail(ctx context.Context) func(context.Context) error {
+	worker := outbox.New(handlers.Repo.DB, app.Mailer.Send, app.Logger.With("job", "mail"))
+	worker.Metrics = app.Metrics
+	if app.MailWorkers > 0 {
+		worker.Workers = app.MailWorkers
+	}
+	if app.MailMaxAttempts > 0 {
+		worker.MaxAttempts = app.MailMaxAttempts
+	}
+
+	done := make(chan struct{})
 	go func() {
-		for {
-			msg := <-app.MailChan
-			sendMsg(msg)
-		}
+		worker.Run(ctx)
+		close(done)
 	}()
 
-}
-
-func sendMsg(m models.MailData) {
-	server := mail.NewSMTPClient()
-	server.Host = "localhost"
-	server.Port = 1025
-	server.KeepAlive = false
-	server.ConnectTimeout = 10 * time.Second
-	server.SendTimeout = 10 * time.Second
-	//altre cose per un live server
-
-	client, err := server.Connect()
-	if err != nil {
-		errorLog.Println(err)
+	return func(ctx context.Context) error {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return worker.Drain(ctx)
 	}
+}
 
-	email := mail.NewMSG()
-	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
-	//se non ho scelto template
-	if m.Template == "" {
-		email.SetBody(mail.TextHTML, m.Content)
-	} else { //se ho scelto template
-		//vado a leggere dal disco
-		data, err := ioutil.ReadFile(fmt.Sprintf("./email-templates/%s", m.Template))
-
+//newMailer returns the mailer chosen in the settings: smtp sends to the mail server, file writes the mails in a folder
+func newMailer(s config.MailSettings) (mailer.Mailer, error) {
+	switch s.Mailer {
+	case "smtp":
+		encryption, err := mailer.ParseEncryption(s.Encryption)
 		if err != nil {
-			app.ErrorLog.Println(err)
+			return nil, err
 		}
-		mailTemplate := string(data)
-		msgToSend := strings.Replace(mailTemplate, "[%body%]", m.Content, 1)
-		email.SetBody(mail.TextHTML, msgToSend)
-	}
-
-	err = email.Send(client)
-	if err != nil {
-		log.Println(err)
-	} else {
-		log.Println("Email sent!")
+		if s.Host == "" {
+			return nil, fmt.Errorf("missing the host of the mail server")
+		}
+		return &mailer.SMTP{
+			Host:       s.Host,
+			Port:       s.Port,
+			Username:   s.Username,
+			Password:   s.Password,
+			Encryption: encryption,
+			From:       s.From,
+		}, nil
+	case "file":
+		return &mailer.File{
+			Dir:  s.Dir,
+			From: s.From,
+		}, nil
 	}
+	return nil, fmt.Errorf("unknown mailer %q, use smtp or file", s.Mailer)
 }
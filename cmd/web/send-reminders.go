@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/Laura470/bookings/internal/handlers"
+)
+
+//sendReminders queues every hour the reminders for the guests arriving within before, in back ground until ctx is done
+func sendReminders(ctx context.Context, before time.Duration) {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		for {
+			err := handlers.Repo.SendReminders(ctx, time.Now(), before)
+			if err != nil {
+				app.Logger.Error("can't send the reminders", "job", "reminders", "error", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/Laura470/bookings/internal/handlers"
+)
+
+//syncCalendars reads the external calendars of the rooms now and then every interval, in back ground until ctx is done
+func syncCalendars(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			err := handlers.Repo.SyncICalFeeds(ctx)
+			//allo spegnimento la sincronizzazione si ferma a metà, non è un errore
+			if err != nil && ctx.Err() == nil {
+				app.Logger.Error("can't sync the calendars", "job", "calendar-sync", "error", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
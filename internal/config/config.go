@@ -2,19 +2,48 @@ package config
 
 import (
 	"html/template"
-	"log"
+	"net"
+	texttemplate "text/template"
+	"time"
 
+	"github.com/Laura470/bookings/internal/logger"
+	"github.com/Laura470/bookings/internal/mailer"
+	"github.com/Laura470/bookings/internal/metrics"
 	"github.com/Laura470/bookings/internal/models"
+	"github.com/Laura470/bookings/internal/tokens"
 	"github.com/alexedwards/scs/v2"
 )
 
 //AppConfig holds the application config
 type AppConfig struct {
-	UseCache      bool
-	TemplateCache map[string]*template.Template
-	InfoLog       *log.Logger
-	ErrorLog      *log.Logger
-	InProduction  bool
-	Session       *scs.SessionManager
-	MailChan      chan models.MailData
+	//Settings are the values read from the config file, the environment and the flags
+	Settings          Settings
+	UseCache          bool
+	TemplateCache     map[string]*template.Template
+	Logger            *logger.Logger
+	Metrics           *metrics.Site
+	InProduction      bool
+	Session           *scs.SessionManager
+	DBTimeout         time.Duration
+	BaseURL           string
+	Signer            *tokens.Signer
+	ICalSyncInterval  time.Duration
+	ReminderBefore    time.Duration
+	MailWorkers       int
+	MailMaxAttempts   int
+	ShutdownTimeout   time.Duration
+	Mailer            mailer.Mailer
+	MailTemplateCache map[string]MailTemplate
+	//Require2FA is the lowest role that must log in with two-factor authentication, 0 if it's optional for everybody
+	Require2FA models.Role
+	//MetricsToken is asked to who reads the metrics, empty to leave them open
+	MetricsToken string
+	//TrustedProxies are the proxies in front of the site, only from them X-Forwarded-For and X-Real-IP are read
+	TrustedProxies []*net.IPNet
+}
+
+//MailTemplate is a mail in html and in plain text, the subject is defined in the text one
+type MailTemplate struct {
+	HTML *template.Template
+	Text *texttemplate.Template
 }
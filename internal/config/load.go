@@ -0,0 +1,324 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Laura470/bookings/internal/logger"
+	"github.com/Laura470/bookings/internal/mailer"
+	"github.com/Laura470/bookings/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+//LoadOptions are the flags of the loader itself, they are not settings of the site
+type LoadOptions struct {
+	//File is the yaml config file, from -config or the environment variable CONFIG_FILE
+	File string
+	//PrintConfig asks to write the settings in use and exit
+	PrintConfig bool
+}
+
+//field is a value of the settings with where it comes from
+type field struct {
+	path     string
+	value    reflect.Value
+	env      string
+	flag     string
+	usage    string
+	secret   bool
+	required bool
+}
+
+//fields returns the values of s that can be set, in the order of the struct
+func fields(s *Settings) []field {
+	var out []field
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			key := prefix + sf.Tag.Get("yaml")
+			if sf.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), key+".")
+				continue
+			}
+			out = append(out, field{
+				path:     key,
+				value:    v.Field(i),
+				env:      sf.Tag.Get("env"),
+				flag:     sf.Tag.Get("flag"),
+				usage:    sf.Tag.Get("usage"),
+				secret:   sf.Tag.Get("secret") == "true",
+				required: sf.Tag.Get("required") == "true",
+			})
+		}
+	}
+	walk(reflect.ValueOf(s).Elem(), "")
+	return out
+}
+
+//set parses s in the value of f
+func (f field) set(s string) error {
+	switch f.value.Interface().(type) {
+	case string:
+		f.value.SetString(s)
+	case bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", s)
+		}
+		f.value.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", s)
+		}
+		f.value.SetInt(int64(n))
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration like 30s or 5m", s)
+		}
+		f.value.SetInt(int64(d))
+	default:
+		return fmt.Errorf("unsupported type %s", f.value.Type())
+	}
+	return nil
+}
+
+//String returns the value of f as it's written in the file, the secrets hidden
+func (f field) String() string {
+	if f.secret && f.value.String() != "" {
+		return "REDACTED"
+	}
+	if d, ok := f.value.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	return fmt.Sprint(f.value.Interface())
+}
+
+//flagValue keeps what was given to a flag, it's applied after the file and the environment
+type flagValue struct {
+	isBool bool
+	value  string
+	def    string
+}
+
+func (v *flagValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return v.def
+}
+
+func (v *flagValue) Set(s string) error {
+	v.value = s
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	return v.isBool
+}
+
+//LoadSettings reads the settings from the config file, the environment and the flags in args, in this order.
+//lookupEnv is os.LookupEnv, except in the tests. The settings are not validated, see Settings.Validate
+func LoadSettings(name string, args []string, lookupEnv func(string) (string, bool)) (Settings, LoadOptions, error) {
+	s := DefaultSettings()
+	var opts LoadOptions
+	all := fields(&s)
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&opts.File, "config", "", "YAML config file, the environment variables and the flags override it (env CONFIG_FILE)")
+	fs.BoolVar(&opts.PrintConfig, "print-config", false, "Print the settings in use, without the secrets, and exit")
+	flags := map[string]*flagValue{}
+	for _, f := range all {
+		v := &flagValue{isBool: f.value.Kind() == reflect.Bool, def: f.String()}
+		flags[f.flag] = v
+		fs.Var(v, f.flag, fmt.Sprintf("%s (env %s, %s in the config file)", f.usage, f.env, f.path))
+	}
+	if err := fs.Parse(args); err != nil {
+		return s, opts, err
+	}
+
+	if opts.File == "" {
+		opts.File, _ = lookupEnv("CONFIG_FILE")
+	}
+	if opts.File != "" {
+		b, err := ioutil.ReadFile(opts.File)
+		if err != nil {
+			return s, opts, err
+		}
+		if err := decodeSettings(b, &s); err != nil {
+			return s, opts, fmt.Errorf("%s: %w", opts.File, err)
+		}
+	}
+
+	var errs []string
+	for _, f := range all {
+		if v, ok := lookupEnv(f.env); ok {
+			if err := f.set(v); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", f.env, err))
+			}
+		}
+	}
+
+	//solo le flag date davvero, i default sono già nelle impostazioni
+	fs.Visit(func(fl *flag.Flag) {
+		for _, f := range all {
+			if f.flag == fl.Name {
+				if err := f.set(flags[f.flag].value); err != nil {
+					errs = append(errs, fmt.Sprintf("-%s: %s", f.flag, err))
+				}
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return s, opts, errors.New("invalid configuration:\n  " + strings.Join(errs, "\n  "))
+	}
+	return s, opts, nil
+}
+
+//decodeSettings reads the yaml file over s, an unknown key is an error so that a typo doesn't go unnoticed
+func decodeSettings(b []byte, s *Settings) error {
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	err := dec.Decode(s)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+//Validate checks the settings, the error lists all the values that are wrong
+func (s Settings) Validate() error {
+	var errs []string
+	invalid := func(f field, format string, a ...interface{}) {
+		errs = append(errs, fmt.Sprintf("%s (env %s, -%s): %s", f.path, f.env, f.flag, fmt.Sprintf(format, a...)))
+	}
+
+	for _, f := range fields(&s) {
+		//senza database i suoi dati non servono
+		if s.DB.Kind == "memory" && strings.HasPrefix(f.path, "db.") && f.path != "db.kind" {
+			continue
+		}
+		//sqlite è un file, non c'è un server a cui fare login
+		if s.DB.Driver == "sqlite" && (f.path == "db.user" || f.path == "db.password") {
+			continue
+		}
+		if f.required && f.value.IsZero() {
+			invalid(f, "required")
+			continue
+		}
+		if d, ok := f.value.Interface().(time.Duration); ok && d < 0 {
+			invalid(f, "can't be negative")
+			continue
+		}
+
+		var err error
+		switch f.path {
+		case "port", "db.port", "mail.smtp_port":
+			if n := f.value.Int(); n < 1 || n > 65535 {
+				err = fmt.Errorf("%d is not a port", n)
+			}
+		case "base_url":
+			u, e := url.Parse(f.value.String())
+			if e != nil || u.Scheme == "" || u.Host == "" {
+				err = fmt.Errorf("%q is not an address like https://www.example.com", f.value.String())
+			}
+		case "require_2fa":
+			if f.value.String() != "" {
+				_, err = models.ParseRole(f.value.String())
+			}
+		case "db.max_open_conns", "db.max_idle_conns", "mail.workers", "mail.max_attempts":
+			if f.value.Int() < 0 {
+				err = errors.New("can't be negative")
+			}
+		case "db.kind":
+			if k := f.value.String(); k != "sql" && k != "memory" {
+				err = fmt.Errorf("unknown database %q, use sql or memory", k)
+			}
+		case "db.driver":
+			if d := f.value.String(); d != "postgres" && d != "sqlite" {
+				err = fmt.Errorf("unknown database driver %q, use postgres or sqlite", d)
+			}
+		case "mail.mailer":
+			if m := f.value.String(); m != "smtp" && m != "file" {
+				err = fmt.Errorf("unknown mailer %q, use smtp or file", m)
+			}
+		case "mail.smtp_encryption":
+			_, err = mailer.ParseEncryption(f.value.String())
+		case "session.store":
+			if st := f.value.String(); st != "database" && st != "memory" {
+				err = fmt.Errorf("unknown session store %q, use database or memory", st)
+			}
+		case "log.format":
+			if f.value.String() != "" {
+				_, err = logger.ParseFormat(f.value.String())
+			}
+		case "log.level":
+			_, err = logger.ParseLevel(f.value.String())
+		case "trusted_proxies":
+			_, err = ParseTrustedProxies(f.value.String())
+		case "secret":
+			//con una chiave a caso i link mandati per email non valgono più dopo un riavvio,
+			//va bene solo in sviluppo o in memoria, dove dopo un riavvio non c'è più niente
+			if s.Production && s.DB.Kind != "memory" && f.value.String() == "" {
+				err = errors.New("required in production")
+			}
+		}
+		if err != nil {
+			invalid(f, "%s", err)
+		}
+	}
+
+	//con più connessioni libere che aperte il pool non le terrebbe comunque
+	if s.DB.Kind != "memory" && s.DB.MaxOpenConns > 0 && s.DB.MaxIdleConns > s.DB.MaxOpenConns {
+		errs = append(errs, fmt.Sprintf("db.max_idle_conns: %d is more than db.max_open_conns %d", s.DB.MaxIdleConns, s.DB.MaxOpenConns))
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+//PrintSettings writes the settings as a yaml config file, the secrets are redacted
+func PrintSettings(w io.Writer, s Settings) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	sections := map[string]*yaml.Node{}
+
+	for _, f := range fields(&s) {
+		parent, key := root, f.path
+		if i := strings.Index(f.path, "."); i >= 0 {
+			section := f.path[:i]
+			key = f.path[i+1:]
+			if sections[section] == nil {
+				sections[section] = &yaml.Node{Kind: yaml.MappingNode}
+				root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: section}, sections[section])
+			}
+			parent = sections[section]
+		}
+
+		value := &yaml.Node{Kind: yaml.ScalarNode, Value: f.String()}
+		if f.value.Kind() == reflect.String {
+			value.Tag = "!!str"
+		}
+		parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,244 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//env returns a lookupEnv that reads from the map
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "bookings.yml")
+	if err := ioutil.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestLoadSettingsPrecedence(t *testing.T) {
+	file := writeFile(t, `
+port: 9000
+db:
+  name: fromfile
+  user: fromfile
+  host: db.example.com
+  timeout: 10s
+mail:
+  smtp_host: mail.example.com
+`)
+
+	s, opts, err := LoadSettings("bookings", []string{"-config", file, "-dbuser=fromflag", "-production=false"}, env(map[string]string{
+		"DB_NAME":   "fromenv",
+		"DB_USER":   "fromenv",
+		"SMTP_PORT": "2525",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.File != file {
+		t.Errorf("expected file %s but got %s", file, opts.File)
+	}
+
+	checks := []struct {
+		name     string
+		got      interface{}
+		expected interface{}
+	}{
+		{"port from file", s.Port, 9000},
+		{"db host from file", s.DB.Host, "db.example.com"},
+		{"db timeout from file", s.DB.Timeout, 10 * time.Second},
+		{"db name from env over file", s.DB.Name, "fromenv"},
+		{"db user from flag over env", s.DB.User, "fromflag"},
+		{"smtp port from env", s.Mail.Port, 2525},
+		{"smtp host from file", s.Mail.Host, "mail.example.com"},
+		{"production from flag", s.Production, false},
+		{"default ssl mode", s.DB.SSLMode, "disable"},
+		{"default session lifetime", s.Session.Lifetime, 24 * time.Hour},
+	}
+	for _, c := range checks {
+		if c.got != c.expected {
+			t.Errorf("%s: expected %v but got %v", c.name, c.expected, c.got)
+		}
+	}
+}
+
+func TestLoadSettingsFileFromEnv(t *testing.T) {
+	file := writeFile(t, "port: 9001\n")
+
+	s, opts, err := LoadSettings("bookings", nil, env(map[string]string{"CONFIG_FILE": file}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.File != file || s.Port != 9001 {
+		t.Errorf("the file in CONFIG_FILE has not been read: %s %d", opts.File, s.Port)
+	}
+}
+
+func TestLoadSettingsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		args     []string
+		env      map[string]string
+		expected string
+	}{
+		{"unknown key in file", "db:\n  nmae: bookings\n", nil, nil, "nmae"},
+		{"wrong type in file", "port: http\n", nil, nil, "line 1: cannot unmarshal"},
+		{"bad env", "", nil, map[string]string{"DB_PORT": "abc"}, `DB_PORT: "abc" is not a number`},
+		{"bad flag", "", []string{"-dbtimeout=soon"}, nil, `-dbtimeout: "soon" is not a duration`},
+		{"unknown flag", "", []string{"-nope"}, nil, "nope"},
+	}
+
+	for _, e := range tests {
+		args := e.args
+		if e.file != "" {
+			args = append([]string{"-config", writeFile(t, e.file)}, args...)
+		}
+		_, _, err := LoadSettings("bookings", args, env(e.env))
+		if err == nil {
+			t.Errorf("%s: no error", e.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), e.expected) {
+			t.Errorf("%s: expected %q in the error but got %q", e.name, e.expected, err)
+		}
+	}
+
+	_, _, err := LoadSettings("bookings", []string{"-config", filepath.Join(t.TempDir(), "missing.yml")}, env(nil))
+	if err == nil {
+		t.Error("missing file read")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s := DefaultSettings()
+	if err := s.Validate(); err == nil {
+		t.Error("settings without a database are valid")
+	} else {
+		for _, expected := range []string{"db.name (env DB_NAME, -dbname): required", "db.user", "db.password"} {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("expected %q in %q", expected, err)
+			}
+		}
+	}
+
+	valid := func() Settings {
+		s := DefaultSettings()
+		s.DB.Name = "bookings"
+		s.DB.User = "postgres"
+		s.DB.Password = "secret"
+		s.Secret = "signsecret"
+		return s
+	}
+	if err := valid().Validate(); err != nil {
+		t.Errorf("default settings with a database are not valid: %s", err)
+	}
+
+	//in memoria il database non serve
+	s = DefaultSettings()
+	s.DB.Kind = "memory"
+	if err := s.Validate(); err != nil {
+		t.Errorf("settings in memory without a database are not valid: %s", err)
+	}
+
+	//sqlite è solo un file, senza utente e password
+	s = DefaultSettings()
+	s.DB.Driver = "sqlite"
+	s.DB.Name = "bookings.db"
+	s.Secret = "signsecret"
+	if err := s.Validate(); err != nil {
+		t.Errorf("settings with sqlite are not valid: %s", err)
+	}
+
+	//in sviluppo senza chiave se ne usa una a caso
+	s = valid()
+	s.Production = false
+	s.Secret = ""
+	if err := s.Validate(); err != nil {
+		t.Errorf("settings in development without a secret are not valid: %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		change   func(s *Settings)
+		expected string
+	}{
+		{"port", func(s *Settings) { s.Port = 70000 }, "port (env PORT, -port): 70000 is not a port"},
+		{"base url", func(s *Settings) { s.BaseURL = "localhost" }, "base_url"},
+		{"role", func(s *Settings) { s.Require2FA = "boss" }, "require_2fa"},
+		{"db kind", func(s *Settings) { s.DB.Kind = "mongo" }, `unknown database "mongo"`},
+		{"db driver", func(s *Settings) { s.DB.Driver = "mysql" }, `unknown database driver "mysql"`},
+		{"mailer", func(s *Settings) { s.Mail.Mailer = "pigeon" }, `unknown mailer "pigeon"`},
+		{"encryption", func(s *Settings) { s.Mail.Encryption = "ssl" }, "mail.smtp_encryption"},
+		{"session store", func(s *Settings) { s.Session.Store = "redis" }, "session.store"},
+		{"log level", func(s *Settings) { s.Log.Level = "loud" }, "log.level"},
+		{"log format", func(s *Settings) { s.Log.Format = "xml" }, "log.format"},
+		{"negative duration", func(s *Settings) { s.DB.Timeout = -time.Second }, "db.timeout (env DB_TIMEOUT, -dbtimeout): can't be negative"},
+		{"negative workers", func(s *Settings) { s.Mail.Workers = -1 }, "mail.workers"},
+		{"pool", func(s *Settings) { s.DB.MaxIdleConns = 20 }, "db.max_idle_conns: 20 is more than db.max_open_conns 10"},
+		{"secret in production", func(s *Settings) { s.Secret = "" }, "secret (env SECRET, -secret): required in production"},
+		{"trusted proxies", func(s *Settings) { s.TrustedProxies = "10.0.0.1, 10.0.0.0/33" }, `trusted_proxies (env TRUSTED_PROXIES, -trustedproxies): "10.0.0.0/33"`},
+	}
+	for _, e := range tests {
+		s := valid()
+		e.change(&s)
+		err := s.Validate()
+		if err == nil {
+			t.Errorf("%s: no error", e.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), e.expected) {
+			t.Errorf("%s: expected %q in the error but got %q", e.name, e.expected, err)
+		}
+	}
+}
+
+func TestPrintSettings(t *testing.T) {
+	s := DefaultSettings()
+	s.DB.Password = "dbsecret"
+	s.Mail.Password = "mailsecret"
+	s.Secret = "signsecret"
+	s.BaseURL = "https://www.example.com"
+
+	var buf bytes.Buffer
+	if err := PrintSettings(&buf, s); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, secret := range []string{"dbsecret", "mailsecret", "signsecret"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("secret %s printed", secret)
+		}
+	}
+	for _, expected := range []string{"  password: REDACTED\n", "secret: REDACTED\n", "base_url: https://www.example.com\n", "metrics_token: \"\"\n", "  max_lifetime: 5m0s\n"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in\n%s", expected, out)
+		}
+	}
+
+	//senza i segreti quello che si stampa si rilegge uguale
+	s.DB.Password, s.Mail.Password, s.Secret = "", "", ""
+	buf.Reset()
+	if err := PrintSettings(&buf, s); err != nil {
+		t.Fatal(err)
+	}
+	read, _, err := LoadSettings("bookings", []string{"-config", writeFile(t, buf.String())}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read != s {
+		t.Errorf("expected %+v but read %+v", s, read)
+	}
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+//Settings are the values that can change from an installation to another. They are read from the config
+//file, then from the environment and then from the flags, every source overriding the ones before.
+//The tags give the key in the file, the environment variable and the flag of every value
+type Settings struct {
+	Production      bool          `yaml:"production" env:"PRODUCTION" flag:"production" usage:"Application is in production"`
+	UseCache        bool          `yaml:"cache" env:"CACHE" flag:"cache" usage:"Use template cache"`
+	Port            int           `yaml:"port" env:"PORT" flag:"port" usage:"Port the site listens on"`
+	BaseURL         string        `yaml:"base_url" env:"BASE_URL" flag:"baseurl" usage:"Public address of the site, used in the links sent by email"`
+	Secret          string        `yaml:"secret" env:"SECRET" flag:"secret" secret:"true" usage:"Secret key used to sign the links sent by email, required in production unless the data is in memory"`
+	Require2FA      string        `yaml:"require_2fa" env:"REQUIRE_2FA" flag:"require2fa" usage:"Lowest role that must log in with two-factor authentication: viewer, front-desk, manager or owner, empty if it's optional"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdowntimeout" usage:"How long the requests being served and the queued mails are waited for when the application stops"`
+	MetricsToken    string        `yaml:"metrics_token" env:"METRICS_TOKEN" flag:"metricstoken" secret:"true" usage:"Bearer token asked to read /metrics, empty to leave the metrics open"`
+	TrustedProxies  string        `yaml:"trusted_proxies" env:"TRUSTED_PROXIES" flag:"trustedproxies" usage:"Addresses or networks like 10.0.0.0/8 of the proxies in front of the site, separated by commas: only when the request comes from one of them the client address is read from X-Forwarded-For or X-Real-IP"`
+
+	DB      DBSettings      `yaml:"db"`
+	Mail    MailSettings    `yaml:"mail"`
+	Session SessionSettings `yaml:"session"`
+	Jobs    JobSettings     `yaml:"jobs"`
+	Log     LogSettings     `yaml:"log"`
+}
+
+//DBSettings are the connection to the database and the size of its pool
+type DBSettings struct {
+	Kind         string        `yaml:"kind" env:"DB_KIND" flag:"db" usage:"Where the data is kept: sql, or memory to try the site without a database"`
+	Driver       string        `yaml:"driver" env:"DB_DRIVER" flag:"dbdriver" usage:"Database used with -db=sql: postgres, or sqlite to keep the data in the file -dbname"`
+	Name         string        `yaml:"name" env:"DB_NAME" flag:"dbname" required:"true" usage:"Database name, or the file of sqlite"`
+	Host         string        `yaml:"host" env:"DB_HOST" flag:"dbhost" usage:"Database host"`
+	Port         int           `yaml:"port" env:"DB_PORT" flag:"dbport" usage:"Database port"`
+	User         string        `yaml:"user" env:"DB_USER" flag:"dbuser" required:"true" usage:"Database user"`
+	Password     string        `yaml:"password" env:"DB_PASSWORD" flag:"dbpass" required:"true" secret:"true" usage:"Database password"`
+	SSLMode      string        `yaml:"ssl_mode" env:"DB_SSLMODE" flag:"dbssl" usage:"Database ssl settings (disable, prefer, require)"`
+	Timeout      time.Duration `yaml:"timeout" env:"DB_TIMEOUT" flag:"dbtimeout" usage:"Timeout for every database query"`
+	MaxOpenConns int           `yaml:"max_open_conns" env:"DB_MAX_OPEN_CONNS" flag:"dbmaxopen" usage:"Most connections open to the database"`
+	MaxIdleConns int           `yaml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS" flag:"dbmaxidle" usage:"Most idle connections kept open to the database"`
+	MaxLifetime  time.Duration `yaml:"max_lifetime" env:"DB_MAX_LIFETIME" flag:"dbmaxlifetime" usage:"How long a connection to the database is used before opening a new one"`
+	AutoMigrate  bool          `yaml:"auto_migrate" env:"DB_AUTO_MIGRATE" flag:"dbmigrate" usage:"Apply the migrations not applied yet when the site starts"`
+}
+
+//MailSettings are how the mails are sent
+type MailSettings struct {
+	Mailer      string `yaml:"mailer" env:"MAILER" flag:"mailer" usage:"How the mails are sent: smtp, or file to write them in -maildir"`
+	Dir         string `yaml:"dir" env:"MAIL_DIR" flag:"maildir" usage:"Folder of the mails written by the file mailer"`
+	Host        string `yaml:"smtp_host" env:"SMTP_HOST" flag:"smtphost" usage:"Mail server host"`
+	Port        int    `yaml:"smtp_port" env:"SMTP_PORT" flag:"smtpport" usage:"Mail server port"`
+	Username    string `yaml:"smtp_username" env:"SMTP_USERNAME" flag:"smtpuser" usage:"Mail server user"`
+	Password    string `yaml:"smtp_password" env:"SMTP_PASSWORD" flag:"smtppass" secret:"true" usage:"Mail server password"`
+	Encryption  string `yaml:"smtp_encryption" env:"SMTP_ENCRYPTION" flag:"smtpencryption" usage:"Mail server encryption: none, starttls or tls"`
+	From        string `yaml:"from" env:"MAIL_FROM" flag:"mailfrom" usage:"Sender of the mails of the site"`
+	Workers     int    `yaml:"workers" env:"MAIL_WORKERS" flag:"mailworkers" usage:"How many mails are sent at the same time"`
+	MaxAttempts int    `yaml:"max_attempts" env:"MAIL_ATTEMPTS" flag:"mailattempts" usage:"How many times a mail is sent before giving up"`
+}
+
+//SessionSettings are where the sessions are kept and for how long
+type SessionSettings struct {
+	Store    string        `yaml:"store" env:"SESSION_STORE" flag:"sessionstore" usage:"Where the sessions are kept: database, to keep them after a restart, or memory"`
+	Lifetime time.Duration `yaml:"lifetime" env:"SESSION_LIFETIME" flag:"sessionlifetime" usage:"How long a session lasts"`
+	Cleanup  time.Duration `yaml:"cleanup" env:"SESSION_CLEANUP" flag:"sessioncleanup" usage:"How often the expired sessions are deleted from the database"`
+}
+
+//JobSettings are the jobs that run in back ground
+type JobSettings struct {
+	RemindBefore time.Duration `yaml:"remind_before" env:"REMIND_BEFORE" flag:"remindbefore" usage:"How long before the arrival the guests get a reminder by email, 0 to send none"`
+	ICalSync     time.Duration `yaml:"ical_sync" env:"ICAL_SYNC" flag:"icalsync" usage:"How often the external calendars of the rooms are read, 0 to never read them"`
+}
+
+//LogSettings are how the log is written
+type LogSettings struct {
+	Format string `yaml:"format" env:"LOG_FORMAT" flag:"logformat" usage:"Format of the log: json or text, empty for json in production and text in development"`
+	Level  string `yaml:"level" env:"LOG_LEVEL" flag:"loglevel" usage:"Lowest level written in the log: debug, info, warn or error"`
+}
+
+//DefaultSettings returns the settings used when nothing else is given
+func DefaultSettings() Settings {
+	return Settings{
+		Production:      true,
+		UseCache:        true,
+		Port:            8080,
+		BaseURL:         "http://localhost:8080",
+		ShutdownTimeout: 30 * time.Second,
+		DB: DBSettings{
+			Kind:         "sql",
+			Driver:       "postgres",
+			Host:         "localhost",
+			Port:         5432,
+			SSLMode:      "disable",
+			Timeout:      3 * time.Second,
+			MaxOpenConns: 10,
+			MaxIdleConns: 5,
+			MaxLifetime:  5 * time.Minute,
+		},
+		Mail: MailSettings{
+			Mailer:      "smtp",
+			Dir:         "./tmp/mail",
+			Host:        "localhost",
+			Port:        1025,
+			Encryption:  "none",
+			From:        "me@here.com",
+			Workers:     2,
+			MaxAttempts: 8,
+		},
+		Session: SessionSettings{
+			Store:    "database",
+			Lifetime: 24 * time.Hour,
+			Cleanup:  5 * time.Minute,
+		},
+		Jobs: JobSettings{
+			RemindBefore: 48 * time.Hour,
+			ICalSync:     30 * time.Minute,
+		},
+		Log: LogSettings{
+			Level: "info",
+		},
+	}
+}
+
+//ParseTrustedProxies reads the list of the trusted proxies, addresses or networks separated by commas.
+//An address is a network with only itself
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, p := range strings.Split(list, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an address or a network like 10.0.0.0/8", p)
+			}
+			//un indirizzo da solo è una rete con tutti i bit della maschera
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an address or a network like 10.0.0.0/8", p)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
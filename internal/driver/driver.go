@@ -1,40 +1,58 @@
 package driver
 
 import (
+	"context"
 	"database/sql"
+	sqldriver "database/sql/driver"
+	"errors"
+	"fmt"
+	"net/url"
 	"time"
 
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
 	_ "github.com/jackc/pgx/v4/stdlib"
+	"modernc.org/sqlite"
+)
+
+//Postgres and SQLite are the databases the site can use
+const (
+	Postgres = "postgres"
+	SQLite   = "sqlite"
 )
 
 // DB holds the database connection pool
 //in questo modo posso cambiare facilmente tipo di db
 type DB struct {
 	SQL *sql.DB
+	//Driver is Postgres or SQLite
+	Driver string
 }
 
 //è la connection pool
 var dbConn = &DB{}
 
-const maxOpenDbConn = 10
-const maxIdleDbConn = 5
-const maxDbLifetime = 5 * time.Minute
+//Pool is how many connections the pool keeps and for how long
+type Pool struct {
+	MaxOpenConns int
+	MaxIdleConns int
+	MaxLifetime  time.Duration
+}
 
-// ConnectSQL creates database pool for Postgres
-func ConnectSQL(dsn string) (*DB, error) {
-	d, err := NewDatabase(dsn)
+// ConnectSQL creates database pool for Postgres or SQLite
+func ConnectSQL(driverName, dsn string, pool Pool) (*DB, error) {
+	d, err := NewDatabase(driverName, dsn)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	//setto i parametri ocme deciso sopra con le costanti
-	d.SetMaxOpenConns(maxOpenDbConn)
-	d.SetMaxIdleConns(maxIdleDbConn)
-	d.SetConnMaxLifetime(maxDbLifetime)
+	//setto i parametri ocme deciso nella configurazione
+	d.SetMaxOpenConns(pool.MaxOpenConns)
+	d.SetMaxIdleConns(pool.MaxIdleConns)
+	d.SetConnMaxLifetime(pool.MaxLifetime)
 
 	dbConn.SQL = d
+	dbConn.Driver = driverName
 
 	//già ceh ci sono faccio un altro test
 	err = testDB(d)
@@ -44,9 +62,28 @@ func ConnectSQL(dsn string) (*DB, error) {
 	return dbConn, nil
 }
 
+//Ping checks that the database answers, within the deadline of ctx
+func (d *DB) Ping(ctx context.Context) error {
+	if d == nil || d.SQL == nil {
+		return errors.New("not connected to the database")
+	}
+	return d.SQL.PingContext(ctx)
+}
+
 // NewDatabase creates a new database for the application
-func NewDatabase(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("pgx", dsn)
+func NewDatabase(driverName, dsn string) (*sql.DB, error) {
+	//il nome che i driver registrano in database/sql
+	var name string
+	switch driverName {
+	case Postgres:
+		name = "pgx"
+	case SQLite:
+		name = sqliteUTC
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driverName)
+	}
+
+	db, err := sql.Open(name, dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -66,3 +103,65 @@ func testDB(d *sql.DB) error {
 	}
 	return nil
 }
+
+//SQLiteDSN returns the dsn of the sqlite database in the file path. The foreign keys are checked,
+//the transactions lock the database when they begin and the times are written all in the same
+//format, in utc by the driver, so that the queries can compare them as text
+func SQLiteDSN(path string) string {
+	q := url.Values{}
+	q.Add("_pragma", "foreign_keys(1)")
+	q.Add("_pragma", "busy_timeout(5000)")
+	q.Add("_pragma", "journal_mode(WAL)")
+	q.Set("_txlock", "immediate")
+	q.Set("_time_format", "sqlite")
+	return "file:" + path + "?" + q.Encode()
+}
+
+//sqliteUTC is the sqlite driver that writes the times in utc
+const sqliteUTC = "sqlite-utc"
+
+func init() {
+	sql.Register(sqliteUTC, utcDriver{&sqlite.Driver{}})
+}
+
+//utcDriver opens the connections of sqlite as utcConn. Sqlite keeps the times as text with
+//their offset, the same time with two offsets wouldn't compare right
+type utcDriver struct {
+	*sqlite.Driver
+}
+
+//sqliteConn are the interfaces of the connections of modernc.org/sqlite used by database/sql
+type sqliteConn interface {
+	sqldriver.Conn
+	sqldriver.ConnBeginTx
+	sqldriver.ConnPrepareContext
+	sqldriver.ExecerContext
+	sqldriver.QueryerContext
+	sqldriver.Pinger
+}
+
+type utcConn struct {
+	sqliteConn
+}
+
+func (d utcDriver) Open(name string) (sqldriver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	sc, ok := c.(sqliteConn)
+	if !ok {
+		c.Close()
+		return nil, fmt.Errorf("the connection of sqlite is a %T", c)
+	}
+	return utcConn{sc}, nil
+}
+
+//CheckNamedValue moves the times in utc, the other values are converted by database/sql as usual
+func (utcConn) CheckNamedValue(nv *sqldriver.NamedValue) error {
+	if t, ok := nv.Value.(time.Time); ok {
+		nv.Value = t.UTC()
+		return nil
+	}
+	return sqldriver.ErrSkip
+}
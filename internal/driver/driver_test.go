@@ -0,0 +1,50 @@
+package driver
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//TestSQLiteUTC checks that the times are written in utc whatever their zone, so that they compare as text
+func TestSQLiteUTC(t *testing.T) {
+	db, err := NewDatabase(SQLite, SQLiteDSN(filepath.Join(t.TempDir(), "test.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`create table times (at timestamp not null)`); err != nil {
+		t.Fatal(err)
+	}
+	rome := time.Date(2040, 1, 10, 1, 30, 0, 0, time.FixedZone("CET", 3600))
+	if _, err := db.Exec(`insert into times (at) values ($1)`, rome); err != nil {
+		t.Fatal(err)
+	}
+
+	var text string
+	if err := db.QueryRow(`select at || '' from times`).Scan(&text); err != nil {
+		t.Fatal(err)
+	}
+	if text != "2040-01-10 00:30:00+00:00" {
+		t.Errorf("expected the time in utc but got %q", text)
+	}
+
+	//00:50 utc viene dopo, anche se come testo "01:30:00+01:00" sarebbe più grande di "00:50:00+00:00"
+	var count int
+	later := time.Date(2040, 1, 10, 0, 50, 0, 0, time.UTC)
+	if err := db.QueryRow(`select count(*) from times where at > $1`, later).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("expected the time to compare as a time but got %d rows", count)
+	}
+
+	var at time.Time
+	if err := db.QueryRow(`select at from times`).Scan(&at); err != nil {
+		t.Fatal(err)
+	}
+	if !at.Equal(rome) {
+		t.Errorf("expected %s but got %s", rome, at)
+	}
+}
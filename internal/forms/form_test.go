@@ -205,3 +205,45 @@ func TestForm_IsEmail(t *testing.T) {
 
 
 */
+
+func TestForm_IsSlug(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("good", "generals-quarters")
+	postedData.Add("spaces", "generals quarters")
+	postedData.Add("uppercase", "Generals")
+	postedData.Add("trailing", "generals-")
+	form := New(postedData)
+
+	if !form.IsSlug("good") {
+		t.Error("generals-quarters should be a valid slug")
+	}
+	for _, field := range []string{"spaces", "uppercase", "trailing", "missing"} {
+		if form.IsSlug(field) {
+			t.Errorf("%s should not be a valid slug", field)
+		}
+	}
+	if form.Errors.Get("spaces") == "" {
+		t.Error("should have an error for spaces but did not get one")
+	}
+}
+
+func TestForm_IsInt(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("two", "2")
+	postedData.Add("zero", "0")
+	postedData.Add("text", "two")
+	form := New(postedData)
+
+	if !form.IsInt("two", 1) {
+		t.Error("2 should be a whole number of at least 1")
+	}
+	if form.IsInt("zero", 1) {
+		t.Error("0 is smaller than 1 but is valid")
+	}
+	if form.IsInt("text", 1) {
+		t.Error("text is not a number but is valid")
+	}
+	if form.Errors.Get("zero") == "" {
+		t.Error("should have an error for zero but did not get one")
+	}
+}
@@ -3,6 +3,8 @@ package forms
 import (
 	"fmt"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/asaskevich/govalidator"
@@ -66,3 +68,25 @@ func (f *Form) IsEmail(field string) {
 		f.Errors.Add(field, "Invalid email address")
 	}
 }
+
+//slugRegexp matches lowercase words separated by hyphens, like generals-quarters
+var slugRegexp = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+//IsSlug checks that the field can be used in an url, like generals-quarters
+func (f *Form) IsSlug(field string) bool {
+	if !slugRegexp.MatchString(f.Get(field)) {
+		f.Errors.Add(field, "Use only lowercase letters, numbers and hyphens")
+		return false
+	}
+	return true
+}
+
+//IsInt checks that the field is a whole number not smaller than min
+func (f *Form) IsInt(field string, min int) bool {
+	n, err := strconv.Atoi(f.Get(field))
+	if err != nil || n < min {
+		f.Errors.Add(field, fmt.Sprintf("This field must be a whole number of at least %d", min))
+		return false
+	}
+	return true
+}
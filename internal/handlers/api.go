@@ -0,0 +1,645 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Laura470/bookings/internal/forms"
+	"github.com/Laura470/bookings/internal/logger"
+	"github.com/Laura470/bookings/internal/metrics"
+	"github.com/Laura470/bookings/internal/models"
+	"github.com/Laura470/bookings/internal/pricing"
+	"github.com/Laura470/bookings/internal/repository"
+	"github.com/Laura470/bookings/internal/tokens"
+	"github.com/go-chi/chi"
+)
+
+//maxAPIBody is the biggest json body we read, our requests are a few hundred bytes
+const maxAPIBody = 1 << 20
+
+type apiContextKey string
+
+//apiTokenKey is where APIAuth puts the token of the request in the context
+const apiTokenKey apiContextKey = "api_token"
+
+//apiEnvelope wraps every api response, either data or error is set
+type apiEnvelope struct {
+	Data  interface{} `json:"data,omitempty"`
+	Error *apiError   `json:"error,omitempty"`
+}
+
+type apiError struct {
+	Status  int                 `json:"status"`
+	Message string              `json:"message"`
+	Fields  map[string][]string `json:"fields,omitempty"`
+}
+
+type apiRoom struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Slug        string   `json:"slug"`
+	Description string   `json:"description"`
+	Capacity    int      `json:"capacity"`
+	Photos      []string `json:"photos"`
+	BaseRate    int      `json:"base_rate"`
+	WeekendRate int      `json:"weekend_rate"`
+	MinStay     int      `json:"min_stay"`
+}
+
+type apiAvailableRoom struct {
+	RoomID     int    `json:"room_id"`
+	Name       string `json:"name"`
+	Slug       string `json:"slug"`
+	Bookable   bool   `json:"bookable"`
+	Nights     int    `json:"nights"`
+	TotalPrice int    `json:"total_price"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+type apiAvailability struct {
+	StartDate string             `json:"start_date"`
+	EndDate   string             `json:"end_date"`
+	Rooms     []apiAvailableRoom `json:"rooms"`
+}
+
+type apiReservation struct {
+	ID         int    `json:"id"`
+	Reference  string `json:"reference,omitempty"`
+	FirstName  string `json:"first_name"`
+	LastName   string `json:"last_name"`
+	Email      string `json:"email"`
+	Phone      string `json:"phone"`
+	RoomID     int    `json:"room_id"`
+	RoomName   string `json:"room_name"`
+	StartDate  string `json:"start_date"`
+	EndDate    string `json:"end_date"`
+	TotalPrice int    `json:"total_price"`
+	Processed  int    `json:"processed"`
+}
+
+type apiReservationRequest struct {
+	RoomID    int    `json:"room_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+}
+
+type apiBlock struct {
+	ID          int    `json:"id"`
+	RoomID      int    `json:"room_id"`
+	RoomName    string `json:"room_name"`
+	StartDate   string `json:"start_date"`
+	EndDate     string `json:"end_date"`
+	Recurrence  string `json:"recurrence"`
+	RepeatUntil string `json:"repeat_until"`
+	Reason      string `json:"reason"`
+}
+
+type apiBlockRequest struct {
+	RoomID      int    `json:"room_id"`
+	StartDate   string `json:"start_date"`
+	EndDate     string `json:"end_date"`
+	Recurrence  string `json:"recurrence"`
+	RepeatUntil string `json:"repeat_until"`
+	Reason      string `json:"reason"`
+}
+
+//APIAuth lets through only the requests with a valid api token in the header Authorization: Bearer <token>
+func (m *Repository) APIAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(header, "Bearer ")
+		if header == "" || token == header {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			m.apiError(w, http.StatusUnauthorized, "missing api token")
+			return
+		}
+
+		t, err := m.DB.GetAPITokenByHash(r.Context(), tokens.Hash(token))
+		if errors.Is(err, sql.ErrNoRows) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			m.apiError(w, http.StatusUnauthorized, "invalid api token")
+			return
+		}
+		if err != nil {
+			m.apiServerError(w, r, err)
+			return
+		}
+
+		logger.SetUserID(r.Context(), t.UserID)
+		ctx := context.WithValue(r.Context(), apiTokenKey, t)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+//RequireAPIScope lets through only the requests made with a token of the given scope, it goes after APIAuth
+func (m *Repository) RequireAPIScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t, ok := r.Context().Value(apiTokenKey).(models.APIToken)
+			if !ok || t.Scope != scope {
+				m.apiError(w, http.StatusForbidden, fmt.Sprintf("this endpoint needs a token with scope %s", scope))
+				return
+			}
+			//un token admin vale solo finché l'utente è ancora owner
+			if scope == models.ScopeAdmin && !t.User.Role().AtLeast(models.RoleOwner) {
+				m.apiError(w, http.StatusForbidden, "the token belongs to a user who is not an owner")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+//APINotFound is the not found handler of the api, it answers with json like the other endpoints
+func (m *Repository) APINotFound(w http.ResponseWriter, r *http.Request) {
+	m.apiError(w, http.StatusNotFound, "no such endpoint")
+}
+
+//APIMethodNotAllowed answers with json when the endpoint exists but not with that method
+func (m *Repository) APIMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	m.apiError(w, http.StatusMethodNotAllowed, fmt.Sprintf("method %s not allowed", r.Method))
+}
+
+//APIRooms lists the rooms guests can book
+func (m *Repository) APIRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		m.apiServerError(w, r, err)
+		return
+	}
+
+	out := []apiRoom{}
+	for _, x := range rooms {
+		if x.Active == 1 {
+			out = append(out, toAPIRoom(x))
+		}
+	}
+
+	m.writeJSON(w, http.StatusOK, out)
+}
+
+//APIRoom returns a room by id
+func (m *Repository) APIRoom(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.apiError(w, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if err != nil || room.Active != 1 {
+		m.apiError(w, http.StatusNotFound, "room not found")
+		return
+	}
+
+	m.writeJSON(w, http.StatusOK, toAPIRoom(room))
+}
+
+//APIAvailability returns the rooms available from start to end, with the price of the stay
+func (m *Repository) APIAvailability(w http.ResponseWriter, r *http.Request) {
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, r.URL.Query().Get("start"))
+	if err != nil {
+		m.apiError(w, http.StatusBadRequest, "start must be a date like 2040-01-31")
+		return
+	}
+	endDate, err := time.Parse(layout, r.URL.Query().Get("end"))
+	if err != nil {
+		m.apiError(w, http.StatusBadRequest, "end must be a date like 2040-01-31")
+		return
+	}
+	if !endDate.After(startDate) {
+		m.apiError(w, http.StatusBadRequest, "end must be after start")
+		return
+	}
+
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), startDate, endDate)
+	if err != nil {
+		m.apiServerError(w, r, err)
+		return
+	}
+	m.App.Metrics.Search(metrics.SourceAPI, len(rooms) > 0)
+
+	out := apiAvailability{
+		StartDate: startDate.Format(layout),
+		EndDate:   endDate.Format(layout),
+		Rooms:     []apiAvailableRoom{},
+	}
+
+	for _, x := range rooms {
+		room := apiAvailableRoom{
+			RoomID: x.ID,
+			Name:   x.RoomName,
+			Slug:   x.Slug,
+		}
+
+		quote, err := m.DB.QuotePrice(r.Context(), x.ID, startDate, endDate)
+		var minStay pricing.MinimumStayError
+		if errors.As(err, &minStay) {
+			room.Reason = minStay.Error()
+		} else if err != nil {
+			m.apiServerError(w, r, err)
+			return
+		} else {
+			room.Bookable = true
+			room.Nights = len(quote.Nights)
+			room.TotalPrice = quote.Total
+		}
+
+		out.Rooms = append(out.Rooms, room)
+	}
+
+	m.writeJSON(w, http.StatusOK, out)
+}
+
+//APIPostReservation books a room, the response has the reference the guest uses to read or cancel the reservation
+func (m *Repository) APIPostReservation(w http.ResponseWriter, r *http.Request) {
+	var req apiReservationRequest
+	if !m.readJSON(w, r, &req) {
+		return
+	}
+
+	//valido i campi con le stesse regole della form di prenotazione
+	form := forms.New(url.Values{
+		"first_name": {req.FirstName},
+		"last_name":  {req.LastName},
+		"email":      {req.Email},
+		"start_date": {req.StartDate},
+		"end_date":   {req.EndDate},
+	})
+	form.Required("first_name", "last_name", "email", "start_date", "end_date")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, req.StartDate)
+	if err != nil && form.Errors.Get("start_date") == "" {
+		form.Errors.Add("start_date", "Invalid date, use 2006-01-02")
+	}
+	endDate, err := time.Parse(layout, req.EndDate)
+	if err != nil && form.Errors.Get("end_date") == "" {
+		form.Errors.Add("end_date", "Invalid date, use 2006-01-02")
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), req.RoomID)
+	if err != nil || room.ID == 0 || room.Active != 1 {
+		form.Errors.Add("room_id", "No such room")
+	}
+
+	var quote models.Quote
+	if form.Valid() {
+		quote, err = m.DB.QuotePrice(r.Context(), room.ID, startDate, endDate)
+		var minStay pricing.MinimumStayError
+		if errors.As(err, &minStay) || errors.Is(err, pricing.ErrInvalidDates) {
+			form.Errors.Add("end_date", err.Error())
+		} else if err != nil {
+			m.apiServerError(w, r, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		m.apiFormError(w, form)
+		return
+	}
+
+	reservation := models.Reservation{
+		FirstName:  req.FirstName,
+		LastName:   req.LastName,
+		Email:      req.Email,
+		Phone:      req.Phone,
+		StartDate:  startDate,
+		EndDate:    endDate,
+		RoomID:     room.ID,
+		Room:       room,
+		TotalPrice: quote.Total,
+	}
+
+	reservation.ID, err = m.DB.BookRoom(r.Context(), reservation)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.apiError(w, http.StatusConflict, "the room is not available for the selected dates")
+		return
+	}
+	if err != nil {
+		m.apiServerError(w, r, err)
+		return
+	}
+	m.App.Metrics.Reservation(metrics.SourceAPI)
+
+	m.sendReservationEmails(r.Context(), reservation)
+
+	out := toAPIReservation(reservation)
+	out.Reference = m.reservationReference(reservation.ID)
+
+	w.Header().Set("Location", "/api/v1/reservations/"+out.Reference)
+	m.writeJSON(w, http.StatusCreated, out)
+}
+
+//APIReservation returns the reservation of a guest, found by its signed reference
+func (m *Repository) APIReservation(w http.ResponseWriter, r *http.Request) {
+	ref := chi.URLParam(r, "ref")
+
+	res, err := m.reservationFromReference(r.Context(), ref)
+	if err != nil {
+		m.apiError(w, http.StatusNotFound, "reservation not found")
+		return
+	}
+
+	out := toAPIReservation(res)
+	out.Reference = ref
+	m.writeJSON(w, http.StatusOK, out)
+}
+
+//APICancelReservation cancels the reservation of a guest, found by its signed reference
+func (m *Repository) APICancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, err := m.reservationFromReference(r.Context(), chi.URLParam(r, "ref"))
+	if err != nil {
+		m.apiError(w, http.StatusNotFound, "reservation not found")
+		return
+	}
+
+	err = m.DB.DeleteReservation(r.Context(), res.ID)
+	if err != nil {
+		m.apiServerError(w, r, err)
+		return
+	}
+
+	m.sendCancellationEmails(r.Context(), res)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//APIAdminReservations lists all the reservations, or only the new ones with ?filter=new
+func (m *Repository) APIAdminReservations(w http.ResponseWriter, r *http.Request) {
+	var reservations []models.Reservation
+	var err error
+
+	switch r.URL.Query().Get("filter") {
+	case "":
+		reservations, err = m.DB.AllReservations(r.Context())
+	case "new":
+		reservations, err = m.DB.AllNewReservations(r.Context())
+	default:
+		m.apiError(w, http.StatusBadRequest, "filter can only be new")
+		return
+	}
+	if err != nil {
+		m.apiServerError(w, r, err)
+		return
+	}
+
+	out := []apiReservation{}
+	for _, x := range reservations {
+		out = append(out, toAPIReservation(x))
+	}
+
+	m.writeJSON(w, http.StatusOK, out)
+}
+
+//APIAdminReservation returns a reservation by id
+func (m *Repository) APIAdminReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.apiReservationFromID(w, r)
+	if !ok {
+		return
+	}
+
+	m.writeJSON(w, http.StatusOK, toAPIReservation(res))
+}
+
+//APIAdminProcessReservation marks a reservation as processed
+func (m *Repository) APIAdminProcessReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.apiReservationFromID(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.UpdateProcessedForReservation(r.Context(), res.ID, 1)
+	if err != nil {
+		m.apiServerError(w, r, err)
+		return
+	}
+
+	res.Processed = 1
+	m.writeJSON(w, http.StatusOK, toAPIReservation(res))
+}
+
+//APIAdminDeleteReservation deletes a reservation, like the delete button of the admin
+func (m *Repository) APIAdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.apiReservationFromID(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.DeleteReservation(r.Context(), res.ID)
+	if err != nil {
+		m.apiServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//APIAdminBlocks lists the owner blocks of all the rooms
+func (m *Repository) APIAdminBlocks(w http.ResponseWriter, r *http.Request) {
+	blocks, err := m.DB.AllRoomBlocks(r.Context())
+	if err != nil {
+		m.apiServerError(w, r, err)
+		return
+	}
+
+	out := []apiBlock{}
+	for _, x := range blocks {
+		out = append(out, toAPIBlock(x))
+	}
+
+	m.writeJSON(w, http.StatusOK, out)
+}
+
+//APIAdminPostBlock blocks a room, with the same rules of the admin blocks page
+func (m *Repository) APIAdminPostBlock(w http.ResponseWriter, r *http.Request) {
+	var req apiBlockRequest
+	if !m.readJSON(w, r, &req) {
+		return
+	}
+
+	form := forms.New(url.Values{
+		"room_id":      {strconv.Itoa(req.RoomID)},
+		"start_date":   {req.StartDate},
+		"end_date":     {req.EndDate},
+		"recurrence":   {req.Recurrence},
+		"repeat_until": {req.RepeatUntil},
+		"reason":       {req.Reason},
+	})
+	block := m.blockFromForm(r.Context(), form)
+	if !form.Valid() {
+		m.apiFormError(w, form)
+		return
+	}
+
+	var err error
+	block.ID, err = m.DB.InsertRoomBlock(r.Context(), block)
+	if errors.Is(err, repository.ErrBlockOverlapsReservation) {
+		m.apiError(w, http.StatusConflict, "the room has reservations in the period")
+		return
+	}
+	if err != nil {
+		m.apiServerError(w, r, err)
+		return
+	}
+
+	if block.Recurrence == models.RecurrenceNone {
+		block.RepeatUntil = block.EndDate
+	}
+	m.writeJSON(w, http.StatusCreated, toAPIBlock(block))
+}
+
+//APIAdminDeleteBlock deletes an owner block with all its occurrences
+func (m *Repository) APIAdminDeleteBlock(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.apiError(w, http.StatusBadRequest, "invalid block id")
+		return
+	}
+
+	err = m.DB.DeleteRoomBlock(r.Context(), id)
+	if err != nil {
+		m.apiServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//apiReservationFromID gets the reservation of the id in the url, answering with an error if it can't
+func (m *Repository) apiReservationFromID(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.apiError(w, http.StatusBadRequest, "invalid reservation id")
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.apiError(w, http.StatusNotFound, "reservation not found")
+		return res, false
+	}
+	if err != nil {
+		m.apiServerError(w, r, err)
+		return res, false
+	}
+	return res, true
+}
+
+//readJSON decodes the body of the request into dst, answering with a bad request if it can't
+func (m *Repository) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		m.apiError(w, http.StatusBadRequest, fmt.Sprintf("invalid json body: %s", err))
+		return false
+	}
+	//nel body ci deve essere un solo oggetto
+	if dec.Decode(&struct{}{}) != io.EOF {
+		m.apiError(w, http.StatusBadRequest, "invalid json body: only one object is allowed")
+		return false
+	}
+	return true
+}
+
+func (m *Repository) writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	m.writeEnvelope(w, status, apiEnvelope{Data: data})
+}
+
+func (m *Repository) apiError(w http.ResponseWriter, status int, message string) {
+	m.writeEnvelope(w, status, apiEnvelope{Error: &apiError{Status: status, Message: message}})
+}
+
+//apiFormError answers with the validation errors of every field
+func (m *Repository) apiFormError(w http.ResponseWriter, form *forms.Form) {
+	m.writeEnvelope(w, http.StatusUnprocessableEntity, apiEnvelope{Error: &apiError{
+		Status:  http.StatusUnprocessableEntity,
+		Message: "invalid data",
+		Fields:  map[string][]string(form.Errors),
+	}})
+}
+
+//apiServerError logs the error and answers with a generic message, the details are only for us
+func (m *Repository) apiServerError(w http.ResponseWriter, r *http.Request, err error) {
+	m.App.Logger.Ctx(r.Context()).Error("api server error", "error", err)
+	m.apiError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
+
+func (m *Repository) writeEnvelope(w http.ResponseWriter, status int, env apiEnvelope) {
+	out, err := json.Marshal(env)
+	if err != nil {
+		m.App.Logger.Error("can't encode the api answer", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+func toAPIRoom(room models.Room) apiRoom {
+	photos := room.Photos
+	if photos == nil {
+		photos = []string{}
+	}
+	return apiRoom{
+		ID:          room.ID,
+		Name:        room.RoomName,
+		Slug:        room.Slug,
+		Description: room.Description,
+		Capacity:    room.Capacity,
+		Photos:      photos,
+		BaseRate:    room.BaseRate,
+		WeekendRate: room.WeekendRate,
+		MinStay:     room.MinStay,
+	}
+}
+
+func toAPIReservation(res models.Reservation) apiReservation {
+	return apiReservation{
+		ID:         res.ID,
+		FirstName:  res.FirstName,
+		LastName:   res.LastName,
+		Email:      res.Email,
+		Phone:      res.Phone,
+		RoomID:     res.RoomID,
+		RoomName:   res.Room.RoomName,
+		StartDate:  res.StartDate.Format("2006-01-02"),
+		EndDate:    res.EndDate.Format("2006-01-02"),
+		TotalPrice: res.TotalPrice,
+		Processed:  res.Processed,
+	}
+}
+
+func toAPIBlock(b models.RoomBlock) apiBlock {
+	return apiBlock{
+		ID:          b.ID,
+		RoomID:      b.RoomID,
+		RoomName:    b.Room.RoomName,
+		StartDate:   b.StartDate.Format("2006-01-02"),
+		EndDate:     b.EndDate.Format("2006-01-02"),
+		Recurrence:  b.Recurrence,
+		RepeatUntil: b.RepeatUntil.Format("2006-01-02"),
+		Reason:      b.Reason,
+	}
+}
@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Laura470/bookings/internal/repository/dbrepo"
+)
+
+var apiTests = []struct {
+	name               string
+	method             string
+	url                string
+	token              string
+	body               string
+	expectedStatusCode int
+	expectedInBody     string
+}{
+	{"no token", "GET", "/api/v1/rooms", "", "", http.StatusUnauthorized, "missing api token"},
+	{"invalid token", "GET", "/api/v1/rooms", "wrong-token", "", http.StatusUnauthorized, "invalid api token"},
+	{"rooms", "GET", "/api/v1/rooms", "public-token", "", http.StatusOK, `"slug":"generals-quarters"`},
+	{"room", "GET", "/api/v1/rooms/1", "public-token", "", http.StatusOK, `"base_rate":12000`},
+	{"room invalid id", "GET", "/api/v1/rooms/one", "public-token", "", http.StatusBadRequest, "invalid room id"},
+	{"room not found", "GET", "/api/v1/rooms/3", "public-token", "", http.StatusNotFound, "room not found"},
+	{"unknown endpoint", "GET", "/api/v1/nothing", "public-token", "", http.StatusNotFound, "no such endpoint"},
+	{"wrong method", "PUT", "/api/v1/rooms", "public-token", "", http.StatusMethodNotAllowed, "method PUT not allowed"},
+
+	//2040-01-02 è un lunedì, due notti a 12000
+	{"availability", "GET", "/api/v1/availability?start=2040-01-02&end=2040-01-04", "public-token", "",
+		http.StatusOK, `"total_price":24000`},
+	{"availability below minimum stay", "GET", "/api/v1/availability?start=2040-07-02&end=2040-07-03", "public-token", "",
+		http.StatusOK, `"reason":"the minimum stay is 3 nights"`},
+	{"availability none", "GET", "/api/v1/availability?start=2050-01-02&end=2050-01-04", "public-token", "",
+		http.StatusOK, `"rooms":[]`},
+	{"availability invalid start", "GET", "/api/v1/availability?start=tomorrow&end=2040-01-04", "public-token", "",
+		http.StatusBadRequest, "start must be a date"},
+	{"availability end before start", "GET", "/api/v1/availability?start=2040-01-04&end=2040-01-02", "public-token", "",
+		http.StatusBadRequest, "end must be after start"},
+
+	{"book", "POST", "/api/v1/reservations", "public-token",
+		`{"room_id":1,"start_date":"2040-01-02","end_date":"2040-01-04","first_name":"John","last_name":"Smith","email":"john@smith.com"}`,
+		http.StatusCreated, `"total_price":24000`},
+	{"book invalid data", "POST", "/api/v1/reservations", "public-token",
+		`{"room_id":1,"start_date":"2040-01-02","end_date":"2040-01-04","first_name":"J","last_name":"Smith","email":"john"}`,
+		http.StatusUnprocessableEntity, `"email":["Invalid email address"]`},
+	{"book no room", "POST", "/api/v1/reservations", "public-token",
+		`{"room_id":99,"start_date":"2040-01-02","end_date":"2040-01-04","first_name":"John","last_name":"Smith","email":"john@smith.com"}`,
+		http.StatusUnprocessableEntity, `"room_id":["No such room"]`},
+	{"book below minimum stay", "POST", "/api/v1/reservations", "public-token",
+		`{"room_id":1,"start_date":"2040-07-02","end_date":"2040-07-03","first_name":"John","last_name":"Smith","email":"john@smith.com"}`,
+		http.StatusUnprocessableEntity, "the minimum stay is 3 nights"},
+	{"book not available", "POST", "/api/v1/reservations", "public-token",
+		`{"room_id":1,"start_date":"2050-01-02","end_date":"2050-01-04","first_name":"John","last_name":"Smith","email":"john@smith.com"}`,
+		http.StatusConflict, "not available"},
+	{"book invalid json", "POST", "/api/v1/reservations", "public-token", `{"room_id":`, http.StatusBadRequest, "invalid json body"},
+	{"book unknown field", "POST", "/api/v1/reservations", "public-token", `{"room":1}`, http.StatusBadRequest, "invalid json body"},
+
+	{"reservation", "GET", "/api/v1/reservations/" + testReference(1), "public-token", "", http.StatusOK, `"first_name":"John"`},
+	{"reservation invalid reference", "GET", "/api/v1/reservations/invalid", "public-token", "", http.StatusNotFound, "reservation not found"},
+	//la 3 è quella di bookAll nella stanza 2, la 1 serve ancora all'admin e la 2 al blocco che si sovrappone
+	{"cancel", "DELETE", "/api/v1/reservations/" + testReference(3), "public-token", "", http.StatusNoContent, ""},
+	{"cancel invalid reference", "DELETE", "/api/v1/reservations/invalid", "public-token", "", http.StatusNotFound, "reservation not found"},
+
+	{"admin with public token", "GET", "/api/v1/admin/reservations", "public-token", "", http.StatusForbidden, "scope admin"},
+	{"admin reservations", "GET", "/api/v1/admin/reservations", "admin-token", "", http.StatusOK, `"first_name":"John"`},
+	{"admin new reservations", "GET", "/api/v1/admin/reservations?filter=new", "admin-token", "", http.StatusOK, `"first_name":"John"`},
+	{"admin reservations invalid filter", "GET", "/api/v1/admin/reservations?filter=old", "admin-token", "", http.StatusBadRequest, "filter"},
+	{"admin reservation", "GET", "/api/v1/admin/reservations/1", "admin-token", "", http.StatusOK, `"room_name":"General's Quarters"`},
+	{"admin reservation not found", "GET", "/api/v1/admin/reservations/1000", "admin-token", "", http.StatusNotFound, "reservation not found"},
+	{"admin process", "POST", "/api/v1/admin/reservations/1/processed", "admin-token", "", http.StatusOK, `"processed":1`},
+	{"admin delete", "DELETE", "/api/v1/admin/reservations/1", "admin-token", "", http.StatusNoContent, ""},
+	{"admin blocks", "GET", "/api/v1/admin/blocks", "admin-token", "", http.StatusOK, `"reason":"Renovation"`},
+	{"admin post block", "POST", "/api/v1/admin/blocks", "admin-token",
+		`{"room_id":1,"start_date":"2040-03-01","end_date":"2040-03-03","reason":"Painting"}`,
+		http.StatusCreated, `"repeat_until":"2040-03-03"`},
+	{"admin post block invalid", "POST", "/api/v1/admin/blocks", "admin-token",
+		`{"room_id":1,"start_date":"2040-03-03","end_date":"2040-03-01"}`,
+		http.StatusUnprocessableEntity, "can't end before it starts"},
+	{"admin post block overlap", "POST", "/api/v1/admin/blocks", "admin-token",
+		`{"room_id":1,"start_date":"2050-01-02","end_date":"2050-01-04"}`,
+		http.StatusConflict, "the room has reservations"},
+	{"admin delete block", "DELETE", "/api/v1/admin/blocks/1", "admin-token", "", http.StatusNoContent, ""},
+}
+
+func TestAPI(t *testing.T) {
+	db := withTestRepo(t)
+	bookAll(t, db, "2050-01-02", "2050-01-04")
+	routes := getRoutes()
+
+	for _, e := range apiTests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.body))
+		if e.token != "" {
+			req.Header.Set("Authorization", "Bearer "+e.token)
+		}
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body.String())
+		}
+		if !strings.Contains(rr.Body.String(), e.expectedInBody) {
+			t.Errorf("%s: expected %q in the body but got %s", e.name, e.expectedInBody, rr.Body.String())
+		}
+		if rr.Code != http.StatusNoContent && rr.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s: expected a json response but got %s", e.name, rr.Header().Get("Content-Type"))
+		}
+	}
+}
+
+var apiDatabaseErrorTests = []struct {
+	name               string
+	method             string
+	url                string
+	token              string
+	body               string
+	fail               func(db *dbrepo.FaultyRepo)
+	expectedStatusCode int
+	expectedInBody     string
+}{
+	{"availability", "GET", "/api/v1/availability?start=2040-01-02&end=2040-01-04", "public-token", "",
+		func(db *dbrepo.FaultyRepo) { db.Fail("SearchAvailabilityForAllRooms", errDB) },
+		http.StatusInternalServerError, "Internal Server Error"},
+	//se la mail non si riesce a mettere in coda la prenotazione c'è comunque
+	{"book mail not queued", "POST", "/api/v1/reservations", "public-token",
+		`{"room_id":1,"start_date":"2040-01-02","end_date":"2040-01-04","first_name":"John","last_name":"Smith","email":"fail@here.com"}`,
+		func(db *dbrepo.FaultyRepo) { failMailTo(db, "fail@here.com") },
+		http.StatusCreated, `"email":"fail@here.com"`},
+	{"admin delete", "DELETE", "/api/v1/admin/reservations/1", "admin-token", "",
+		func(db *dbrepo.FaultyRepo) { db.Fail("DeleteReservation", errDB) },
+		http.StatusInternalServerError, "Internal Server Error"},
+}
+
+func TestAPI_DatabaseErrors(t *testing.T) {
+	for _, e := range apiDatabaseErrorTests {
+		db := withTestRepo(t)
+		e.fail(db)
+
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.body))
+		req.Header.Set("Authorization", "Bearer "+e.token)
+		rr := httptest.NewRecorder()
+		getRoutes().ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body.String())
+		}
+		if !strings.Contains(rr.Body.String(), e.expectedInBody) {
+			t.Errorf("%s: expected %q in the body but got %s", e.name, e.expectedInBody, rr.Body.String())
+		}
+	}
+}
+
+func TestAPI_ErrorEnvelope(t *testing.T) {
+	withTestRepo(t)
+	req, _ := http.NewRequest("GET", "/api/v1/rooms", nil)
+	rr := httptest.NewRecorder()
+	getRoutes().ServeHTTP(rr, req)
+
+	if rr.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Errorf("expected the WWW-Authenticate header but got %q", rr.Header().Get("WWW-Authenticate"))
+	}
+
+	var env struct {
+		Error apiError `json:"error"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Error.Status != http.StatusUnauthorized {
+		t.Errorf("expected status 401 in the envelope but got %d", env.Error.Status)
+	}
+}
+
+func TestAPI_PostReservationLocation(t *testing.T) {
+	withTestRepo(t)
+	body := `{"room_id":1,"start_date":"2040-01-02","end_date":"2040-01-04","first_name":"John","last_name":"Smith","email":"john@smith.com"}`
+	req, _ := http.NewRequest("POST", "/api/v1/reservations", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer public-token")
+	rr := httptest.NewRecorder()
+	getRoutes().ServeHTTP(rr, req)
+
+	var env struct {
+		Data apiReservation `json:"data"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//con il reference si deve poter leggere la prenotazione, la 1 è quella di seedTestRepo
+	if env.Data.Reference != testReference(2) {
+		t.Errorf("expected the reference of the reservation 2 but got %q", env.Data.Reference)
+	}
+	if rr.Header().Get("Location") != "/api/v1/reservations/"+env.Data.Reference {
+		t.Errorf("wrong location %q", rr.Header().Get("Location"))
+	}
+}
+
+var apiChangedUserTests = []struct {
+	name               string
+	change             func(db *dbrepo.FaultyRepo) error
+	token              string
+	url                string
+	expectedStatusCode int
+}{
+	{"disabled public", func(db *dbrepo.FaultyRepo) error { return db.UpdateActiveForUser(context.Background(), 1, 0) },
+		"public-token", "/api/v1/rooms", http.StatusUnauthorized},
+	{"disabled admin", func(db *dbrepo.FaultyRepo) error { return db.UpdateActiveForUser(context.Background(), 1, 0) },
+		"admin-token", "/api/v1/admin/reservations", http.StatusUnauthorized},
+	{"demoted admin", func(db *dbrepo.FaultyRepo) error {
+		u, err := db.GetUserByID(context.Background(), 1)
+		if err != nil {
+			return err
+		}
+		u.AccessLevel = 3
+		return db.UpdateUser(context.Background(), u)
+	}, "admin-token", "/api/v1/admin/reservations", http.StatusUnauthorized},
+}
+
+//disabilitando o declassando l'utente i suoi token si cancellano
+func TestAPI_TokenOfChangedUser(t *testing.T) {
+	for _, e := range apiChangedUserTests {
+		db := withTestRepo(t)
+		if err := e.change(db); err != nil {
+			t.Fatal(err)
+		}
+
+		req, _ := http.NewRequest("GET", e.url, nil)
+		req.Header.Set("Authorization", "Bearer "+e.token)
+		rr := httptest.NewRecorder()
+		getRoutes().ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+var adminAPITokensTests = []struct {
+	name               string
+	url                string
+	postedData         map[string]string
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"create", "/admin/api-tokens", map[string]string{"name": "Partner", "scope": "public"}, http.StatusSeeOther, "/admin/api-tokens"},
+	{"missing name", "/admin/api-tokens", map[string]string{"scope": "public"}, http.StatusOK, ""},
+	{"invalid scope", "/admin/api-tokens", map[string]string{"name": "Partner", "scope": "root"}, http.StatusOK, ""},
+}
+
+func TestAdminPostAPIToken(t *testing.T) {
+	withTestRepo(t)
+	for _, e := range adminAPITokensTests {
+		data := make([]string, 0, len(e.postedData))
+		for k, v := range e.postedData {
+			data = append(data, k+"="+v)
+		}
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(strings.Join(data, "&")))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostAPIToken).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+
+		//il token in chiaro resta in sessione per mostrarlo una volta
+		if e.expectedStatusCode == http.StatusSeeOther && session.GetString(ctx, "api_token") == "" {
+			t.Errorf("%s: expected the new token in the session", e.name)
+		}
+	}
+}
+
+func TestAdminAPITokens(t *testing.T) {
+	withTestRepo(t)
+	req, _ := http.NewRequest("GET", "/admin/api-tokens", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "api_token", "the-new-token")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminAPITokens).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200 but got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "the-new-token") {
+		t.Error("expected the new token on the page")
+	}
+	if session.Exists(ctx, "api_token") {
+		t.Error("the new token must be shown only once")
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Laura470/bookings/internal/forms"
+	"github.com/Laura470/bookings/internal/helpers"
+	"github.com/Laura470/bookings/internal/models"
+	"github.com/Laura470/bookings/internal/render"
+	"github.com/Laura470/bookings/internal/tokens"
+	"github.com/go-chi/chi"
+)
+
+//AdminAPITokens shows the api tokens, with the form to create one
+func (m *Repository) AdminAPITokens(w http.ResponseWriter, r *http.Request) {
+	m.renderAPITokens(w, r, forms.New(nil))
+}
+
+//AdminPostAPIToken creates an api token, the clear token is shown only once, we keep just its hash
+func (m *Repository) AdminPostAPIToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "scope")
+	scope := form.Get("scope")
+	if scope != "" && scope != models.ScopePublic && scope != models.ScopeAdmin {
+		form.Errors.Add("scope", "Invalid scope")
+	}
+
+	if !form.Valid() {
+		m.renderAPITokens(w, r, form)
+		return
+	}
+
+	token, err := tokens.Generate()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	_, err = m.DB.InsertAPIToken(r.Context(), models.APIToken{
+		UserID:    m.App.Session.GetInt(r.Context(), "user_id"),
+		Name:      strings.TrimSpace(form.Get("name")),
+		TokenHash: tokens.Hash(token),
+		Scope:     scope,
+	})
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	//il token in chiaro lo mostro una volta sola, poi non si può più recuperare
+	m.App.Session.Put(r.Context(), "api_token", token)
+	m.App.Session.Put(r.Context(), "flash", "Token created, copy it now: it won't be shown again")
+	http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+}
+
+//AdminDeleteAPIToken revokes an api token
+func (m *Repository) AdminDeleteAPIToken(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteAPIToken(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Token revoked")
+	http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+}
+
+//renderAPITokens renders the api tokens page, the form keeps what the admin posted
+func (m *Repository) renderAPITokens(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	apiTokens, err := m.DB.AllAPITokens(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["tokens"] = apiTokens
+
+	stringMap := make(map[string]string)
+	stringMap["new_token"] = m.App.Session.PopString(r.Context(), "api_token")
+
+	render.Template(w, r, "admin-api-tokens.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Laura470/bookings/internal/forms"
+	"github.com/Laura470/bookings/internal/helpers"
+	"github.com/Laura470/bookings/internal/models"
+	"github.com/Laura470/bookings/internal/render"
+	"github.com/Laura470/bookings/internal/repository"
+	"github.com/go-chi/chi"
+)
+
+//maxBlockRepeat is how far a recurring block can go, every occurrence is a row in room_restrictions
+const maxBlockRepeat = 2 * 365 * 24 * time.Hour
+
+//AdminBlocks shows the owner blocks of all the rooms, with the form to add one
+func (m *Repository) AdminBlocks(w http.ResponseWriter, r *http.Request) {
+	m.renderBlocks(w, r, forms.New(nil))
+}
+
+//AdminPostBlock blocks a room for a period, once or repeated every week or month
+func (m *Repository) AdminPostBlock(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	block := m.blockFromForm(r.Context(), form)
+
+	if !form.Valid() {
+		m.renderBlocks(w, r, form)
+		return
+	}
+
+	_, err = m.DB.InsertRoomBlock(r.Context(), block)
+	if errors.Is(err, repository.ErrBlockOverlapsReservation) {
+		form.Errors.Add("start_date", "The room has reservations in the period, move or cancel them first")
+		m.renderBlocks(w, r, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room blocked")
+	http.Redirect(w, r, "/admin/blocks", http.StatusSeeOther)
+}
+
+//AdminDeleteBlock deletes an owner block with all its occurrences
+func (m *Repository) AdminDeleteBlock(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteRoomBlock(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Block deleted")
+	http.Redirect(w, r, "/admin/blocks", http.StatusSeeOther)
+}
+
+//blockFromForm validates the fields of a new block, used by the admin page and by the api
+func (m *Repository) blockFromForm(ctx context.Context, form *forms.Form) models.RoomBlock {
+	form.Required("room_id", "start_date", "end_date")
+
+	roomID, _ := strconv.Atoi(form.Get("room_id"))
+	block := models.RoomBlock{
+		RoomID:     roomID,
+		Recurrence: form.Get("recurrence"),
+		Reason:     strings.TrimSpace(form.Get("reason")),
+	}
+
+	layout := "2006-01-02"
+	var err error
+	block.StartDate, err = time.Parse(layout, form.Get("start_date"))
+	if err != nil && form.Errors.Get("start_date") == "" {
+		form.Errors.Add("start_date", "Invalid date")
+	}
+	block.EndDate, err = time.Parse(layout, form.Get("end_date"))
+	if err != nil && form.Errors.Get("end_date") == "" {
+		form.Errors.Add("end_date", "Invalid date")
+	}
+	if form.Errors.Get("start_date") == "" && form.Errors.Get("end_date") == "" && block.EndDate.Before(block.StartDate) {
+		form.Errors.Add("end_date", "The block can't end before it starts")
+	}
+
+	switch block.Recurrence {
+	case models.RecurrenceNone:
+	case models.RecurrenceWeekly, models.RecurrenceMonthly:
+		form.Required("repeat_until")
+		block.RepeatUntil, err = time.Parse(layout, form.Get("repeat_until"))
+		if err != nil && form.Errors.Get("repeat_until") == "" {
+			form.Errors.Add("repeat_until", "Invalid date")
+		}
+		if err == nil && block.RepeatUntil.Sub(block.StartDate) > maxBlockRepeat {
+			form.Errors.Add("repeat_until", "A block can be repeated for two years at most")
+		}
+	default:
+		form.Errors.Add("recurrence", "Invalid repetition")
+	}
+
+	if _, err := m.DB.GetRoomByID(ctx, roomID); err != nil && form.Errors.Get("room_id") == "" {
+		form.Errors.Add("room_id", "Choose a room")
+	}
+
+	return block
+}
+
+//renderBlocks renders the blocks page, the form keeps what the admin posted
+func (m *Repository) renderBlocks(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	blocks, err := m.DB.AllRoomBlocks(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["blocks"] = blocks
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-blocks.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Laura470/bookings/internal/models"
+)
+
+var adminPostBlockTests = []struct {
+	name             string
+	postedData       url.Values
+	expectedCode     int
+	expectedLocation string
+	expectedHTML     string
+}{
+	{
+		name: "two-weeks",
+		postedData: url.Values{
+			"room_id":    {"1"},
+			"start_date": {"2040-03-02"},
+			"end_date":   {"2040-03-15"},
+			"reason":     {"Renovation"},
+		},
+		expectedCode:     http.StatusSeeOther,
+		expectedLocation: "/admin/blocks",
+	},
+	{
+		name: "every-monday",
+		postedData: url.Values{
+			"room_id":      {"2"},
+			"start_date":   {"2040-01-02"},
+			"end_date":     {"2040-01-02"},
+			"recurrence":   {"weekly"},
+			"repeat_until": {"2040-12-31"},
+		},
+		expectedCode:     http.StatusSeeOther,
+		expectedLocation: "/admin/blocks",
+	},
+	{
+		name: "repeated-without-until",
+		postedData: url.Values{
+			"room_id":    {"1"},
+			"start_date": {"2040-01-01"},
+			"end_date":   {"2040-01-07"},
+			"recurrence": {"monthly"},
+		},
+		expectedCode: http.StatusOK,
+		expectedHTML: `id="repeat_until"`,
+	},
+	{
+		name: "repeated-too-long",
+		postedData: url.Values{
+			"room_id":      {"1"},
+			"start_date":   {"2040-01-01"},
+			"end_date":     {"2040-01-07"},
+			"recurrence":   {"monthly"},
+			"repeat_until": {"2045-01-01"},
+		},
+		expectedCode: http.StatusOK,
+		expectedHTML: "two years at most",
+	},
+	{
+		name: "invalid-recurrence",
+		postedData: url.Values{
+			"room_id":    {"1"},
+			"start_date": {"2040-01-01"},
+			"end_date":   {"2040-01-07"},
+			"recurrence": {"daily"},
+		},
+		expectedCode: http.StatusOK,
+		expectedHTML: "Invalid repetition",
+	},
+	{
+		name: "ends-before-start",
+		postedData: url.Values{
+			"room_id":    {"1"},
+			"start_date": {"2040-01-07"},
+			"end_date":   {"2040-01-01"},
+		},
+		expectedCode: http.StatusOK,
+		expectedHTML: "can&#39;t end before it starts",
+	},
+	{
+		name: "non-existent-room",
+		postedData: url.Values{
+			"room_id":    {"100"},
+			"start_date": {"2040-01-01"},
+			"end_date":   {"2040-01-07"},
+		},
+		expectedCode: http.StatusOK,
+		expectedHTML: "Choose a room",
+	},
+	{
+		name: "overlaps-reservation",
+		postedData: url.Values{
+			"room_id":    {"1"},
+			"start_date": {"2040-06-01"},
+			"end_date":   {"2040-06-07"},
+		},
+		expectedCode: http.StatusOK,
+		expectedHTML: "has reservations in the period",
+	},
+}
+
+func TestAdminPostBlock(t *testing.T) {
+	withTestRepo(t)
+	for _, e := range adminPostBlockTests {
+		req, _ := http.NewRequest("POST", "/admin/blocks", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostBlock)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc == nil || actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s but got location %v", e.name, e.expectedLocation, actualLoc)
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestAdminDeleteBlock(t *testing.T) {
+	withTestRepo(t)
+	req, _ := http.NewRequest("GET", "/admin/blocks/1/delete/do", nil)
+	ctx := getCtx(req)
+	ctx = withURLParams(ctx, map[string]string{"id": "1"})
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminDeleteBlock)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminDeleteBlock returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+	if session.GetString(ctx, "flash") != "Block deleted" {
+		t.Error("AdminDeleteBlock did not put the flash message in session")
+	}
+}
+
+func TestAdminReservationsCalendar_Blocks(t *testing.T) {
+	db := withTestRepo(t)
+	restrictions, err := db.GetRestrictionForRoomByDate(context.Background(), 1, date("2040-01-10"), date("2040-01-10"))
+	if err != nil || len(restrictions) != 1 {
+		t.Fatalf("can't find the one day block: %v %v", restrictions, err)
+	}
+
+	req, _ := http.NewRequest("GET", "/admin/reservations-calendar?y=2040&m=1", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminReservationsCalendar)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("AdminReservationsCalendar returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	//il blocco del proprietario dal 20 al 22 deve comparire in tutti e tre i giorni
+	body := rr.Body.String()
+	if n := strings.Count(body, `href="/admin/blocks#block-1"`); n != 3 {
+		t.Errorf("expected the owner block on 3 days but found it on %d", n)
+	}
+	if !strings.Contains(body, `title="Renovation"`) {
+		t.Error("the reason of the owner block is not shown")
+	}
+	if !strings.Contains(body, "remove_block_1_2040-01-10") {
+		t.Error("the one day block is not shown as a checkbox")
+	}
+	//i giorni presi sul calendario esterno non si tolgono con le checkbox
+	if n := strings.Count(body, `title="Booking site"`); n != 2 {
+		t.Errorf("expected the external calendar on 2 days but found it on %d", n)
+	}
+	if strings.Contains(body, "remove_block_1_2040-01-25") {
+		t.Error("a day of the external calendar is shown as a checkbox")
+	}
+
+	//nella session ci sono solo i blocchi che si tolgono con le checkbox
+	blockMap, _ := session.Get(ctx, "block_map_1").(map[string]int)
+	if blockMap["2040-01-10"] != restrictions[0].ID || blockMap["2040-01-20"] != 0 {
+		t.Errorf("wrong block map in session: %v", blockMap)
+	}
+}
+
+// dal calendario un giorno si blocca come un blocco del proprietario, e non sopra una prenotazione
+func TestAdminPostReservationsCalendar_Blocks(t *testing.T) {
+	db := withTestRepo(t)
+	postedData := url.Values{
+		"y":                      {"2040"},
+		"m":                      {"6"},
+		"add_block_1_2040-06-02": {"1"},
+		"add_block_2_2040-06-02": {"1"},
+	}
+	req, _ := http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, id := range []int{1, 2, 3} {
+		session.Put(ctx, fmt.Sprintf("block_map_%d", id), map[string]int{})
+	}
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.AdminPostReservationsCalendar).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected 303 but got %d", rr.Code)
+	}
+	if rr.Header().Get("Location") != "/admin/reservations-calendar?y=2040&m=6" {
+		t.Errorf("wrong location %s", rr.Header().Get("Location"))
+	}
+	//la stanza 1 ha la prenotazione di John Smith dal primo al 3 giugno
+	if msg := session.GetString(ctx, "error"); !strings.Contains(msg, "2040-06-02") {
+		t.Errorf("expected the day with the reservation in the error but got %q", msg)
+	}
+
+	blocks, err := db.AllRoomBlocks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calendarBlocks []models.RoomBlock
+	for _, b := range blocks {
+		if b.Reason == "Blocked from the calendar" {
+			calendarBlocks = append(calendarBlocks, b)
+		}
+	}
+	if len(calendarBlocks) != 1 || calendarBlocks[0].RoomID != 2 || !calendarBlocks[0].StartDate.Equal(date("2040-06-02")) {
+		t.Errorf("expected only the block of room 2 on 2040-06-02 but got %+v", calendarBlocks)
+	}
+}
@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Laura470/bookings/internal/forms"
+	"github.com/Laura470/bookings/internal/helpers"
+	"github.com/Laura470/bookings/internal/ical"
+	"github.com/Laura470/bookings/internal/models"
+	"github.com/Laura470/bookings/internal/render"
+	"github.com/go-chi/chi"
+)
+
+//icalClient reads the external calendars, a slow booking site must not block the sync of the others
+var icalClient = &http.Client{Timeout: 30 * time.Second}
+
+//RoomCalendar exports the reservations and the blocks of a room as an ics calendar, for the other booking sites.
+//The events read from the external calendars are left out, they already know them
+func (m *Repository) RoomCalendar(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if err != nil || room.ID == 0 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	//un mese indietro e un anno avanti bastano ai siti di prenotazione
+	today := time.Now()
+	restrictions, err := m.DB.GetRestrictionForRoomByDate(r.Context(), room.ID, today.AddDate(0, -1, 0), today.AddDate(1, 0, 0))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	host := "bookings"
+	if u, err := url.Parse(m.App.BaseURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+
+	var events []ical.Event
+	for _, x := range restrictions {
+		if x.FeedID > 0 {
+			continue
+		}
+
+		//la reservation finisce il giorno della partenza, il blocco l'ultimo giorno bloccato
+		e := ical.Event{
+			UID:     fmt.Sprintf("restriction-%d@%s", x.ID, host),
+			Summary: "Blocked",
+			Start:   x.StartDate,
+			End:     x.EndDate.AddDate(0, 0, 1),
+			AllDay:  true,
+		}
+		if x.ReservationID > 0 {
+			e.Summary = "Reserved"
+			e.End = x.EndDate
+		}
+		events = append(events, e)
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", room.Slug+".ics"))
+	err = ical.Write(w, room.RoomName, events)
+	if err != nil {
+		m.App.Logger.Ctx(r.Context()).Error("can't write the calendar", "room_id", room.ID, "error", err)
+	}
+}
+
+//AdminRoomCalendars shows the external calendars of a room, with the address of its own calendar
+func (m *Repository) AdminRoomCalendars(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	m.renderRoomCalendars(w, r, room, forms.New(nil))
+}
+
+//AdminPostRoomCalendar adds an external calendar to a room, it's read with the next sync
+func (m *Repository) AdminPostRoomCalendar(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "url")
+	feed := models.ICalFeed{
+		RoomID: room.ID,
+		Name:   strings.TrimSpace(form.Get("name")),
+		URL:    strings.TrimSpace(form.Get("url")),
+	}
+	if feed.URL != "" && !ical.ValidURL(feed.URL) {
+		form.Errors.Add("url", "Invalid address, it must start with https://, http:// or webcal://")
+	}
+
+	if !form.Valid() {
+		m.renderRoomCalendars(w, r, room, form)
+		return
+	}
+
+	_, err = m.DB.InsertICalFeed(r.Context(), feed)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Calendar added, sync it to import its events")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/calendars", room.ID), http.StatusSeeOther)
+}
+
+//AdminDeleteRoomCalendar deletes an external calendar, the days it blocked are free again
+func (m *Repository) AdminDeleteRoomCalendar(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	feedID, err := strconv.Atoi(chi.URLParam(r, "feed"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteICalFeed(r.Context(), feedID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Calendar deleted")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/calendars", id), http.StatusSeeOther)
+}
+
+//AdminSyncRoomCalendars reads now the external calendars of a room, without waiting for the next sync
+func (m *Repository) AdminSyncRoomCalendars(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	feeds, err := m.DB.GetICalFeedsForRoom(r.Context(), room.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	failed := 0
+	for _, f := range feeds {
+		if err := m.SyncICalFeed(r.Context(), f); err != nil {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("%d calendars could not be read, see the errors below", failed))
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Calendars synced")
+	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/calendars", room.ID), http.StatusSeeOther)
+}
+
+//SyncICalFeeds reads all the external calendars, the errors are saved with every calendar
+func (m *Repository) SyncICalFeeds(ctx context.Context) error {
+	feeds, err := m.DB.AllICalFeeds(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range feeds {
+		//se il sito si spegne i calendari che mancano aspettano il prossimo giro
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := m.SyncICalFeed(ctx, f)
+		if err != nil {
+			m.App.Logger.Ctx(ctx).Warn("calendar not synced", "feed_id", f.ID, "url", f.URL, "error", err)
+		}
+	}
+	return nil
+}
+
+//SyncICalFeed reads an external calendar and replaces its restrictions with the days of its events,
+//the past ones are left out
+func (m *Repository) SyncICalFeed(ctx context.Context, feed models.ICalFeed) error {
+	events, err := ical.Fetch(ctx, icalClient, feed.URL)
+	if err == nil {
+		err = m.DB.ReplaceICalFeedRestrictions(ctx, feed, icalPeriods(events, time.Now()))
+	}
+
+	lastError := ""
+	if err != nil {
+		lastError = err.Error()
+	}
+	if updateErr := m.DB.UpdateICalFeedSync(ctx, feed.ID, time.Now(), lastError); updateErr != nil && err == nil {
+		err = updateErr
+	}
+	return err
+}
+
+//icalPeriods returns the days taken by the events, from their first to their last day, if they aren't over before today
+func icalPeriods(events []ical.Event, today time.Time) []models.Period {
+	y, mo, d := today.Date()
+	today = time.Date(y, mo, d, 0, 0, 0, 0, time.UTC)
+
+	var periods []models.Period
+	for _, e := range events {
+		first, last := e.Days()
+		if last.Before(today) {
+			continue
+		}
+		periods = append(periods, models.Period{Start: first, End: last})
+	}
+	return periods
+}
+
+//roomFromURL gets the room of the id in the url, answering with an error if it can't
+func (m *Repository) roomFromURL(w http.ResponseWriter, r *http.Request) (models.Room, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return models.Room{}, false
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return room, false
+	}
+	return room, true
+}
+
+//renderRoomCalendars renders the calendars page of a room, the form keeps what the admin posted
+func (m *Repository) renderRoomCalendars(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	feeds, err := m.DB.GetICalFeedsForRoom(r.Context(), room.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["feeds"] = feeds
+
+	stringMap := make(map[string]string)
+	stringMap["export_url"] = fmt.Sprintf("%s/rooms/%d/calendar.ics", m.App.BaseURL, room.ID)
+
+	render.Template(w, r, "admin-room-calendars.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Laura470/bookings/internal/ical"
+	"github.com/Laura470/bookings/internal/models"
+)
+
+func TestRoomCalendar(t *testing.T) {
+	db := withTestRepo(t)
+	//il calendario ha solo i giorni vicini a oggi, non quelli del 2040 di seedTestRepo
+	bg := context.Background()
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	_, err := db.BookRoom(bg, models.Reservation{FirstName: "John", LastName: "Smith", Email: "john@smith.com",
+		RoomID: 1, StartDate: today.AddDate(0, 0, 10), EndDate: today.AddDate(0, 0, 12)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.InsertRoomRestriction(bg, models.RoomRestriction{RoomID: 1, RestrictionID: models.RestrictionBlock,
+		StartDate: today.AddDate(0, 0, 20), EndDate: today.AddDate(0, 0, 20)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.InsertRoomBlock(bg, models.RoomBlock{RoomID: 1, Reason: "Painting",
+		StartDate: today.AddDate(0, 0, 30), EndDate: today.AddDate(0, 0, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.ReplaceICalFeedRestrictions(bg, models.ICalFeed{ID: 1, RoomID: 1},
+		[]models.Period{{Start: today.AddDate(0, 0, 40), End: today.AddDate(0, 0, 41)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := getRoutes()
+
+	req, _ := http.NewRequest("GET", "/rooms/1/calendar.ics", nil)
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 but got %d", rr.Code)
+	}
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/calendar") {
+		t.Errorf("wrong content type %s", rr.Header().Get("Content-Type"))
+	}
+
+	events, err := ical.Parse(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//la reservation, il blocco veloce e il blocco del proprietario, non il giorno preso sul calendario esterno
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events but got %d", len(events))
+	}
+	summaries := map[string]int{}
+	for _, e := range events {
+		summaries[e.Summary]++
+	}
+	if summaries["Reserved"] != 1 || summaries["Blocked"] != 2 {
+		t.Errorf("wrong events: %v", summaries)
+	}
+
+	//la reservation finisce il giorno della partenza, il blocco il giorno dopo il suo ultimo giorno
+	for _, e := range events {
+		days := int(e.End.Sub(e.Start).Hours() / 24)
+		if e.Summary == "Reserved" && days != 2 {
+			t.Errorf("expected the reservation to last 2 days but got %d", days)
+		}
+	}
+
+	for _, u := range []struct {
+		url  string
+		code int
+	}{
+		{"/rooms/100/calendar.ics", http.StatusNotFound},
+		{"/rooms/one/calendar.ics", http.StatusBadRequest},
+		{"/rooms/generals-quarters", http.StatusOK},
+	} {
+		req, _ := http.NewRequest("GET", u.url, nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+		if rr.Code != u.code {
+			t.Errorf("%s: expected %d but got %d", u.url, u.code, rr.Code)
+		}
+	}
+}
+
+var adminRoomCalendarTests = []struct {
+	name             string
+	params           map[string]string
+	handler          func(*Repository, http.ResponseWriter, *http.Request)
+	postedData       url.Values
+	expectedCode     int
+	expectedLocation string
+	expectedHTML     string
+}{
+	{
+		name:         "calendars-page",
+		params:       map[string]string{"id": "1"},
+		handler:      (*Repository).AdminRoomCalendars,
+		expectedCode: http.StatusOK,
+		expectedHTML: "/rooms/1/calendar.ics",
+	},
+	{
+		name:         "calendars-page-non-existent-room",
+		params:       map[string]string{"id": "100"},
+		handler:      (*Repository).AdminRoomCalendars,
+		expectedCode: http.StatusNotFound,
+	},
+	{
+		name:    "new-calendar",
+		params:  map[string]string{"id": "1"},
+		handler: (*Repository).AdminPostRoomCalendar,
+		postedData: url.Values{
+			"name": {"Other site"},
+			"url":  {"webcal://other.example/rooms/1.ics"},
+		},
+		expectedCode:     http.StatusSeeOther,
+		expectedLocation: "/admin/rooms/1/calendars",
+	},
+	{
+		name:    "new-calendar-missing-url",
+		params:  map[string]string{"id": "1"},
+		handler: (*Repository).AdminPostRoomCalendar,
+		postedData: url.Values{
+			"name": {"Other site"},
+		},
+		expectedCode: http.StatusOK,
+		expectedHTML: "cannot be blank",
+	},
+	{
+		name:    "new-calendar-invalid-url",
+		params:  map[string]string{"id": "1"},
+		handler: (*Repository).AdminPostRoomCalendar,
+		postedData: url.Values{
+			"name": {"Other site"},
+			"url":  {"other.example/rooms/1.ics"},
+		},
+		expectedCode: http.StatusOK,
+		expectedHTML: "Invalid address",
+	},
+	{
+		name:             "delete-calendar",
+		params:           map[string]string{"id": "1", "feed": "1"},
+		handler:          (*Repository).AdminDeleteRoomCalendar,
+		expectedCode:     http.StatusSeeOther,
+		expectedLocation: "/admin/rooms/1/calendars",
+	},
+}
+
+func TestAdminRoomCalendars(t *testing.T) {
+	withTestRepo(t)
+	for _, e := range adminRoomCalendarTests {
+		method := "GET"
+		if e.postedData != nil {
+			method = "POST"
+		}
+		req, _ := http.NewRequest(method, "/admin/rooms/1/calendars", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		ctx = withURLParams(ctx, e.params)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc == nil || actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s but got location %v", e.name, e.expectedLocation, actualLoc)
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestSyncICalFeed(t *testing.T) {
+	db := withTestRepo(t)
+	db.FailWhen("ReplaceICalFeedRestrictions", errDB, func(args ...interface{}) bool {
+		return args[0].(models.ICalFeed).ID == 2
+	})
+	srv := httptest.NewServer(http.FileServer(http.Dir("../ical/testdata")))
+	defer srv.Close()
+
+	err := Repo.SyncICalFeed(context.Background(), models.ICalFeed{ID: 1, RoomID: 1, URL: srv.URL + "/booking.ics"})
+	if err != nil {
+		t.Errorf("unexpected error syncing a calendar: %s", err)
+	}
+
+	//il database non riesce a salvare i giorni del calendario 2
+	err = Repo.SyncICalFeed(context.Background(), models.ICalFeed{ID: 2, RoomID: 1, URL: srv.URL + "/booking.ics"})
+	if err == nil {
+		t.Error("expected the error of the database")
+	}
+
+	err = Repo.SyncICalFeed(context.Background(), models.ICalFeed{ID: 1, RoomID: 1, URL: srv.URL + "/missing.ics"})
+	if err == nil {
+		t.Error("expected an error for a missing calendar")
+	}
+
+	path, _ := filepath.Abs("../ical/testdata/booking.ics")
+	err = Repo.SyncICalFeed(context.Background(), models.ICalFeed{ID: 1, RoomID: 1, URL: "file://" + path})
+	if err == nil {
+		t.Error("read a calendar from a file of the server")
+	}
+}
+
+//TestSyncICalFeedsStopped checks that a sync started before the shutdown doesn't go on with the other calendars
+func TestSyncICalFeedsStopped(t *testing.T) {
+	withTestRepo(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := Repo.SyncICalFeeds(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled but got %v", err)
+	}
+}
+
+func TestICalPeriods(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	events := []ical.Event{
+		{Start: day("2040-01-01"), End: day("2040-01-03"), AllDay: true},
+		{Start: day("2040-01-09"), End: day("2040-01-11"), AllDay: true},
+		{Start: day("2040-01-10"), End: day("2040-01-13"), AllDay: true},
+	}
+
+	//il primo evento è già finito, il secondo finisce oggi
+	periods := icalPeriods(events, day("2040-01-10").Add(15*time.Hour))
+	if len(periods) != 2 {
+		t.Fatalf("expected 2 periods but got %d", len(periods))
+	}
+	if !periods[1].Start.Equal(day("2040-01-10")) || !periods[1].End.Equal(day("2040-01-12")) {
+		t.Errorf("wrong period %v", periods[1])
+	}
+}
@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -13,16 +15,22 @@ import (
 	"github.com/Laura470/bookings/internal/driver"
 	"github.com/Laura470/bookings/internal/forms"
 	"github.com/Laura470/bookings/internal/helpers"
+	"github.com/Laura470/bookings/internal/metrics"
 	"github.com/Laura470/bookings/internal/models"
+	"github.com/Laura470/bookings/internal/pricing"
 	"github.com/Laura470/bookings/internal/render"
 	"github.com/Laura470/bookings/internal/repository"
 	"github.com/Laura470/bookings/internal/repository/dbrepo"
+	"github.com/Laura470/bookings/internal/tokens"
 	"github.com/go-chi/chi"
 )
 
 // Repo the repository used by the handlers
 var Repo *Repository
 
+//ownerEmail is where the mails for the owner go
+const ownerEmail = "owner@fort.com"
+
 // Repository is the repository type
 type Repository struct {
 	App *config.AppConfig
@@ -31,17 +39,23 @@ type Repository struct {
 
 // NewRepo creates a new repository
 func NewRepo(a *config.AppConfig, db *driver.DB) *Repository {
+	if db.Driver == driver.SQLite {
+		return &Repository{
+			App: a,
+			DB:  dbrepo.NewSQLiteRepo(db.SQL, a),
+		}
+	}
 	return &Repository{
 		App: a,
 		DB:  dbrepo.NewPostgresRepo(db.SQL, a),
 	}
 }
 
-// NewTestRepo creates a new repository for testing
-func NewTestRepo(a *config.AppConfig) *Repository {
+//NewMemoryRepo creates a new repository that keeps the data in memory, without a database
+func NewMemoryRepo(a *config.AppConfig) *Repository {
 	return &Repository{
 		App: a,
-		DB:  dbrepo.NewTestingRepo(a),
+		DB:  dbrepo.NewMemoryRepo(a),
 	}
 }
 
@@ -77,7 +91,7 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	}
 
 	//cerco il nome della stanza
-	room, err := m.DB.GetRoomByID(res.RoomID)
+	room, err := m.DB.GetRoomByID(r.Context(), res.RoomID)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Can't find room")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -86,6 +100,13 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	// passo il nome della stanza alla reservation
 	res.Room.RoomName = room.RoomName
 
+	//calcolo il prezzo del soggiorno, notte per notte
+	quote, ok := m.quoteForReservation(w, r, res)
+	if !ok {
+		return
+	}
+	res.TotalPrice = quote.Total
+
 	//metto la reservationa nella session
 	m.App.Session.Put(r.Context(), "reservation", res)
 
@@ -99,6 +120,7 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["quote"] = quote
 
 	render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
 		Form:      forms.New(nil),
@@ -146,7 +168,7 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room, err := m.DB.GetRoomByID(roomID)
+	room, err := m.DB.GetRoomByID(r.Context(), roomID)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "invalid data!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -164,6 +186,13 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		Room:      room,
 	}
 
+	//ricalcolo il prezzo con le tariffe di adesso, è quello che salvo nella prenotazione
+	quote, ok := m.quoteForReservation(w, r, reservation)
+	if !ok {
+		return
+	}
+	reservation.TotalPrice = quote.Total
+
 	form := forms.New(r.PostForm)
 
 	form.Required("first_name", "last_name", "email")
@@ -173,6 +202,7 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	if !form.Valid() {
 		data := make(map[string]interface{})
 		data["reservation"] = reservation
+		data["quote"] = quote
 		render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
 			Form: form,
 			Data: data,
@@ -180,72 +210,22 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	//dopo avere validato i dati dalla form li scrivo nel db
-	newReservationID, err := m.DB.InsertReservation(reservation)
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Can't insert reservation into database")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+	//dopo avere validato i dati dalla form li scrivo nel db, prenotazione e restriction insieme
+	newReservationID, err := m.DB.BookRoom(r.Context(), reservation)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room is no longer available for the selected dates")
+		http.Redirect(w, r, "/search-availibility", http.StatusSeeOther)
 		return
 	}
-
-	/* 	restriction := models.RoomRestriction{
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		RoomID:        reservation.RoomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
-	} */
-
-	restriction := models.RoomRestriction{
-		StartDate:     startDate,
-		EndDate:       endDate,
-		RoomID:        roomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
-	}
-
-	err = m.DB.InsertRoomRestriction(restriction)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Can't insert room restriction into database")
+		m.App.Session.Put(r.Context(), "error", "Can't insert reservation into database")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
+	reservation.ID = newReservationID
+	m.App.Metrics.Reservation(metrics.SourceWeb)
 
-	//send notification via email first to guest
-	htmlMessage := fmt.Sprintf(` 
-	<strong>Reservation Confirmation</strong>
-	Dear %s, <br>
-	This is confirm your reservation from %s to %s.
-
-	`, reservation.FirstName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
-
-	msg := models.MailData{
-		To:       reservation.Email,
-		From:     "me@here.com",
-		Subject:  "Reservation Confirmation",
-		Content:  htmlMessage,
-		Template: "basic.html",
-	}
-
-	m.App.MailChan <- msg
-
-	//send notification via email second to the owner
-	htmlMessage = fmt.Sprintf(` 
-	<strong>Reservation Confirmation</strong>
-	Dear Owner, <br>
-	This is confirm a reservation by %s %s, from %s to %s.
-
-	`, reservation.FirstName, reservation.LastName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
-
-	msg = models.MailData{
-		To:       "owner@fort.com",
-		From:     "me@here.com",
-		Subject:  "Reservation Received",
-		Content:  htmlMessage,
-		Template: "basic.html",
-	}
-
-	m.App.MailChan <- msg
+	m.sendReservationEmails(r.Context(), reservation)
 
 	//e ora rimetto la mia reservation nella session
 	m.App.Session.Put(r.Context(), "reservation", reservation)
@@ -254,14 +234,11 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
 }
 
-// Generals renders the room page
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
-}
-
-// Majors renders the room page
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "majors.page.tmpl", &models.TemplateData{})
+//sendReservationEmails sends the confirmation to the guest, with the link to manage the reservation, and lets the owner know
+func (m *Repository) sendReservationEmails(ctx context.Context, reservation models.Reservation) {
+	md := m.mailTemplateData(reservation)
+	m.queueTemplateMail(ctx, "confirmation", reservation.Email, md)
+	m.queueTemplateMail(ctx, "owner-reservation", ownerEmail, md)
 }
 
 // Availability renders the search availability page
@@ -297,12 +274,13 @@ func (m *Repository) PostAvailibility(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), startDate, endDate)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Can't connect to data base")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
+	m.App.Metrics.Search(metrics.SourceWeb, len(rooms) > 0)
 
 	if len(rooms) == 0 {
 		//no availibility
@@ -311,8 +289,28 @@ func (m *Repository) PostAvailibility(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	//il prezzo di ogni stanza, o il motivo per cui non si può prenotare (il soggiorno minimo)
+	quotes := make(map[int]models.Quote)
+	quoteErrors := make(map[int]string)
+	for _, room := range rooms {
+		quote, err := m.DB.QuotePrice(r.Context(), room.ID, startDate, endDate)
+		var minStay pricing.MinimumStayError
+		if errors.As(err, &minStay) {
+			quoteErrors[room.ID] = fmt.Sprintf("Minimum stay %d nights", minStay.MinStay)
+			continue
+		}
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "Can't quote the price of the rooms")
+			http.Redirect(w, r, "/search-availibility", http.StatusSeeOther)
+			return
+		}
+		quotes[room.ID] = quote
+	}
+
 	data := make(map[string]interface{})
 	data["rooms"] = rooms
+	data["quotes"] = quotes
+	data["quoteErrors"] = quoteErrors
 
 	res := models.Reservation{
 		StartDate: startDate,
@@ -358,13 +356,21 @@ func (m *Repository) AvailibilityJSON(w http.ResponseWriter, r *http.Request) {
 
 	//le trasformo in tipo data
 	layout := "2006-01-02"
-	startDate, _ := time.Parse(layout, sd)
-	endDate, _ := time.Parse(layout, ed)
+	startDate, err := time.Parse(layout, sd)
+	if err != nil {
+		m.writeAvailibilityError(w, "Invalid start date")
+		return
+	}
+	endDate, err := time.Parse(layout, ed)
+	if err != nil {
+		m.writeAvailibilityError(w, "Invalid end date")
+		return
+	}
 
 	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
 
 	//posso interrogare il db
-	available, err := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(r.Context(), startDate, endDate, roomID)
 	if err != nil {
 		// got a database error, so return appropriate json
 		resp := jsonResponse{
@@ -377,6 +383,7 @@ func (m *Repository) AvailibilityJSON(w http.ResponseWriter, r *http.Request) {
 		w.Write(out)
 		return
 	}
+	m.App.Metrics.Search(metrics.SourceWeb, available)
 
 	resp := jsonResponse{
 		OK:        available,
@@ -395,6 +402,18 @@ func (m *Repository) AvailibilityJSON(w http.ResponseWriter, r *http.Request) {
 	w.Write(out)
 }
 
+//writeAvailibilityError sends the json of a failed availibility search
+func (m *Repository) writeAvailibilityError(w http.ResponseWriter, message string) {
+	resp := jsonResponse{
+		OK:      false,
+		Message: message,
+	}
+
+	out, _ := json.MarshalIndent(resp, "", "     ")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
 func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "contact.page.tmpl", &models.TemplateData{})
 }
@@ -422,6 +441,10 @@ func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request)
 	stringMap["start_date"] = sd
 	stringMap["end_date"] = ed
 
+	if reservation.ID > 0 {
+		stringMap["reference"] = m.reservationReference(reservation.ID)
+	}
+
 	render.Template(w, r, "reservation-summary.page.tmpl", &models.TemplateData{
 		Data:      data,
 		StringMap: stringMap,
@@ -429,6 +452,227 @@ func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request)
 
 }
 
+//reservationReference returns the signed reference the guest uses to manage his reservation
+func (m *Repository) reservationReference(id int) string {
+	return m.App.Signer.Sign(fmt.Sprintf("reservation:%d", id))
+}
+
+//manageReservationURL returns the link to the manage reservation page that we send by email
+func (m *Repository) manageReservationURL(id int) string {
+	return fmt.Sprintf("%s/my-reservation/%s", m.App.BaseURL, m.reservationReference(id))
+}
+
+//reservationFromReference verifies the reference and gets from the database the reservation it refers to
+func (m *Repository) reservationFromReference(ctx context.Context, ref string) (models.Reservation, error) {
+	value, err := m.App.Signer.Verify(ref)
+	if err != nil {
+		return models.Reservation{}, err
+	}
+
+	if !strings.HasPrefix(value, "reservation:") {
+		return models.Reservation{}, tokens.ErrInvalidToken
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(value, "reservation:"))
+	if err != nil {
+		return models.Reservation{}, tokens.ErrInvalidToken
+	}
+
+	return m.DB.GetReservationByID(ctx, id)
+}
+
+//quoteForReservation quotes the stay of res. When the room can't be booked for those dates,
+//because of the minimum stay or invalid dates, it tells the guest why and redirects, returning false
+func (m *Repository) quoteForReservation(w http.ResponseWriter, r *http.Request, res models.Reservation) (models.Quote, bool) {
+	quote, err := m.DB.QuotePrice(r.Context(), res.RoomID, res.StartDate, res.EndDate)
+	var minStay pricing.MinimumStayError
+	if errors.As(err, &minStay) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Sorry, the minimum stay for this room is %d nights", minStay.MinStay))
+		http.Redirect(w, r, "/search-availibility", http.StatusSeeOther)
+		return quote, false
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Can't quote the price of your stay")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return quote, false
+	}
+	return quote, true
+}
+
+//ManageReservation shows the guest his reservation, with the forms to change the dates or cancel it
+func (m *Repository) ManageReservation(w http.ResponseWriter, r *http.Request) {
+	ref := chi.URLParam(r, "ref")
+
+	res, err := m.reservationFromReference(r.Context(), ref)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Can't find your reservation")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+
+	stringMap := make(map[string]string)
+	stringMap["reference"] = ref
+	stringMap["start_date"] = res.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = res.EndDate.Format("2006-01-02")
+
+	render.Template(w, r, "manage-reservation.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      forms.New(nil),
+	})
+}
+
+//PostChangeReservation moves the guest reservation to new dates, if the room is still available
+func (m *Repository) PostChangeReservation(w http.ResponseWriter, r *http.Request) {
+	ref := chi.URLParam(r, "ref")
+	manageURL := fmt.Sprintf("/my-reservation/%s", ref)
+
+	res, err := m.reservationFromReference(r.Context(), ref)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Can't find your reservation")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Can't parse form")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, r.Form.Get("start_date"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse start date")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+
+	endDate, err := time.Parse(layout, r.Form.Get("end_date"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse end date")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+
+	if !endDate.After(startDate) {
+		m.App.Session.Put(r.Context(), "error", "Departure must be after arrival")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+
+	//le date arrivano senza ora e in UTC, le confronto con il giorno di oggi
+	now := time.Now()
+	if startDate.Before(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)) {
+		m.App.Session.Put(r.Context(), "error", "Arrival can't be in the past")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+
+	previous := res
+	res.StartDate = startDate
+	res.EndDate = endDate
+
+	//le nuove date hanno un nuovo prezzo
+	quote, err := m.DB.QuotePrice(r.Context(), res.RoomID, res.StartDate, res.EndDate)
+	var minStay pricing.MinimumStayError
+	if errors.As(err, &minStay) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Sorry, the minimum stay for this room is %d nights", minStay.MinStay))
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Can't quote the price of your stay")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+	res.TotalPrice = quote.Total
+
+	err = m.DB.UpdateReservationDates(r.Context(), res)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, the room is not available for the selected dates")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Can't change your reservation")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+
+	md := m.mailTemplateData(res)
+	md.Previous = previous
+	m.queueTemplateMail(r.Context(), "change", res.Email, md)
+	m.queueTemplateMail(r.Context(), "owner-change", ownerEmail, md)
+
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been changed")
+	http.Redirect(w, r, manageURL, http.StatusSeeOther)
+}
+
+//PostCancelReservation cancels the guest reservation, freeing the room, and lets the owner know
+func (m *Repository) PostCancelReservation(w http.ResponseWriter, r *http.Request) {
+	ref := chi.URLParam(r, "ref")
+
+	res, err := m.reservationFromReference(r.Context(), ref)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Can't find your reservation")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	//cancellando la reservation si cancella anche la room restriction
+	err = m.DB.DeleteReservation(r.Context(), res.ID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Can't cancel your reservation")
+		http.Redirect(w, r, fmt.Sprintf("/my-reservation/%s", ref), http.StatusSeeOther)
+		return
+	}
+
+	m.sendCancellationEmails(r.Context(), res)
+
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been cancelled")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+//queueMail puts a mail in the outbox, if it can't the mail is lost but what the guest did is done anyway
+func (m *Repository) queueMail(ctx context.Context, msg models.MailData) error {
+	_, err := m.DB.QueueMail(ctx, msg)
+	if err != nil {
+		m.App.Logger.Ctx(ctx).Error("can't queue the mail", "subject", msg.Subject, "to", msg.To, "error", err)
+	}
+	return err
+}
+
+//sendCancellationEmails lets the guest and the owner know that the guest cancelled the reservation
+func (m *Repository) sendCancellationEmails(ctx context.Context, res models.Reservation) {
+	md := m.mailTemplateData(res)
+	m.queueTemplateMail(ctx, "cancellation", res.Email, md)
+	m.queueTemplateMail(ctx, "owner-cancellation", ownerEmail, md)
+}
+
+//queueTemplateMail renders the mail template tmpl for to and puts it in the outbox
+func (m *Repository) queueTemplateMail(ctx context.Context, tmpl, to string, md *models.MailTemplateData) error {
+	msg, err := render.Mail(tmpl, to, md)
+	if err != nil {
+		m.App.Logger.Ctx(ctx).Error("can't render the mail", "template", tmpl, "to", to, "error", err)
+		return err
+	}
+	return m.queueMail(ctx, msg)
+}
+
+//mailTemplateData is what the mail templates need to know about a reservation
+func (m *Repository) mailTemplateData(res models.Reservation) *models.MailTemplateData {
+	return &models.MailTemplateData{
+		Reservation: res,
+		ManageURL:   m.manageReservationURL(res.ID),
+		SiteURL:     m.App.BaseURL,
+	}
+}
+
 //ChooseRoom displays list of available rooms
 func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
 	// used to have next 6 lines
@@ -478,7 +722,7 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 	var res models.Reservation
 
 	//cerco il nome della stanza
-	room, err := m.DB.GetRoomByID(roomID)
+	room, err := m.DB.GetRoomByID(r.Context(), roomID)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Can't get room from db!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -510,7 +754,7 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 
 	err := r.ParseForm()
 	if err != nil {
-		log.Println(err)
+		m.App.Logger.Ctx(r.Context()).Warn("can't parse the form", "error", err)
 	}
 
 	email := r.Form.Get("email")
@@ -526,20 +770,36 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, _, err := m.DB.Authenticate(email, password)
+	ip := m.clientIP(r)
+	failures, ok := m.throttleLogin(w, r, email, ip)
+	if !ok {
+		return
+	}
+
+	id, _, err := m.DB.Authenticate(r.Context(), email, password)
 	if err != nil {
-		log.Println(err)
+		m.App.Logger.Ctx(r.Context()).Info("login failed", "ip", ip, "error", err)
+		m.loginFailed(r.Context(), email, ip, failures)
 
 		m.App.Session.Put(r.Context(), "error", "invalid login credentials")
 		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 		return
 	}
 
-	//metto l'id nella session
-	m.App.Session.Put(r.Context(), "user_id", id)
-	m.App.Session.Put(r.Context(), "flash", "Logged in succesfully")
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	user, err := m.DB.GetUserByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	//con la verifica in due passaggi la password non basta, manca il codice dell'app
+	if user.HasTOTP() {
+		m.App.Session.Put(r.Context(), "2fa_user_id", id)
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
 
+	m.logIn(w, r, user, false)
 }
 
 func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
@@ -556,9 +816,9 @@ func (m *Repository) AdminDashBoard(w http.ResponseWriter, r *http.Request) {
 
 func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
 	//chiamo la funzione che mi restituisce tutte le reservations
-	reservations, err := m.DB.AllReservations()
+	reservations, err := m.DB.AllReservations(r.Context())
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -574,9 +834,9 @@ func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request
 
 func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
 	//chiamo la funzione che mi restituisce tutte le reservations
-	reservations, err := m.DB.AllNewReservations()
+	reservations, err := m.DB.AllNewReservations(r.Context())
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -596,7 +856,7 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 
 	id, err := strconv.Atoi(exploded[4])
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 	src := exploded[3]
@@ -612,9 +872,9 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 	stringMap["year"] = year
 
 	//ger reservation form the data base
-	res, err := m.DB.GetReservationByID(id)
+	res, err := m.DB.GetReservationByID(r.Context(), id)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -634,7 +894,7 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 	err := r.ParseForm()
 	if err != nil {
 		//uso gli helpers perchè è amministrazione,
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 	//grab the url and separate by /
@@ -642,7 +902,7 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 
 	id, err := strconv.Atoi(exploded[4])
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 	src := exploded[3]
@@ -652,9 +912,9 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 	stringMap["src"] = src
 
 	//non capisco perchè devo prendere la reservation dal db prima di fare editing
-	res, err := m.DB.GetReservationByID(id)
+	res, err := m.DB.GetReservationByID(r.Context(), id)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -664,10 +924,10 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 	res.Email = r.Form.Get("email")
 	res.Phone = r.Form.Get("phone")
 
-	err = m.DB.UpdateReservation(res)
+	err = m.DB.UpdateReservation(r.Context(), res)
 
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -694,15 +954,15 @@ func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Requ
 	//lo devo convertire in una integer
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 	}
 	//src è a posto
 	src := chi.URLParam(r, "src")
 
 	// l'errore è ignorato e non va bene
-	err = m.DB.UpdateProcessedForReservation(id, 1)
+	err = m.DB.UpdateProcessedForReservation(r.Context(), id, 1)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 	}
 
 	year := r.URL.Query().Get("y")
@@ -726,15 +986,15 @@ func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Reque
 	//lo devo convertire in una integer
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 	}
 	//src è a posto
 	src := chi.URLParam(r, "src")
 
 	// l'errore è ignorato e non va bene
-	err = m.DB.DeleteReservation(id)
+	err = m.DB.DeleteReservation(r.Context(), id)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 	}
 
 	year := r.URL.Query().Get("y")
@@ -759,12 +1019,12 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 	if r.URL.Query().Get("y") != "" {
 		year, err := strconv.Atoi(r.URL.Query().Get("y"))
 		if err != nil {
-			helpers.ServerError(w, err)
+			helpers.ServerError(w, r, err)
 			return
 		}
 		month, err := strconv.Atoi(r.URL.Query().Get("m"))
 		if err != nil {
-			helpers.ServerError(w, err)
+			helpers.ServerError(w, r, err)
 			return
 		}
 		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
@@ -809,9 +1069,9 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 	intMap["days_in_month"] = lastOfMonth.Day()
 
 	//vado a prendere tutte le rooms che ci sono nel DB
-	rooms, err := m.DB.AllRooms()
+	rooms, err := m.DB.AllRooms(r.Context())
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -821,10 +1081,19 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 	//una sola volta per ogni mese
 	//quindi faccio passare le mie rooms:
 	//x è la iesima room nella mia rooms
+	//il motivo di ogni blocco del proprietario, lo mostro passando sopra il giorno
+	blockReasons := make(map[int]string)
+	//i giorni presi sui calendari esterni, con il nome del calendario
+	feedNames := make(map[int]string)
+
 	for _, x := range rooms {
-		//al loro interno create 2 maps
+		//al loro interno create 3 maps
 		reservationMap := make(map[string]int)
 		blockMap := make(map[string]int)
+		//i blocchi creati dalla pagina dei blocchi, di più giorni o ripetuti, non si tolgono con le checkbox
+		ownerBlockMap := make(map[string]int)
+		//i giorni presi su altri siti si liberano solo dal loro calendario
+		externalMap := make(map[string]int)
 
 		//ora devo mettere le informazioni utili nelle maps
 		//faccio passare i giorni del mese, così creo la coppia giorno(key) e 0 (value) con valore di default
@@ -832,12 +1101,14 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 		for d := firstOfMonth; !d.After(lastOfMonth); d = d.AddDate(0, 0, 1) {
 			reservationMap[d.Format("2006-01-2")] = 0
 			blockMap[d.Format("2006-01-2")] = 0
+			ownerBlockMap[d.Format("2006-01-2")] = 0
+			externalMap[d.Format("2006-01-2")] = 0
 		}
 
 		//get all the restriction for the current room
-		restrictions, err := m.DB.GetRestrictionForRoomByDate(x.ID, firstOfMonth, lastOfMonth)
+		restrictions, err := m.DB.GetRestrictionForRoomByDate(r.Context(), x.ID, firstOfMonth, lastOfMonth)
 		if err != nil {
-			helpers.ServerError(w, err)
+			helpers.ServerError(w, r, err)
 			return
 		}
 
@@ -848,23 +1119,38 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 				for d := y.StartDate; !d.After(y.EndDate); d = d.AddDate(0, 0, 1) {
 					reservationMap[d.Format("2006-01-2")] = y.ReservationID
 				}
+			} else if y.BlockID > 0 {
+				//it is an owner block, segno tutti i suoi giorni
+				for d := y.StartDate; !d.After(y.EndDate); d = d.AddDate(0, 0, 1) {
+					ownerBlockMap[d.Format("2006-01-2")] = y.BlockID
+				}
+				blockReasons[y.BlockID] = y.Block.Reason
+			} else if y.FeedID > 0 {
+				//it comes from an external calendar
+				for d := y.StartDate; !d.After(y.EndDate); d = d.AddDate(0, 0, 1) {
+					externalMap[d.Format("2006-01-2")] = y.FeedID
+				}
+				feedNames[y.FeedID] = y.Feed.Name
 			} else {
-				//it is a block ma è di un solo giorno per decisione amministrativa
-				blockMap[y.StartDate.Format("2006-01-2")] = y.ID
-				//cambio il codice sopra in:
-				/* 				for d := y.StartDate; !d.After(y.EndDate); d = d.AddDate(0, 0, 1) {
+				//it is a block, anche se dal calendario si bloccano i giorni uno alla volta
+				for d := y.StartDate; !d.After(y.EndDate); d = d.AddDate(0, 0, 1) {
 					blockMap[d.Format("2006-01-2")] = y.ID
-				} */
+				}
 			}
 		}
 
 		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
 		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
+		data[fmt.Sprintf("owner_block_map_%d", x.ID)] = ownerBlockMap
+		data[fmt.Sprintf("external_map_%d", x.ID)] = externalMap
 
 		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
 		//devo aggi8ungere la mappa nel main gob.Register(map[string]int{})
 	}
 
+	data["block_reasons"] = blockReasons
+	data["feed_names"] = feedNames
+
 	//
 	render.Template(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{
 		StringMap: stringMap,
@@ -877,27 +1163,27 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 	//prendo anno e mese dalla form
 	year, err := strconv.Atoi(r.Form.Get("y"))
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 	month, err := strconv.Atoi(r.Form.Get("m"))
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
 	//process blocks
 
 	//vado a prendere tutte le rooms che ci sono nel DB
-	rooms, err := m.DB.AllRooms()
+	rooms, err := m.DB.AllRooms(r.Context())
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -924,9 +1210,9 @@ func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *htt
 				if val > 0 {
 					if !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, name)) {
 						//delete tehe restriction by id
-						err := m.DB.DeleteBlockByID(value)
+						err := m.DB.DeleteBlockByID(r.Context(), value)
 						if err != nil {
-							log.Println(err)
+							m.App.Logger.Ctx(r.Context()).Error("can't delete the block", "block_id", value, "error", err)
 							return
 						}
 					}
@@ -937,6 +1223,8 @@ func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *htt
 	}
 
 	//now handle new block
+	//i giorni che non si possono bloccare perché hanno una prenotazione
+	var overlapping []string
 	//faccio passare tutto il post
 	for name := range r.PostForm {
 		//log.Println("Form has name", name)
@@ -946,19 +1234,31 @@ func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *htt
 			roomID, _ := strconv.Atoi(exploded[2])
 			t, err := time.Parse("2006-01-2", exploded[3])
 			if err != nil {
-				helpers.ServerError(w, err)
+				helpers.ServerError(w, r, err)
 				return
 			}
-			//insert new block
-			err = m.DB.InsertBlockForRoom(roomID, t)
+			//insert new block, come quelli della pagina dei blocchi blocca la stanza e controlla le prenotazioni
+			_, err = m.DB.InsertRoomBlock(r.Context(), models.RoomBlock{RoomID: roomID, StartDate: t, EndDate: t,
+				Recurrence: models.RecurrenceNone, Reason: "Blocked from the calendar"})
+			if errors.Is(err, repository.ErrBlockOverlapsReservation) {
+				overlapping = append(overlapping, t.Format("2006-01-02"))
+				continue
+			}
 			if err != nil {
-				log.Println(err)
+				helpers.ServerError(w, r, err)
 				return
 			}
 		}
 
 	}
 
+	if len(overlapping) > 0 {
+		sort.Strings(overlapping)
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Can't block %s, the room has reservations on those days", strings.Join(overlapping, ", ")))
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+		return
+	}
+
 	m.App.Session.Put(r.Context(), "flash", "Changes saved")
 	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
 
@@ -10,8 +10,10 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Laura470/bookings/internal/models"
+	"github.com/go-chi/chi"
 )
 
 /* type postData struct {
@@ -40,10 +42,29 @@ var theTests = []struct {
 	{"new res", "/admin/new-reservations", "Get", http.StatusOK},
 	{"all res", "/admin/all-reservations", "Get", http.StatusOK},
 	//attenzione al path di show reservation, lo devo costruire
-	{"show res", "/admin/reservations/new/28/show", "Get", http.StatusOK},
+	{"show res", "/admin/reservations/new/1/show", "Get", http.StatusOK},
+	{"manage res", "/my-reservation/" + testReference(1), "Get", http.StatusOK},
+	{"rooms", "/rooms", "GET", http.StatusOK},
+	{"room", "/rooms/majors-suite", "GET", http.StatusOK},
+	{"inactive room", "/rooms/old-room", "GET", http.StatusNotFound},
+	{"non-existent room", "/rooms/no-such-room", "GET", http.StatusNotFound},
+	{"admin rooms", "/admin/rooms", "GET", http.StatusOK},
+	{"admin new room", "/admin/rooms/new", "GET", http.StatusOK},
+	{"admin show room", "/admin/rooms/1", "GET", http.StatusOK},
+	{"admin show non-existent room", "/admin/rooms/100", "GET", http.StatusNotFound},
+	{"admin room rates", "/admin/rooms/1/rates", "GET", http.StatusOK},
+	{"room calendar", "/rooms/2/calendar.ics", "GET", http.StatusOK},
+	{"calendar", "/admin/reservations-calendar?y=2040&m=1", "GET", http.StatusOK},
+	{"admin blocks", "/admin/blocks", "GET", http.StatusOK},
+	{"admin api tokens", "/admin/api-tokens", "GET", http.StatusOK},
+	{"admin mail", "/admin/mail", "GET", http.StatusOK},
+	{"forgot password", "/user/forgot-password", "GET", http.StatusOK},
+	{"admin users", "/admin/users", "GET", http.StatusOK},
+	{"admin new user", "/admin/users/new", "GET", http.StatusOK},
 }
 
 func TestHandlers(t *testing.T) {
+	withTestRepo(t)
 	routes := getRoutes()
 	//devo crere un sever e un client che chiama il server, ma in go è già tutto creato
 	ts := httptest.NewTLSServer(routes) //ts è il mio testserver
@@ -64,6 +85,7 @@ func TestHandlers(t *testing.T) {
 }
 
 func TestRepository_Reservation(t *testing.T) {
+	withTestRepo(t)
 
 	reservation := models.Reservation{
 		RoomID: 1,
@@ -72,6 +94,8 @@ func TestRepository_Reservation(t *testing.T) {
 			RoomName: "General's Quarters",
 		},
 	}
+	reservation.StartDate, _ = time.Parse("2006-01-02", "2040-01-06")
+	reservation.EndDate, _ = time.Parse("2006-01-02", "2040-01-08")
 	//faccio una richiesta con un empty body
 	req, _ := http.NewRequest("GET", "/make-reservation", nil)
 	ctx := getCtx(req)
@@ -93,6 +117,28 @@ func TestRepository_Reservation(t *testing.T) {
 	if rr.Code != http.StatusOK {
 		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
 	}
+	//venerdì e sabato sono notti del weekend, 2 x $150.00
+	if !strings.Contains(rr.Body.String(), "$300.00") {
+		t.Error("Reservation handler did not show the quoted total $300.00")
+	}
+
+	//test with a stay shorter than the minimum stay of the summer season
+	req, _ = http.NewRequest("GET", "/make-reservation", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+	shortStay := reservation
+	shortStay.StartDate, _ = time.Parse("2006-01-02", "2040-07-02")
+	shortStay.EndDate, _ = time.Parse("2006-01-02", "2040-07-03")
+	session.Put(ctx, "reservation", shortStay)
+
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("Reservation handler returned wrong response code for a short stay: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+	if !strings.Contains(session.GetString(ctx, "error"), "minimum stay") {
+		t.Error("Reservation handler did not tell the guest about the minimum stay")
+	}
 
 	//test case where reservation is not in session (reset everything)
 	//reinizializzo req
@@ -122,12 +168,13 @@ func TestRepository_Reservation(t *testing.T) {
 }
 
 func TestRepository_PostReservation(t *testing.T) {
+	db := withTestRepo(t)
 
 	// ---------------------- 1° TEST ----------------------------------------------
 	//test iwth everything ok
 	//now I build the body request
-	reqBody := "start_date=2050-01-01"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-01-02")
+	reqBody := "start_date=2040-01-01"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2040-01-02")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=John")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=jj@jj.it")
@@ -151,6 +198,10 @@ func TestRepository_PostReservation(t *testing.T) {
 	if rr.Code != http.StatusSeeOther {
 		t.Errorf("PostReservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
+	actualLoc, _ := rr.Result().Location()
+	if actualLoc.String() != "/reservation-summary" {
+		t.Errorf("PostReservation handler redirected to wrong location: got %s, wanted %s", actualLoc.String(), "/reservation-summary")
+	}
 
 	// ---------------------- 2° TEST ----------------------------------------------
 	//test for missing request body
@@ -279,6 +330,7 @@ func TestRepository_PostReservation(t *testing.T) {
 
 	// ---------------------- 7° TEST ----------------------------------------------
 	// test for failure to insert reservation into database
+	db.Fail("BookRoom", errDB)
 	reqBody = "start_date=2050-01-01"
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-01-02")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=John")
@@ -300,9 +352,13 @@ func TestRepository_PostReservation(t *testing.T) {
 	if rr.Code != http.StatusSeeOther {
 		t.Errorf("PostReservation handler failed when trying to fail inserting reservation: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
+	if reservations, _ := db.AllReservations(context.Background()); len(reservations) != 2 {
+		t.Errorf("expected no new reservation when the database fails but got %d reservations", len(reservations))
+	}
+	db.Reset()
 
 	// ---------------------- 8° TEST ----------------------------------------------
-	// test for failure to insert restriction into database
+	// test for a room that doesn't exist, the restriction can't be inserted
 	reqBody = "start_date=2050-01-01"
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-01-02")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=John")
@@ -325,9 +381,73 @@ func TestRepository_PostReservation(t *testing.T) {
 		t.Errorf("PostReservation handler failed when trying to fail inserting reservation: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 
+	// ---------------------- 9° TEST ----------------------------------------------
+	// test for room booked by someone else in the meantime
+	bookAll(t, db, "2050-01-01", "2050-01-02")
+	reqBody = "start_date=2050-01-01"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-01-02")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=John")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=123456789")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
+
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.PostReservation)
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("PostReservation handler returned wrong response code for room no longer available: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+	actualLoc, _ = rr.Result().Location()
+	if actualLoc.String() != "/search-availibility" {
+		t.Errorf("PostReservation handler redirected to wrong location for room no longer available: got %s, wanted %s", actualLoc.String(), "/search-availibility")
+	}
+	if session.GetString(ctx, "error") == "" {
+		t.Error("PostReservation handler didn't put an error message in session for room no longer available")
+	}
+
+	// ---------------------- 10° TEST ----------------------------------------------
+	// test for a stay shorter than the minimum stay of the season
+	reqBody = "start_date=2040-07-02"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2040-07-03")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=John")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=123456789")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
+
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.PostReservation)
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("PostReservation handler returned wrong response code for minimum stay: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+	actualLoc, _ = rr.Result().Location()
+	if actualLoc.String() != "/search-availibility" {
+		t.Errorf("PostReservation handler redirected to wrong location for minimum stay: got %s, wanted %s", actualLoc.String(), "/search-availibility")
+	}
+	if !strings.Contains(session.GetString(ctx, "error"), "minimum stay") {
+		t.Error("PostReservation handler didn't put the minimum stay error in session")
+	}
+
 }
 
 func TestRepository_PostAvailibility(t *testing.T) {
+	db := withTestRepo(t)
 
 	// non si riesce a fare parsing di start
 	reqBody := "start=invalid"
@@ -393,7 +513,8 @@ func TestRepository_PostAvailibility(t *testing.T) {
 	}
 
 	//errore di connessione con il db
-	reqBody = "start=2060-01-01"
+	db.Fail("SearchAvailabilityForAllRooms", errDB)
+	reqBody = "start=2040-01-01"
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "end=2040-01-02")
 
 	//in questo caso non posso fare una richiesta con un empty body, è un post!
@@ -413,12 +534,14 @@ func TestRepository_PostAvailibility(t *testing.T) {
 	if rr.Code != http.StatusSeeOther {
 		t.Errorf("Post Availibility with connection error with the database and wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
+	db.Reset()
 
 	//len == 0  - rooms are not available
 	/*****************************************/
 	// create our request body
+	bookAll(t, db, "2050-01-01", "2050-01-02")
 	reqBody = "start=2050-01-01"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end=2060-01-02")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end=2050-01-02")
 
 	//in questo caso non posso fare una richiesta con un empty body, è un post!
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
@@ -477,6 +600,8 @@ func TestRepository_PostAvailibility(t *testing.T) {
 }
 
 func TestRepository_AvailabilityJSON(t *testing.T) {
+	db := withTestRepo(t)
+	bookAll(t, db, "2050-01-01", "2050-01-02")
 
 	/*****************************************
 	// first case -- rooms are not available
@@ -516,8 +641,7 @@ func TestRepository_AvailabilityJSON(t *testing.T) {
 		t.Error("failed to parse json!")
 	}
 
-	// since we specified a start date > 2049-12-31, we expect no availability
-	//ha modificato la funzione SearchAvailabilityByDatesByRoomID in testrepo
+	// the room is booked on those dates, we expect no availability
 	if j.OK {
 		t.Error("Got availability when none was expected in AvailabilityJSON")
 	}
@@ -554,17 +678,17 @@ func TestRepository_AvailabilityJSON(t *testing.T) {
 		t.Error("failed to parse form!")
 	}
 
-	// since we specified a start date > 2049-12-31, we expect no availability
+	// the form can't be parsed, we expect no availability
 	if j.OK {
 		t.Error("Got availability when none was expected in AvailabilityJSON")
 	}
 	/*****************************************
 	// third case -- got a database error
 	*****************************************/
-	//modifico ulteriormente la funzione SearchAvailabilityByDatesByRoomID in test tepo
+	db.Fail("SearchAvailabilityByDatesByRoomID", errDB)
 	// create our request body
-	reqBody = "start=2060-01-01"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end=2060-01-02")
+	reqBody = "start=2040-01-01"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end=2040-01-02")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
 
 	// create our request
@@ -597,15 +721,42 @@ func TestRepository_AvailabilityJSON(t *testing.T) {
 		t.Error("failed to parse json!")
 	}
 
-	// since we specified a start date = 2060.01.01, we expect error
-	//ha modificato la funzione SearchAvailabilityByDatesByRoomID in testrepo
+	// the database fails, we expect error
 	if j.OK {
 		t.Error("Got availability when an error was expected in AvailabilityJSON")
 	}
+	db.Reset()
+
+	/*****************************************
+	// fourth case -- invalid date
+	*****************************************/
+	reqBody = "start=invalid"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end=2050-01-02")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
+
+	req, _ = http.NewRequest("POST", "/search-availability-json", strings.NewReader(reqBody))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+	handler = http.HandlerFunc(Repo.AvailibilityJSON)
+	handler.ServeHTTP(rr, req)
+
+	j = jsonResponse{}
+	err = json.Unmarshal(rr.Body.Bytes(), &j)
+	if err != nil {
+		t.Error("failed to parse json!")
+	}
+
+	if j.OK || j.Message != "Invalid start date" {
+		t.Errorf("expected the invalid start date message but got ok %v and %q", j.OK, j.Message)
+	}
 
 }
 
 func TestRepository_ReservationSummary(t *testing.T) {
+	withTestRepo(t)
 
 	reservation := models.Reservation{
 		RoomID: 1,
@@ -652,6 +803,7 @@ func TestRepository_ReservationSummary(t *testing.T) {
 }
 
 func TestRepository_ChooseRoom(t *testing.T) {
+	withTestRepo(t)
 	/*
 		In your test for ChooseRoom, you will want to set the URL on your request as follows:
 		req.RequestURI = "/choose-room/1"
@@ -776,6 +928,7 @@ func TestRepository_ChooseRoom(t *testing.T) {
 }
 
 func TestRepository_BookRoom(t *testing.T) {
+	db := withTestRepo(t)
 
 	reservation := models.Reservation{
 		RoomID: 1,
@@ -805,10 +958,11 @@ func TestRepository_BookRoom(t *testing.T) {
 		t.Errorf("BookRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 
-	//testo errore connessione db con id>2
+	//testo errore connessione db
+	db.Fail("GetRoomByID", errDB)
 
 	// create our request
-	req, _ = http.NewRequest("GET", "/book-room?s=2050-01-01&e=2050-01-02&id=5", nil)
+	req, _ = http.NewRequest("GET", "/book-room?s=2050-01-01&e=2050-01-02&id=1", nil)
 	// get the context with session
 	ctx = getCtx(req)
 	req = req.WithContext(ctx)
@@ -862,11 +1016,13 @@ var loginTests = []struct {
 //sto testando SHOWPOSTLOGIN
 //
 func TestLogin(t *testing.T) {
+	db := withTestRepo(t)
+	withPassword(t, db, 1)
 	//range attraverso all tests, cioè prendo i dati che ho inserito
 	for _, e := range loginTests {
 		postedData := url.Values{} //per fare login inserisco email e password
 		postedData.Add("email", e.email)
-		postedData.Add("password", "password")
+		postedData.Add("password", testPassword)
 
 		// create a request
 		//simulo un utente che inserisce i dati nella form e che da quei dati venga creata una request verso il server (e la funzione che devo testare)
@@ -966,6 +1122,7 @@ var adminPostShowReservationTests = []struct {
 }
 
 func TestAdminPostShowReservation(t *testing.T) {
+	withTestRepo(t)
 	for _, e := range adminPostShowReservationTests {
 
 		var req *http.Request
@@ -1048,9 +1205,12 @@ var adminProcessReservationTests = []struct {
 }
 
 func TestAdminProcessReservation(t *testing.T) {
+	withTestRepo(t)
 	for _, e := range adminProcessReservationTests {
 		req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/process-reservation/cal/1/do%s", e.queryParams), nil)
 		ctx := getCtx(req)
+		//chiamo l'handler direttamente, quindi i parametri dell'url li devo mettere io nel context di chi
+		ctx = withURLParams(ctx, map[string]string{"src": "cal", "id": "1"})
 		req = req.WithContext(ctx)
 
 		rr := httptest.NewRecorder()
@@ -1121,6 +1281,177 @@ func TestAdminProcessReservation(t *testing.T) {
 //
 //
 
+var manageReservationTests = []struct {
+	name             string
+	reference        string
+	expectedCode     int
+	expectedLocation string
+}{
+	{"valid-reference", testReference(1), http.StatusOK, ""},
+	{"tampered-reference", testReference(1) + "x", http.StatusSeeOther, "/"},
+	{"empty-reference", "", http.StatusSeeOther, "/"},
+	{"signed-but-not-a-reservation", testSigner.Sign("user:1"), http.StatusSeeOther, "/"},
+	{"missing-reservation", testReference(1000), http.StatusSeeOther, "/"},
+}
+
+//testReference mi dà il riferimento firmato di una reservation, come quello mandato per email
+func testReference(id int) string {
+	return testSigner.Sign(fmt.Sprintf("reservation:%d", id))
+}
+
+func TestRepository_ManageReservation(t *testing.T) {
+	withTestRepo(t)
+	for _, e := range manageReservationTests {
+		req, _ := http.NewRequest("GET", "/my-reservation/"+e.reference, nil)
+		ctx := getCtx(req)
+		ctx = withURLParams(ctx, map[string]string{"ref": e.reference})
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.ManageReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+var changeReservationTests = []struct {
+	name             string
+	reference        string
+	postedData       url.Values
+	expectedLocation string
+	expectedFlash    string
+}{
+	{
+		name:             "valid-dates",
+		reference:        testReference(1),
+		postedData:       url.Values{"start_date": {"2040-02-01"}, "end_date": {"2040-02-03"}},
+		expectedLocation: "/my-reservation/" + testReference(1),
+		expectedFlash:    "Your reservation has been changed",
+	},
+	{
+		name:             "room-not-available",
+		reference:        testReference(1),
+		postedData:       url.Values{"start_date": {"2050-02-01"}, "end_date": {"2050-02-03"}},
+		expectedLocation: "/my-reservation/" + testReference(1),
+	},
+	{
+		name:             "departure-before-arrival",
+		reference:        testReference(1),
+		postedData:       url.Values{"start_date": {"2040-02-03"}, "end_date": {"2040-02-01"}},
+		expectedLocation: "/my-reservation/" + testReference(1),
+	},
+	{
+		name:      "arrival-in-the-past",
+		reference: testReference(1),
+		postedData: url.Values{"start_date": {time.Now().AddDate(0, 0, -1).Format("2006-01-02")},
+			"end_date": {time.Now().AddDate(0, 0, 2).Format("2006-01-02")}},
+		expectedLocation: "/my-reservation/" + testReference(1),
+	},
+	{
+		name:             "invalid-start-date",
+		reference:        testReference(1),
+		postedData:       url.Values{"start_date": {"invalid"}, "end_date": {"2040-02-01"}},
+		expectedLocation: "/my-reservation/" + testReference(1),
+	},
+	{
+		name:             "invalid-reference",
+		reference:        "invalid",
+		postedData:       url.Values{"start_date": {"2040-02-01"}, "end_date": {"2040-02-03"}},
+		expectedLocation: "/",
+	},
+}
+
+func TestRepository_PostChangeReservation(t *testing.T) {
+	db := withTestRepo(t)
+	bookAll(t, db, "2050-02-01", "2050-02-03")
+	for _, e := range changeReservationTests {
+		req, _ := http.NewRequest("POST", "/my-reservation/"+e.reference+"/change", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		ctx = withURLParams(ctx, map[string]string{"ref": e.reference})
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostChangeReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s but got location %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+
+		if e.expectedFlash != "" {
+			if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+				t.Errorf("failed %s: expected flash %s but got %s", e.name, e.expectedFlash, flash)
+			}
+		} else if session.GetString(ctx, "error") == "" {
+			t.Errorf("failed %s: expected an error message in session", e.name)
+		}
+	}
+}
+
+var cancelReservationTests = []struct {
+	name             string
+	reference        string
+	dbFails          bool
+	expectedLocation string
+}{
+	{"delete-fails", testReference(1), true, "/my-reservation/" + testReference(1)},
+	{"valid-reference", testReference(1), false, "/"},
+	{"invalid-reference", "invalid", false, "/"},
+}
+
+func TestRepository_PostCancelReservation(t *testing.T) {
+	db := withTestRepo(t)
+	for _, e := range cancelReservationTests {
+		db.Reset()
+		if e.dbFails {
+			db.Fail("DeleteReservation", errDB)
+		}
+
+		req, _ := http.NewRequest("POST", "/my-reservation/"+e.reference+"/cancel", nil)
+		ctx := getCtx(req)
+		ctx = withURLParams(ctx, map[string]string{"ref": e.reference})
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostCancelReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s but got location %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+	}
+}
+
+//withURLParams mette i parametri dell'url nel context di chi, per chiamare gli handler senza il router
+func withURLParams(ctx context.Context, params map[string]string) context.Context {
+	rctx := chi.NewRouteContext()
+	for k, v := range params {
+		rctx.URLParams.Add(k, v)
+	}
+	return context.WithValue(ctx, chi.RouteCtxKey, rctx)
+}
+
 //
 //
 //la sessione deve avere un context
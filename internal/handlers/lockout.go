@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Laura470/bookings/internal/helpers"
+	"github.com/Laura470/bookings/internal/models"
+)
+
+//the wrong passwords are counted in loginFailureWindow: after maxLoginFailures for an account
+//the account is locked for loginLockout, after maxIPLoginFailures from an address the address waits
+const (
+	loginFailureWindow = 15 * time.Minute
+	maxLoginFailures   = 5
+	maxIPLoginFailures = 20
+	loginLockout       = 30 * time.Minute
+	maxLoginDelay      = 5 * time.Second
+)
+
+//loginDelayStep is how much longer every wrong password makes the next login wait
+var loginDelayStep = 500 * time.Millisecond
+
+//throttleLogin stops the login if the account is locked or the address has made too many wrong logins,
+//otherwise it makes the login wait more for every wrong password and returns how many the account has
+func (m *Repository) throttleLogin(w http.ResponseWriter, r *http.Request, email, ip string) (int, bool) {
+	now := time.Now()
+
+	byEmail, byIP, err := m.DB.CountLoginFailures(r.Context(), email, ip, now.Add(-loginFailureWindow))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return 0, false
+	}
+
+	user, err := m.DB.GetUserByEmail(r.Context(), email)
+	locked := err == nil && user.IsLocked(now)
+
+	if locked || byIP >= maxIPLoginFailures {
+		m.App.Session.Put(r.Context(), "error", "Too many failed logins, try again later or reset your password")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return byEmail, false
+	}
+
+	delay := time.Duration(byEmail+byIP/maxLoginFailures) * loginDelayStep
+	if delay > maxLoginDelay {
+		delay = maxLoginDelay
+	}
+	select {
+	case <-time.After(delay):
+	case <-r.Context().Done():
+		return byEmail, false
+	}
+	return byEmail, true
+}
+
+//loginFailed records a wrong password, failures are the ones before it: at maxLoginFailures the account
+//is locked and the user gets a mail
+func (m *Repository) loginFailed(ctx context.Context, email, ip string, failures int) {
+	err := m.DB.InsertLoginFailure(ctx, email, ip)
+	if err != nil {
+		m.App.Logger.Ctx(ctx).Error("can't record the login failure", "ip", ip, "error", err)
+	}
+
+	if failures+1 < maxLoginFailures {
+		return
+	}
+
+	user, err := m.DB.GetUserByEmail(ctx, email)
+	if err != nil || user.Active != 1 {
+		return
+	}
+
+	user.LockedUntil = time.Now().Add(loginLockout)
+	err = m.DB.LockUser(ctx, user.ID, user.LockedUntil)
+	if err != nil {
+		m.App.Logger.Ctx(ctx).Error("can't lock the account", "user_id", user.ID, "error", err)
+		return
+	}
+	m.App.Logger.Ctx(ctx).Warn("account locked", "user_id", user.ID, "until", user.LockedUntil)
+
+	//se non è stato lui a sbagliare la password lo deve sapere
+	_ = m.queueTemplateMail(ctx, "account-locked", user.Email, &models.MailTemplateData{
+		User:    user,
+		Link:    m.App.BaseURL + "/user/forgot-password",
+		SiteURL: m.App.BaseURL,
+	})
+}
+
+//clientIP returns the address the request comes from, without the port. When it comes from a trusted proxy
+//the address is the one the proxy got the request from, read in X-Forwarded-For or X-Real-IP
+func (m *Repository) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	//chiunque può mandare gli header, valgono solo se li ha messi un proxy di cui ci fidiamo
+	if !m.trustedProxy(net.ParseIP(host)) {
+		return host
+	}
+
+	//ogni proxy aggiunge in fondo chi gli ha mandato la richiesta: da destra il primo che non è
+	//un nostro proxy è il client, quello che c'è prima l'ha scritto lui e non vale
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		addrs := strings.Split(forwarded, ",")
+		for i := len(addrs) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(addrs[i]))
+			if ip == nil {
+				break
+			}
+			host = ip.String()
+			if !m.trustedProxy(ip) {
+				break
+			}
+		}
+		return host
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
+//trustedProxy tells if ip is one of the proxies in front of the site
+func (m *Repository) trustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, p := range m.App.TrustedProxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
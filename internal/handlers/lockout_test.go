@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Laura470/bookings/internal/config"
+)
+
+var throttledLoginTests = []struct {
+	name             string
+	email            string
+	remoteAddr       string
+	expectedLocation string
+	expectedMails    int
+}{
+	{"login", "me@here.ca", "192.168.1.10:50000", "/", 0},
+	{"address with too many failures", "me@here.ca", "10.0.0.66:50000", "/user/login", 0},
+	{"locked account", "lucy@here.ca", "192.168.1.10:50000", "/user/login", 0},
+	{"failure that locks the account", "fred@here.ca", "192.168.1.10:50000", "/user/login", 1},
+}
+
+func TestThrottledLogin(t *testing.T) {
+	db := withTestRepo(t)
+	withPassword(t, db, 1)
+	for _, e := range throttledLoginTests {
+		postedData := url.Values{"email": {e.email}, "password": {testPassword}}
+		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+		req.RemoteAddr = e.remoteAddr
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.PostShowLogin).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected 303 but got %d", e.name, rr.Code)
+		}
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+
+		sent := sendQueuedMail(t, db)
+		if len(sent) != e.expectedMails {
+			t.Fatalf("%s: expected %d mails but got %d", e.name, e.expectedMails, len(sent))
+		}
+		if e.expectedMails > 0 && (sent[0].To != "fred@here.ca" || !strings.Contains(sent[0].Subject, "Locked")) {
+			t.Errorf("%s: the user didn't get the mail about the lock: %+v", e.name, sent[0])
+		}
+	}
+}
+
+var clientIPTests = []struct {
+	name       string
+	remoteAddr string
+	headers    map[string]string
+	expected   string
+}{
+	{"ipv4", "192.168.1.10:50000", nil, "192.168.1.10"},
+	{"ipv6", "[::1]:50000", nil, "::1"},
+	{"no port", "192.168.1.10", nil, "192.168.1.10"},
+	//gli header di chi non è un nostro proxy non contano
+	{"forwarded not from a proxy", "192.168.1.10:50000", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "192.168.1.10"},
+	{"real ip not from a proxy", "192.168.1.10:50000", map[string]string{"X-Real-IP": "1.2.3.4"}, "192.168.1.10"},
+	{"forwarded", "10.0.0.1:50000", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "1.2.3.4"},
+	{"forwarded by two proxies", "10.0.0.1:50000", map[string]string{"X-Forwarded-For": "1.2.3.4, 10.0.0.2"}, "1.2.3.4"},
+	{"forwarded written by the client", "10.0.0.1:50000", map[string]string{"X-Forwarded-For": "9.9.9.9, 1.2.3.4"}, "1.2.3.4"},
+	{"forwarded invalid", "10.0.0.1:50000", map[string]string{"X-Forwarded-For": "nobody"}, "10.0.0.1"},
+	{"real ip", "172.16.0.5:50000", map[string]string{"X-Real-IP": "1.2.3.4"}, "1.2.3.4"},
+	{"proxy without headers", "172.16.0.5:50000", nil, "172.16.0.5"},
+}
+
+func TestClientIP(t *testing.T) {
+	withTestRepo(t)
+	proxies, err := config.ParseTrustedProxies("10.0.0.0/8, 172.16.0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.TrustedProxies = proxies
+	defer func() { app.TrustedProxies = nil }()
+
+	for _, e := range clientIPTests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = e.remoteAddr
+		for k, v := range e.headers {
+			req.Header.Set(k, v)
+		}
+		if ip := Repo.clientIP(req); ip != e.expected {
+			t.Errorf("%s: expected %s but got %s", e.name, e.expected, ip)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Laura470/bookings/internal/helpers"
+	"github.com/Laura470/bookings/internal/models"
+	"github.com/Laura470/bookings/internal/render"
+	"github.com/go-chi/chi"
+)
+
+//AdminMail shows the mails not sent yet, the failed ones first
+func (m *Repository) AdminMail(w http.ResponseWriter, r *http.Request) {
+	mails, err := m.DB.AllOutboxMail(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["mails"] = mails
+
+	render.Template(w, r, "admin-mail.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+//AdminResendMail puts a mail back in the queue, to send it now
+func (m *Repository) AdminResendMail(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.ResendOutboxMail(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Mail queued again")
+	http.Redirect(w, r, "/admin/mail", http.StatusSeeOther)
+}
+
+//SendReminders queues a reminder for the guests arriving from today to before from now, every guest gets only one
+func (m *Repository) SendReminders(ctx context.Context, now time.Time, before time.Duration) error {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	reservations, err := m.DB.ReservationsToRemind(ctx, today, now.Add(before))
+	if err != nil {
+		return err
+	}
+
+	for _, res := range reservations {
+		//se non la metto in coda riprovo al prossimo giro
+		err := m.queueTemplateMail(ctx, "reminder", res.Email, m.mailTemplateData(res))
+		if err != nil {
+			continue
+		}
+
+		err = m.DB.SetReservationReminded(ctx, res.ID, now)
+		if err != nil {
+			m.App.Logger.Ctx(ctx).Error("can't mark the reminder as sent", "reservation_id", res.ID, "error", err)
+		}
+	}
+	return nil
+}
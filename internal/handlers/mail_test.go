@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Laura470/bookings/internal/models"
+	"github.com/Laura470/bookings/internal/repository"
+)
+
+//queueFailedMail puts in the outbox a mail that the mailer couldn't send
+func queueFailedMail(t *testing.T, db repository.DatabaseRepo) {
+	ctx := context.Background()
+	if _, err := db.QueueMail(ctx, models.MailData{To: "john@smith.com", Subject: "Reservation Confirmation"}); err != nil {
+		t.Fatal(err)
+	}
+	queued, err := db.ClaimOutboxMail(ctx, 1)
+	if err != nil || len(queued) != 1 {
+		t.Fatalf("can't claim the mail: %v %v", queued, err)
+	}
+	queued[0].Status = models.MailFailed
+	queued[0].LastError = errDB.Error()
+	if err := db.UpdateOutboxMail(ctx, queued[0]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAdminMail(t *testing.T) {
+	db := withTestRepo(t)
+	queueFailedMail(t, db)
+
+	req, _ := http.NewRequest("GET", "/admin/mail", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.AdminMail).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 but got %d", rr.Code)
+	}
+
+	body := rr.Body.String()
+	if !strings.Contains(body, "connection refused") {
+		t.Error("the error of the failed mail is not shown")
+	}
+	if !strings.Contains(body, "/admin/mail/1/resend/do") {
+		t.Error("the failed mail can't be sent again")
+	}
+}
+
+var adminResendMailTests = []struct {
+	name             string
+	id               string
+	expectedCode     int
+	expectedLocation string
+}{
+	{"resend", "1", http.StatusSeeOther, "/admin/mail"},
+	{"invalid id", "one", http.StatusBadRequest, ""},
+}
+
+func TestAdminResendMail(t *testing.T) {
+	db := withTestRepo(t)
+	queueFailedMail(t, db)
+	for _, e := range adminResendMailTests {
+		req, _ := http.NewRequest("GET", "/admin/mail/"+e.id+"/resend/do", nil)
+		ctx := getCtx(req)
+		ctx = withURLParams(ctx, map[string]string{"id": e.id})
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminResendMail).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestReservationEmails(t *testing.T) {
+	db := withTestRepo(t)
+
+	body := `{"room_id":1,"start_date":"2040-01-02","end_date":"2040-01-04","first_name":"John","last_name":"Smith","email":"john@smith.com"}`
+	req, _ := http.NewRequest("POST", "/api/v1/reservations", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer public-token")
+	rr := httptest.NewRecorder()
+	getRoutes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201 but got %d", rr.Code)
+	}
+
+	sent := sendQueuedMail(t, db)
+	if len(sent) != 2 {
+		t.Fatalf("expected 2 mails but got %d", len(sent))
+	}
+	if sent[0].To != "john@smith.com" || !strings.Contains(sent[0].Content, "/my-reservation/") || !strings.Contains(sent[0].Text, "/my-reservation/") {
+		t.Errorf("the guest didn't get the link to the reservation: %+v", sent[0])
+	}
+	if sent[1].To != "owner@fort.com" || sent[1].Subject != "Reservation Received" {
+		t.Errorf("the owner didn't get the reservation: %+v", sent[1])
+	}
+	//il mittente lo mette il mailer
+	if sent[0].From != "" {
+		t.Errorf("expected no sender but got %s", sent[0].From)
+	}
+}
+
+func TestSendReminders(t *testing.T) {
+	db := withTestRepo(t)
+	ctx := context.Background()
+	tomorrow := time.Now().AddDate(0, 0, 1)
+	for _, res := range []models.Reservation{
+		{FirstName: "John", LastName: "Smith", Email: "john@smith.com", RoomID: 1},
+		{FirstName: "Jane", LastName: "Doe", Email: "fail@here.com", RoomID: 2},
+	} {
+		res.StartDate = time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day(), 0, 0, 0, 0, time.UTC)
+		res.EndDate = res.StartDate.AddDate(0, 0, 2)
+		if _, err := db.BookRoom(ctx, res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	failMailTo(db, "fail@here.com")
+
+	err := Repo.SendReminders(ctx, time.Now(), 48*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//la mail per fail@here.com non va in coda
+	sent := sendQueuedMail(t, db)
+	if len(sent) != 1 {
+		t.Fatalf("expected 1 reminder but got %d", len(sent))
+	}
+	if sent[0].To != "john@smith.com" || !strings.Contains(sent[0].Text, "General's Quarters") || !strings.Contains(sent[0].Content, "/my-reservation/") {
+		t.Errorf("wrong reminder: %+v", sent[0])
+	}
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Laura470/bookings/internal/models"
+	"github.com/Laura470/bookings/internal/repository/dbrepo"
+)
+
+//withMemoryRepo makes the handlers use a repository in memory until the end of the test,
+//the faults of the repository returned make the calls fail like the database would
+func withMemoryRepo(t *testing.T) *dbrepo.FaultyRepo {
+	db := dbrepo.NewFaultyRepo(dbrepo.NewMemoryRepo(&app))
+	old := Repo
+	NewHandlers(&Repository{App: &app, DB: db})
+	t.Cleanup(func() {
+		NewHandlers(old)
+	})
+	return db
+}
+
+func postReservation(start, end string) *httptest.ResponseRecorder {
+	form := url.Values{}
+	form.Add("start_date", start)
+	form.Add("end_date", end)
+	form.Add("first_name", "John")
+	form.Add("last_name", "Smith")
+	form.Add("email", "john@smith.com")
+	form.Add("phone", "123456")
+	form.Add("room_id", "1")
+
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(form.Encode()))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestMemory_PostReservation(t *testing.T) {
+	db := withMemoryRepo(t)
+
+	rr := postReservation("2040-01-10", "2040-01-12")
+	if loc := rr.Header().Get("Location"); loc != "/reservation-summary" {
+		t.Fatalf("expected the reservation to be booked but got redirected to %q", loc)
+	}
+	reservations, _ := db.AllReservations(context.Background())
+	if len(reservations) != 1 || reservations[0].LastName != "Smith" || reservations[0].RoomID != 1 {
+		t.Fatalf("expected the reservation of John Smith but got %+v", reservations)
+	}
+	available, _ := db.SearchAvailabilityByDatesByRoomID(context.Background(), date("2040-01-12"), date("2040-01-12"), 1)
+	if available {
+		t.Error("the room is still available on the day of the departure")
+	}
+
+	//le stesse date non si possono prenotare due volte
+	rr = postReservation("2040-01-11", "2040-01-13")
+	if loc := rr.Header().Get("Location"); loc != "/search-availibility" {
+		t.Errorf("expected to search again for dates already booked but got redirected to %q", loc)
+	}
+
+	db.Fail("BookRoom", errDB)
+	rr = postReservation("2040-02-10", "2040-02-12")
+	if loc := rr.Header().Get("Location"); loc != "/" {
+		t.Errorf("expected to go home when the database fails but got redirected to %q", loc)
+	}
+
+	reservations, _ = db.AllReservations(context.Background())
+	if len(reservations) != 1 {
+		t.Errorf("expected only the first reservation but got %d", len(reservations))
+	}
+}
+
+func TestMemory_AdminReservations(t *testing.T) {
+	db := withMemoryRepo(t)
+	ctx := context.Background()
+
+	id, err := db.BookRoom(ctx, models.Reservation{FirstName: "John", StartDate: date("2040-01-10"), EndDate: date("2040-01-12"), RoomID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/admin/process-reservation/new/1/do", nil)
+	req = req.WithContext(withURLParams(getCtx(req), map[string]string{"src": "new", "id": "1"}))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminProcessReservation).ServeHTTP(rr, req)
+
+	if res, _ := db.GetReservationByID(ctx, id); res.Processed != 1 {
+		t.Errorf("reservation %d has not been marked as processed", id)
+	}
+	if fresh, _ := db.AllNewReservations(ctx); len(fresh) != 0 {
+		t.Errorf("expected no new reservations but got %d", len(fresh))
+	}
+
+	req, _ = http.NewRequest("GET", "/admin/delete-reservation/all/1/do", nil)
+	req = req.WithContext(withURLParams(getCtx(req), map[string]string{"src": "all", "id": "1"}))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminDeleteReservation).ServeHTTP(rr, req)
+
+	if available, _ := db.SearchAvailabilityByDatesByRoomID(ctx, date("2040-01-10"), date("2040-01-12"), 1); !available {
+		t.Error("the dates of a deleted reservation are still taken")
+	}
+}
+
+func TestMemory_AdminDeleteBlock(t *testing.T) {
+	db := withMemoryRepo(t)
+	ctx := context.Background()
+
+	id, err := db.InsertRoomBlock(ctx, models.RoomBlock{RoomID: 2, StartDate: date("2040-03-01"), EndDate: date("2040-03-05")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleteBlock := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/admin/blocks/1/delete/do", nil)
+		req = req.WithContext(withURLParams(getCtx(req), map[string]string{"id": "1"}))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminDeleteBlock).ServeHTTP(rr, req)
+		return rr
+	}
+
+	//se il database non risponde il blocco resta
+	db.FailWhen("DeleteRoomBlock", errDB, func(args ...interface{}) bool {
+		return args[0].(int) == id
+	})
+	if rr := deleteBlock(); rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected %d when the database fails but got %d", http.StatusInternalServerError, rr.Code)
+	}
+	if blocks, _ := db.AllRoomBlocks(ctx); len(blocks) != 1 {
+		t.Errorf("expected the block to be still there but got %d blocks", len(blocks))
+	}
+
+	db.Reset()
+	if rr := deleteBlock(); rr.Code != http.StatusSeeOther {
+		t.Errorf("expected %d but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if blocks, _ := db.AllRoomBlocks(ctx); len(blocks) != 0 {
+		t.Errorf("expected no blocks but got %d", len(blocks))
+	}
+	restrictions, _ := db.GetRestrictionForRoomByDate(ctx, 2, date("2040-03-01"), date("2040-03-31"))
+	if len(restrictions) != 0 {
+		t.Errorf("the restrictions of the deleted block are still there: %+v", restrictions)
+	}
+}
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
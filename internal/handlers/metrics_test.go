@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Laura470/bookings/internal/metrics"
+)
+
+func TestBusinessMetrics(t *testing.T) {
+	db := withTestRepo(t)
+	bookAll(t, db, "2050-01-01", "2050-01-04")
+	app.Metrics = metrics.NewSite()
+	defer func() { app.Metrics = nil }()
+
+	routes := getRoutes()
+	for _, e := range []struct {
+		method, url, body string
+	}{
+		{"GET", "/api/v1/availability?start=2040-01-02&end=2040-01-04", ""},
+		{"GET", "/api/v1/availability?start=2050-01-02&end=2050-01-04", ""},
+		{"POST", "/api/v1/reservations", `{"room_id":1,"start_date":"2040-01-02","end_date":"2040-01-04","first_name":"John","last_name":"Smith","email":"john@smith.com"}`},
+		//la stanza non è libera, non conta
+		{"POST", "/api/v1/reservations", `{"room_id":1,"start_date":"2050-01-02","end_date":"2050-01-04","first_name":"John","last_name":"Smith","email":"john@smith.com"}`},
+	} {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.body))
+		req.Header.Set("Authorization", "Bearer public-token")
+		routes.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req, _ := http.NewRequest("POST", "/search-availibility", strings.NewReader("start=2050-01-01&end=2050-01-02"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = req.WithContext(getCtx(req))
+	Repo.PostAvailibility(httptest.NewRecorder(), req)
+
+	tests := []struct {
+		name     string
+		counter  *metrics.Counter
+		source   string
+		expected float64
+	}{
+		{"api searches", app.Metrics.Searches, metrics.SourceAPI, 2},
+		{"api empty searches", app.Metrics.EmptySearches, metrics.SourceAPI, 1},
+		{"api reservations", app.Metrics.Reservations, metrics.SourceAPI, 1},
+		{"web searches", app.Metrics.Searches, metrics.SourceWeb, 1},
+		{"web empty searches", app.Metrics.EmptySearches, metrics.SourceWeb, 1},
+		{"web reservations", app.Metrics.Reservations, metrics.SourceWeb, 0},
+	}
+	for _, e := range tests {
+		if got := e.counter.Value(e.source); got != e.expected {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, got)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Laura470/bookings/internal/forms"
+	"github.com/Laura470/bookings/internal/helpers"
+	"github.com/Laura470/bookings/internal/models"
+	"github.com/Laura470/bookings/internal/render"
+)
+
+//passwordResetLifetime is how long the link to reset the password works
+const passwordResetLifetime = time.Hour
+
+//ForgotPassword shows the form to ask for the link to reset the password
+func (m *Repository) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "forgot-password.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+//PostForgotPassword mails the link to reset the password to the user with the posted email
+func (m *Repository) PostForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email")
+	if !form.Valid() {
+		render.Template(w, r, "forgot-password.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	//la risposta è sempre la stessa, così non si scopre chi ha un account
+	user, err := m.DB.GetUserByEmail(r.Context(), strings.TrimSpace(form.Get("email")))
+	if err == nil && user.Active == 1 {
+		err = m.sendUserToken(r.Context(), user, models.TokenPasswordReset, passwordResetLifetime, "password-reset", "/user/reset-password/")
+		if err != nil {
+			m.App.Logger.Ctx(r.Context()).Error("can't send the password reset link", "user_id", user.ID, "error", err)
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "If the email is of an account, a link to reset the password is on its way")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+//ResetPassword shows the form to choose a new password
+func (m *Repository) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	userToken, ok := m.userTokenFromURL(w, r, models.TokenPasswordReset, "This link is not valid anymore, ask for a new one")
+	if !ok {
+		return
+	}
+
+	m.renderSetPassword(w, r, "reset-password.page.tmpl", userToken, forms.New(nil))
+}
+
+//PostResetPassword saves the new password, the link can't be used again
+func (m *Repository) PostResetPassword(w http.ResponseWriter, r *http.Request) {
+	userToken, ok := m.userTokenFromURL(w, r, models.TokenPasswordReset, "This link is not valid anymore, ask for a new one")
+	if !ok {
+		return
+	}
+
+	m.setPassword(w, r, "reset-password.page.tmpl", userToken, "Your password has been changed, you can log in now")
+}
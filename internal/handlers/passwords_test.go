@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var postForgotPasswordTests = []struct {
+	name          string
+	email         string
+	expectedCode  int
+	expectedMails int
+}{
+	{"user", "ME@here.ca", http.StatusSeeOther, 1},
+	{"unknown email", "nobody@here.ca", http.StatusSeeOther, 0},
+	{"disabled user", "jane@here.ca", http.StatusSeeOther, 0},
+	{"invalid email", "me", http.StatusOK, 0},
+}
+
+func TestPostForgotPassword(t *testing.T) {
+	db := withTestRepo(t)
+	for _, e := range postForgotPasswordTests {
+		postedData := url.Values{"email": {e.email}}
+		req, _ := http.NewRequest("POST", "/user/forgot-password", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.PostForgotPassword).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+
+		sent := sendQueuedMail(t, db)
+		if len(sent) != e.expectedMails {
+			t.Fatalf("%s: expected %d mails but got %d", e.name, e.expectedMails, len(sent))
+		}
+		if e.expectedMails > 0 && (sent[0].To != "me@here.ca" || !strings.Contains(sent[0].Text, "/user/reset-password/")) {
+			t.Errorf("%s: the mail has no link to reset the password: %+v", e.name, sent[0])
+		}
+	}
+}
+
+var resetPasswordTests = []struct {
+	name             string
+	method           string
+	token            string
+	postedData       url.Values
+	expectedCode     int
+	expectedLocation string
+}{
+	{"show", "GET", "reset-token", nil, http.StatusOK, ""},
+	{"invitation token", "GET", "invite-token", nil, http.StatusSeeOther, "/user/login"},
+	{"unknown", "GET", "nobody-token", nil, http.StatusSeeOther, "/user/login"},
+	{
+		"passwords don't match", "POST", "reset-token",
+		url.Values{"password": {"password1"}, "password_confirm": {"password2"}},
+		http.StatusOK, "",
+	},
+	{
+		"short password", "POST", "reset-token",
+		url.Values{"password": {"pass"}, "password_confirm": {"pass"}},
+		http.StatusOK, "",
+	},
+	{
+		"password changed", "POST", "reset-token",
+		url.Values{"password": {"password1"}, "password_confirm": {"password1"}},
+		http.StatusSeeOther, "/user/login",
+	},
+}
+
+func TestResetPassword(t *testing.T) {
+	withTestRepo(t)
+	for _, e := range resetPasswordTests {
+		req, _ := http.NewRequest(e.method, "/user/reset-password/"+e.token, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		ctx = withURLParams(ctx, map[string]string{"token": e.token})
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := Repo.ResetPassword
+		if e.method == "POST" {
+			handler = Repo.PostResetPassword
+		}
+		http.HandlerFunc(handler).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
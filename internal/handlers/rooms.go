@@ -0,0 +1,426 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Laura470/bookings/internal/forms"
+	"github.com/Laura470/bookings/internal/helpers"
+	"github.com/Laura470/bookings/internal/models"
+	"github.com/Laura470/bookings/internal/pricing"
+	"github.com/Laura470/bookings/internal/render"
+	"github.com/Laura470/bookings/internal/repository"
+	"github.com/go-chi/chi"
+)
+
+//Rooms renders the list of the rooms guests can book
+func (m *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	//al pubblico mostro solo le stanze attive
+	var active []models.Room
+	for _, x := range rooms {
+		if x.Active == 1 {
+			active = append(active, x)
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = active
+
+	render.Template(w, r, "rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+//Room renders the page of a room, found by the slug in the url
+func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomBySlug(r.Context(), chi.URLParam(r, "slug"))
+	if err != nil || room.Active != 1 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	render.Template(w, r, "room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+//AdminRooms shows all the rooms, active or not
+func (m *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+//AdminNewRoom shows the form to create a room
+func (m *Repository) AdminNewRoom(w http.ResponseWriter, r *http.Request) {
+	data := make(map[string]interface{})
+	data["room"] = models.Room{Capacity: 2, Active: 1, MinStay: 1}
+
+	render.Template(w, r, "admin-room.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: forms.New(nil),
+	})
+}
+
+//AdminPostNewRoom creates a room
+func (m *Repository) AdminPostNewRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	room, form := m.roomFromForm(r, models.Room{Active: 1})
+	if !form.Valid() {
+		data := make(map[string]interface{})
+		data["room"] = room
+		render.Template(w, r, "admin-room.page.tmpl", &models.TemplateData{
+			Data: data,
+			Form: form,
+		})
+		return
+	}
+
+	_, err = m.DB.InsertRoom(r.Context(), room)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room created")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+//AdminShowRoom shows the form to edit a room
+func (m *Repository) AdminShowRoom(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	render.Template(w, r, "admin-room.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: forms.New(nil),
+	})
+}
+
+//AdminPostShowRoom saves the changes to a room
+func (m *Repository) AdminPostShowRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	existing, err := m.DB.GetRoomByID(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	room, form := m.roomFromForm(r, existing)
+	if !form.Valid() {
+		data := make(map[string]interface{})
+		data["room"] = room
+		render.Template(w, r, "admin-room.page.tmpl", &models.TemplateData{
+			Data: data,
+			Form: form,
+		})
+		return
+	}
+
+	err = m.DB.UpdateRoom(r.Context(), room)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room's changes saved")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+//AdminActivateRoom makes a room bookable again
+func (m *Repository) AdminActivateRoom(w http.ResponseWriter, r *http.Request) {
+	m.setRoomActive(w, r, 1, "Room activated")
+}
+
+//AdminDeactivateRoom hides a room from the site, so it can't be booked anymore
+func (m *Repository) AdminDeactivateRoom(w http.ResponseWriter, r *http.Request) {
+	m.setRoomActive(w, r, 0, "Room deactivated")
+}
+
+func (m *Repository) setRoomActive(w http.ResponseWriter, r *http.Request, active int, message string) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.UpdateActiveForRoom(r.Context(), id, active)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", message)
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+//AdminDeleteRoom deletes a room, if it has no reservations
+func (m *Repository) AdminDeleteRoom(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteRoom(r.Context(), id)
+	if errors.Is(err, repository.ErrRoomHasReservations) {
+		m.App.Session.Put(r.Context(), "error", "The room has reservations and can't be deleted, deactivate it instead")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room deleted")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+//AdminRoomRates shows the seasonal rates of a room, with the form to add one
+func (m *Repository) AdminRoomRates(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	m.renderRoomRates(w, r, room, forms.New(nil))
+}
+
+//AdminPostRoomRate adds a seasonal rate to a room
+func (m *Repository) AdminPostRoomRate(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	rate := models.RoomRate{
+		RoomID: room.ID,
+		Name:   strings.TrimSpace(r.Form.Get("name")),
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "start_date", "end_date", "nightly_rate")
+
+	layout := "2006-01-02"
+	rate.StartDate, err = time.Parse(layout, form.Get("start_date"))
+	if err != nil && form.Errors.Get("start_date") == "" {
+		form.Errors.Add("start_date", "Invalid date")
+	}
+	rate.EndDate, err = time.Parse(layout, form.Get("end_date"))
+	if err != nil && form.Errors.Get("end_date") == "" {
+		form.Errors.Add("end_date", "Invalid date")
+	}
+	if form.Valid() && rate.EndDate.Before(rate.StartDate) {
+		form.Errors.Add("end_date", "The season can't end before it starts")
+	}
+
+	rate.NightlyRate = amountFromForm(form, "nightly_rate")
+	if form.Get("weekend_rate") != "" {
+		rate.WeekendRate = amountFromForm(form, "weekend_rate")
+	}
+	//il soggiorno minimo è facoltativo, 0 vuol dire quello della stanza
+	if form.Get("min_stay") != "" && form.IsInt("min_stay", 1) {
+		rate.MinStay, _ = strconv.Atoi(form.Get("min_stay"))
+	}
+
+	if !form.Valid() {
+		m.renderRoomRates(w, r, room, form)
+		return
+	}
+
+	_, err = m.DB.InsertRoomRate(r.Context(), rate)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Rate added, new reservations will use it")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/rates", room.ID), http.StatusSeeOther)
+}
+
+//AdminDeleteRoomRate deletes a seasonal rate, the reservations already made keep their price
+func (m *Repository) AdminDeleteRoomRate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	rateID, err := strconv.Atoi(chi.URLParam(r, "rate"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteRoomRate(r.Context(), rateID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Rate deleted")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/rates", id), http.StatusSeeOther)
+}
+
+//renderRoomRates renders the rates page, the form keeps what the admin posted
+func (m *Repository) renderRoomRates(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	rates, err := m.DB.GetRatesForRoom(r.Context(), room.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["rates"] = rates
+
+	render.Template(w, r, "admin-room-rates.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+//roomFromForm copies the posted fields over room and validates them
+func (m *Repository) roomFromForm(r *http.Request, room models.Room) (models.Room, *forms.Form) {
+	room.RoomName = strings.TrimSpace(r.Form.Get("room_name"))
+	room.Description = r.Form.Get("description")
+	room.Slug = strings.TrimSpace(r.Form.Get("slug"))
+	if room.Slug == "" {
+		room.Slug = slugify(room.RoomName)
+		r.PostForm.Set("slug", room.Slug)
+	}
+	room.Capacity, _ = strconv.Atoi(r.Form.Get("capacity"))
+
+	room.Photos = nil
+	for _, p := range strings.Split(r.Form.Get("photos"), "\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			room.Photos = append(room.Photos, p)
+		}
+	}
+
+	room.MinStay, _ = strconv.Atoi(r.Form.Get("min_stay"))
+
+	form := forms.New(r.PostForm)
+	form.Required("room_name", "slug", "capacity", "base_rate", "min_stay")
+	form.IsSlug("slug")
+	form.IsInt("capacity", 1)
+	form.IsInt("min_stay", 1)
+
+	room.BaseRate = amountFromForm(form, "base_rate")
+	//la tariffa del weekend è facoltativa, 0 vuol dire uguale alla base
+	room.WeekendRate = 0
+	if form.Get("weekend_rate") != "" {
+		room.WeekendRate = amountFromForm(form, "weekend_rate")
+	}
+
+	//lo slug è nell'url della stanza, quindi deve essere unico
+	if form.Errors.Get("slug") == "" {
+		other, err := m.DB.GetRoomBySlug(r.Context(), room.Slug)
+		if err == nil && other.ID != room.ID {
+			form.Errors.Add("slug", fmt.Sprintf("The slug %s is already used by %s", room.Slug, other.RoomName))
+		}
+	}
+
+	return room, form
+}
+
+//amountFromForm parses a field like 120.50 into cents, adding an error to the form if it is not an amount
+func amountFromForm(form *forms.Form, field string) int {
+	cents, err := pricing.ParseAmount(form.Get(field))
+	if err != nil && form.Errors.Get(field) == "" {
+		form.Errors.Add(field, "This field must be an amount like 120 or 120.50")
+	}
+	return cents
+}
+
+//slugify turns a room name like General's Quarters into generals-quarters
+func slugify(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, c := range strings.ToLower(name) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			b.WriteRune(c)
+			hyphen = false
+		case c == '\'':
+			//General's diventa generals
+		default:
+			if !hyphen && b.Len() > 0 {
+				b.WriteRune('-')
+				hyphen = true
+			}
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var adminPostRoomTests = []struct {
+	name             string
+	url              string
+	params           map[string]string
+	handler          func(*Repository, http.ResponseWriter, *http.Request)
+	postedData       url.Values
+	expectedCode     int
+	expectedLocation string
+	expectedHTML     string
+}{
+	{
+		name:    "new-room",
+		url:     "/admin/rooms/new",
+		handler: (*Repository).AdminPostNewRoom,
+		postedData: url.Values{
+			"room_name": {"Colonel's Loft"},
+			"capacity":  {"3"},
+			"photos":    {"/static/images/outside.png\n/static/images/tray.png"},
+			"base_rate": {"110"},
+			"min_stay":  {"2"},
+		},
+		expectedCode:     http.StatusSeeOther,
+		expectedLocation: "/admin/rooms",
+	},
+	{
+		name:    "new-room-missing-name",
+		url:     "/admin/rooms/new",
+		handler: (*Repository).AdminPostNewRoom,
+		postedData: url.Values{
+			"slug":     {"colonels-loft"},
+			"capacity": {"3"},
+		},
+		expectedCode: http.StatusOK,
+		expectedHTML: `id="room_name"`,
+	},
+	{
+		name:    "new-room-slug-already-used",
+		url:     "/admin/rooms/new",
+		handler: (*Repository).AdminPostNewRoom,
+		postedData: url.Values{
+			"room_name": {"Another Suite"},
+			"slug":      {"majors-suite"},
+			"capacity":  {"2"},
+		},
+		expectedCode: http.StatusOK,
+		expectedHTML: "already used",
+	},
+	{
+		name:    "edit-room",
+		url:     "/admin/rooms/1",
+		params:  map[string]string{"id": "1"},
+		handler: (*Repository).AdminPostShowRoom,
+		postedData: url.Values{
+			"room_name":    {"General's Quarters"},
+			"slug":         {"generals-quarters"},
+			"capacity":     {"4"},
+			"base_rate":    {"120.50"},
+			"weekend_rate": {"150"},
+			"min_stay":     {"1"},
+		},
+		expectedCode:     http.StatusSeeOther,
+		expectedLocation: "/admin/rooms",
+	},
+	{
+		name:    "edit-room-invalid-rate",
+		url:     "/admin/rooms/1",
+		params:  map[string]string{"id": "1"},
+		handler: (*Repository).AdminPostShowRoom,
+		postedData: url.Values{
+			"room_name": {"General's Quarters"},
+			"slug":      {"generals-quarters"},
+			"capacity":  {"4"},
+			"base_rate": {"a lot"},
+			"min_stay":  {"1"},
+		},
+		expectedCode: http.StatusOK,
+		expectedHTML: "must be an amount",
+	},
+	{
+		name:    "edit-room-invalid-capacity",
+		url:     "/admin/rooms/1",
+		params:  map[string]string{"id": "1"},
+		handler: (*Repository).AdminPostShowRoom,
+		postedData: url.Values{
+			"room_name": {"General's Quarters"},
+			"slug":      {"generals-quarters"},
+			"capacity":  {"0"},
+		},
+		expectedCode: http.StatusOK,
+		expectedHTML: "at least 1",
+	},
+	{
+		name:    "edit-non-existent-room",
+		url:     "/admin/rooms/100",
+		params:  map[string]string{"id": "100"},
+		handler: (*Repository).AdminPostShowRoom,
+		postedData: url.Values{
+			"room_name": {"Nowhere"},
+			"capacity":  {"1"},
+		},
+		expectedCode: http.StatusNotFound,
+	},
+}
+
+func TestAdminPostRoom(t *testing.T) {
+	withTestRepo(t)
+	for _, e := range adminPostRoomTests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		ctx = withURLParams(ctx, e.params)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var adminRoomActionTests = []struct {
+	name          string
+	id            string
+	handler       func(*Repository, http.ResponseWriter, *http.Request)
+	expectedCode  int
+	expectedFlash string
+	expectedError string
+}{
+	{"activate", "3", (*Repository).AdminActivateRoom, http.StatusSeeOther, "Room activated", ""},
+	{"deactivate", "1", (*Repository).AdminDeactivateRoom, http.StatusSeeOther, "Room deactivated", ""},
+	{"delete", "3", (*Repository).AdminDeleteRoom, http.StatusSeeOther, "Room deleted", ""},
+	{"delete-with-reservations", "1", (*Repository).AdminDeleteRoom, http.StatusSeeOther, "",
+		"The room has reservations and can't be deleted, deactivate it instead"},
+	{"invalid-id", "x", (*Repository).AdminDeleteRoom, http.StatusBadRequest, "", ""},
+}
+
+func TestAdminRoomActions(t *testing.T) {
+	withTestRepo(t)
+	for _, e := range adminRoomActionTests {
+		req, _ := http.NewRequest("GET", "/admin/rooms/"+e.id+"/do", nil)
+		ctx := getCtx(req)
+		ctx = withURLParams(ctx, map[string]string{"id": e.id})
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q but got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.GetString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("failed %s: expected error %q but got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"General's Quarters": "generals-quarters",
+		"Major's  Suite ":    "majors-suite",
+		"Room 101":           "room-101",
+		"  --  ":             "",
+	}
+
+	for name, expected := range tests {
+		if got := slugify(name); got != expected {
+			t.Errorf("slugify(%q): expected %q but got %q", name, expected, got)
+		}
+	}
+}
+
+var adminRoomRateTests = []struct {
+	name             string
+	params           map[string]string
+	handler          func(*Repository, http.ResponseWriter, *http.Request)
+	postedData       url.Values
+	expectedCode     int
+	expectedLocation string
+	expectedHTML     string
+}{
+	{
+		name:         "rates-page",
+		params:       map[string]string{"id": "1"},
+		handler:      (*Repository).AdminRoomRates,
+		expectedCode: http.StatusOK,
+		expectedHTML: "Summer",
+	},
+	{
+		name:         "rates-page-non-existent-room",
+		params:       map[string]string{"id": "100"},
+		handler:      (*Repository).AdminRoomRates,
+		expectedCode: http.StatusNotFound,
+	},
+	{
+		name:    "new-rate",
+		params:  map[string]string{"id": "1"},
+		handler: (*Repository).AdminPostRoomRate,
+		postedData: url.Values{
+			"name":         {"Christmas"},
+			"start_date":   {"2040-12-20"},
+			"end_date":     {"2040-12-31"},
+			"nightly_rate": {"200"},
+			"min_stay":     {"3"},
+		},
+		expectedCode:     http.StatusSeeOther,
+		expectedLocation: "/admin/rooms/1/rates",
+	},
+	{
+		name:    "new-rate-ends-before-start",
+		params:  map[string]string{"id": "1"},
+		handler: (*Repository).AdminPostRoomRate,
+		postedData: url.Values{
+			"name":         {"Christmas"},
+			"start_date":   {"2040-12-31"},
+			"end_date":     {"2040-12-20"},
+			"nightly_rate": {"200"},
+		},
+		expectedCode: http.StatusOK,
+		expectedHTML: "can&#39;t end before it starts",
+	},
+	{
+		name:    "new-rate-invalid-rate",
+		params:  map[string]string{"id": "1"},
+		handler: (*Repository).AdminPostRoomRate,
+		postedData: url.Values{
+			"name":         {"Christmas"},
+			"start_date":   {"2040-12-20"},
+			"end_date":     {"2040-12-31"},
+			"nightly_rate": {"free"},
+		},
+		expectedCode: http.StatusOK,
+		expectedHTML: "must be an amount",
+	},
+	{
+		name:             "delete-rate",
+		params:           map[string]string{"id": "1", "rate": "1"},
+		handler:          (*Repository).AdminDeleteRoomRate,
+		expectedCode:     http.StatusSeeOther,
+		expectedLocation: "/admin/rooms/1/rates",
+	},
+}
+
+func TestAdminRoomRates(t *testing.T) {
+	withTestRepo(t)
+	for _, e := range adminRoomRateTests {
+		method := "GET"
+		if e.postedData != nil {
+			method = "POST"
+		}
+		req, _ := http.NewRequest(method, "/admin/rooms/1/rates", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		ctx = withURLParams(ctx, e.params)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc == nil || actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s but got location %v", e.name, e.expectedLocation, actualLoc)
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
@@ -1,19 +1,29 @@
 package handlers
 
 import (
+	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	texttemplate "text/template"
 	"time"
 
 	"github.com/Laura470/bookings/internal/config"
+	"github.com/Laura470/bookings/internal/helpers"
+	"github.com/Laura470/bookings/internal/logger"
 	"github.com/Laura470/bookings/internal/models"
+	"github.com/Laura470/bookings/internal/pricing"
 	"github.com/Laura470/bookings/internal/render"
+	"github.com/Laura470/bookings/internal/repository"
+	"github.com/Laura470/bookings/internal/repository/dbrepo"
+	"github.com/Laura470/bookings/internal/tokens"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -23,11 +33,19 @@ import (
 var app config.AppConfig
 var session *scs.SessionManager
 var pathToTemplates = "./../../templates"
+var pathToMailTemplates = "./../../email-templates"
+var testSigner = tokens.NewSigner([]byte("test secret key"))
+
+//errDB is what the repository returns when a test makes the database fail
+var errDB = errors.New("connection refused")
 
 var functions = template.FuncMap{
-	"humanDate":  render.HumanDate,
-	"formatDate": render.FormatDate,
-	"iterate":    render.Iterate,
+	"humanDate":    render.HumanDate,
+	"formatDate":   render.FormatDate,
+	"iterate":      render.Iterate,
+	"formatPrice":  pricing.FormatPrice,
+	"formatAmount": pricing.FormatAmount,
+	"hasRole":      render.HasRole,
 }
 
 func TestMain(m *testing.M) {
@@ -42,11 +60,7 @@ func TestMain(m *testing.M) {
 	//in here so it is available outside the main for the main package (middleware is in the main package)
 	app.InProduction = false
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
-
-	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errorLog
+	app.Logger = logger.New(os.Stdout, logger.Text, logger.LevelInfo)
 
 	session = scs.New() //tolto il due punti
 	session.Lifetime = 24 * time.Hour
@@ -58,13 +72,11 @@ func TestMain(m *testing.M) {
 	//inizializzo la session in config
 	app.Session = session
 
-	//creo una funzione che mi simula la funzione listen email
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-	defer close(mailChan)
+	app.BaseURL = "http://localhost:8080"
+	app.Signer = testSigner
 
-	//chiamo una funzione che creo apposta per il test (più sotto)
-	listenForMail()
+	//nei test il login sbagliato non aspetta
+	loginDelayStep = 0
 
 	//chiamo la funzione CreateTemplateCache dal package render
 	tc, err := CreateTestTemplateCache()
@@ -79,60 +91,315 @@ func TestMain(m *testing.M) {
 	//setto la variabile a false
 	app.UseCache = true
 
-	repo := NewTestRepo(&app)
-	NewHandlers(repo)
+	mtc, err := CreateTestMailTemplateCache()
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.MailTemplateCache = mtc
+
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 	os.Exit(m.Run())
 }
 
-func listenForMail() {
-	go func() {
-		for {
-			<-app.MailChan
+//withTestRepo makes the handlers use, until the end of the test, a repository in memory with the data of seedTestRepo
+func withTestRepo(t *testing.T) *dbrepo.FaultyRepo {
+	db := withMemoryRepo(t)
+	if err := seedTestRepo(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+//seedTestRepo adds to the rooms and the restrictions of the seed migrations:
+//the room 3 that is not active anymore and a summer season for the room 1, with a minimum stay of 3 nights;
+//the owner me@here.ca, the user 2 invited who has no password yet, the disabled user 3, the front desk user 4
+//with 4 wrong passwords, the user 5 locked out and the manager 6 who logs in with the authenticator app
+//and has the recovery code "abcd-efgh";
+//the invitations of the user 2 "invite-token", "expired-token" and "used-token", the password reset "reset-token"
+//of the user 1 and 20 wrong passwords from the address 10.0.0.66;
+//the api tokens "public-token" and "admin-token";
+//the reservation 1 of John Smith in the room 1 from 2040-06-01 to 2040-06-03, the owner block 1 of the room 1
+//from 2040-01-20 to 2040-01-22, the one day block of the room 1 on 2040-01-10 and the external calendars 1 and 2
+//of the room 1, with the calendar 1 taking 2040-01-25 and 2040-01-26.
+//The passwords are set by withPassword, bcrypt is too slow to do it for every test
+func seedTestRepo(ctx context.Context, db repository.DatabaseRepo) error {
+	id, err := db.InsertRoom(ctx, models.Room{RoomName: "Old Room", Slug: "old-room", Capacity: 2, Active: 0})
+	if err != nil {
+		return err
+	}
+	if err := db.UpdateActiveForRoom(ctx, id, 0); err != nil {
+		return err
+	}
+	_, err = db.InsertRoomRate(ctx, models.RoomRate{RoomID: 1, Name: "Summer", NightlyRate: 18000, MinStay: 3,
+		StartDate: date("2040-07-01"), EndDate: date("2040-08-31")})
+	if err != nil {
+		return err
+	}
+
+	for _, u := range []models.User{
+		{FirstName: "Laura", LastName: "Admin", Email: "me@here.ca", AccessLevel: 4, Active: 1},
+		{FirstName: "John", LastName: "Invited", Email: "john@here.ca", AccessLevel: 1, Active: 1},
+		{FirstName: "Jane", LastName: "Disabled", Email: "jane@here.ca", AccessLevel: 1, Active: 0},
+		{FirstName: "Fred", LastName: "Desk", Email: "fred@here.ca", AccessLevel: 2, Active: 1},
+		{FirstName: "Lucy", LastName: "Locked", Email: "lucy@here.ca", AccessLevel: 1, Active: 1},
+		{FirstName: "Tom", LastName: "Totp", Email: "totp@here.ca", AccessLevel: 3, Active: 1},
+	} {
+		if _, err := db.InsertUser(ctx, u); err != nil {
+			return err
+		}
+	}
+	if err := db.LockUser(ctx, 5, time.Now().Add(time.Hour)); err != nil {
+		return err
+	}
+	codes := []string{tokens.Hash("abcd-efgh")}
+	for i := 1; i < 10; i++ {
+		codes = append(codes, tokens.Hash(fmt.Sprintf("code-%d", i)))
+	}
+	if err := db.EnableTOTP(ctx, 6, testTOTPSecret, 0, codes); err != nil {
+		return err
+	}
+
+	for _, x := range []models.UserToken{
+		{UserID: 2, TokenHash: tokens.Hash("invite-token"), Purpose: models.TokenInvitation, ExpiresAt: time.Now().Add(time.Hour)},
+		{UserID: 2, TokenHash: tokens.Hash("expired-token"), Purpose: models.TokenInvitation, ExpiresAt: time.Now().Add(-time.Hour)},
+		{UserID: 2, TokenHash: tokens.Hash("used-token"), Purpose: models.TokenInvitation, ExpiresAt: time.Now().Add(time.Hour)},
+		{UserID: 1, TokenHash: tokens.Hash("reset-token"), Purpose: models.TokenPasswordReset, ExpiresAt: time.Now().Add(time.Hour)},
+	} {
+		if _, err := db.InsertUserToken(ctx, x); err != nil {
+			return err
+		}
+	}
+	if err := db.UseUserToken(ctx, 3); err != nil {
+		return err
+	}
+	for i := 0; i < 4; i++ {
+		if err := db.InsertLoginFailure(ctx, "fred@here.ca", "192.168.1.20"); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < 20; i++ {
+		if err := db.InsertLoginFailure(ctx, "someone@else.com", "10.0.0.66"); err != nil {
+			return err
+		}
+	}
+
+	for _, x := range []models.APIToken{
+		{UserID: 1, Name: "Website", TokenHash: tokens.Hash("public-token"), Scope: models.ScopePublic},
+		{UserID: 1, Name: "Back office", TokenHash: tokens.Hash("admin-token"), Scope: models.ScopeAdmin},
+	} {
+		if _, err := db.InsertAPIToken(ctx, x); err != nil {
+			return err
+		}
+	}
+
+	_, err = db.BookRoom(ctx, models.Reservation{FirstName: "John", LastName: "Smith", Email: "john@smith.com", Phone: "123456",
+		RoomID: 1, StartDate: date("2040-06-01"), EndDate: date("2040-06-03")})
+	if err != nil {
+		return err
+	}
+	_, err = db.InsertRoomBlock(ctx, models.RoomBlock{RoomID: 1, Reason: "Renovation",
+		StartDate: date("2040-01-20"), EndDate: date("2040-01-22")})
+	if err != nil {
+		return err
+	}
+	//un blocco di un giorno senza room_blocks, come quelli fatti dal calendario prima dei blocchi del proprietario
+	err = db.InsertRoomRestriction(ctx, models.RoomRestriction{RoomID: 1, RestrictionID: models.RestrictionBlock,
+		StartDate: date("2040-01-10"), EndDate: date("2040-01-10")})
+	if err != nil {
+		return err
+	}
+	booking := models.ICalFeed{RoomID: 1, Name: "Booking site", URL: "https://booking.example/ical/1.ics"}
+	if booking.ID, err = db.InsertICalFeed(ctx, booking); err != nil {
+		return err
+	}
+	_, err = db.InsertICalFeed(ctx, models.ICalFeed{RoomID: 1, Name: "Other site", URL: "https://other.example/ical/1.ics"})
+	if err != nil {
+		return err
+	}
+	return db.ReplaceICalFeedRestrictions(ctx, booking, []models.Period{{Start: date("2040-01-25"), End: date("2040-01-26")}})
+}
+
+//testPassword is the password that withPassword gives to the users
+const testPassword = "password"
+
+//withPassword gives testPassword to the users ids
+func withPassword(t *testing.T, db repository.DatabaseRepo, ids ...int) {
+	for _, id := range ids {
+		if err := db.UpdatePassword(context.Background(), id, testPassword); err != nil {
+			t.Fatal(err)
 		}
-	}()
+	}
+}
+
+//bookAll books all the active rooms from start to end, so there is no availability on those dates
+func bookAll(t *testing.T, db repository.DatabaseRepo, start, end string) {
+	ctx := context.Background()
+	rooms, err := db.AllRooms(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rooms {
+		if r.Active != 1 {
+			continue
+		}
+		_, err := db.BookRoom(ctx, models.Reservation{FirstName: "Jane", LastName: "Doe", Email: "jane@doe.com",
+			RoomID: r.ID, StartDate: date(start), EndDate: date(end)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+//sendQueuedMail sends the mails in the queue like the outbox does, and returns them
+func sendQueuedMail(t *testing.T, db repository.DatabaseRepo) []models.MailData {
+	ctx := context.Background()
+	queued, err := db.ClaimOutboxMail(ctx, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent []models.MailData
+	for _, o := range queued {
+		o.Status = models.MailSent
+		o.SentAt = time.Now()
+		if err := db.UpdateOutboxMail(ctx, o); err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, o.Mail)
+	}
+	return sent
+}
+
+//failMailTo makes the mails to email fail to go in the queue
+func failMailTo(db *dbrepo.FaultyRepo, email string) {
+	db.FailWhen("QueueMail", errDB, func(args ...interface{}) bool {
+		return args[0].(models.MailData).To == email
+	})
 }
 
 func getRoutes() http.Handler {
 
 	mux := chi.NewRouter()
 	mux.Use(middleware.Recoverer)
-	//mux.Use(NoSurf) non uso il token perchè l'ho già testato nel middleware
-	mux.Use(SessionLoad)
-
-	mux.Get("/", Repo.Home)
-	mux.Get("/about", Repo.About)
-	mux.Get("/generals-quarters", Repo.Generals)
-	mux.Get("/majors-suite", Repo.Majors)
-
-	mux.Get("/search-availibility", Repo.Availibility)
-	mux.Post("/search-availibility", Repo.PostAvailibility)
-	mux.Post("/search-availibility-json", Repo.AvailibilityJSON)
-
-	mux.Get("/contact", Repo.Contact)
-
-	mux.Get("/make-reservation", Repo.Reservation)
-	mux.Post("/make-reservation", Repo.PostReservation)
-	mux.Get("/reservation-summary", Repo.ReservationSummary)
-
-	mux.Get("/user/login", Repo.ShowLogin)
-	mux.Post("/user/login", Repo.PostShowLogin)
-	mux.Get("/user/logout", Repo.Logout)
-
-	mux.Get("/admin/dashboard", Repo.AdminDashBoard)
-	mux.Get("/admin/all-reservations", Repo.AdminAllReservations)
-	mux.Get("/admin/new-reservations", Repo.AdminNewReservations)
-	mux.Get("/admin/reservations-calendar", Repo.AdminReservationsCalendar)
-	mux.Post("/admin/reservations-calendar", Repo.AdminPostReservationsCalendar)
-	mux.Get("/admin/process-reservation/{src}/{id}/do", Repo.AdminProcessReservation)
-	mux.Get("/admin/delete-reservation/{src}/{id}/do", Repo.AdminDeleteReservation)
-
-	mux.Get("/admin/reservations/{src}/{id}/show", Repo.AdminShowReservation)
-	mux.Post("/admin/reservations/{src}/{id}", Repo.AdminPostShowReservation)
-
-	//per potere visualizzare i file statici nelle mie pagine html
-	fileServer := http.FileServer(http.Dir("./static/"))
-	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
+
+	//le api usano i token e non la sessione, quindi niente csrf
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.Use(Repo.APIAuth)
+		mux.NotFound(Repo.APINotFound)
+		mux.MethodNotAllowed(Repo.APIMethodNotAllowed)
+
+		mux.Get("/rooms", Repo.APIRooms)
+		mux.Get("/rooms/{id}", Repo.APIRoom)
+		mux.Get("/availability", Repo.APIAvailability)
+		mux.Post("/reservations", Repo.APIPostReservation)
+		mux.Get("/reservations/{ref}", Repo.APIReservation)
+		mux.Delete("/reservations/{ref}", Repo.APICancelReservation)
+
+		mux.Route("/admin", func(mux chi.Router) {
+			mux.Use(Repo.RequireAPIScope(models.ScopeAdmin))
+			mux.Get("/reservations", Repo.APIAdminReservations)
+			mux.Get("/reservations/{id}", Repo.APIAdminReservation)
+			mux.Post("/reservations/{id}/processed", Repo.APIAdminProcessReservation)
+			mux.Delete("/reservations/{id}", Repo.APIAdminDeleteReservation)
+			mux.Get("/blocks", Repo.APIAdminBlocks)
+			mux.Post("/blocks", Repo.APIAdminPostBlock)
+			mux.Delete("/blocks/{id}", Repo.APIAdminDeleteBlock)
+		})
+	})
+
+	mux.Group(func(mux chi.Router) {
+		//mux.Use(NoSurf) non uso il token perchè l'ho già testato nel middleware
+		mux.Use(SessionLoad)
+
+		mux.Get("/", Repo.Home)
+		mux.Get("/about", Repo.About)
+		mux.Get("/rooms", Repo.Rooms)
+		mux.Get("/rooms/{slug}", Repo.Room)
+		mux.Get("/rooms/{id}/calendar.ics", Repo.RoomCalendar)
+		//i vecchi indirizzi delle stanze, per i link che ci sono già in giro
+		mux.Handle("/generals-quarters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+		mux.Handle("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
+
+		mux.Get("/search-availibility", Repo.Availibility)
+		mux.Post("/search-availibility", Repo.PostAvailibility)
+		mux.Post("/search-availibility-json", Repo.AvailibilityJSON)
+
+		mux.Get("/contact", Repo.Contact)
+
+		mux.Get("/make-reservation", Repo.Reservation)
+		mux.Post("/make-reservation", Repo.PostReservation)
+		mux.Get("/reservation-summary", Repo.ReservationSummary)
+
+		mux.Get("/my-reservation/{ref}", Repo.ManageReservation)
+		mux.Post("/my-reservation/{ref}/change", Repo.PostChangeReservation)
+		mux.Post("/my-reservation/{ref}/cancel", Repo.PostCancelReservation)
+
+		mux.Get("/user/login", Repo.ShowLogin)
+		mux.Post("/user/login", Repo.PostShowLogin)
+		mux.Get("/user/login/2fa", Repo.TwoFactor)
+		mux.Post("/user/login/2fa", Repo.PostTwoFactor)
+		mux.Get("/user/logout", Repo.Logout)
+		mux.Get("/user/invitation/{token}", Repo.Invitation)
+		mux.Post("/user/invitation/{token}", Repo.PostInvitation)
+		mux.Get("/user/forgot-password", Repo.ForgotPassword)
+		mux.Post("/user/forgot-password", Repo.PostForgotPassword)
+		mux.Get("/user/reset-password/{token}", Repo.ResetPassword)
+		mux.Post("/user/reset-password/{token}", Repo.PostResetPassword)
+
+		mux.Get("/admin/dashboard", Repo.AdminDashBoard)
+		mux.Get("/admin/all-reservations", Repo.AdminAllReservations)
+		mux.Get("/admin/new-reservations", Repo.AdminNewReservations)
+		mux.Get("/admin/reservations-calendar", Repo.AdminReservationsCalendar)
+		mux.Post("/admin/reservations-calendar", Repo.AdminPostReservationsCalendar)
+		mux.Get("/admin/blocks", Repo.AdminBlocks)
+		mux.Post("/admin/blocks", Repo.AdminPostBlock)
+		mux.Get("/admin/blocks/{id}/delete/do", Repo.AdminDeleteBlock)
+		mux.Get("/admin/process-reservation/{src}/{id}/do", Repo.AdminProcessReservation)
+		mux.Get("/admin/delete-reservation/{src}/{id}/do", Repo.AdminDeleteReservation)
+
+		mux.Get("/admin/reservations/{src}/{id}/show", Repo.AdminShowReservation)
+		mux.Post("/admin/reservations/{src}/{id}", Repo.AdminPostShowReservation)
+
+		mux.Get("/admin/rooms", Repo.AdminRooms)
+		mux.Get("/admin/rooms/new", Repo.AdminNewRoom)
+		mux.Post("/admin/rooms/new", Repo.AdminPostNewRoom)
+		mux.Get("/admin/rooms/{id}", Repo.AdminShowRoom)
+		mux.Post("/admin/rooms/{id}", Repo.AdminPostShowRoom)
+		mux.Get("/admin/rooms/{id}/activate/do", Repo.AdminActivateRoom)
+		mux.Get("/admin/rooms/{id}/deactivate/do", Repo.AdminDeactivateRoom)
+		mux.Get("/admin/rooms/{id}/delete/do", Repo.AdminDeleteRoom)
+		mux.Get("/admin/rooms/{id}/rates", Repo.AdminRoomRates)
+		mux.Post("/admin/rooms/{id}/rates", Repo.AdminPostRoomRate)
+		mux.Get("/admin/rooms/{id}/rates/{rate}/delete/do", Repo.AdminDeleteRoomRate)
+		mux.Get("/admin/rooms/{id}/calendars", Repo.AdminRoomCalendars)
+		mux.Post("/admin/rooms/{id}/calendars", Repo.AdminPostRoomCalendar)
+		mux.Get("/admin/rooms/{id}/calendars/sync/do", Repo.AdminSyncRoomCalendars)
+		mux.Get("/admin/rooms/{id}/calendars/{feed}/delete/do", Repo.AdminDeleteRoomCalendar)
+		mux.Get("/admin/api-tokens", Repo.AdminAPITokens)
+		mux.Post("/admin/api-tokens", Repo.AdminPostAPIToken)
+		mux.Get("/admin/api-tokens/{id}/delete/do", Repo.AdminDeleteAPIToken)
+		mux.Get("/admin/mail", Repo.AdminMail)
+		mux.Get("/admin/mail/{id}/resend/do", Repo.AdminResendMail)
+		mux.Get("/admin/users", Repo.AdminUsers)
+		mux.Get("/admin/users/new", Repo.AdminNewUser)
+		mux.Post("/admin/users/new", Repo.AdminPostNewUser)
+		mux.Get("/admin/users/{id}", Repo.AdminShowUser)
+		mux.Post("/admin/users/{id}", Repo.AdminPostShowUser)
+		mux.Get("/admin/users/{id}/enable/do", Repo.AdminEnableUser)
+		mux.Get("/admin/users/{id}/disable/do", Repo.AdminDisableUser)
+		mux.Get("/admin/users/{id}/invite/do", Repo.AdminInviteUser)
+		mux.Get("/admin/users/{id}/unlock/do", Repo.AdminUnlockUser)
+		mux.Get("/admin/users/{id}/2fa/reset/do", Repo.AdminResetUserTwoFactor)
+		mux.Get("/admin/profile", Repo.AdminProfile)
+		mux.Get("/admin/profile/2fa", Repo.AdminTwoFactor)
+		mux.Post("/admin/profile/2fa", Repo.AdminPostTwoFactor)
+		mux.Post("/admin/profile/2fa/disable", Repo.AdminPostDisableTwoFactor)
+
+		//per potere visualizzare i file statici nelle mie pagine html
+		fileServer := http.FileServer(http.Dir("./static/"))
+		mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
+	})
+
 	return mux
 }
 
@@ -196,3 +463,36 @@ func CreateTestTemplateCache() (map[string]*template.Template, error) {
 
 	return myCache, nil
 }
+
+func CreateTestMailTemplateCache() (map[string]config.MailTemplate, error) {
+	myCache := map[string]config.MailTemplate{}
+
+	pages, err := filepath.Glob(fmt.Sprintf("%s/*.html.tmpl", pathToMailTemplates))
+	if err != nil {
+		return myCache, err
+	}
+
+	for _, page := range pages {
+		name := strings.TrimSuffix(filepath.Base(page), ".html.tmpl")
+
+		ts, err := template.New(filepath.Base(page)).Funcs(functions).ParseFiles(page)
+		if err != nil {
+			return myCache, err
+		}
+
+		ts, err = ts.ParseGlob(fmt.Sprintf("%s/*.layout.tmpl", pathToMailTemplates))
+		if err != nil {
+			return myCache, err
+		}
+
+		textPage := fmt.Sprintf("%s/%s.txt.tmpl", pathToMailTemplates, name)
+		text, err := texttemplate.New(filepath.Base(textPage)).Funcs(texttemplate.FuncMap(functions)).ParseFiles(textPage)
+		if err != nil {
+			return myCache, err
+		}
+
+		myCache[name] = config.MailTemplate{HTML: ts, Text: text}
+	}
+
+	return myCache, nil
+}
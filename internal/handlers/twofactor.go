@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Laura470/bookings/internal/forms"
+	"github.com/Laura470/bookings/internal/helpers"
+	"github.com/Laura470/bookings/internal/models"
+	"github.com/Laura470/bookings/internal/render"
+	"github.com/Laura470/bookings/internal/tokens"
+	"github.com/Laura470/bookings/internal/totp"
+)
+
+//recoveryCodes is how many recovery codes a user gets when enabling two-factor authentication
+const recoveryCodes = 10
+
+//totpIssuer is the name the authenticator apps show next to the codes
+const totpIssuer = "Fort Smythe"
+
+//RequireTwoFactor sends the users who must use two-factor authentication, and haven't enabled it yet,
+//to the page to enable it. It goes after RequireRole
+func (m *Repository) RequireTwoFactor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ := r.Context().Value(userKey).(models.User)
+		if m.App.Require2FA.Valid() && user.Role().AtLeast(m.App.Require2FA) && !user.HasTOTP() {
+			m.App.Session.Put(r.Context(), "warning", "Enable two-factor authentication to continue")
+			http.Redirect(w, r, "/admin/profile/2fa", http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//TwoFactor shows the form for the code of the authenticator app, after the password
+func (m *Repository) TwoFactor(w http.ResponseWriter, r *http.Request) {
+	if !m.App.Session.Exists(r.Context(), "2fa_user_id") {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	render.Template(w, r, "login-2fa.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+//PostTwoFactor checks the code of the authenticator app, or a recovery code, and logs the user in
+func (m *Repository) PostTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := m.DB.GetUserByID(r.Context(), m.App.Session.GetInt(r.Context(), "2fa_user_id"))
+	if err != nil || !user.HasTOTP() {
+		m.App.Session.Put(r.Context(), "error", "log in first")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	if !form.Valid() {
+		render.Template(w, r, "login-2fa.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	//anche i codici sbagliati contano per bloccare l'account
+	ip := m.clientIP(r)
+	failures, ok := m.throttleLogin(w, r, user.Email, ip)
+	if !ok {
+		return
+	}
+
+	if !m.checkSecondFactor(r, user, form.Get("code")) {
+		m.loginFailed(r.Context(), user.Email, ip, failures)
+		m.App.Session.Put(r.Context(), "error", "invalid code")
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+
+	m.logIn(w, r, user, true)
+}
+
+//AdminProfile shows the account of the logged in user, with its two-factor authentication
+func (m *Repository) AdminProfile(w http.ResponseWriter, r *http.Request) {
+	user, err := m.DB.GetUserByID(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	codes := 0
+	if user.HasTOTP() {
+		codes, err = m.DB.CountRecoveryCodes(r.Context(), user.ID)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["user"] = user
+	data["required"] = m.App.Require2FA.Valid() && user.Role().AtLeast(m.App.Require2FA)
+
+	render.Template(w, r, "admin-profile.page.tmpl", &models.TemplateData{
+		Data:   data,
+		IntMap: map[string]int{"recovery_codes": codes},
+		Form:   forms.New(nil),
+	})
+}
+
+//AdminTwoFactor shows the secret to scan with the authenticator app, it's saved only when the user
+//types a code made with it
+func (m *Repository) AdminTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := m.DB.GetUserByID(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	if user.HasTOTP() {
+		m.App.Session.Put(r.Context(), "warning", "Two-factor authentication is already enabled, disable it first to use a new app")
+		http.Redirect(w, r, "/admin/profile", http.StatusSeeOther)
+		return
+	}
+
+	//il secret resta nella sessione finché l'utente non scrive un codice giusto
+	secret := m.App.Session.GetString(r.Context(), "totp_secret")
+	if secret == "" {
+		secret, err = totp.GenerateSecret()
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		m.App.Session.Put(r.Context(), "totp_secret", secret)
+	}
+
+	m.renderTwoFactor(w, r, user, secret, forms.New(nil))
+}
+
+//AdminPostTwoFactor enables two-factor authentication if the code is right, and shows the recovery codes once
+func (m *Repository) AdminPostTwoFactor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	user, err := m.DB.GetUserByID(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	secret := m.App.Session.GetString(r.Context(), "totp_secret")
+	if secret == "" || user.HasTOTP() {
+		http.Redirect(w, r, "/admin/profile/2fa", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	counter, ok := totp.Validate(secret, form.Get("code"), time.Now(), 0)
+	if form.Errors.Get("code") == "" && !ok {
+		form.Errors.Add("code", "The code is not right, check the clock of your phone")
+	}
+	if !form.Valid() {
+		m.renderTwoFactor(w, r, user, secret, form)
+		return
+	}
+
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodes)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = tokens.Hash(c)
+	}
+
+	err = m.DB.EnableTOTP(r.Context(), user.ID, secret, counter, hashes)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Remove(r.Context(), "totp_secret")
+	m.App.Session.Put(r.Context(), "2fa_passed", true)
+
+	//i codici si vedono solo adesso, nel database c'è solo l'hash
+	data := make(map[string]interface{})
+	data["codes"] = codes
+	m.App.Session.Put(r.Context(), "flash", "Two-factor authentication enabled")
+	render.Template(w, r, "admin-recovery-codes.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+//AdminPostDisableTwoFactor disables two-factor authentication, the user has to type a code to do it
+func (m *Repository) AdminPostDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	user, err := m.DB.GetUserByID(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	if m.App.Require2FA.Valid() && user.Role().AtLeast(m.App.Require2FA) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Two-factor authentication is required for the %s role", user.Role()))
+		http.Redirect(w, r, "/admin/profile", http.StatusSeeOther)
+		return
+	}
+
+	if !user.HasTOTP() || !m.checkSecondFactor(r, user, r.Form.Get("code")) {
+		m.App.Session.Put(r.Context(), "error", "invalid code")
+		http.Redirect(w, r, "/admin/profile", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.DisableTOTP(r.Context(), user.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Two-factor authentication disabled")
+	http.Redirect(w, r, "/admin/profile", http.StatusSeeOther)
+}
+
+//checkSecondFactor tells if code is the current code of the app of the user, or one of its recovery codes,
+//either way the code can't be used again
+func (m *Repository) checkSecondFactor(r *http.Request, user models.User, code string) bool {
+	if counter, ok := totp.Validate(user.TOTPSecret, code, time.Now(), user.TOTPCounter); ok {
+		return m.DB.UseTOTPCounter(r.Context(), user.ID, counter) == nil
+	}
+	return m.DB.UseRecoveryCode(r.Context(), user.ID, tokens.Hash(totp.NormalizeRecoveryCode(code))) == nil
+}
+
+//renderTwoFactor renders the page with the secret to scan
+func (m *Repository) renderTwoFactor(w http.ResponseWriter, r *http.Request, user models.User, secret string, form *forms.Form) {
+	render.Template(w, r, "admin-2fa.page.tmpl", &models.TemplateData{
+		StringMap: map[string]string{
+			"secret": secret,
+			"uri":    totp.URI(secret, totpIssuer, user.Email),
+		},
+		Form: form,
+	})
+}
+
+//logIn puts the user in the session, after the password and, if the user has it, the code of the app
+func (m *Repository) logIn(w http.ResponseWriter, r *http.Request, user models.User, twoFactor bool) {
+	err := m.DB.ClearLoginFailures(r.Context(), user.Email)
+	if err != nil {
+		m.App.Logger.Ctx(r.Context()).Error("can't clear the login failures", "user_id", user.ID, "error", err)
+	}
+
+	//previene gli attacchi tramite furto del token  session fixation attac
+	_ = m.App.Session.RenewToken(r.Context())
+
+	m.App.Session.Remove(r.Context(), "2fa_user_id")
+	m.App.Session.Put(r.Context(), "user_id", user.ID)
+	m.App.Session.Put(r.Context(), "2fa_passed", twoFactor)
+	m.App.Session.Put(r.Context(), "flash", "Logged in succesfully")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
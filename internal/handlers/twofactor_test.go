@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Laura470/bookings/internal/models"
+	"github.com/Laura470/bookings/internal/totp"
+)
+
+//testTOTPSecret is the secret of the user 6 of seedTestRepo
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+func currentCode(t *testing.T, secret string) string {
+	code, err := totp.Code(secret, totp.Counter(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestLoginWithTwoFactor(t *testing.T) {
+	db := withTestRepo(t)
+	withPassword(t, db, 6)
+
+	postedData := url.Values{"email": {"totp@here.ca"}, "password": {testPassword}}
+	req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.PostShowLogin).ServeHTTP(rr, req)
+
+	if rr.Header().Get("Location") != "/user/login/2fa" {
+		t.Errorf("expected location /user/login/2fa but got %s", rr.Header().Get("Location"))
+	}
+	if session.Exists(ctx, "user_id") || session.GetInt(ctx, "2fa_user_id") != 6 {
+		t.Error("the user is logged in with the password only")
+	}
+}
+
+func TestPostTwoFactor(t *testing.T) {
+	withTestRepo(t)
+	tests := []struct {
+		name             string
+		pendingUser      int
+		code             string
+		expectedLocation string
+	}{
+		{"code of the app", 6, currentCode(t, testTOTPSecret), "/"},
+		{"recovery code", 6, "ABCD EFGH", "/"},
+		{"wrong code", 6, "000000", "/user/login/2fa"},
+		{"no password first", 0, currentCode(t, testTOTPSecret), "/user/login"},
+		{"user without two-factor", 1, "000000", "/user/login"},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"code": {e.code}}
+		req, _ := http.NewRequest("POST", "/user/login/2fa", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		if e.pendingUser != 0 {
+			session.Put(ctx, "2fa_user_id", e.pendingUser)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.PostTwoFactor).ServeHTTP(rr, req)
+
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+
+		loggedIn := e.expectedLocation == "/"
+		if loggedIn != (session.GetInt(ctx, "user_id") == 6 && session.GetBool(ctx, "2fa_passed")) {
+			t.Errorf("%s: wrong session after the code", e.name)
+		}
+	}
+}
+
+func TestRequireTwoFactor(t *testing.T) {
+	withTestRepo(t)
+	defer func() { app.Require2FA = 0 }()
+
+	tests := []struct {
+		name         string
+		required     models.Role
+		userID       int
+		expectedCode int
+	}{
+		{"optional", 0, 1, http.StatusOK},
+		{"required and enabled", models.RoleManager, 6, http.StatusOK},
+		{"required and not enabled", models.RoleManager, 1, http.StatusSeeOther},
+		{"required for higher roles", models.RoleManager, 4, http.StatusOK},
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, e := range tests {
+		app.Require2FA = e.required
+
+		req, _ := http.NewRequest("GET", "/admin/dashboard", nil)
+		ctx := getCtx(req)
+		session.Put(ctx, "user_id", e.userID)
+		session.Put(ctx, "2fa_passed", true)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		Repo.RequireRole(models.RoleViewer)(Repo.RequireTwoFactor(next)).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+	}
+}
+
+func TestRequireRoleWithoutSecondFactor(t *testing.T) {
+	withTestRepo(t)
+	req, _ := http.NewRequest("GET", "/admin/dashboard", nil)
+	ctx := getCtx(req)
+	session.Put(ctx, "user_id", 6)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	Repo.RequireRole(models.RoleViewer)(next).ServeHTTP(rr, req)
+
+	if rr.Header().Get("Location") != "/user/login" {
+		t.Errorf("a user with two-factor got in without the code, location %s", rr.Header().Get("Location"))
+	}
+}
+
+func TestAdminProfile(t *testing.T) {
+	withTestRepo(t)
+	req, _ := http.NewRequest("GET", "/admin/profile", nil)
+	ctx := getCtx(req)
+	session.Put(ctx, "user_id", 6)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.AdminProfile).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 but got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "10 recovery codes left") {
+		t.Error("the recovery codes left are not shown")
+	}
+}
+
+func TestAdminEnableTwoFactor(t *testing.T) {
+	withTestRepo(t)
+	req, _ := http.NewRequest("GET", "/admin/profile/2fa", nil)
+	ctx := getCtx(req)
+	session.Put(ctx, "user_id", 1)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.AdminTwoFactor).ServeHTTP(rr, req)
+
+	secret := session.GetString(ctx, "totp_secret")
+	if rr.Code != http.StatusOK || secret == "" {
+		t.Fatalf("expected 200 and a new secret but got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), secret) {
+		t.Error("the secret is not shown")
+	}
+
+	for _, code := range []string{"000000", currentCode(t, secret)} {
+		postedData := url.Values{"code": {code}}
+		req, _ = http.NewRequest("POST", "/admin/profile/2fa", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr = httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminPostTwoFactor).ServeHTTP(rr, req)
+
+		enabled := strings.Contains(rr.Body.String(), "Recovery Codes")
+		if rr.Code != http.StatusOK || enabled != (code != "000000") {
+			t.Errorf("code %s: got %d, enabled %t", code, rr.Code, enabled)
+		}
+	}
+	if session.Exists(ctx, "totp_secret") || !session.GetBool(ctx, "2fa_passed") {
+		t.Error("the session is not updated after enabling two-factor")
+	}
+}
+
+func TestAdminPostDisableTwoFactor(t *testing.T) {
+	withTestRepo(t)
+	defer func() { app.Require2FA = 0 }()
+
+	tests := []struct {
+		name          string
+		required      models.Role
+		code          string
+		expectedFlash bool
+	}{
+		{"wrong code", 0, "000000", false},
+		{"required", models.RoleManager, "abcd-efgh", false},
+		{"disabled", 0, "abcd-efgh", true},
+	}
+
+	for _, e := range tests {
+		app.Require2FA = e.required
+
+		postedData := url.Values{"code": {e.code}}
+		req, _ := http.NewRequest("POST", "/admin/profile/2fa/disable", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		session.Put(ctx, "user_id", 6)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminPostDisableTwoFactor).ServeHTTP(rr, req)
+
+		if rr.Header().Get("Location") != "/admin/profile" {
+			t.Errorf("%s: expected location /admin/profile but got %s", e.name, rr.Header().Get("Location"))
+		}
+		if session.Exists(ctx, "flash") != e.expectedFlash {
+			t.Errorf("%s: expected disabled %t", e.name, e.expectedFlash)
+		}
+	}
+}
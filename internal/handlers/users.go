@@ -0,0 +1,481 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Laura470/bookings/internal/forms"
+	"github.com/Laura470/bookings/internal/helpers"
+	"github.com/Laura470/bookings/internal/models"
+	"github.com/Laura470/bookings/internal/render"
+	"github.com/Laura470/bookings/internal/tokens"
+	"github.com/go-chi/chi"
+)
+
+//invitationLifetime is how long the link sent with an invitation works
+const invitationLifetime = 7 * 24 * time.Hour
+
+//minPasswordLength is the shortest password a user can choose
+const minPasswordLength = 8
+
+type userContextKey string
+
+//userKey is where RequireRole puts the logged in user in the context
+const userKey userContextKey = "user"
+
+//RequireRole lets through only the users with at least the role min, it goes after Auth
+//the user is read again at every request, so a new role or a disabled user count at once
+func (m *Repository) RequireRole(min models.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			//nei gruppi annidati l'utente è già stato letto dal primo RequireRole
+			user, ok := r.Context().Value(userKey).(models.User)
+			if !ok {
+				user, ok = m.sessionUser(w, r)
+				if !ok {
+					return
+				}
+				r = r.WithContext(context.WithValue(r.Context(), userKey, user))
+			}
+
+			if !user.Role().AtLeast(min) {
+				m.App.Session.Put(r.Context(), "error", fmt.Sprintf("You need the %s role for this page", min))
+				http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+//sessionUser returns the logged in user, if it can still use the back office, otherwise it sends it to the login
+func (m *Repository) sessionUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	user, err := m.DB.GetUserByID(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, r, err)
+		return user, false
+	}
+
+	//chi ha attivato la verifica in due passaggi in un'altra sessione deve rifare il login
+	if err != nil || user.Active != 1 || !user.Role().Valid() ||
+		(user.HasTOTP() && !m.App.Session.GetBool(r.Context(), "2fa_passed")) {
+		_ = m.App.Session.Destroy(r.Context())
+		m.App.Session.Put(r.Context(), "error", "log in first")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return user, false
+	}
+
+	//il ruolo nella sessione serve ai template per nascondere i menu
+	if m.App.Session.GetInt(r.Context(), "access_level") != user.AccessLevel {
+		m.App.Session.Put(r.Context(), "access_level", user.AccessLevel)
+	}
+	return user, true
+}
+
+//AdminUsers shows all the staff users
+func (m *Repository) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := m.DB.AllUsers(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	now := time.Now()
+	var locked []models.User
+	for _, u := range users {
+		if u.IsLocked(now) {
+			locked = append(locked, u)
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["users"] = users
+	data["locked"] = locked
+
+	render.Template(w, r, "admin-users.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+//AdminNewUser shows the form to create a user
+func (m *Repository) AdminNewUser(w http.ResponseWriter, r *http.Request) {
+	m.renderUser(w, r, models.User{AccessLevel: int(models.RoleViewer), Active: 1}, forms.New(nil))
+}
+
+//AdminPostNewUser creates a user, with the password given by the admin or, without it, sending an invitation
+func (m *Repository) AdminPostNewUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	user, form := m.userFromForm(r, models.User{Active: 1})
+	if !form.Valid() {
+		m.renderUser(w, r, user, form)
+		return
+	}
+
+	user.ID, err = m.DB.InsertUser(r.Context(), user)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	password := form.Get("password")
+	if password != "" {
+		err = m.DB.UpdatePassword(r.Context(), user.ID, password)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		m.App.Session.Put(r.Context(), "flash", "User created")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	err = m.inviteUser(r.Context(), user)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "User created, but the invitation can't be sent: try again from the list")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("User created, an invitation has been sent to %s", user.Email))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+//AdminShowUser shows the form to edit a user
+func (m *Repository) AdminShowUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	user, err := m.DB.GetUserByID(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	m.renderUser(w, r, user, forms.New(nil))
+}
+
+//AdminPostShowUser saves the changes to a user, the password only if the admin typed a new one
+func (m *Repository) AdminPostShowUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	existing, err := m.DB.GetUserByID(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	user, form := m.userFromForm(r, existing)
+	if !form.Valid() {
+		m.renderUser(w, r, user, form)
+		return
+	}
+
+	err = m.DB.UpdateUser(r.Context(), user)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	if password := form.Get("password"); password != "" {
+		err = m.DB.UpdatePassword(r.Context(), user.ID, password)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "User's changes saved")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+//AdminEnableUser lets a disabled user log in again
+func (m *Repository) AdminEnableUser(w http.ResponseWriter, r *http.Request) {
+	m.setUserActive(w, r, 1, "User enabled")
+}
+
+//AdminDisableUser stops a user from logging in, the user is kept with its history
+func (m *Repository) AdminDisableUser(w http.ResponseWriter, r *http.Request) {
+	m.setUserActive(w, r, 0, "User disabled")
+}
+
+func (m *Repository) setUserActive(w http.ResponseWriter, r *http.Request, active int, message string) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	//non posso chiudermi fuori da solo
+	if active == 0 && id == m.App.Session.GetInt(r.Context(), "user_id") {
+		m.App.Session.Put(r.Context(), "error", "You can't disable yourself")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.UpdateActiveForUser(r.Context(), id, active)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", message)
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+//AdminUnlockUser lets a user locked out by too many wrong passwords log in again
+func (m *Repository) AdminUnlockUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.UnlockUser(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "User unlocked")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+//AdminResetUserTwoFactor removes the two-factor authentication of a user who lost the app and the recovery codes
+func (m *Repository) AdminResetUserTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DisableTOTP(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Two-factor authentication removed, the user can enable it again from the profile")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+//AdminInviteUser sends again the invitation to a user who hasn't set a password yet
+func (m *Repository) AdminInviteUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	user, err := m.DB.GetUserByID(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	if user.HasPassword() {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s has already set a password", user.Email))
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	err = m.inviteUser(r.Context(), user)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Invitation sent to %s", user.Email))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+//Invitation shows the form where an invited user chooses the password
+func (m *Repository) Invitation(w http.ResponseWriter, r *http.Request) {
+	userToken, ok := m.userTokenFromURL(w, r, models.TokenInvitation, "This link is not valid anymore, ask for a new invitation")
+	if !ok {
+		return
+	}
+
+	m.renderSetPassword(w, r, "invitation.page.tmpl", userToken, forms.New(nil))
+}
+
+//PostInvitation sets the password of an invited user, the link can't be used again
+func (m *Repository) PostInvitation(w http.ResponseWriter, r *http.Request) {
+	userToken, ok := m.userTokenFromURL(w, r, models.TokenInvitation, "This link is not valid anymore, ask for a new invitation")
+	if !ok {
+		return
+	}
+
+	m.setPassword(w, r, "invitation.page.tmpl", userToken, "Your password has been set, you can log in now")
+}
+
+//userTokenFromURL returns the token in the url, if it's for purpose and it can still be used,
+//otherwise it shows message in the login page
+func (m *Repository) userTokenFromURL(w http.ResponseWriter, r *http.Request, purpose, message string) (models.UserToken, bool) {
+	userToken, err := m.DB.GetUserTokenByHash(r.Context(), tokens.Hash(chi.URLParam(r, "token")))
+	if err != nil || userToken.Purpose != purpose || !userToken.UsedAt.IsZero() || time.Now().After(userToken.ExpiresAt) {
+		m.App.Session.Put(r.Context(), "error", message)
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return userToken, false
+	}
+	return userToken, true
+}
+
+//setPassword validates the posted password and saves it for the user of the token, that can't be used again
+func (m *Repository) setPassword(w http.ResponseWriter, r *http.Request, tmpl string, userToken models.UserToken, message string) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("password", "password_confirm")
+	form.MinLength("password", minPasswordLength)
+	if form.Get("password") != form.Get("password_confirm") {
+		form.Errors.Add("password_confirm", "The passwords don't match")
+	}
+	if !form.Valid() {
+		m.renderSetPassword(w, r, tmpl, userToken, form)
+		return
+	}
+
+	//se due richieste arrivano insieme solo la prima usa il token
+	err = m.DB.UseUserToken(r.Context(), userToken.ID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "This link has been used already")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.UpdatePassword(r.Context(), userToken.UserID, form.Get("password"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	//con la password nuova chi l'aveva indovinata non entra più, quindi l'account si sblocca
+	err = m.DB.UnlockUser(r.Context(), userToken.UserID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", message)
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+//renderUser renders the form of user, with the roles to choose from
+func (m *Repository) renderUser(w http.ResponseWriter, r *http.Request, user models.User, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["user"] = user
+	data["roles"] = models.Roles
+
+	render.Template(w, r, "admin-user.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+//renderSetPassword renders tmpl, the form to choose the password with the link of the token
+func (m *Repository) renderSetPassword(w http.ResponseWriter, r *http.Request, tmpl string, userToken models.UserToken, form *forms.Form) {
+	user, err := m.DB.GetUserByID(r.Context(), userToken.UserID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["user"] = user
+
+	render.Template(w, r, tmpl, &models.TemplateData{
+		Data:      data,
+		Form:      form,
+		StringMap: map[string]string{"token": chi.URLParam(r, "token")},
+	})
+}
+
+//inviteUser sends to the user the one-time link to choose the password
+func (m *Repository) inviteUser(ctx context.Context, user models.User) error {
+	return m.sendUserToken(ctx, user, models.TokenInvitation, invitationLifetime, "invitation", "/user/invitation/")
+}
+
+//sendUserToken stores the hash of a new token for purpose and mails the link with the token in clear to the user
+func (m *Repository) sendUserToken(ctx context.Context, user models.User, purpose string, lifetime time.Duration, tmpl, path string) error {
+	token, err := tokens.Generate()
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.InsertUserToken(ctx, models.UserToken{
+		UserID:    user.ID,
+		TokenHash: tokens.Hash(token),
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(lifetime),
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.queueTemplateMail(ctx, tmpl, user.Email, &models.MailTemplateData{
+		User:    user,
+		Link:    m.App.BaseURL + path + token,
+		SiteURL: m.App.BaseURL,
+	})
+}
+
+//userFromForm copies the posted fields over user and validates them, the password is optional
+func (m *Repository) userFromForm(r *http.Request, user models.User) (models.User, *forms.Form) {
+	user.FirstName = strings.TrimSpace(r.Form.Get("first_name"))
+	user.LastName = strings.TrimSpace(r.Form.Get("last_name"))
+	user.Email = strings.TrimSpace(r.Form.Get("email"))
+	user.AccessLevel, _ = strconv.Atoi(r.Form.Get("access_level"))
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email", "access_level")
+	form.IsEmail("email")
+	if form.Get("access_level") != "" && !user.Role().Valid() {
+		form.Errors.Add("access_level", "Choose one of the roles")
+	}
+	if form.Get("password") != "" {
+		form.MinLength("password", minPasswordLength)
+	}
+
+	//se l'owner si toglie il ruolo nessuno può più gestire gli utenti
+	if user.ID != 0 && user.ID == m.App.Session.GetInt(r.Context(), "user_id") && !user.Role().AtLeast(models.RoleOwner) {
+		form.Errors.Add("access_level", "You can't take the owner role away from yourself")
+	}
+
+	//l'email serve per entrare, quindi deve essere unica
+	if form.Errors.Get("email") == "" {
+		other, err := m.DB.GetUserByEmail(r.Context(), user.Email)
+		if err == nil && other.ID != user.ID {
+			form.Errors.Add("email", fmt.Sprintf("The email %s is already used by %s %s", user.Email, other.FirstName, other.LastName))
+		}
+	}
+
+	return user, form
+}
@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Laura470/bookings/internal/models"
+)
+
+func TestAdminUsers(t *testing.T) {
+	withTestRepo(t)
+	req, _ := http.NewRequest("GET", "/admin/users", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.AdminUsers).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 but got %d", rr.Code)
+	}
+
+	body := rr.Body.String()
+	for _, s := range []string{"me@here.ca", "Invited", "Disabled", "/admin/users/2/invite/do", "/admin/users/3/enable/do", "Locked Accounts", "/admin/users/5/unlock/do"} {
+		if !strings.Contains(body, s) {
+			t.Errorf("%q not in the list of users", s)
+		}
+	}
+}
+
+var adminPostNewUserTests = []struct {
+	name             string
+	postedData       url.Values
+	expectedCode     int
+	expectedLocation string
+	expectedMails    int
+}{
+	{
+		name: "with password",
+		postedData: url.Values{
+			"first_name":   {"Mary"},
+			"last_name":    {"Poppins"},
+			"email":        {"mary@here.ca"},
+			"access_level": {"1"},
+			"password":     {"supercalifragilistic"},
+		},
+		expectedCode:     http.StatusSeeOther,
+		expectedLocation: "/admin/users",
+	},
+	{
+		name: "invitation",
+		postedData: url.Values{
+			"first_name":   {"Mary"},
+			"last_name":    {"Poppins"},
+			"email":        {"mary@here.ca"},
+			"access_level": {"1"},
+		},
+		expectedCode:     http.StatusSeeOther,
+		expectedLocation: "/admin/users",
+		expectedMails:    1,
+	},
+	{
+		name: "email already used",
+		postedData: url.Values{
+			"first_name":   {"Mary"},
+			"last_name":    {"Poppins"},
+			"email":        {"JOHN@here.ca"},
+			"access_level": {"1"},
+		},
+		expectedCode: http.StatusOK,
+	},
+	{
+		name: "short password",
+		postedData: url.Values{
+			"first_name":   {"Mary"},
+			"last_name":    {"Poppins"},
+			"email":        {"mary@here.ca"},
+			"access_level": {"1"},
+			"password":     {"short"},
+		},
+		expectedCode: http.StatusOK,
+	},
+	{
+		name: "invalid access level",
+		postedData: url.Values{
+			"first_name":   {"Mary"},
+			"last_name":    {"Poppins"},
+			"email":        {"mary@here.ca"},
+			"access_level": {"admin"},
+		},
+		expectedCode: http.StatusOK,
+	},
+	{
+		name: "unknown role",
+		postedData: url.Values{
+			"first_name":   {"Mary"},
+			"last_name":    {"Poppins"},
+			"email":        {"mary@here.ca"},
+			"access_level": {"9"},
+		},
+		expectedCode: http.StatusOK,
+	},
+}
+
+func TestAdminPostNewUser(t *testing.T) {
+	for _, e := range adminPostNewUserTests {
+		//ogni caso ha il suo repository, se no mary@here.ca esiste già
+		db := withTestRepo(t)
+
+		req, _ := http.NewRequest("POST", "/admin/users/new", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminPostNewUser).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+
+		sent := sendQueuedMail(t, db)
+		if len(sent) != e.expectedMails {
+			t.Fatalf("%s: expected %d mails but got %d", e.name, e.expectedMails, len(sent))
+		}
+		if e.expectedMails > 0 && (sent[0].To != "mary@here.ca" || !strings.Contains(sent[0].Text, "/user/invitation/")) {
+			t.Errorf("%s: the invitation has no link: %+v", e.name, sent[0])
+		}
+	}
+}
+
+var adminUserTests = []struct {
+	name             string
+	url              string
+	id               string
+	handler          func(*Repository, http.ResponseWriter, *http.Request)
+	expectedCode     int
+	expectedLocation string
+}{
+	{"show", "/admin/users/1", "1", (*Repository).AdminShowUser, http.StatusOK, ""},
+	{"show missing", "/admin/users/100", "100", (*Repository).AdminShowUser, http.StatusNotFound, ""},
+	{"show invalid id", "/admin/users/one", "one", (*Repository).AdminShowUser, http.StatusBadRequest, ""},
+	{"enable", "/admin/users/3/enable/do", "3", (*Repository).AdminEnableUser, http.StatusSeeOther, "/admin/users"},
+	{"disable", "/admin/users/2/disable/do", "2", (*Repository).AdminDisableUser, http.StatusSeeOther, "/admin/users"},
+	{"invite", "/admin/users/2/invite/do", "2", (*Repository).AdminInviteUser, http.StatusSeeOther, "/admin/users"},
+	{"invite with password", "/admin/users/1/invite/do", "1", (*Repository).AdminInviteUser, http.StatusSeeOther, "/admin/users"},
+	{"invite missing", "/admin/users/100/invite/do", "100", (*Repository).AdminInviteUser, http.StatusNotFound, ""},
+	{"unlock", "/admin/users/5/unlock/do", "5", (*Repository).AdminUnlockUser, http.StatusSeeOther, "/admin/users"},
+	{"remove two-factor", "/admin/users/6/2fa/reset/do", "6", (*Repository).AdminResetUserTwoFactor, http.StatusSeeOther, "/admin/users"},
+	{"unlock invalid id", "/admin/users/one/unlock/do", "one", (*Repository).AdminUnlockUser, http.StatusBadRequest, ""},
+}
+
+func TestAdminUser(t *testing.T) {
+	db := withTestRepo(t)
+	withPassword(t, db, 1)
+	for _, e := range adminUserTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		ctx = withURLParams(ctx, map[string]string{"id": e.id})
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestAdminDisableYourself(t *testing.T) {
+	withTestRepo(t)
+	req, _ := http.NewRequest("GET", "/admin/users/1/disable/do", nil)
+	ctx := getCtx(req)
+	ctx = withURLParams(ctx, map[string]string{"id": "1"})
+	req = req.WithContext(ctx)
+	session.Put(ctx, "user_id", 1)
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.AdminDisableUser).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected 303 but got %d", rr.Code)
+	}
+	if session.GetString(ctx, "error") == "" {
+		t.Error("an admin could disable themself")
+	}
+}
+
+func TestAdminPostShowUser(t *testing.T) {
+	withTestRepo(t)
+	postedData := url.Values{
+		"first_name":   {"John"},
+		"last_name":    {"Doe"},
+		"email":        {"john@here.ca"},
+		"access_level": {"2"},
+	}
+
+	for _, id := range []string{"2", "1"} {
+		req, _ := http.NewRequest("POST", "/admin/users/"+id, strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		ctx = withURLParams(ctx, map[string]string{"id": id})
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminPostShowUser).ServeHTTP(rr, req)
+
+		//l'utente 1 non può prendere l'email dell'utente 2
+		expected := http.StatusSeeOther
+		if id == "1" {
+			expected = http.StatusOK
+		}
+		if rr.Code != expected {
+			t.Errorf("user %s: expected %d but got %d", id, expected, rr.Code)
+		}
+	}
+}
+
+//un utente disabilitato o che non è più owner perde i token delle api
+func TestAdminUserRevokesAPITokens(t *testing.T) {
+	for _, demote := range []bool{false, true} {
+		withTestRepo(t)
+		postedData := url.Values{
+			"first_name":   {"Me"},
+			"last_name":    {"Here"},
+			"email":        {"me@here.ca"},
+			"access_level": {"3"},
+		}
+
+		req, _ := http.NewRequest("GET", "/admin/users/1/disable/do", nil)
+		handler := Repo.AdminDisableUser
+		if demote {
+			req, _ = http.NewRequest("POST", "/admin/users/1", strings.NewReader(postedData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			handler = Repo.AdminPostShowUser
+		}
+		ctx := getCtx(req)
+		ctx = withURLParams(ctx, map[string]string{"id": "1"})
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 6)
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(handler).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Fatalf("demote %t: expected 303 but got %d", demote, rr.Code)
+		}
+		for _, token := range []string{"public-token", "admin-token"} {
+			req, _ = http.NewRequest("GET", "/api/v1/rooms", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rr = httptest.NewRecorder()
+			getRoutes().ServeHTTP(rr, req)
+			if rr.Code != http.StatusUnauthorized {
+				t.Errorf("demote %t: expected 401 for %s but got %d", demote, token, rr.Code)
+			}
+		}
+	}
+}
+
+var invitationTests = []struct {
+	name             string
+	method           string
+	token            string
+	postedData       url.Values
+	expectedCode     int
+	expectedLocation string
+}{
+	{"show", "GET", "invite-token", nil, http.StatusOK, ""},
+	{"expired", "GET", "expired-token", nil, http.StatusSeeOther, "/user/login"},
+	{"used", "GET", "used-token", nil, http.StatusSeeOther, "/user/login"},
+	{"unknown", "GET", "nobody-token", nil, http.StatusSeeOther, "/user/login"},
+	{
+		"passwords don't match", "POST", "invite-token",
+		url.Values{"password": {"password1"}, "password_confirm": {"password2"}},
+		http.StatusOK, "",
+	},
+	{
+		"short password", "POST", "invite-token",
+		url.Values{"password": {"pass"}, "password_confirm": {"pass"}},
+		http.StatusOK, "",
+	},
+	{
+		"password set", "POST", "invite-token",
+		url.Values{"password": {"password1"}, "password_confirm": {"password1"}},
+		http.StatusSeeOther, "/user/login",
+	},
+	{
+		"post expired", "POST", "expired-token",
+		url.Values{"password": {"password1"}, "password_confirm": {"password1"}},
+		http.StatusSeeOther, "/user/login",
+	},
+}
+
+func TestInvitation(t *testing.T) {
+	withTestRepo(t)
+	for _, e := range invitationTests {
+		req, _ := http.NewRequest(e.method, "/user/invitation/"+e.token, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		ctx = withURLParams(ctx, map[string]string{"token": e.token})
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := Repo.Invitation
+		if e.method == "POST" {
+			handler = Repo.PostInvitation
+		}
+		http.HandlerFunc(handler).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
+
+var requireRoleTests = []struct {
+	name             string
+	userID           int
+	min              models.Role
+	expectedCode     int
+	expectedLocation string
+}{
+	{"owner", 1, models.RoleOwner, http.StatusOK, ""},
+	{"front desk", 4, models.RoleFrontDesk, http.StatusOK, ""},
+	{"front desk on a manager page", 4, models.RoleManager, http.StatusSeeOther, "/admin/dashboard"},
+	{"disabled", 3, models.RoleViewer, http.StatusSeeOther, "/user/login"},
+	{"deleted", 100, models.RoleViewer, http.StatusSeeOther, "/user/login"},
+}
+
+func TestRequireRole(t *testing.T) {
+	withTestRepo(t)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, e := range requireRoleTests {
+		req, _ := http.NewRequest("GET", "/admin/users", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", e.userID)
+		rr := httptest.NewRecorder()
+
+		Repo.RequireRole(e.min)(next).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestAdminTakeOwnerRoleFromYourself(t *testing.T) {
+	withTestRepo(t)
+	postedData := url.Values{
+		"first_name":   {"Laura"},
+		"last_name":    {"Admin"},
+		"email":        {"me@here.ca"},
+		"access_level": {"3"},
+	}
+
+	req, _ := http.NewRequest("POST", "/admin/users/1", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	ctx = withURLParams(ctx, map[string]string{"id": "1"})
+	req = req.WithContext(ctx)
+	session.Put(ctx, "user_id", 1)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.AdminPostShowUser).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("the owner could become a manager, got %d", rr.Code)
+	}
+}
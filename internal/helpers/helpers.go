@@ -1,7 +1,6 @@
 package helpers
 
 import (
-	"fmt"
 	"net/http"
 	"runtime/debug"
 
@@ -15,13 +14,12 @@ func NewHelpers(a *config.AppConfig) {
 }
 
 func ClientError(w http.ResponseWriter, status int) {
-	app.InfoLog.Println("Client error with status of", status)
 	http.Error(w, http.StatusText(status), status)
 }
 
-func ServerError(w http.ResponseWriter, err error) {
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
-	app.ErrorLog.Panicln(trace)
+//ServerError logs err with the stack and the request id, and answers with a 500
+func ServerError(w http.ResponseWriter, r *http.Request, err error) {
+	app.Logger.Ctx(r.Context()).Error("server error", "error", err, "stack", string(debug.Stack()))
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
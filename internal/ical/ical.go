@@ -0,0 +1,278 @@
+package ical
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//maxFeedSize is the biggest calendar we read, the calendars of a room are a few kilobytes
+const maxFeedSize = 5 << 20
+
+//Event is a VEVENT of a calendar. All day events end the day after the last day, like in the ics files
+type Event struct {
+	UID     string
+	Summary string
+	Start   time.Time
+	End     time.Time
+	AllDay  bool
+}
+
+//Days returns the first and the last day the event occupies, both included
+func (e Event) Days() (time.Time, time.Time) {
+	first := day(e.Start)
+	if !e.End.After(e.Start) {
+		return first, first
+	}
+
+	//la fine è esclusa: un evento che finisce a mezzanotte non occupa quel giorno
+	last := day(e.End.Add(-time.Nanosecond))
+	if last.Before(first) {
+		last = first
+	}
+	return first, last
+}
+
+func day(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+//Write writes a calendar with the given events, the all day ones as dates
+func Write(w io.Writer, name string, events []Event) error {
+	bw := bufio.NewWriter(w)
+
+	line := func(s string) {
+		bw.WriteString(fold(s))
+		bw.WriteString("\r\n")
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//Bookings//Calendar//EN")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	line("X-WR-CALNAME:" + escape(name))
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	for _, e := range events {
+		line("BEGIN:VEVENT")
+		line("UID:" + escape(e.UID))
+		line("DTSTAMP:" + stamp)
+		if e.AllDay {
+			line("DTSTART;VALUE=DATE:" + e.Start.Format("20060102"))
+			line("DTEND;VALUE=DATE:" + e.End.Format("20060102"))
+		} else {
+			line("DTSTART:" + e.Start.UTC().Format("20060102T150405Z"))
+			line("DTEND:" + e.End.UTC().Format("20060102T150405Z"))
+		}
+		line("SUMMARY:" + escape(e.Summary))
+		line("END:VEVENT")
+	}
+
+	line("END:VCALENDAR")
+	return bw.Flush()
+}
+
+//Parse reads the events of a calendar. Events without a start are skipped,
+//events without an end last one day when all day, otherwise they end when they start
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var e *Event
+	var hasEnd bool
+
+	for n, l := range lines {
+		name, params, value, ok := splitLine(l)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			e = &Event{}
+			hasEnd = false
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			if e == nil {
+				return nil, fmt.Errorf("line %d: END:VEVENT without BEGIN:VEVENT", n+1)
+			}
+			if !e.Start.IsZero() {
+				if !hasEnd {
+					e.End = e.Start
+					if e.AllDay {
+						e.End = e.Start.AddDate(0, 0, 1)
+					}
+				}
+				events = append(events, *e)
+			}
+			e = nil
+		case e == nil:
+			//fuori dagli eventi non mi interessa niente
+		case name == "UID":
+			e.UID = unescape(value)
+		case name == "SUMMARY":
+			e.Summary = unescape(value)
+		case name == "DTSTART":
+			e.Start, e.AllDay, err = parseTime(params, value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+1, err)
+			}
+		case name == "DTEND":
+			e.End, _, err = parseTime(params, value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+1, err)
+			}
+			hasEnd = true
+		}
+	}
+
+	return events, nil
+}
+
+//Fetch downloads and parses the calendar at rawURL. Besides http and https it reads
+//webcal, that is http with another name. The files of this machine can't be read, the
+//address is chosen by the managers and the errors of the parser end up in the admin
+func Fetch(ctx context.Context, client *http.Client, rawURL string) ([]Event, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.ReadCloser
+	switch u.Scheme {
+	case "webcal":
+		u.Scheme = "https"
+		fallthrough
+	case "http", "https":
+		req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s answered %s", u.Host, resp.Status)
+		}
+		body = resp.Body
+	default:
+		return nil, fmt.Errorf("unsupported calendar address %s", rawURL)
+	}
+	defer body.Close()
+
+	return Parse(io.LimitReader(body, maxFeedSize))
+}
+
+//ValidURL tells if Fetch can read the calendar at rawURL
+func ValidURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "http", "https", "webcal":
+		return u.Host != ""
+	}
+	return false
+}
+
+//unfold joins the lines that go on in the next one, they start with a space or a tab
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxFeedSize)
+	for scanner.Scan() {
+		l := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += l[1:]
+			continue
+		}
+		lines = append(lines, l)
+	}
+	return lines, scanner.Err()
+}
+
+//splitLine splits a line like DTSTART;VALUE=DATE:20400101 in name, parameters and value
+func splitLine(l string) (string, map[string]string, string, bool) {
+	i := strings.Index(l, ":")
+	if i < 0 {
+		return "", nil, "", false
+	}
+
+	parts := strings.Split(l[:i], ";")
+	params := make(map[string]string)
+	for _, p := range parts[1:] {
+		if kv := strings.SplitN(p, "=", 2); len(kv) == 2 {
+			params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return strings.ToUpper(parts[0]), params, l[i+1:], true
+}
+
+func parseTime(params map[string]string, value string) (time.Time, bool, error) {
+	if params["VALUE"] == "DATE" || len(value) == len("20060102") {
+		t, err := time.Parse("20060102", value)
+		return t, true, err
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+
+	//senza fuso orario, o con un fuso che non conosciamo, uso UTC
+	loc := time.UTC
+	if tz, ok := params["TZID"]; ok {
+		if l, err := time.LoadLocation(tz); err == nil {
+			loc = l
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	if err != nil {
+		return t, false, errors.New("invalid date " + value)
+	}
+	return t, false, nil
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+var unescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func unescape(s string) string {
+	return unescaper.Replace(s)
+}
+
+//fold splits the lines longer than 75 bytes, as the ics format wants
+func fold(s string) string {
+	if len(s) <= 75 {
+		return s
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		size := len(string(r))
+		if n+size > 75 {
+			b.WriteString("\r\n ")
+			n = 1
+		}
+		b.WriteRune(r)
+		n += size
+	}
+	return b.String()
+}
@@ -0,0 +1,171 @@
+package ical
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestParse(t *testing.T) {
+	f, err := os.Open("testdata/booking.ics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	events, err := Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//l'evento senza inizio non c'è
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events but got %d", len(events))
+	}
+
+	if events[0].UID != "abc-1@booking.example" || !events[0].AllDay {
+		t.Errorf("wrong first event: %+v", events[0])
+	}
+	if events[0].Summary != "CLOSED - Not available" {
+		t.Errorf("folded summary not joined: %q", events[0].Summary)
+	}
+	if events[1].Summary != "Reserved, thanks" {
+		t.Errorf("summary not unescaped: %q", events[1].Summary)
+	}
+	if events[1].AllDay || events[1].Start.Hour() != 14 {
+		t.Errorf("wrong date time event: %+v", events[1])
+	}
+	if !events[2].End.Equal(events[2].Start) {
+		t.Errorf("an event without end should end when it starts: %+v", events[2])
+	}
+	if _, offset := events[2].Start.Zone(); offset == 0 {
+		t.Error("the time zone of the event was ignored")
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	_, err := Parse(strings.NewReader("BEGIN:VCALENDAR\nBEGIN:VEVENT\nDTSTART:tomorrow\nEND:VEVENT\nEND:VCALENDAR\n"))
+	if err == nil {
+		t.Error("expected an error for an invalid date")
+	}
+
+	_, err = Parse(strings.NewReader("BEGIN:VCALENDAR\nEND:VEVENT\nEND:VCALENDAR\n"))
+	if err == nil {
+		t.Error("expected an error for an event that never begins")
+	}
+}
+
+var daysTests = []struct {
+	name          string
+	event         Event
+	expectedFirst string
+	expectedLast  string
+}{
+	{"all day", Event{Start: date("2040-01-05"), End: date("2040-01-08"), AllDay: true}, "2040-01-05", "2040-01-07"},
+	{"one day", Event{Start: date("2040-01-05"), End: date("2040-01-06"), AllDay: true}, "2040-01-05", "2040-01-05"},
+	{"no end", Event{Start: date("2040-01-05"), End: date("2040-01-05")}, "2040-01-05", "2040-01-05"},
+	{"date time", Event{Start: date("2040-01-10").Add(14 * time.Hour), End: date("2040-01-12").Add(10 * time.Hour)}, "2040-01-10", "2040-01-12"},
+}
+
+func TestEvent_Days(t *testing.T) {
+	for _, e := range daysTests {
+		first, last := e.event.Days()
+		if !first.Equal(date(e.expectedFirst)) || !last.Equal(date(e.expectedLast)) {
+			t.Errorf("%s: expected %s to %s but got %s to %s", e.name, e.expectedFirst, e.expectedLast,
+				first.Format("2006-01-02"), last.Format("2006-01-02"))
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	events := []Event{
+		{UID: "restriction-1@bookings", Summary: "Reserved", Start: date("2040-01-05"), End: date("2040-01-08"), AllDay: true},
+		{UID: "restriction-2@bookings", Summary: "Blocked; " + strings.Repeat("long ", 30), Start: date("2040-01-10"), End: date("2040-01-11"), AllDay: true},
+	}
+
+	var buf bytes.Buffer
+	err := Write(&buf, "General's Quarters", events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, l := range strings.Split(buf.String(), "\r\n") {
+		if len(l) > 75 {
+			t.Errorf("line longer than 75 bytes: %q", l)
+		}
+	}
+
+	//quello che scrivo lo devo poter rileggere
+	back, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(back) != 2 {
+		t.Fatalf("expected 2 events but got %d", len(back))
+	}
+	for i := range events {
+		if back[i].UID != events[i].UID || back[i].Summary != events[i].Summary ||
+			!back[i].Start.Equal(events[i].Start) || !back[i].End.Equal(events[i].End) {
+			t.Errorf("event %d: expected %+v but got %+v", i, events[i], back[i])
+		}
+	}
+}
+
+func TestFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/room.ics" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, "testdata/booking.ics")
+	}))
+	defer srv.Close()
+
+	events, err := Fetch(context.Background(), srv.Client(), srv.URL+"/room.ics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Errorf("expected 3 events from the server but got %d", len(events))
+	}
+
+	_, err = Fetch(context.Background(), srv.Client(), srv.URL+"/missing.ics")
+	if err == nil {
+		t.Error("expected an error for a missing calendar")
+	}
+
+	//i file della macchina non si leggono
+	for _, u := range []string{"ftp://example.com/room.ics", "file:///etc/passwd"} {
+		if _, err := Fetch(context.Background(), srv.Client(), u); err == nil {
+			t.Errorf("expected an error for the unsupported address %s", u)
+		}
+	}
+}
+
+func TestValidURL(t *testing.T) {
+	tests := map[string]bool{
+		"https://example.com/room.ics":  true,
+		"http://example.com/room.ics":   true,
+		"webcal://example.com/room.ics": true,
+		"file:///var/calendars/1.ics":   false,
+		"ftp://example.com/room.ics":    false,
+		"https://":                      false,
+		"example.com/room.ics":          false,
+		"":                              false,
+	}
+	for u, expected := range tests {
+		if got := ValidURL(u); got != expected {
+			t.Errorf("ValidURL(%q): expected %v but got %v", u, expected, got)
+		}
+	}
+}
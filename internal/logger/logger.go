@@ -0,0 +1,298 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Level is how important a log line is
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+//String returns the name of the level
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return "level(" + strconv.Itoa(int(l)) + ")"
+}
+
+//ParseLevel returns the level with the given name
+func ParseLevel(name string) (Level, error) {
+	for l, n := range levelNames {
+		if strings.EqualFold(n, name) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q, use debug, info, warn or error", name)
+}
+
+//Format is how the lines are written
+type Format int
+
+const (
+	//Text is one line of key=value, easy to read in development
+	Text Format = iota
+	//JSON is one object per line, for the log collectors in production
+	JSON
+)
+
+//ParseFormat returns the format with the given name
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "text":
+		return Text, nil
+	case "json":
+		return JSON, nil
+	}
+	return 0, fmt.Errorf("unknown log format %q, use text or json", name)
+}
+
+//Logger writes structured log lines, a message with a list of key and value pairs
+type Logger struct {
+	out    *output
+	level  Level
+	fields []interface{}
+}
+
+//output is shared by a logger and the ones made from it with With, so the lines don't get mixed
+type output struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format Format
+	now    func() time.Time
+}
+
+//New returns a logger that writes the lines of at least level to w
+func New(w io.Writer, format Format, level Level) *Logger {
+	return &Logger{
+		out:   &output{w: w, format: format, now: time.Now},
+		level: level,
+	}
+}
+
+//Discard returns a logger that writes nothing, for the tests
+func Discard() *Logger {
+	return New(io.Discard, Text, LevelError+1)
+}
+
+//With returns a logger that adds the key and value pairs to every line
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return &Logger{out: l.out, level: l.level, fields: fields}
+}
+
+//Ctx returns a logger that adds the request id of ctx to every line
+func (l *Logger) Ctx(ctx context.Context) *Logger {
+	id := RequestID(ctx)
+	if id == "" {
+		return l
+	}
+	return l.With("request_id", id)
+}
+
+//Debug logs msg at debug level
+func (l *Logger) Debug(msg string, kv ...interface{}) {
+	l.log(LevelDebug, msg, kv)
+}
+
+//Info logs msg at info level
+func (l *Logger) Info(msg string, kv ...interface{}) {
+	l.log(LevelInfo, msg, kv)
+}
+
+//Warn logs msg at warn level
+func (l *Logger) Warn(msg string, kv ...interface{}) {
+	l.log(LevelWarn, msg, kv)
+}
+
+//Error logs msg at error level
+func (l *Logger) Error(msg string, kv ...interface{}) {
+	l.log(LevelError, msg, kv)
+}
+
+//StdLogger returns a *log.Logger that writes every line at level, for the packages that want one like http.Server
+func (l *Logger) StdLogger(level Level) *log.Logger {
+	return log.New(writerFunc(func(p []byte) (int, error) {
+		l.log(level, strings.TrimSpace(string(p)), nil)
+		return len(p), nil
+	}), "", 0)
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func (l *Logger) log(level Level, msg string, kv []interface{}) {
+	if level < l.level {
+		return
+	}
+
+	fields := l.fields
+	if len(kv) > 0 {
+		fields = append(fields[:len(fields):len(fields)], kv...)
+	}
+
+	var buf bytes.Buffer
+	now := l.out.now().UTC().Format(time.RFC3339Nano)
+	if l.out.format == JSON {
+		writeJSON(&buf, now, level, msg, fields)
+	} else {
+		writeText(&buf, now, level, msg, fields)
+	}
+	buf.WriteByte('\n')
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(buf.Bytes())
+}
+
+func writeJSON(buf *bytes.Buffer, now string, level Level, msg string, fields []interface{}) {
+	buf.WriteString(`{"time":`)
+	writeJSONValue(buf, now)
+	buf.WriteString(`,"level":`)
+	writeJSONValue(buf, level.String())
+	buf.WriteString(`,"msg":`)
+	writeJSONValue(buf, msg)
+	for i := 0; i < len(fields); i += 2 {
+		key, value := pair(fields, i)
+		buf.WriteByte(',')
+		writeJSONValue(buf, key)
+		buf.WriteByte(':')
+		writeJSONValue(buf, value)
+	}
+	buf.WriteByte('}')
+}
+
+func writeJSONValue(buf *bytes.Buffer, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(b)
+}
+
+func writeText(buf *bytes.Buffer, now string, level Level, msg string, fields []interface{}) {
+	buf.WriteString(now)
+	buf.WriteByte(' ')
+	buf.WriteString(strings.ToUpper(level.String()))
+	buf.WriteByte(' ')
+	buf.WriteString(msg)
+	for i := 0; i < len(fields); i += 2 {
+		key, value := pair(fields, i)
+		buf.WriteByte(' ')
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		buf.WriteString(textValue(value))
+	}
+}
+
+//textValue quotes the values with spaces or quotes, so a line can still be split in its pairs
+func textValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " \t\n\r\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+//pair returns the key and the value at i, turning the values json can't show well in strings
+func pair(fields []interface{}, i int) (string, interface{}) {
+	key := fmt.Sprint(fields[i])
+	if i+1 >= len(fields) {
+		return key, nil
+	}
+
+	switch v := fields[i+1].(type) {
+	case error:
+		return key, v.Error()
+	case time.Duration:
+		return key, v.String()
+	case time.Time:
+		return key, v
+	case fmt.Stringer:
+		return key, v.String()
+	default:
+		return key, v
+	}
+}
+
+type contextKey string
+
+const requestKey = contextKey("request")
+
+//request is what the log knows of the request being served
+type request struct {
+	id     string
+	mu     sync.Mutex
+	userID int
+}
+
+//WithRequestID returns a context with the id of the request
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestKey, &request{id: id})
+}
+
+//RequestID returns the id of the request of ctx, empty if there's none
+func RequestID(ctx context.Context) string {
+	if r, ok := ctx.Value(requestKey).(*request); ok {
+		return r.id
+	}
+	return ""
+}
+
+//SetUserID records who made the request of ctx, it does nothing when ctx has no request id
+func SetUserID(ctx context.Context, userID int) {
+	if r, ok := ctx.Value(requestKey).(*request); ok {
+		r.mu.Lock()
+		r.userID = userID
+		r.mu.Unlock()
+	}
+}
+
+//UserID returns the user set with SetUserID, 0 if there's none
+func UserID(ctx context.Context) int {
+	if r, ok := ctx.Value(requestKey).(*request); ok {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return r.userID
+	}
+	return 0
+}
+
+//NewRequestID returns a new random request id
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		//senza casualità un id che cambia ogni volta basta lo stesso
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
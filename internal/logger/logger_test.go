@@ -0,0 +1,107 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+//testLogger returns a logger with a fixed clock, so the lines can be compared
+func testLogger(format Format, level Level) (*Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	l := New(&buf, format, level)
+	l.out.now = func() time.Time {
+		return time.Date(2050, 1, 2, 3, 4, 5, 0, time.UTC)
+	}
+	return l, &buf
+}
+
+func TestText(t *testing.T) {
+	l, buf := testLogger(Text, LevelInfo)
+
+	l.With("user_id", 3).Info("request", "path", "/admin/dashboard", "latency", 1500*time.Millisecond, "error", errors.New("no rows"))
+
+	expected := `2050-01-02T03:04:05Z INFO request user_id=3 path=/admin/dashboard latency=1.5s error="no rows"` + "\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q but got %q", expected, buf.String())
+	}
+}
+
+func TestJSON(t *testing.T) {
+	l, buf := testLogger(JSON, LevelInfo)
+
+	l.Error("mail not sent", "mail_id", 7, "error", errors.New("connection refused"))
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("the line is not json: %v %q", err, buf.String())
+	}
+	if line["level"] != "error" || line["msg"] != "mail not sent" || line["mail_id"] != float64(7) || line["error"] != "connection refused" {
+		t.Errorf("wrong line %q", buf.String())
+	}
+	if !strings.HasPrefix(buf.String(), `{"time":"2050-01-02T03:04:05Z","level":"error","msg":"mail not sent"`) {
+		t.Errorf("time, level and msg are not the first keys: %q", buf.String())
+	}
+}
+
+func TestLevel(t *testing.T) {
+	l, buf := testLogger(Text, LevelWarn)
+
+	l.Info("not written")
+	l.Debug("not written")
+	if buf.Len() != 0 {
+		t.Errorf("lines under the level have been written: %q", buf.String())
+	}
+
+	l.Warn("written")
+	if !strings.Contains(buf.String(), "WARN written") {
+		t.Errorf("the warn line has not been written: %q", buf.String())
+	}
+
+	level, err := ParseLevel("DEBUG")
+	if err != nil || level != LevelDebug {
+		t.Errorf("expected debug but got %v (%v)", level, err)
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("unknown level parsed")
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	l, buf := testLogger(Text, LevelInfo)
+
+	ctx := context.Background()
+	if RequestID(ctx) != "" || UserID(ctx) != 0 {
+		t.Error("empty context has a request")
+	}
+	//senza richiesta non deve succedere niente
+	SetUserID(ctx, 1)
+
+	ctx = WithRequestID(ctx, "abc123")
+	SetUserID(ctx, 4)
+	if RequestID(ctx) != "abc123" || UserID(ctx) != 4 {
+		t.Errorf("expected abc123 and 4 but got %s and %d", RequestID(ctx), UserID(ctx))
+	}
+
+	l.Ctx(ctx).Info("hello")
+	if !strings.Contains(buf.String(), "request_id=abc123") {
+		t.Errorf("the request id is not in the line: %q", buf.String())
+	}
+
+	if NewRequestID() == NewRequestID() {
+		t.Error("two request ids are the same")
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	l, buf := testLogger(Text, LevelInfo)
+
+	l.StdLogger(LevelError).Println("http: TLS handshake error")
+	if !strings.HasSuffix(buf.String(), "ERROR http: TLS handshake error\n") {
+		t.Errorf("wrong line %q", buf.String())
+	}
+}
@@ -0,0 +1,239 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Laura470/bookings/internal/models"
+	mail "github.com/xhit/go-simple-mail"
+)
+
+//Mailer sends a mail, the error tells the outbox to try again later. Check tells if the mails can be sent
+//right now, without sending one
+type Mailer interface {
+	Send(m models.MailData) error
+	Check(ctx context.Context) error
+}
+
+//Encryption is how the connection to the mail server is protected
+type Encryption string
+
+const (
+	EncryptionNone     Encryption = "none"
+	EncryptionSTARTTLS Encryption = "starttls"
+	EncryptionTLS      Encryption = "tls"
+)
+
+//ParseEncryption returns the encryption called s, "" is no encryption
+func ParseEncryption(s string) (Encryption, error) {
+	switch e := Encryption(strings.ToLower(s)); e {
+	case "", EncryptionNone:
+		return EncryptionNone, nil
+	case EncryptionSTARTTLS, EncryptionTLS:
+		return e, nil
+	}
+	return "", fmt.Errorf("unknown mail encryption %q, use none, starttls or tls", s)
+}
+
+//SMTP sends the mails to a mail server
+type SMTP struct {
+	Host       string
+	Port       int
+	Username   string
+	Password   string
+	Encryption Encryption
+	From       string
+	Timeout    time.Duration
+}
+
+//Send sends m to the mail server
+func (s *SMTP) Send(m models.MailData) error {
+	email, err := message(m, s.From)
+	if err != nil {
+		return err
+	}
+
+	server := mail.NewSMTPClient()
+	server.Host = s.Host
+	server.Port = s.Port
+	server.Username = s.Username
+	server.Password = s.Password
+	server.KeepAlive = false
+	server.ConnectTimeout = 10 * time.Second
+	server.SendTimeout = 10 * time.Second
+	if s.Timeout > 0 {
+		server.ConnectTimeout = s.Timeout
+		server.SendTimeout = s.Timeout
+	}
+
+	//nella libreria TLS è lo STARTTLS, SSL è la connessione già cifrata
+	switch s.Encryption {
+	case EncryptionSTARTTLS:
+		server.Encryption = mail.EncryptionTLS
+	case EncryptionTLS:
+		server.Encryption = mail.EncryptionSSL
+	default:
+		server.Encryption = mail.EncryptionNone
+	}
+
+	client, err := server.Connect()
+	if err != nil {
+		return err
+	}
+
+	return email.Send(client)
+}
+
+//Check connects to the mail server and waits for its greeting, it doesn't log in
+func (s *SMTP) Check(ctx context.Context) error {
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	//con la connessione già cifrata il saluto arriva dopo l'handshake
+	if s.Encryption == EncryptionTLS {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: s.Host})
+		if err := tlsConn.Handshake(); err != nil {
+			return err
+		}
+		conn = tlsConn
+	}
+
+	text := textproto.NewConn(conn)
+	if _, _, err := text.ReadResponse(220); err != nil {
+		return fmt.Errorf("mail server %s: %w", addr, err)
+	}
+	//il saluto basta, la risposta al QUIT non interessa
+	text.PrintfLine("QUIT")
+	return nil
+}
+
+//File writes every mail in a .eml file in Dir instead of sending it, for development
+type File struct {
+	Dir  string
+	From string
+}
+
+//Send writes m in a new file of the folder
+func (f *File) Send(m models.MailData) error {
+	email, err := message(m, f.From)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(f.Dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	//il nome comincia con la data così i file sono in ordine
+	file, err := ioutil.TempFile(f.Dir, time.Now().Format("20060102-150405")+"-*.eml")
+	if err != nil {
+		return err
+	}
+
+	_, err = file.WriteString(email.GetMessage())
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+//Check makes sure a mail can be written in the folder
+func (f *File) Check(ctx context.Context) error {
+	err := os.MkdirAll(f.Dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(f.Dir, ".check-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+//Memory keeps the mails instead of sending them, so the tests can look at them
+type Memory struct {
+	//Err, if set, is returned by Send and the mail is not kept
+	Err error
+
+	mu   sync.Mutex
+	sent []models.MailData
+}
+
+//Send keeps m
+func (mem *Memory) Send(m models.MailData) error {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	if mem.Err != nil {
+		return mem.Err
+	}
+	mem.sent = append(mem.sent, m)
+	return nil
+}
+
+//Check returns Err, like Send
+func (mem *Memory) Check(ctx context.Context) error {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	return mem.Err
+}
+
+//Sent returns the mails sent so far, the oldest first
+func (mem *Memory) Sent() []models.MailData {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	sent := make([]models.MailData, len(mem.sent))
+	copy(sent, mem.sent)
+	return sent
+}
+
+//Reset forgets the mails sent so far
+func (mem *Memory) Reset() {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	mem.sent = nil
+}
+
+//message builds the mail, from is used when m doesn't say who is sending it
+func message(m models.MailData, from string) (*mail.Email, error) {
+	if m.From == "" {
+		m.From = from
+	}
+	if m.From == "" {
+		return nil, fmt.Errorf("no sender for the mail %q", m.Subject)
+	}
+
+	email := mail.NewMSG()
+	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
+
+	//il testo semplice va prima, i programmi di posta mostrano l'ultima versione che sanno leggere
+	if m.Text != "" {
+		email.SetBody(mail.TextPlain, m.Text)
+		email.AddAlternative(mail.TextHTML, m.Content)
+	} else {
+		email.SetBody(mail.TextHTML, m.Content)
+	}
+	return email, email.GetError()
+}
@@ -0,0 +1,210 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Laura470/bookings/internal/models"
+)
+
+func TestParseEncryption(t *testing.T) {
+	tests := map[string]Encryption{
+		"":         EncryptionNone,
+		"none":     EncryptionNone,
+		"STARTTLS": EncryptionSTARTTLS,
+		"tls":      EncryptionTLS,
+	}
+	for s, expected := range tests {
+		e, err := ParseEncryption(s)
+		if err != nil || e != expected {
+			t.Errorf("%q: expected %s but got %s (%v)", s, expected, e, err)
+		}
+	}
+
+	_, err := ParseEncryption("ssl")
+	if err == nil {
+		t.Error("expected an error for an unknown encryption")
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := &File{Dir: filepath.Join(dir, "out"), From: "bookings@here.com"}
+	err = f.Send(models.MailData{To: "john@smith.com", Subject: "Reservation Confirmation", Content: "<p>Hello</p>", Text: "Hello in plain text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "out", "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file but got %d", len(files))
+	}
+	data, _ := ioutil.ReadFile(files[0])
+	for _, s := range []string{"From: <bookings@here.com>", "To: <john@smith.com>", "Subject: Reservation Confirmation", "<p>Hello</p>", "Hello in plain text", "multipart/alternative"} {
+		if !strings.Contains(string(data), s) {
+			t.Errorf("%q not in the mail:\n%s", s, data)
+		}
+	}
+
+	//senza mittente la mail non si può mandare
+	err = (&File{Dir: dir}).Send(models.MailData{To: "john@smith.com"})
+	if err == nil {
+		t.Error("expected an error for a mail without sender")
+	}
+}
+
+func TestMemory(t *testing.T) {
+	mem := &Memory{}
+	mem.Send(models.MailData{To: "john@smith.com"})
+	mem.Send(models.MailData{To: "owner@fort.com"})
+
+	sent := mem.Sent()
+	if len(sent) != 2 || sent[0].To != "john@smith.com" {
+		t.Errorf("wrong mails sent: %v", sent)
+	}
+
+	mem.Reset()
+	mem.Err = errors.New("connection refused")
+	if mem.Send(models.MailData{To: "john@smith.com"}) == nil {
+		t.Error("expected the error of the mailer")
+	}
+	if len(mem.Sent()) != 0 {
+		t.Error("expected no mails after reset")
+	}
+}
+
+//fakeSMTP accepts one mail and returns what the client wrote after DATA
+func fakeSMTP(t *testing.T) (int, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make(chan string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ready")
+
+		var msg strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					data <- msg.String()
+					reply("250 OK")
+					continue
+				}
+				msg.WriteString(line)
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO", "HELO", "MAIL", "RCPT", "RSET", "NOOP":
+				reply("250 OK")
+			case "DATA":
+				inData = true
+				reply("354 go ahead")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+
+	return l.Addr().(*net.TCPAddr).Port, data
+}
+
+func TestSMTP(t *testing.T) {
+	port, data := fakeSMTP(t)
+
+	s := &SMTP{Host: "127.0.0.1", Port: port, From: "bookings@here.com", Timeout: 5 * time.Second}
+	err := s.Send(models.MailData{To: "john@smith.com", Subject: "Reservation Confirmation", Content: "<p>Hello</p>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-data:
+		if !strings.Contains(msg, "Subject: Reservation Confirmation") || !strings.Contains(msg, "Hello") {
+			t.Errorf("wrong mail:\n%s", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server got no mail")
+	}
+
+	//il server non c'è più, l'errore deve arrivare al worker
+	err = s.Send(models.MailData{To: "john@smith.com", Subject: "Reservation Confirmation"})
+	if err == nil {
+		t.Error("expected an error without a mail server")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	port, _ := fakeSMTP(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s := &SMTP{Host: "127.0.0.1", Port: port}
+	if err := s.Check(ctx); err != nil {
+		t.Errorf("the mail server is there but got %v", err)
+	}
+	//il server finto accetta una sola connessione
+	if err := s.Check(ctx); err == nil {
+		t.Error("expected an error without a mail server")
+	}
+
+	dir, err := ioutil.TempDir("", "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := &File{Dir: filepath.Join(dir, "out")}
+	if err := f.Check(ctx); err != nil {
+		t.Errorf("the folder can be written but got %v", err)
+	}
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "out"))
+	if len(files) != 0 {
+		t.Errorf("the check left %d files in the folder", len(files))
+	}
+
+	//una cartella dentro un file non si può creare
+	ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0644)
+	if err := (&File{Dir: filepath.Join(dir, "file", "out")}).Check(ctx); err == nil {
+		t.Error("expected an error for a folder that can't be written")
+	}
+
+	mem := &Memory{Err: errors.New("connection refused")}
+	if mem.Check(ctx) == nil {
+		t.Error("expected the error of the memory mailer")
+	}
+}
@@ -0,0 +1,297 @@
+//Package metrics keeps counters, histograms and gauges and writes them in the text format of Prometheus
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//DefaultBuckets are the upper bounds of the histograms of the latencies, in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+//metric is something the registry can write
+type metric interface {
+	write(w io.Writer)
+}
+
+//Registry holds the metrics and writes them, in the order they have been added
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+//NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+func (r *Registry) add(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("metrics: " + name + " registered twice")
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+//WriteTo writes all the metrics in the text format of Prometheus
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+	for _, m := range metrics {
+		m.write(cw)
+	}
+	if cw.err == nil {
+		cw.err = bw.Flush()
+	}
+	return cw.n, cw.err
+}
+
+//Handler returns the handler of the page Prometheus reads
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+//countWriter keeps the first error, so the metrics don't have to check every line
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+//vec is what counters and histograms share: the series for every set of label values
+type vec struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (v vec) header(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, escapeHelp(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, kind)
+}
+
+//key checks the label values and joins them, it's the key of the series
+func (v vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+//labelPairs returns the labels of the series key, with extra added at the end
+func (v vec) labelPairs(key string, extra ...string) string {
+	var pairs []string
+	if len(v.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, v.labels[i]+`="`+escapeLabel(value)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+//Counter is a number that only goes up, one for every set of label values
+type Counter struct {
+	vec
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+//NewCounter adds a counter with the given labels to the registry
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec: vec{name: name, help: help, labels: labels}, values: map[string]float64{}}
+	r.add(name, c)
+	return c
+}
+
+//Inc adds one to the counter of the label values, a nil counter does nothing
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+//Add adds n to the counter of the label values, a nil counter does nothing
+func (c *Counter) Add(n float64, labelValues ...string) {
+	if c == nil {
+		return
+	}
+	key := c.key(labelValues)
+	c.mu.Lock()
+	c.values[key] += n
+	c.mu.Unlock()
+}
+
+//Value returns the counter of the label values
+func (c *Counter) Value(labelValues ...string) float64 {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.header(w, "counter")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	//un contatore senza label vale 0 anche prima del primo Inc
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(key), formatFloat(c.values[key]))
+	}
+}
+
+//Histogram counts the observations in buckets, one histogram for every set of label values
+type Histogram struct {
+	vec
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+//NewHistogram adds a histogram with the given upper bounds and labels to the registry
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	h := &Histogram{vec: vec{name: name, help: help, labels: labels}, buckets: b, series: map[string]*histogramSeries{}}
+	r.add(name, h)
+	return h
+}
+
+//Observe adds v to the histogram of the label values, a nil histogram does nothing
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	if h == nil {
+		return
+	}
+	key := h.key(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.header(w, "histogram")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", formatFloat(bound)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(key), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(key), s.count)
+	}
+}
+
+//funcMetric is a value read when the metrics are written, like the connections of the database
+type funcMetric struct {
+	vec
+	kind string
+	f    func() float64
+}
+
+//NewGaugeFunc adds a gauge that is read from f every time the metrics are written
+func (r *Registry) NewGaugeFunc(name, help string, f func() float64) {
+	r.add(name, &funcMetric{vec: vec{name: name, help: help}, kind: "gauge", f: f})
+}
+
+//NewCounterFunc is like NewGaugeFunc for a value that only goes up
+func (r *Registry) NewCounterFunc(name, help string, f func() float64) {
+	r.add(name, &funcMetric{vec: vec{name: name, help: help}, kind: "counter", f: f})
+}
+
+func (m *funcMetric) write(w io.Writer) {
+	m.header(w, m.kind)
+	fmt.Fprintf(w, "%s %s\n", m.name, formatFloat(m.f()))
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("searches_total", "Searches.", "source")
+	h := r.NewHistogram("latency_seconds", "Latency.", []float64{1, 0.1}, "route")
+	r.NewGaugeFunc("queue_depth", "Mails waiting.", func() float64 { return 3 })
+	r.NewCounter("empty_total", "Never incremented.")
+
+	c.Inc("web")
+	c.Inc("web")
+	c.Add(0.5, `a"b`)
+	h.Observe(0.05, "/rooms/{id}")
+	h.Observe(0.5, "/rooms/{id}")
+	h.Observe(5, "/rooms/{id}")
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP searches_total Searches.
+# TYPE searches_total counter
+searches_total{source="a\"b"} 0.5
+searches_total{source="web"} 2
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/rooms/{id}",le="0.1"} 1
+latency_seconds_bucket{route="/rooms/{id}",le="1"} 2
+latency_seconds_bucket{route="/rooms/{id}",le="+Inf"} 3
+latency_seconds_sum{route="/rooms/{id}"} 5.55
+latency_seconds_count{route="/rooms/{id}"} 3
+# HELP queue_depth Mails waiting.
+# TYPE queue_depth gauge
+queue_depth 3
+# HELP empty_total Never incremented.
+# TYPE empty_total counter
+empty_total 0
+`
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, buf.String())
+	}
+}
+
+func TestLabelValues(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("requests_total", "Requests.", "method", "status")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("wrong number of label values accepted")
+		}
+	}()
+	c.Inc("GET")
+}
+
+func TestNilSite(t *testing.T) {
+	//senza metriche configurate i gestori devono funzionare lo stesso
+	var s *Site
+	s.ObserveRequest("GET", "/", 200, time.Second)
+	s.MailSent(nil)
+	s.Search(SourceWeb, false)
+	s.Reservation(SourceAPI)
+}
+
+func TestSite(t *testing.T) {
+	s := NewSite()
+	s.ObserveRequest("GET", "/rooms/{slug}", 200, 30*time.Millisecond)
+	s.MailSent(nil)
+	s.MailSent(errors.New("connection refused"))
+	s.Search(SourceWeb, true)
+	s.Search(SourceWeb, false)
+	s.Reservation(SourceAPI)
+	s.AddDBStats(func() sql.DBStats {
+		return sql.DBStats{OpenConnections: 4, InUse: 1, Idle: 3, WaitDuration: 1500 * time.Millisecond}
+	})
+	s.AddMailQueue(func() float64 { return 7 })
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("wrong content type %s", rr.Header().Get("Content-Type"))
+	}
+	for _, line := range []string{
+		`bookings_http_requests_total{method="GET",route="/rooms/{slug}",status="200"} 1`,
+		`bookings_http_request_duration_seconds_bucket{method="GET",route="/rooms/{slug}",le="0.05"} 1`,
+		`bookings_mail_send_total{result="failure"} 1`,
+		`bookings_mail_send_total{result="success"} 1`,
+		`bookings_availability_searches_total{source="web"} 2`,
+		`bookings_availability_searches_empty_total{source="web"} 1`,
+		`bookings_reservations_created_total{source="api"} 1`,
+		`bookings_db_open_connections 4`,
+		`bookings_db_wait_duration_seconds_total 1.5`,
+		`bookings_mail_queue_depth 7`,
+	} {
+		if !strings.Contains(rr.Body.String(), line+"\n") {
+			t.Errorf("missing %s in\n%s", line, rr.Body.String())
+		}
+	}
+}
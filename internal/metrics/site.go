@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+//the source label of the business counters
+const (
+	SourceWeb = "web"
+	SourceAPI = "api"
+)
+
+//Site are the metrics of the booking site, the methods of a nil Site do nothing
+type Site struct {
+	*Registry
+	//Requests and RequestDuration have the chi route pattern, not the path, so the series don't grow with the ids
+	Requests        *Counter
+	RequestDuration *Histogram
+	MailSends       *Counter
+	Reservations    *Counter
+	Searches        *Counter
+	EmptySearches   *Counter
+}
+
+//NewSite returns the metrics of the site in a new registry
+func NewSite() *Site {
+	r := NewRegistry()
+	return &Site{
+		Registry:        r,
+		Requests:        r.NewCounter("bookings_http_requests_total", "Requests served, by method, route and status.", "method", "route", "status"),
+		RequestDuration: r.NewHistogram("bookings_http_request_duration_seconds", "Time to serve a request, by method and route.", DefaultBuckets, "method", "route"),
+		MailSends:       r.NewCounter("bookings_mail_send_total", "Attempts to send a mail of the outbox, by result: success or failure.", "result"),
+		Reservations:    r.NewCounter("bookings_reservations_created_total", "Reservations made by the guests, by source: web or api.", "source"),
+		Searches:        r.NewCounter("bookings_availability_searches_total", "Searches of available rooms, by source: web or api.", "source"),
+		EmptySearches:   r.NewCounter("bookings_availability_searches_empty_total", "Searches that found no available room, by source: web or api.", "source"),
+	}
+}
+
+//ObserveRequest records a request served
+func (s *Site) ObserveRequest(method, route string, status int, latency time.Duration) {
+	if s == nil {
+		return
+	}
+	s.Requests.Inc(method, route, strconv.Itoa(status))
+	s.RequestDuration.Observe(latency.Seconds(), method, route)
+}
+
+//MailSent records an attempt to send a mail, err is what the mail server answered
+func (s *Site) MailSent(err error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.MailSends.Inc("failure")
+		return
+	}
+	s.MailSends.Inc("success")
+}
+
+//Search records a search of available rooms, found is false when there was nothing
+func (s *Site) Search(source string, found bool) {
+	if s == nil {
+		return
+	}
+	s.Searches.Inc(source)
+	if !found {
+		s.EmptySearches.Inc(source)
+	}
+}
+
+//AddDBStats adds the gauges and counters of the connection pool of the database
+func (s *Site) AddDBStats(stats func() sql.DBStats) {
+	s.NewGaugeFunc("bookings_db_open_connections", "Connections to the database, in use and idle.", func() float64 {
+		return float64(stats().OpenConnections)
+	})
+	s.NewGaugeFunc("bookings_db_in_use_connections", "Connections to the database in use.", func() float64 {
+		return float64(stats().InUse)
+	})
+	s.NewGaugeFunc("bookings_db_idle_connections", "Idle connections to the database.", func() float64 {
+		return float64(stats().Idle)
+	})
+	s.NewGaugeFunc("bookings_db_max_open_connections", "Most connections to the database allowed, 0 for no limit.", func() float64 {
+		return float64(stats().MaxOpenConnections)
+	})
+	s.NewCounterFunc("bookings_db_wait_total", "Times a query waited for a free connection.", func() float64 {
+		return float64(stats().WaitCount)
+	})
+	s.NewCounterFunc("bookings_db_wait_duration_seconds_total", "Time spent waiting for a free connection.", func() float64 {
+		return stats().WaitDuration.Seconds()
+	})
+	s.NewCounterFunc("bookings_db_closed_max_idle_total", "Connections closed because of the limit of idle connections.", func() float64 {
+		return float64(stats().MaxIdleClosed)
+	})
+	s.NewCounterFunc("bookings_db_closed_max_lifetime_total", "Connections closed because they were too old.", func() float64 {
+		return float64(stats().MaxLifetimeClosed)
+	})
+}
+
+//Reservation records a reservation made by a guest
+func (s *Site) Reservation(source string) {
+	if s == nil {
+		return
+	}
+	s.Reservations.Inc(source)
+}
+
+//AddMailQueue adds the gauge of the mails waiting in the outbox, read with depth
+func (s *Site) AddMailQueue(depth func() float64) {
+	s.NewGaugeFunc("bookings_mail_queue_depth", "Mails in the outbox waiting to be sent.", depth)
+}
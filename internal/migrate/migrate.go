@@ -0,0 +1,389 @@
+//Package migrate applies the sql migrations to the database and keeps track of the versions applied
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Laura470/bookings/internal/driver"
+	"github.com/Laura470/bookings/internal/logger"
+)
+
+//lockKey is the key of the advisory lock taken while migrating, so that two instances
+//starting together don't apply the same migration twice
+const lockKey = 4672110
+
+//Migration is a change of the schema, Down undoes what Up does
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+//Status is a migration and whether it has been applied
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	//Unknown is a version applied to the database that this binary doesn't have, from a newer one
+	Unknown bool
+}
+
+//ErrNoMigrations is returned by Load when the folder has no migrations
+var ErrNoMigrations = errors.New("no migrations found")
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+var migrationName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+//Load reads the migrations in the folder dir of fsys, sorted by version. Every migration must have both files
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	up := map[int64]bool{}
+	down := map[int64]bool{}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		match := fileName.FindStringSubmatch(e.Name())
+		if match == nil {
+			return nil, fmt.Errorf("%s: not a migration, the name must be <version>_<name>.up.sql or .down.sql", e.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		b, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("version %d is used by %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(b)
+			up[version] = true
+		} else {
+			m.Down = string(b)
+			down[version] = true
+		}
+	}
+
+	if len(byVersion) == 0 {
+		return nil, ErrNoMigrations
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for version, m := range byVersion {
+		if !up[version] || !down[version] {
+			return nil, fmt.Errorf("migration %d_%s has only one of the up and down files", version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+//Create writes the empty files of a new migration in dir, with the time now as version, and returns their paths
+func Create(dir, name string, now time.Time) ([]string, error) {
+	if !migrationName.MatchString(name) {
+		return nil, fmt.Errorf("%q is not a good name for a migration, use lower case letters, numbers and _", name)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a folder, create the migrations from the root of the source code", dir)
+	}
+
+	version := now.UTC().Format("20060102150405")
+	var paths []string
+	for _, direction := range []string{"up", "down"} {
+		p := filepath.Join(dir, fmt.Sprintf("%s_%s.%s.sql", version, name, direction))
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return paths, err
+		}
+		if err := f.Close(); err != nil {
+			return paths, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+//Migrator applies the migrations to a database
+type Migrator struct {
+	db *sql.DB
+	//driver is driver.Postgres or driver.SQLite
+	driver     string
+	migrations []Migration
+	log        *logger.Logger
+}
+
+//New returns a migrator of db, a database of driverName, with the migrations returned by Load
+func New(db *sql.DB, driverName string, migrations []Migration, log *logger.Logger) *Migrator {
+	return &Migrator{db: db, driver: driverName, migrations: migrations, log: log}
+}
+
+//Up applies the migrations not applied yet, in order, and returns how many they are
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	n := 0
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		for version := range applied {
+			if m.find(version) == nil {
+				m.log.Warn("migration applied to the database but unknown to this version of the site", "version", version)
+			}
+		}
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, mig, true); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+//Down undoes the last steps migrations applied and returns how many have been undone
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	n := 0
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		versions := make([]int64, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		sort.Slice(versions, func(i, j int) bool {
+			return versions[i] > versions[j]
+		})
+
+		for _, version := range versions {
+			if n == steps {
+				break
+			}
+			mig := m.find(version)
+			if mig == nil {
+				return fmt.Errorf("migration %d is not in this version of the site, it can't be undone", version)
+			}
+			if err := m.apply(ctx, conn, *mig, false); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+//Status returns all the migrations, applied or not, and the versions applied that this binary doesn't know
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		for _, mig := range m.migrations {
+			at, ok := applied[mig.Version]
+			statuses = append(statuses, Status{Migration: mig, Applied: ok, AppliedAt: at})
+			delete(applied, mig.Version)
+		}
+		for version, at := range applied {
+			statuses = append(statuses, Status{Migration: Migration{Version: version}, Applied: true, AppliedAt: at, Unknown: true})
+		}
+		sort.Slice(statuses, func(i, j int) bool {
+			return statuses[i].Version < statuses[j].Version
+		})
+		return nil
+	})
+	return statuses, err
+}
+
+func (m *Migrator) find(version int64) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
+
+//apply runs the up or the down of mig and records it, in a single transaction
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mig Migration, up bool) error {
+	start := time.Now()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	script, record := mig.Up, `insert into schema_versions (version, name, applied_at) values ($1, $2, $3)`
+	args := []interface{}{mig.Version, mig.Name, time.Now()}
+	if !up {
+		script, record = mig.Down, `delete from schema_versions where version = $1`
+		args = args[:1]
+	}
+
+	//un file vuoto non ha niente da fare, ma la versione va registrata lo stesso
+	if script != "" {
+		if _, err := tx.ExecContext(ctx, script); err != nil {
+			return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	msg := "migration applied"
+	if !up {
+		msg = "migration undone"
+	}
+	m.log.Info(msg, "version", mig.Version, "name", mig.Name, "duration", time.Since(start))
+	return nil
+}
+
+//locked runs f holding the advisory lock of Postgres, with the table of the versions created and the versions applied
+func (m *Migrator) locked(ctx context.Context, f func(conn *sql.Conn, applied map[int64]time.Time) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	//sqlite sta in un file di una sola macchina, non ci sono altre istanze da aspettare
+	if m.driver != driver.SQLite {
+		//il lock è della sessione, quindi lock e unlock devono passare dalla stessa connessione
+		if _, err := conn.ExecContext(ctx, `select pg_advisory_lock($1)`, lockKey); err != nil {
+			return fmt.Errorf("can't lock the migrations: %w", err)
+		}
+		defer func() {
+			//anche se ctx è scaduto il lock va lasciato, altrimenti resta finché la connessione non si chiude
+			if _, err := conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, lockKey); err != nil {
+				m.log.Error("can't unlock the migrations", "error", err)
+			}
+		}()
+	}
+
+	_, err = conn.ExecContext(ctx, `create table if not exists schema_versions (
+		version bigint primary key,
+		name varchar(255) not null default '',
+		applied_at timestamp not null
+	)`)
+	if err != nil {
+		return err
+	}
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+	//soda ha migrato solo database postgres
+	if len(applied) == 0 && m.driver != driver.SQLite {
+		applied, err = m.importSoda(ctx, conn)
+		if err != nil {
+			return err
+		}
+	}
+	return f(conn, applied)
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `select version, applied_at from schema_versions`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+//importSoda records as applied the versions in the table of soda, the tool that migrated the database
+//before, so that a database created with it isn't migrated again
+func (m *Migrator) importSoda(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	var exists bool
+	err := conn.QueryRowContext(ctx, `select to_regclass('schema_migration') is not null`).Scan(&exists)
+	if err != nil || !exists {
+		return map[int64]time.Time{}, err
+	}
+
+	rows, err := conn.QueryContext(ctx, `select version from schema_migration`)
+	if err != nil {
+		return nil, err
+	}
+	var versions []int64
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		version, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("version %q of soda: %w", v, err)
+		}
+		versions = append(versions, version)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	applied := map[int64]time.Time{}
+	for _, version := range versions {
+		name := ""
+		if mig := m.find(version); mig != nil {
+			name = mig.Name
+		}
+		_, err := tx.ExecContext(ctx, `insert into schema_versions (version, name, applied_at) values ($1, $2, $3)`, version, name, now)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = now
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if len(versions) > 0 {
+		m.log.Info("migrations applied by soda imported", "versions", len(versions))
+	}
+	return applied, nil
+}
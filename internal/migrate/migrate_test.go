@@ -0,0 +1,185 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/Laura470/bookings/internal/driver"
+	"github.com/Laura470/bookings/internal/logger"
+	"github.com/Laura470/bookings/migrations"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"pg/20210927091617_create_rooms.up.sql":      {Data: []byte("create table rooms (id serial primary key);")},
+		"pg/20210927091617_create_rooms.down.sql":    {Data: []byte("drop table rooms;")},
+		"pg/20210927071902_create_users.up.sql":      {Data: []byte("create table users (id serial primary key);")},
+		"pg/20210927071902_create_users.down.sql":    {Data: []byte("drop table users;")},
+		"pg/20210928142939_nothing_to_undo.up.sql":   {Data: []byte("alter table rooms add column slug text;")},
+		"pg/20210928142939_nothing_to_undo.down.sql": {Data: []byte("")},
+	}
+
+	all, err := Load(fsys, "pg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("expected 3 migrations but got %d", len(all))
+	}
+	if all[0].Version != 20210927071902 || all[0].Name != "create_users" || all[0].Down != "drop table users;" {
+		t.Errorf("wrong first migration %+v", all[0])
+	}
+	if all[1].Version != 20210927091617 || all[2].Version != 20210928142939 {
+		t.Errorf("migrations not sorted by version: %d %d", all[1].Version, all[2].Version)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		fsys     fstest.MapFS
+		expected string
+	}{
+		{"no down", fstest.MapFS{"pg/1_a.up.sql": {}}, "only one of the up and down files"},
+		{"wrong name", fstest.MapFS{"pg/1_a.sql": {}}, "not a migration"},
+		{"same version", fstest.MapFS{"pg/1_a.up.sql": {}, "pg/1_b.down.sql": {}}, "version 1 is used by"},
+	}
+
+	for _, e := range tests {
+		_, err := Load(e.fsys, "pg")
+		if err == nil {
+			t.Errorf("%s: no error", e.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), e.expected) {
+			t.Errorf("%s: expected %q in the error but got %q", e.name, e.expected, err)
+		}
+	}
+
+	if _, err := Load(fstest.MapFS{}, "."); !errors.Is(err, ErrNoMigrations) {
+		t.Errorf("expected ErrNoMigrations but got %v", err)
+	}
+}
+
+//TestEmbedded checks the migrations built in the binary, a file without its pair would stop the site from starting
+func TestEmbedded(t *testing.T) {
+	all, err := Load(migrations.FS, migrations.Postgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range all {
+		if strings.TrimSpace(m.Up) == "" {
+			t.Errorf("migration %d_%s does nothing", m.Version, m.Name)
+		}
+	}
+	if all[0].Name != "create_create_user_tables" {
+		t.Errorf("the first migration should create the users but it's %s", all[0].Name)
+	}
+
+	lite, err := Load(migrations.FS, migrations.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//ogni migration di postgres ha la sua in sqlite, con la stessa versione e lo stesso nome
+	for i := 0; i < len(all) || i < len(lite); i++ {
+		switch {
+		case i >= len(lite):
+			t.Errorf("%d_%s is missing in migrations/sqlite", all[i].Version, all[i].Name)
+		case i >= len(all):
+			t.Errorf("%d_%s is missing in migrations/postgres", lite[i].Version, lite[i].Name)
+		case all[i].Version != lite[i].Version || all[i].Name != lite[i].Name:
+			t.Errorf("postgres has %d_%s where sqlite has %d_%s", all[i].Version, all[i].Name, lite[i].Version, lite[i].Name)
+			return
+		}
+	}
+}
+
+//TestSQLite applies the migrations of sqlite, undoes them all and applies them again
+func TestSQLite(t *testing.T) {
+	db, err := driver.NewDatabase(driver.SQLite, driver.SQLiteDSN(filepath.Join(t.TempDir(), "bookings.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	all, err := Load(migrations.FS, migrations.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New(db, driver.SQLite, all, logger.Discard())
+	ctx := context.Background()
+
+	schema := func() string {
+		rows, err := db.Query(`select coalesce(sql, '') from sqlite_master where name <> 'schema_versions' order by name`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var b strings.Builder
+		for rows.Next() {
+			var sql string
+			if err := rows.Scan(&sql); err != nil {
+				t.Fatal(err)
+			}
+			b.WriteString(sql + "\n")
+		}
+		return b.String()
+	}
+
+	if n, err := m.Up(ctx); err != nil || n != len(all) {
+		t.Fatalf("up: applied %d of %d migrations, %v", n, len(all), err)
+	}
+	first := schema()
+	if !strings.Contains(first, `"room_restrictions_reservations_id_fk"`) || !strings.Contains(first, `"room_restrictions_room_ical_feeds_id_fk"`) {
+		t.Errorf("the foreign keys of room_restrictions are missing:\n%s", first)
+	}
+
+	if n, err := m.Down(ctx, len(all)); err != nil || n != len(all) {
+		t.Fatalf("down: undone %d of %d migrations, %v", n, len(all), err)
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if again := schema(); again != first {
+		t.Errorf("the schema changed after down and up:\n%s\ninstead of\n%s", again, first)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 10, 18, 9, 30, 5, 0, time.UTC)
+
+	paths, err := Create(dir, "add_notes_to_reservations", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		filepath.Join(dir, "20261018093005_add_notes_to_reservations.up.sql"),
+		filepath.Join(dir, "20261018093005_add_notes_to_reservations.down.sql"),
+	}
+	if len(paths) != 2 || paths[0] != expected[0] || paths[1] != expected[1] {
+		t.Errorf("expected %v but got %v", expected, paths)
+	}
+	for _, p := range paths {
+		if _, err := ioutil.ReadFile(p); err != nil {
+			t.Error(err)
+		}
+	}
+
+	//la stessa versione non sovrascrive i file già scritti
+	if _, err := Create(dir, "add_notes_to_reservations", now); err == nil {
+		t.Error("migration created twice")
+	}
+	if _, err := Create(dir, "Add Notes", now); err == nil {
+		t.Error("bad name accepted")
+	}
+	if _, err := Create(filepath.Join(dir, "missing"), "add_notes", now); err == nil {
+		t.Error("migration created in a missing folder")
+	}
+}
@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+//the recurrences of a RoomBlock
+const (
+	RecurrenceNone    = ""
+	RecurrenceWeekly  = "weekly"
+	RecurrenceMonthly = "monthly"
+)
+
+// Period is a range of days, start and end included
+type Period struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Occurrences expands the block into the periods the room is blocked, one for each repetition
+// starting on or before RepeatUntil. A monthly block starting on the 31st skips the months without it
+func (b RoomBlock) Occurrences() []Period {
+	periods := []Period{{Start: b.StartDate, End: b.EndDate}}
+
+	days := int(b.EndDate.Sub(b.StartDate).Hours() / 24)
+
+	for i := 1; ; i++ {
+		var start time.Time
+		switch b.Recurrence {
+		case RecurrenceWeekly:
+			start = b.StartDate.AddDate(0, 0, 7*i)
+		case RecurrenceMonthly:
+			start = b.StartDate.AddDate(0, i, 0)
+			//AddDate normalizza il 31 aprile in 1 maggio, quel mese lo salto
+			if start.Day() != b.StartDate.Day() {
+				if start.After(b.RepeatUntil) {
+					return periods
+				}
+				continue
+			}
+		default:
+			return periods
+		}
+
+		if start.After(b.RepeatUntil) {
+			return periods
+		}
+		periods = append(periods, Period{Start: start, End: start.AddDate(0, 0, days)})
+	}
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+var occurrencesTests = []struct {
+	name     string
+	block    RoomBlock
+	expected []string
+}{
+	{
+		name:     "one off",
+		block:    RoomBlock{StartDate: date("2040-03-02"), EndDate: date("2040-03-15")},
+		expected: []string{"2040-03-02/2040-03-15"},
+	},
+	{
+		//il 2040-01-02 è un lunedì
+		name: "every monday",
+		block: RoomBlock{StartDate: date("2040-01-02"), EndDate: date("2040-01-02"),
+			Recurrence: RecurrenceWeekly, RepeatUntil: date("2040-01-23")},
+		expected: []string{"2040-01-02/2040-01-02", "2040-01-09/2040-01-09", "2040-01-16/2040-01-16", "2040-01-23/2040-01-23"},
+	},
+	{
+		name: "first week of every month",
+		block: RoomBlock{StartDate: date("2040-01-01"), EndDate: date("2040-01-07"),
+			Recurrence: RecurrenceMonthly, RepeatUntil: date("2040-03-31")},
+		expected: []string{"2040-01-01/2040-01-07", "2040-02-01/2040-02-07", "2040-03-01/2040-03-07"},
+	},
+	{
+		name: "monthly on the 31st skips the shorter months",
+		block: RoomBlock{StartDate: date("2040-01-31"), EndDate: date("2040-01-31"),
+			Recurrence: RecurrenceMonthly, RepeatUntil: date("2040-05-31")},
+		expected: []string{"2040-01-31/2040-01-31", "2040-03-31/2040-03-31", "2040-05-31/2040-05-31"},
+	},
+	{
+		name: "repeat until before the second occurrence",
+		block: RoomBlock{StartDate: date("2040-01-02"), EndDate: date("2040-01-03"),
+			Recurrence: RecurrenceWeekly, RepeatUntil: date("2040-01-08")},
+		expected: []string{"2040-01-02/2040-01-03"},
+	},
+}
+
+func TestRoomBlock_Occurrences(t *testing.T) {
+	for _, e := range occurrencesTests {
+		periods := e.block.Occurrences()
+
+		if len(periods) != len(e.expected) {
+			t.Errorf("%s: expected %d occurrences but got %d", e.name, len(e.expected), len(periods))
+			continue
+		}
+		for i, p := range periods {
+			got := p.Start.Format("2006-01-02") + "/" + p.End.Format("2006-01-02")
+			if got != e.expected[i] {
+				t.Errorf("%s: expected occurrence %s but got %s", e.name, e.expected[i], got)
+			}
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -12,18 +14,150 @@ type User struct {
 	Email       string
 	Password    string
 	AccessLevel int
+	Active      int
+	//LockedUntil is when the user can try to log in again after too many wrong passwords
+	LockedUntil time.Time
+	//TOTPSecret is the secret of the authenticator app, empty without two-factor authentication,
+	//TOTPCounter is the counter of the last code used
+	TOTPSecret  string
+	TOTPCounter int64
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
 
-// Room is the room model
-type Room struct {
+//HasTOTP tells if the user logs in with a code of the authenticator app after the password
+func (u User) HasTOTP() bool {
+	return u.TOTPSecret != ""
+}
+
+//IsLocked tells if at now the user can't log in because of too many wrong passwords
+func (u User) IsLocked(now time.Time) bool {
+	return now.Before(u.LockedUntil)
+}
+
+//HasPassword tells if the user has set a password, an invited user hasn't yet
+func (u User) HasPassword() bool {
+	return u.Password != ""
+}
+
+//Role returns what the user can do in the back office, it's the access level with a name
+func (u User) Role() Role {
+	return Role(u.AccessLevel)
+}
+
+//Role is the access level of a user, every role can do what the roles below it do
+type Role int
+
+//the roles of the users, they are saved as the access level so the order matters
+const (
+	RoleViewer    Role = 1
+	RoleFrontDesk Role = 2
+	RoleManager   Role = 3
+	RoleOwner     Role = 4
+)
+
+//Roles are all the roles, from the lowest
+var Roles = []Role{RoleViewer, RoleFrontDesk, RoleManager, RoleOwner}
+
+var roleNames = map[Role]string{
+	RoleViewer:    "viewer",
+	RoleFrontDesk: "front-desk",
+	RoleManager:   "manager",
+	RoleOwner:     "owner",
+}
+
+//String returns the name of the role
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("role(%d)", int(r))
+}
+
+//Valid tells if r is one of the Roles
+func (r Role) Valid() bool {
+	_, ok := roleNames[r]
+	return ok
+}
+
+//AtLeast tells if r can do what min can do
+func (r Role) AtLeast(min Role) bool {
+	return r.Valid() && r >= min
+}
+
+//ParseRole returns the role called name
+func ParseRole(name string) (Role, error) {
+	for r, n := range roleNames {
+		if n == strings.ToLower(name) {
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown role %q", name)
+}
+
+//UserToken is a one-time link sent to a user by email, only the hash of the token is stored
+type UserToken struct {
 	ID        int
-	RoomName  string
+	UserID    int
+	TokenHash string
+	Purpose   string
+	ExpiresAt time.Time
+	UsedAt    time.Time
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+//what a UserToken is for
+const (
+	TokenInvitation    = "invitation"
+	TokenPasswordReset = "password-reset"
+)
+
+// Room is the room model
+type Room struct {
+	ID          int
+	RoomName    string
+	Description string
+	Capacity    int
+	Slug        string
+	Photos      []string
+	Active      int
+	BaseRate    int
+	WeekendRate int
+	MinStay     int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// RoomRate is a seasonal override of the rates of a room, prices are in cents
+type RoomRate struct {
+	ID          int
+	RoomID      int
+	Name        string
+	StartDate   time.Time
+	EndDate     time.Time
+	NightlyRate int
+	WeekendRate int
+	MinStay     int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// NightPrice is the price of a single night of a quote
+type NightPrice struct {
+	Date  time.Time
+	Price int
+}
+
+// Quote is the price of a stay in a room, prices are in cents
+type Quote struct {
+	RoomID    int
+	StartDate time.Time
+	EndDate   time.Time
+	Nights    []NightPrice
+	Total     int
+}
+
 // Restriction is the restriction model
 type Restriction struct {
 	ID              int
@@ -34,18 +168,24 @@ type Restriction struct {
 
 // Reservation is the reservation model
 type Reservation struct {
-	ID        int
-	FirstName string
-	LastName  string
-	Email     string
-	Phone     string
-	StartDate time.Time
-	EndDate   time.Time
-	RoomID    int
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	Room      Room
-	Processed int
+	ID         int
+	FirstName  string
+	LastName   string
+	Email      string
+	Phone      string
+	StartDate  time.Time
+	EndDate    time.Time
+	RoomID     int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Room       Room
+	Processed  int
+	TotalPrice int
+}
+
+//Nights is how many nights the guest stays
+func (r Reservation) Nights() int {
+	return int(r.EndDate.Sub(r.StartDate).Hours() / 24)
 }
 
 // RoomRestriction is the room restriction model
@@ -56,18 +196,100 @@ type RoomRestriction struct {
 	RoomID        int
 	ReservationID int
 	RestrictionID int
+	BlockID       int
+	FeedID        int
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 	Room          Room
 	Reservation   Reservation
 	Restriction   Restriction
+	Block         RoomBlock
+	Feed          ICalFeed
+}
+
+//the restrictions of a RoomRestriction, as in the restrictions table
+const (
+	RestrictionReservation = 1
+	RestrictionBlock       = 2
+	RestrictionExternal    = 3
+)
+
+// ICalFeed is the calendar of a room on another booking site, its events become restrictions of the room
+type ICalFeed struct {
+	ID           int
+	RoomID       int
+	Name         string
+	URL          string
+	LastSyncedAt time.Time
+	LastError    string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Room         Room
+}
+
+// RoomBlock is an owner block of a room, from StartDate to EndDate included,
+// repeated weekly or monthly until RepeatUntil when Recurrence is set
+type RoomBlock struct {
+	ID          int
+	RoomID      int
+	StartDate   time.Time
+	EndDate     time.Time
+	Recurrence  string
+	RepeatUntil time.Time
+	Reason      string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Room        Room
+}
+
+// APIToken is a token to use the JSON api, only the hash of the token is stored
+type APIToken struct {
+	ID         int
+	UserID     int
+	Name       string
+	TokenHash  string
+	Scope      string
+	LastUsedAt time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	User       User
 }
 
+//the scopes of an APIToken, an admin token can use the public api too
+const (
+	ScopePublic = "public"
+	ScopeAdmin  = "admin"
+)
+
 //Mail DAta holds email message
+//Content is the html of the mail, Text the same mail in plain text
 type MailData struct {
-	To       string
-	From     string
-	Subject  string
-	Content  string
-	Template string
+	To      string
+	From    string
+	Subject string
+	Content string
+	Text    string
 }
+
+// OutboxMail is an email waiting in the outbox, it's sent again until MaxAttempts and then it fails
+type OutboxMail struct {
+	ID            int
+	Mail          MailData
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	SentAt        time.Time
+	//RequestID is the request that queued the mail, to find it in the log
+	RequestID string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+//the status of an OutboxMail, a failed mail isn't sent again unless the admin asks it
+const (
+	MailQueued  = "queued"
+	MailSending = "sending"
+	MailSent    = "sent"
+	MailFailed  = "failed"
+)
@@ -0,0 +1,29 @@
+package models
+
+import "testing"
+
+func TestRole(t *testing.T) {
+	for _, r := range Roles {
+		parsed, err := ParseRole(r.String())
+		if err != nil || parsed != r {
+			t.Errorf("%s: parsed as %s (%v)", r, parsed, err)
+		}
+	}
+
+	if _, err := ParseRole("admin"); err == nil {
+		t.Error("expected an error for an unknown role")
+	}
+
+	if !RoleOwner.AtLeast(RoleManager) || RoleFrontDesk.AtLeast(RoleManager) || !RoleViewer.AtLeast(RoleViewer) {
+		t.Error("wrong order of the roles")
+	}
+
+	//un access level che non è un ruolo non può fare niente
+	if Role(0).AtLeast(0) || Role(9).AtLeast(RoleViewer) {
+		t.Error("an unknown role can do something")
+	}
+
+	if (User{AccessLevel: 2}).Role() != RoleFrontDesk {
+		t.Error("the access level 2 is not the front desk")
+	}
+}
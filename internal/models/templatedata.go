@@ -16,4 +16,18 @@ type TemplateData struct {
 	Error           string
 	Form            *forms.Form
 	IsAuthenticated int
+	//Role is the role of the logged in user, to hide what the user can't do
+	Role Role
+}
+
+//MailTemplateData holds data sent from handlers to the mail templates
+type MailTemplateData struct {
+	Reservation Reservation
+	//Previous is the reservation before the guest changed it
+	Previous  Reservation
+	ManageURL string
+	SiteURL   string
+	//User and Link are for the mails to the staff, like the invitation
+	User User
+	Link string
 }
@@ -0,0 +1,184 @@
+package outbox
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Laura470/bookings/internal/logger"
+	"github.com/Laura470/bookings/internal/metrics"
+	"github.com/Laura470/bookings/internal/models"
+)
+
+//Store is the part of the database the worker needs
+type Store interface {
+	ClaimOutboxMail(ctx context.Context, limit int) ([]models.OutboxMail, error)
+	UpdateOutboxMail(ctx context.Context, m models.OutboxMail) error
+}
+
+//SendFunc sends a mail, it returns an error when the mail server doesn't take it
+type SendFunc func(m models.MailData) error
+
+//Worker sends the mails of the outbox with a pool of goroutines, a mail that can't be sent
+//is tried again later, waiting longer every time, until MaxAttempts
+type Worker struct {
+	Store        Store
+	Send         SendFunc
+	Workers      int
+	BatchSize    int
+	MaxAttempts  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	PollInterval time.Duration
+	Logger       *logger.Logger
+	//Metrics counts the mails sent and the ones the mail server didn't take, it can be nil
+	Metrics *metrics.Site
+}
+
+//New returns a worker with the default settings
+func New(store Store, send SendFunc, log *logger.Logger) *Worker {
+	return &Worker{
+		Store:        store,
+		Send:         send,
+		Workers:      2,
+		BatchSize:    20,
+		MaxAttempts:  8,
+		BaseDelay:    30 * time.Second,
+		MaxDelay:     6 * time.Hour,
+		PollInterval: 5 * time.Second,
+		Logger:       log,
+	}
+}
+
+//Run sends the mails until ctx is done, looking for new ones every PollInterval. A batch already
+//taken is finished also when ctx is done, so no mail is left as sending
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		//finché ci sono mail da mandare non aspetto il prossimo giro
+		n, err := w.ProcessOnce(context.Background())
+		if err != nil {
+			w.Logger.Error("can't take the mails from the outbox", "error", err)
+		}
+		if n > 0 && err == nil && ctx.Err() == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//Drain sends the mails due until there are none left or ctx is done, for the shutdown after Run returned.
+//The mails that fail wait for their next attempt, so Drain doesn't try them again
+func (w *Worker) Drain(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := w.ProcessOnce(ctx)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+	}
+}
+
+//ProcessOnce sends a batch of the mails due, it returns how many it took from the outbox
+func (w *Worker) ProcessOnce(ctx context.Context) (int, error) {
+	mails, err := w.Store.ClaimOutboxMail(ctx, w.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	workers := w.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan models.OutboxMail)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for o := range jobs {
+				w.send(ctx, o)
+			}
+		}()
+	}
+
+	for _, o := range mails {
+		jobs <- o
+	}
+	close(jobs)
+	wg.Wait()
+
+	return len(mails), nil
+}
+
+//send sends a mail and saves how it went, the log has the id of the request that queued the mail
+func (w *Worker) send(ctx context.Context, o models.OutboxMail) {
+	ctx = logger.WithRequestID(ctx, o.RequestID)
+	log := w.Logger.Ctx(ctx).With("mail_id", o.ID, "to", o.Mail.To)
+
+	err := w.Send(o.Mail)
+	w.Metrics.MailSent(err)
+	o = w.result(o, err, time.Now())
+	switch o.Status {
+	case models.MailSent:
+		log.Info("mail sent", "attempts", o.Attempts)
+	case models.MailFailed:
+		log.Error("mail failed, no more attempts", "attempts", o.Attempts, "error", err)
+	default:
+		log.Warn("mail not sent", "attempts", o.Attempts, "next_attempt_at", o.NextAttemptAt, "error", err)
+	}
+
+	if err := w.Store.UpdateOutboxMail(ctx, o); err != nil {
+		log.Error("can't update the mail", "error", err)
+	}
+}
+
+//result returns the mail updated with how sending it went
+func (w *Worker) result(o models.OutboxMail, err error, now time.Time) models.OutboxMail {
+	o.Attempts++
+
+	if err == nil {
+		o.Status = models.MailSent
+		o.SentAt = now
+		o.LastError = ""
+		return o
+	}
+
+	o.LastError = err.Error()
+	if o.Attempts >= w.MaxAttempts {
+		o.Status = models.MailFailed
+		return o
+	}
+
+	o.Status = models.MailQueued
+	o.NextAttemptAt = now.Add(Backoff(w.BaseDelay, w.MaxDelay, o.Attempts))
+	return o
+}
+
+//Backoff is how long to wait after the attempt number attempts failed: base, then twice as much every time, up to max
+func Backoff(base, max time.Duration, attempts int) time.Duration {
+	d := base
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= max {
+			return max
+		}
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
@@ -0,0 +1,241 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Laura470/bookings/internal/logger"
+	"github.com/Laura470/bookings/internal/metrics"
+	"github.com/Laura470/bookings/internal/models"
+)
+
+//memoryStore keeps the outbox in memory, claiming works like in the database
+type memoryStore struct {
+	sync.Mutex
+	mails map[int]models.OutboxMail
+}
+
+func (s *memoryStore) ClaimOutboxMail(ctx context.Context, limit int) ([]models.OutboxMail, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var claimed []models.OutboxMail
+	for id, o := range s.mails {
+		if len(claimed) == limit {
+			break
+		}
+		if o.Status == models.MailQueued && !o.NextAttemptAt.After(time.Now()) {
+			o.Status = models.MailSending
+			s.mails[id] = o
+			claimed = append(claimed, o)
+		}
+	}
+	return claimed, nil
+}
+
+func (s *memoryStore) UpdateOutboxMail(ctx context.Context, o models.OutboxMail) error {
+	s.Lock()
+	defer s.Unlock()
+	s.mails[o.ID] = o
+	return nil
+}
+
+func newTestWorker(store Store, send SendFunc) *Worker {
+	w := New(store, send, logger.Discard())
+	w.MaxAttempts = 3
+	return w
+}
+
+func TestProcessOnce(t *testing.T) {
+	store := &memoryStore{mails: map[int]models.OutboxMail{}}
+	for i := 1; i <= 5; i++ {
+		store.mails[i] = models.OutboxMail{ID: i, Status: models.MailQueued, Mail: models.MailData{To: "john@smith.com"}}
+	}
+	store.mails[3] = models.OutboxMail{ID: 3, Status: models.MailQueued, Mail: models.MailData{To: "down@here.com"}}
+
+	var sent []string
+	var mu sync.Mutex
+	w := newTestWorker(store, func(m models.MailData) error {
+		if m.To == "down@here.com" {
+			return errors.New("connection refused")
+		}
+		mu.Lock()
+		sent = append(sent, m.To)
+		mu.Unlock()
+		return nil
+	})
+	w.Metrics = metrics.NewSite()
+
+	n, err := w.ProcessOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Errorf("expected 5 mails processed but got %d", n)
+	}
+	if len(sent) != 4 {
+		t.Errorf("expected 4 mails sent but got %d", len(sent))
+	}
+	if w.Metrics.MailSends.Value("success") != 4 || w.Metrics.MailSends.Value("failure") != 1 {
+		t.Errorf("expected 4 successes and 1 failure in the metrics but got %v and %v",
+			w.Metrics.MailSends.Value("success"), w.Metrics.MailSends.Value("failure"))
+	}
+
+	for id, o := range store.mails {
+		if id == 3 {
+			if o.Status != models.MailQueued || o.Attempts != 1 || o.LastError != "connection refused" {
+				t.Errorf("wrong failed mail: %+v", o)
+			}
+			if !o.NextAttemptAt.After(time.Now()) {
+				t.Error("the failed mail must wait before the next attempt")
+			}
+			continue
+		}
+		if o.Status != models.MailSent || o.SentAt.IsZero() {
+			t.Errorf("mail %d not sent: %+v", id, o)
+		}
+	}
+
+	//la mail che non è partita aspetta, quindi al secondo giro non c'è niente da fare
+	n, _ = w.ProcessOnce(context.Background())
+	if n != 0 {
+		t.Errorf("expected no mails due but got %d", n)
+	}
+}
+
+func TestResult(t *testing.T) {
+	w := newTestWorker(nil, nil)
+	now := time.Now()
+	fail := errors.New("connection refused")
+
+	o := w.result(models.OutboxMail{}, fail, now)
+	if o.Status != models.MailQueued || !o.NextAttemptAt.Equal(now.Add(w.BaseDelay)) {
+		t.Errorf("wrong first retry: %+v", o)
+	}
+
+	o = w.result(o, fail, now)
+	if o.Status != models.MailQueued || !o.NextAttemptAt.Equal(now.Add(2*w.BaseDelay)) {
+		t.Errorf("wrong second retry: %+v", o)
+	}
+
+	//al terzo tentativo fallito la mail non si manda più
+	o = w.result(o, fail, now)
+	if o.Status != models.MailFailed || o.Attempts != 3 {
+		t.Errorf("expected a failed mail after 3 attempts: %+v", o)
+	}
+
+	o = w.result(models.OutboxMail{Attempts: 1, LastError: "timeout"}, nil, now)
+	if o.Status != models.MailSent || o.LastError != "" || !o.SentAt.Equal(now) {
+		t.Errorf("wrong sent mail: %+v", o)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := map[int]time.Duration{
+		1:  time.Minute,
+		2:  2 * time.Minute,
+		3:  4 * time.Minute,
+		5:  16 * time.Minute,
+		6:  30 * time.Minute,
+		50: 30 * time.Minute,
+	}
+	for attempts, expected := range tests {
+		if got := Backoff(time.Minute, 30*time.Minute, attempts); got != expected {
+			t.Errorf("Backoff after %d attempts: expected %s but got %s", attempts, expected, got)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	store := &memoryStore{mails: map[int]models.OutboxMail{
+		1: {ID: 1, Status: models.MailQueued},
+	}}
+	sent := make(chan struct{}, 1)
+	w := newTestWorker(store, func(m models.MailData) error {
+		sent <- struct{}{}
+		return nil
+	})
+	w.PollInterval = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Error("the mail was not sent")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("the worker did not stop")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	store := &memoryStore{mails: map[int]models.OutboxMail{}}
+	for i := 1; i <= 5; i++ {
+		store.mails[i] = models.OutboxMail{ID: i, Status: models.MailQueued, Mail: models.MailData{To: "john@smith.com"}}
+	}
+	store.mails[3] = models.OutboxMail{ID: 3, Status: models.MailQueued, Mail: models.MailData{To: "down@here.com"}}
+
+	w := newTestWorker(store, func(m models.MailData) error {
+		if m.To == "down@here.com" {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	//un giro non basta per tutte le mail
+	w.BatchSize = 2
+
+	if err := w.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for id, o := range store.mails {
+		if id == 3 && o.Status != models.MailQueued {
+			t.Errorf("the failed mail must wait for the next attempt: %+v", o)
+		}
+		if id != 3 && o.Status != models.MailSent {
+			t.Errorf("mail %d not sent: %+v", id, o)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := w.Drain(ctx); err != context.Canceled {
+		t.Errorf("expected context.Canceled but got %v", err)
+	}
+}
+
+func TestSendLogsRequestID(t *testing.T) {
+	store := &memoryStore{mails: map[int]models.OutboxMail{
+		1: {ID: 1, Status: models.MailQueued, RequestID: "abc123", Mail: models.MailData{To: "down@here.com"}},
+	}}
+
+	var buf bytes.Buffer
+	w := newTestWorker(store, func(m models.MailData) error {
+		return errors.New("connection refused")
+	})
+	w.Logger = logger.New(&buf, logger.Text, logger.LevelInfo)
+
+	if _, err := w.ProcessOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	//chi legge il log deve potere risalire alla richiesta che ha messo la mail in coda
+	for _, s := range []string{"WARN mail not sent", "request_id=abc123", "mail_id=1", `error="connection refused"`} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("expected %q in the log but got %q", s, buf.String())
+		}
+	}
+}
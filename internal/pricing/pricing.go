@@ -0,0 +1,136 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Laura470/bookings/internal/models"
+)
+
+//ErrInvalidDates is returned when the departure is not after the arrival
+var ErrInvalidDates = errors.New("departure must be after arrival")
+
+//MinimumStayError is returned when the stay is shorter than the minimum stay of the room
+type MinimumStayError struct {
+	Nights  int
+	MinStay int
+}
+
+func (e MinimumStayError) Error() string {
+	return fmt.Sprintf("the minimum stay is %d nights", e.MinStay)
+}
+
+//Quote computes the price of a stay from start (arrival) to end (departure), night by night.
+//Every night uses the seasonal rate that covers it, if any, otherwise the rates of the room.
+//Friday and Saturday nights use the weekend rate, when it is set.
+//The minimum stay is the one of the seasonal rate covering the arrival night, or the one of the room
+func Quote(room models.Room, rates []models.RoomRate, start, end time.Time) (models.Quote, error) {
+	quote := models.Quote{
+		RoomID:    room.ID,
+		StartDate: start,
+		EndDate:   end,
+	}
+
+	if !end.After(start) {
+		return quote, ErrInvalidDates
+	}
+
+	minStay := room.MinStay
+	if r, ok := rateFor(rates, start); ok && r.MinStay > 0 {
+		minStay = r.MinStay
+	}
+
+	//l'ultima notte è quella prima della partenza
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		nightly, weekend := room.BaseRate, room.WeekendRate
+		if r, ok := rateFor(rates, d); ok {
+			nightly, weekend = r.NightlyRate, r.WeekendRate
+		}
+
+		price := nightly
+		if isWeekendNight(d) && weekend > 0 {
+			price = weekend
+		}
+
+		quote.Nights = append(quote.Nights, models.NightPrice{Date: d, Price: price})
+		quote.Total += price
+	}
+
+	if len(quote.Nights) < minStay {
+		return quote, MinimumStayError{Nights: len(quote.Nights), MinStay: minStay}
+	}
+
+	return quote, nil
+}
+
+//rateFor returns the seasonal rate covering the night of d, start and end dates included.
+//When more rates cover it the last one in the slice wins
+func rateFor(rates []models.RoomRate, d time.Time) (models.RoomRate, bool) {
+	var found models.RoomRate
+	ok := false
+	for _, r := range rates {
+		if !d.Before(r.StartDate) && !d.After(r.EndDate) {
+			found = r
+			ok = true
+		}
+	}
+	return found, ok
+}
+
+func isWeekendNight(d time.Time) bool {
+	return d.Weekday() == time.Friday || d.Weekday() == time.Saturday
+}
+
+//FormatAmount formats an amount in cents the way ParseAmount reads it, 12050 becomes 120.50
+func FormatAmount(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+//FormatPrice formats an amount in cents for the guests, 12050 becomes $120.50
+func FormatPrice(cents int) string {
+	if cents < 0 {
+		return "-$" + FormatAmount(-cents)
+	}
+	return "$" + FormatAmount(cents)
+}
+
+//ParseAmount parses an amount like 120, 120.5 or 120.50 into cents
+func ParseAmount(s string) (int, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "$")
+	if s == "" {
+		return 0, errors.New("empty amount")
+	}
+
+	units, decimals := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		units, decimals = s[:i], s[i+1:]
+	}
+	if len(decimals) > 2 {
+		return 0, fmt.Errorf("too many decimals in %s", s)
+	}
+	for len(decimals) < 2 {
+		decimals += "0"
+	}
+	if units == "" {
+		units = "0"
+	}
+
+	u, err := strconv.Atoi(units)
+	if err != nil || u < 0 {
+		return 0, fmt.Errorf("invalid amount %s", s)
+	}
+	c, err := strconv.Atoi(decimals)
+	if err != nil || c < 0 {
+		return 0, fmt.Errorf("invalid amount %s", s)
+	}
+
+	return u*100 + c, nil
+}
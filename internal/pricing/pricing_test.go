@@ -0,0 +1,153 @@
+package pricing
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Laura470/bookings/internal/models"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+var room = models.Room{
+	ID:          1,
+	BaseRate:    10000,
+	WeekendRate: 12000,
+	MinStay:     1,
+}
+
+var summer = models.RoomRate{
+	RoomID:      1,
+	Name:        "Summer",
+	StartDate:   date("2040-07-01"),
+	EndDate:     date("2040-08-31"),
+	NightlyRate: 15000,
+	MinStay:     3,
+}
+
+var quoteTests = []struct {
+	name          string
+	rates         []models.RoomRate
+	start         string
+	end           string
+	expectedTotal int
+	expectedErr   error
+}{
+	//2040-01-02 è un lunedì
+	{"weekdays", nil, "2040-01-02", "2040-01-05", 30000, nil},
+	{"friday and saturday are weekend nights", nil, "2040-01-05", "2040-01-09", 10000 + 12000 + 12000 + 10000, nil},
+	{"one night", nil, "2040-01-02", "2040-01-03", 10000, nil},
+	{"same day", nil, "2040-01-02", "2040-01-02", 0, ErrInvalidDates},
+	{"departure before arrival", nil, "2040-01-05", "2040-01-02", 0, ErrInvalidDates},
+	//il 2040-07-02 è un lunedì, senza weekend rate si paga la nightly rate anche il weekend
+	{"seasonal rate", []models.RoomRate{summer}, "2040-07-02", "2040-07-09", 7 * 15000, nil},
+	{"seasonal minimum stay", []models.RoomRate{summer}, "2040-07-02", "2040-07-04", 2 * 15000,
+		MinimumStayError{Nights: 2, MinStay: 3}},
+	//arrivo il 2040-06-29 (venerdì), le notti di luglio hanno la tariffa estiva
+	{"across the start of a season", []models.RoomRate{summer}, "2040-06-28", "2040-07-03",
+		10000 + 12000 + 12000 + 15000 + 15000, nil},
+}
+
+func TestQuote(t *testing.T) {
+	for _, e := range quoteTests {
+		q, err := Quote(room, e.rates, date(e.start), date(e.end))
+
+		if !errors.Is(err, e.expectedErr) {
+			t.Errorf("%s: expected error %v but got %v", e.name, e.expectedErr, err)
+		}
+		if q.Total != e.expectedTotal {
+			t.Errorf("%s: expected total %d but got %d", e.name, e.expectedTotal, q.Total)
+		}
+	}
+}
+
+func TestQuote_Nights(t *testing.T) {
+	q, err := Quote(room, nil, date("2040-01-05"), date("2040-01-07"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(q.Nights) != 2 {
+		t.Fatalf("expected 2 nights but got %d", len(q.Nights))
+	}
+	if !q.Nights[1].Date.Equal(date("2040-01-06")) || q.Nights[1].Price != 12000 {
+		t.Errorf("wrong second night: %v", q.Nights[1])
+	}
+}
+
+func TestQuote_LastRateWins(t *testing.T) {
+	christmas := models.RoomRate{
+		StartDate:   date("2040-12-20"),
+		EndDate:     date("2040-12-31"),
+		NightlyRate: 20000,
+	}
+	winter := models.RoomRate{
+		StartDate:   date("2040-12-01"),
+		EndDate:     date("2041-02-28"),
+		NightlyRate: 8000,
+	}
+
+	q, _ := Quote(room, []models.RoomRate{winter, christmas}, date("2040-12-24"), date("2040-12-25"))
+	if q.Total != 20000 {
+		t.Errorf("expected the christmas rate 20000 but got %d", q.Total)
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	tests := map[int]string{
+		12050: "120.50",
+		5:     "0.05",
+		0:     "0.00",
+		-150:  "-1.50",
+	}
+	for cents, expected := range tests {
+		if got := FormatAmount(cents); got != expected {
+			t.Errorf("FormatAmount(%d): expected %s but got %s", cents, expected, got)
+		}
+		//quello che scrivo lo devo poter rileggere
+		if cents >= 0 {
+			if back, _ := ParseAmount(expected); back != cents {
+				t.Errorf("ParseAmount(%s): expected %d but got %d", expected, cents, back)
+			}
+		}
+	}
+}
+
+func TestFormatPrice(t *testing.T) {
+	if got := FormatPrice(12050); got != "$120.50" {
+		t.Errorf("expected $120.50 but got %s", got)
+	}
+	if got := FormatPrice(-150); got != "-$1.50" {
+		t.Errorf("expected -$1.50 but got %s", got)
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	valid := map[string]int{
+		"120":    12000,
+		"120.5":  12050,
+		"120.50": 12050,
+		"$99.99": 9999,
+		" 0.05 ": 5,
+		".5":     50,
+	}
+	for s, expected := range valid {
+		got, err := ParseAmount(s)
+		if err != nil {
+			t.Errorf("ParseAmount(%q): unexpected error %v", s, err)
+		}
+		if got != expected {
+			t.Errorf("ParseAmount(%q): expected %d but got %d", s, expected, got)
+		}
+	}
+
+	for _, s := range []string{"", "abc", "1.234", "-5", "1.-5"} {
+		if _, err := ParseAmount(s); err == nil {
+			t.Errorf("ParseAmount(%q): expected an error", s)
+		}
+	}
+}
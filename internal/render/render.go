@@ -7,24 +7,32 @@ import (
 	"html/template"
 	"net/http"
 	"path/filepath"
+	"strings"
+	texttemplate "text/template"
 	"time"
 
 	"github.com/Laura470/bookings/internal/config"
 	"github.com/Laura470/bookings/internal/models"
+	"github.com/Laura470/bookings/internal/pricing"
 	"github.com/justinas/nosurf"
 )
 
 //FuncMap provvede una map di nomi e funzioni disponibili nei template
 var functions = template.FuncMap{
-	"humanDate":  HumanDate,
-	"formatDate": FormatDate,
-	"iterate":    Iterate,
+	"humanDate":    HumanDate,
+	"formatDate":   FormatDate,
+	"iterate":      Iterate,
+	"formatPrice":  pricing.FormatPrice,
+	"formatAmount": pricing.FormatAmount,
+	"hasRole":      HasRole,
 }
 
 var app *config.AppConfig
 
 var pathToTemplates = "./templates"
 
+var pathToMailTemplates = "./email-templates"
+
 //Iterate resturns a slice of ints starting at 1 going to count
 func Iterate(count int) []int {
 	var i int
@@ -51,6 +59,15 @@ func FormatDate(t time.Time, f string) string {
 	return t.Format(f)
 }
 
+//HasRole tells if role can do what the role called name can do, to hide the menu items and the buttons
+func HasRole(role models.Role, name string) (bool, error) {
+	min, err := models.ParseRole(name)
+	if err != nil {
+		return false, err
+	}
+	return role.AtLeast(min), nil
+}
+
 //AddDefaultData aggiunge data a ogni pagina, lo uso per il tocken csrf
 func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateData {
 	td.Flash = app.Session.PopString(r.Context(), "flash")
@@ -59,6 +76,7 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 	td.CSRFToken = nosurf.Token(r)
 	if app.Session.Exists(r.Context(), "user_id") {
 		td.IsAuthenticated = 1
+		td.Role = models.Role(app.Session.GetInt(r.Context(), "access_level"))
 	}
 	return td
 }
@@ -90,7 +108,7 @@ func Template(w http.ResponseWriter, r *http.Request, tmpl string, td *models.Te
 
 	_, err := buf.WriteTo(w)
 	if err != nil {
-		fmt.Println("Error writing template to browser", err)
+		app.Logger.Ctx(r.Context()).Error("can't write the template to the browser", "template", tmpl, "error", err)
 		return err
 	}
 	return nil
@@ -139,3 +157,92 @@ func CreateTemplateCache() (map[string]*template.Template, error) {
 
 	return myCache, nil
 }
+
+//Mail renders the mail tmpl for to, with the subject and the plain text from the text template
+func Mail(tmpl string, to string, md *models.MailTemplateData) (models.MailData, error) {
+	var tc map[string]config.MailTemplate
+
+	//come per le pagine, senza cache vedo subito le modifiche
+	if app.UseCache {
+		tc = app.MailTemplateCache
+	} else {
+		var err error
+		tc, err = CreateMailTemplateCache()
+		if err != nil {
+			return models.MailData{}, err
+		}
+	}
+
+	t, ok := tc[tmpl]
+	if !ok {
+		return models.MailData{}, fmt.Errorf("could not get mail template %s from cache", tmpl)
+	}
+
+	subject := new(bytes.Buffer)
+	err := t.Text.ExecuteTemplate(subject, "subject", md)
+	if err != nil {
+		return models.MailData{}, err
+	}
+
+	text := new(bytes.Buffer)
+	err = t.Text.Execute(text, md)
+	if err != nil {
+		return models.MailData{}, err
+	}
+
+	html := new(bytes.Buffer)
+	err = t.HTML.Execute(html, md)
+	if err != nil {
+		return models.MailData{}, err
+	}
+
+	return models.MailData{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Content: html.String(),
+		Text:    strings.TrimSpace(text.String()),
+	}, nil
+}
+
+//CreateMailTemplateCache creates the cache of the mail templates: every mail has name.html.tmpl, with the layouts,
+//and name.txt.tmpl
+func CreateMailTemplateCache() (map[string]config.MailTemplate, error) {
+	myCache := map[string]config.MailTemplate{}
+
+	pages, err := filepath.Glob(fmt.Sprintf("%s/*.html.tmpl", pathToMailTemplates))
+	if err != nil {
+		return myCache, err
+	}
+
+	for _, page := range pages {
+		name := strings.TrimSuffix(filepath.Base(page), ".html.tmpl")
+
+		ts, err := template.New(filepath.Base(page)).Funcs(functions).ParseFiles(page)
+		if err != nil {
+			return myCache, err
+		}
+
+		matches, err := filepath.Glob(fmt.Sprintf("%s/*.layout.tmpl", pathToMailTemplates))
+		if err != nil {
+			return myCache, err
+		}
+
+		if len(matches) > 0 {
+			ts, err = ts.ParseGlob(fmt.Sprintf("%s/*.layout.tmpl", pathToMailTemplates))
+			if err != nil {
+				return myCache, err
+			}
+		}
+
+		//il testo semplice non va escaped, per questo è un text/template
+		textPage := fmt.Sprintf("%s/%s.txt.tmpl", pathToMailTemplates, name)
+		text, err := texttemplate.New(filepath.Base(textPage)).Funcs(texttemplate.FuncMap(functions)).ParseFiles(textPage)
+		if err != nil {
+			return myCache, err
+		}
+
+		myCache[name] = config.MailTemplate{HTML: ts, Text: text}
+	}
+
+	return myCache, nil
+}
@@ -2,7 +2,9 @@ package render
 
 import (
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Laura470/bookings/internal/models"
 )
@@ -83,3 +85,71 @@ func TestCreateTemplateCache(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestMail(t *testing.T) {
+	pathToMailTemplates = "./../../email-templates"
+	tc, err := CreateMailTemplateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.MailTemplateCache = tc
+
+	for _, name := range []string{"confirmation", "owner-reservation", "change", "owner-change", "cancellation", "owner-cancellation", "reminder"} {
+		if _, ok := tc[name]; !ok {
+			t.Errorf("mail template %s not in the cache", name)
+		}
+	}
+
+	start := time.Date(2040, 1, 2, 0, 0, 0, 0, time.UTC)
+	md := &models.MailTemplateData{
+		Reservation: models.Reservation{
+			FirstName:  "<b>John</b>",
+			StartDate:  start,
+			EndDate:    start.AddDate(0, 0, 2),
+			TotalPrice: 24000,
+			Room:       models.Room{RoomName: "General's Quarters"},
+		},
+		ManageURL: "http://localhost:8080/my-reservation/abc",
+	}
+
+	msg, err := Mail("confirmation", "john@smith.com", md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.To != "john@smith.com" || msg.Subject != "Reservation Confirmation" {
+		t.Errorf("wrong mail: %s %s", msg.To, msg.Subject)
+	}
+
+	//il nome dell'ospite nell'html è escaped, nel testo semplice no
+	if strings.Contains(msg.Content, "<b>John</b>") || !strings.Contains(msg.Content, "&lt;b&gt;John&lt;/b&gt;") {
+		t.Error("the name of the guest is not escaped in the html")
+	}
+	if !strings.Contains(msg.Text, "Dear <b>John</b>,") || !strings.Contains(msg.Text, "General's Quarters") {
+		t.Errorf("wrong plain text:\n%s", msg.Text)
+	}
+	if !strings.Contains(msg.Content, "2 nights") || !strings.Contains(msg.Text, md.ManageURL) {
+		t.Error("the mail doesn't have the data of the reservation")
+	}
+
+	_, err = Mail("non-existent", "john@smith.com", md)
+	if err == nil {
+		t.Error("rendered a mail template that doesn't exist")
+	}
+}
+
+func TestHasRole(t *testing.T) {
+	ok, err := HasRole(models.RoleManager, "front-desk")
+	if err != nil || !ok {
+		t.Errorf("a manager can't do what the front desk does (%v)", err)
+	}
+
+	ok, _ = HasRole(models.RoleViewer, "manager")
+	if ok {
+		t.Error("a viewer can do what a manager does")
+	}
+
+	_, err = HasRole(models.RoleOwner, "admin")
+	if err == nil {
+		t.Error("expected an error for an unknown role")
+	}
+}
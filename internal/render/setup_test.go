@@ -2,13 +2,13 @@ package render
 
 import (
 	"encoding/gob"
-	"log"
 	"net/http"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/Laura470/bookings/internal/config"
+	"github.com/Laura470/bookings/internal/logger"
 	"github.com/Laura470/bookings/internal/models"
 	"github.com/alexedwards/scs/v2"
 )
@@ -23,11 +23,7 @@ func TestMain(m *testing.M) {
 
 	testApp.InProduction = false
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	testApp.InfoLog = infoLog
-
-	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	testApp.ErrorLog = errorLog
+	testApp.Logger = logger.New(os.Stdout, logger.Text, logger.LevelInfo)
 
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
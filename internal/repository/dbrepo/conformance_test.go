@@ -0,0 +1,745 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Laura470/bookings/internal/config"
+	"github.com/Laura470/bookings/internal/driver"
+	"github.com/Laura470/bookings/internal/logger"
+	"github.com/Laura470/bookings/internal/migrate"
+	"github.com/Laura470/bookings/internal/models"
+	"github.com/Laura470/bookings/internal/repository"
+	"github.com/Laura470/bookings/migrations"
+)
+
+//postgresDSNEnv is the variable with the dsn of a Postgres database to run the tests on too,
+//like "host=localhost dbname=bookings_test user=postgres password=secret". Every test works
+//in a schema of its own, dropped at the end, so the database can be one already used for other things
+const postgresDSNEnv = "BOOKINGS_TEST_DSN"
+
+type backend struct {
+	name string
+	new  func(t *testing.T) repository.DatabaseRepo
+}
+
+//backends returns the repositories that must behave the same, every test gets a new one with only the seeds.
+//The ones in the process run always, Postgres only when postgresDSNEnv is set
+func backends() []backend {
+	all := []backend{
+		{"memory", func(t *testing.T) repository.DatabaseRepo {
+			return NewMemoryRepo(&config.AppConfig{})
+		}},
+		{"sqlite", newSQLiteRepo},
+	}
+	if dsn := os.Getenv(postgresDSNEnv); dsn != "" {
+		all = append(all, backend{"postgres", func(t *testing.T) repository.DatabaseRepo {
+			return newPostgresRepo(t, dsn)
+		}})
+	}
+	return all
+}
+
+//newSQLiteRepo returns the repository of a sqlite database in a temporary file, with the migrations applied
+func newSQLiteRepo(t *testing.T) repository.DatabaseRepo {
+	db, err := driver.NewDatabase(driver.SQLite, driver.SQLiteDSN(filepath.Join(t.TempDir(), "bookings.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	all, err := migrate.Load(migrations.FS, migrations.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := migrate.New(db, driver.SQLite, all, logger.Discard())
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return NewSQLiteRepo(db, &config.AppConfig{})
+}
+
+//newPostgresRepo returns the repository of a new schema of the Postgres database of dsn, with the migrations applied
+func newPostgresRepo(t *testing.T, dsn string) repository.DatabaseRepo {
+	admin, err := driver.NewDatabase(driver.Postgres, dsn)
+	if err != nil {
+		t.Fatalf("can't connect to %s: %s", postgresDSNEnv, err)
+	}
+	schema := fmt.Sprintf("bookings_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("create schema " + schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("drop schema " + schema + " cascade"); err != nil {
+			t.Errorf("can't drop the schema %s: %s", schema, err)
+		}
+		admin.Close()
+	})
+
+	//le tabelle create senza schema finiscono nel primo del search_path
+	db, err := driver.NewDatabase(driver.Postgres, withSearchPath(dsn, schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//chiuso prima di admin, le cleanup vanno al contrario
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	all, err := migrate.Load(migrations.FS, migrations.Postgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := migrate.New(db, driver.Postgres, all, logger.Discard())
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return NewPostgresRepo(db, &config.AppConfig{})
+}
+
+//withSearchPath adds the search_path to the dsn, an url or key=value pairs
+func withSearchPath(dsn, schema string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		return dsn + sep + "search_path=" + schema
+	}
+	return dsn + " search_path=" + schema
+}
+
+//TestRepository runs the same tests on every backend
+func TestRepository(t *testing.T) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repo repository.DatabaseRepo)
+	}{
+		{"BookRoom", testRepoBookRoom},
+		{"Availability", testRepoAvailability},
+		{"Reservations", testRepoReservations},
+		{"Processed", testRepoProcessed},
+		{"Cascades", testRepoCascades},
+		{"RoomBlocks", testRepoRoomBlocks},
+		{"DeleteRoom", testRepoDeleteRoom},
+		{"Users", testRepoUsers},
+		{"Authenticate", testRepoAuthenticate},
+		{"LoginFailures", testRepoLoginFailures},
+		{"APITokens", testRepoAPITokens},
+		{"ICalFeeds", testRepoICalFeeds},
+		{"Outbox", testRepoOutbox},
+	}
+	for _, b := range backends() {
+		for _, e := range tests {
+			t.Run(b.name+"/"+e.name, func(t *testing.T) {
+				e.test(t, b.new(t))
+			})
+		}
+	}
+}
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func testRepoBookRoom(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	res := models.Reservation{FirstName: "John", LastName: "Smith", Email: "john@smith.com",
+		StartDate: date("2040-01-10"), EndDate: date("2040-01-12"), RoomID: 1}
+	id, err := repo.BookRoom(ctx, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 1 {
+		t.Errorf("expected the first reservation to have id 1 but got %d", id)
+	}
+
+	//le prenotazioni che toccano le date di un'altra non entrano, in ordine perché quelle che entrano occupano altre date
+	tests := []struct {
+		name     string
+		roomID   int
+		start    string
+		end      string
+		expected error
+	}{
+		{"same dates", 1, "2040-01-10", "2040-01-12", repository.ErrRoomNotAvailable},
+		{"ends on the arrival", 1, "2040-01-08", "2040-01-10", repository.ErrRoomNotAvailable},
+		{"starts on the departure", 1, "2040-01-12", "2040-01-14", repository.ErrRoomNotAvailable},
+		{"one night inside", 1, "2040-01-11", "2040-01-11", repository.ErrRoomNotAvailable},
+		{"around", 1, "2040-01-01", "2040-01-31", repository.ErrRoomNotAvailable},
+		{"ends the day before the arrival", 1, "2040-01-07", "2040-01-09", nil},
+		{"starts the day after the departure", 1, "2040-01-13", "2040-01-15", nil},
+		{"other room", 2, "2040-01-10", "2040-01-12", nil},
+		{"missing room", 1000, "2040-03-01", "2040-03-02", sql.ErrNoRows},
+	}
+	for _, e := range tests {
+		res := models.Reservation{LastName: e.name, StartDate: date(e.start), EndDate: date(e.end), RoomID: e.roomID}
+		if _, err := repo.BookRoom(ctx, res); !errors.Is(err, e.expected) {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, err)
+		}
+	}
+	if all, _ := repo.AllReservations(ctx); len(all) != 4 {
+		t.Errorf("expected the 4 reservations that fit but got %d", len(all))
+	}
+
+	repo.UpdateActiveForRoom(ctx, 2, 0)
+	res.RoomID = 2
+	if _, err := repo.BookRoom(ctx, res); !errors.Is(err, repository.ErrRoomNotAvailable) {
+		t.Errorf("booked an inactive room: %v", err)
+	}
+}
+
+func testRepoReservations(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	first, _ := repo.BookRoom(ctx, models.Reservation{StartDate: date("2040-02-01"), EndDate: date("2040-02-03"), RoomID: 1})
+	second, _ := repo.BookRoom(ctx, models.Reservation{StartDate: date("2040-01-01"), EndDate: date("2040-01-03"), RoomID: 1})
+
+	all, _ := repo.AllReservations(ctx)
+	if len(all) != 2 || all[0].ID != second || all[0].Room.RoomName != "General's Quarters" {
+		t.Errorf("expected the reservations by start date with the room but got %+v", all)
+	}
+
+	//una prenotazione si può spostare sulle sue stesse date, non su quelle di un'altra
+	err := repo.UpdateReservationDates(ctx, models.Reservation{ID: first, RoomID: 1, StartDate: date("2040-02-02"), EndDate: date("2040-02-05")})
+	if err != nil {
+		t.Errorf("can't move a reservation over its own dates: %v", err)
+	}
+	err = repo.UpdateReservationDates(ctx, models.Reservation{ID: first, RoomID: 1, StartDate: date("2040-01-03"), EndDate: date("2040-01-05")})
+	if !errors.Is(err, repository.ErrRoomNotAvailable) {
+		t.Errorf("moved a reservation over another one: %v", err)
+	}
+
+	repo.DeleteReservation(ctx, first)
+	restrictions, _ := repo.GetRestrictionForRoomByDate(ctx, 1, date("2040-02-01"), date("2040-02-28"))
+	if len(restrictions) != 0 {
+		t.Errorf("the restriction of a deleted reservation is still there: %+v", restrictions)
+	}
+	if _, err := repo.GetReservationByID(ctx, first); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a deleted reservation but got %v", err)
+	}
+}
+
+func testRepoRoomBlocks(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	repo.BookRoom(ctx, models.Reservation{StartDate: date("2040-01-16"), EndDate: date("2040-01-17"), RoomID: 1})
+
+	//la terza settimana tocca la prenotazione, quindi non si inserisce niente
+	block := models.RoomBlock{RoomID: 1, StartDate: date("2040-01-02"), EndDate: date("2040-01-03"),
+		Recurrence: models.RecurrenceWeekly, RepeatUntil: date("2040-01-31")}
+	if _, err := repo.InsertRoomBlock(ctx, block); !errors.Is(err, repository.ErrBlockOverlapsReservation) {
+		t.Errorf("expected ErrBlockOverlapsReservation but got %v", err)
+	}
+	if blocks, _ := repo.AllRoomBlocks(ctx); len(blocks) != 0 {
+		t.Errorf("a block that overlaps a reservation has been inserted: %+v", blocks)
+	}
+
+	block.RepeatUntil = date("2040-01-10")
+	id, err := repo.InsertRoomBlock(ctx, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restrictions, _ := repo.GetRestrictionForRoomByDate(ctx, 1, date("2040-01-01"), date("2040-01-10"))
+	if len(restrictions) != 2 || restrictions[0].Block.ID != id {
+		t.Errorf("expected a restriction for each week but got %+v", restrictions)
+	}
+
+	repo.DeleteRoomBlock(ctx, id)
+	if restrictions, _ := repo.GetRestrictionForRoomByDate(ctx, 1, date("2040-01-01"), date("2040-01-10")); len(restrictions) != 0 {
+		t.Errorf("the restrictions of a deleted block are still there: %+v", restrictions)
+	}
+}
+
+func testRepoDeleteRoom(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	repo.BookRoom(ctx, models.Reservation{StartDate: date("2040-01-16"), EndDate: date("2040-01-17"), RoomID: 1})
+	if err := repo.DeleteRoom(ctx, 1); !errors.Is(err, repository.ErrRoomHasReservations) {
+		t.Errorf("expected ErrRoomHasReservations but got %v", err)
+	}
+
+	repo.InsertRoomBlock(ctx, models.RoomBlock{RoomID: 2, StartDate: date("2040-01-16"), EndDate: date("2040-01-16")})
+	repo.InsertRoomRate(ctx, models.RoomRate{RoomID: 2, StartDate: date("2040-07-01"), EndDate: date("2040-08-31"), NightlyRate: 15000})
+	if err := repo.DeleteRoom(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if restrictions, _ := repo.GetRestrictionForRoomByDate(ctx, 2, date("2040-01-01"), date("2040-12-31")); len(restrictions) != 0 {
+		t.Errorf("the restrictions of a deleted room are still there: %+v", restrictions)
+	}
+	if rates, _ := repo.GetRatesForRoom(ctx, 2); len(rates) != 0 {
+		t.Errorf("the rates of a deleted room are still there: %+v", rates)
+	}
+	if err := repo.InsertRoomRestriction(ctx, models.RoomRestriction{RoomID: 2, RestrictionID: 2}); err == nil {
+		t.Error("inserted a restriction for a deleted room")
+	}
+}
+
+func testRepoUsers(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id, err := repo.InsertUser(ctx, models.User{FirstName: "Admin", Email: "admin@admin.com", AccessLevel: 3, Active: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.InsertUser(ctx, models.User{Email: "admin@admin.com"}); err == nil {
+		t.Error("inserted two users with the same email")
+	}
+
+	repo.UpdatePassword(ctx, id, "password")
+	u, err := repo.GetUserByEmail(ctx, "admin@admin.com")
+	if err != nil || u.ID != id || u.Password == "" || u.Password == "password" {
+		t.Errorf("expected user %d with the password hashed but got %+v, %v", id, u, err)
+	}
+}
+
+func testRepoAvailability(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	if _, err := repo.BookRoom(ctx, models.Reservation{StartDate: date("2040-01-10"), EndDate: date("2040-01-12"), RoomID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.InsertRoomBlock(ctx, models.RoomBlock{RoomID: 2, StartDate: date("2040-01-20"), EndDate: date("2040-01-20")}); err != nil {
+		t.Fatal(err)
+	}
+
+	//le date sono incluse: il giorno dell'arrivo e quello della partenza sono occupati
+	tests := []struct {
+		name         string
+		start        string
+		end          string
+		available1   bool
+		restrictions int
+		free         []int
+	}{
+		{"before", "2040-01-05", "2040-01-09", true, 0, []int{1, 2}},
+		{"the day before the arrival", "2040-01-09", "2040-01-09", true, 0, []int{1, 2}},
+		{"ends on the arrival", "2040-01-05", "2040-01-10", false, 1, []int{2}},
+		{"the day of the arrival", "2040-01-10", "2040-01-10", false, 1, []int{2}},
+		{"same dates", "2040-01-10", "2040-01-12", false, 1, []int{2}},
+		{"inside", "2040-01-11", "2040-01-11", false, 1, []int{2}},
+		{"the day of the departure", "2040-01-12", "2040-01-12", false, 1, []int{2}},
+		{"starts on the departure", "2040-01-12", "2040-01-15", false, 1, []int{2}},
+		{"the day after the departure", "2040-01-13", "2040-01-13", true, 0, []int{1, 2}},
+		{"around", "2040-01-01", "2040-01-31", false, 1, nil},
+		{"the day blocked in room 2", "2040-01-20", "2040-01-20", true, 0, []int{1}},
+		{"ends on the block", "2040-01-15", "2040-01-20", true, 0, []int{1}},
+		{"starts the day after the block", "2040-01-21", "2040-01-25", true, 0, []int{1, 2}},
+	}
+	for _, e := range tests {
+		available, err := repo.SearchAvailabilityByDatesByRoomID(ctx, date(e.start), date(e.end), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if available != e.available1 {
+			t.Errorf("%s: expected room 1 available %v but got %v", e.name, e.available1, available)
+		}
+
+		restrictions, err := repo.GetRestrictionForRoomByDate(ctx, 1, date(e.start), date(e.end))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(restrictions) != e.restrictions {
+			t.Errorf("%s: expected %d restrictions of room 1 but got %d", e.name, e.restrictions, len(restrictions))
+		}
+
+		rooms, err := repo.SearchAvailabilityForAllRooms(ctx, date(e.start), date(e.end))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var free []int
+		for _, r := range rooms {
+			free = append(free, r.ID)
+		}
+		if fmt.Sprint(free) != fmt.Sprint(e.free) {
+			t.Errorf("%s: expected rooms %v available but got %v", e.name, e.free, free)
+		}
+	}
+}
+
+func testRepoProcessed(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	first, _ := repo.BookRoom(ctx, models.Reservation{StartDate: date("2040-01-01"), EndDate: date("2040-01-03"), RoomID: 1})
+	second, _ := repo.BookRoom(ctx, models.Reservation{StartDate: date("2040-02-01"), EndDate: date("2040-02-03"), RoomID: 1})
+
+	//ogni passo parte da dove è arrivato quello prima
+	tests := []struct {
+		name      string
+		id        int
+		processed int
+		fresh     []int
+	}{
+		{"first processed", first, 1, []int{second}},
+		{"processed twice", first, 1, []int{second}},
+		{"second processed", second, 1, nil},
+		{"first new again", first, 0, []int{first}},
+		{"missing reservation", 1000, 1, []int{first}},
+	}
+	for _, e := range tests {
+		if err := repo.UpdateProcessedForReservation(ctx, e.id, e.processed); err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+		if res, err := repo.GetReservationByID(ctx, e.id); err == nil && res.Processed != e.processed {
+			t.Errorf("%s: expected processed %d but got %d", e.name, e.processed, res.Processed)
+		}
+
+		reservations, err := repo.AllNewReservations(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fresh []int
+		for _, r := range reservations {
+			fresh = append(fresh, r.ID)
+		}
+		if fmt.Sprint(fresh) != fmt.Sprint(e.fresh) {
+			t.Errorf("%s: expected the new reservations %v but got %v", e.name, e.fresh, fresh)
+		}
+		//le prenotazioni già viste restano tra tutte
+		if all, _ := repo.AllReservations(ctx); len(all) != 2 {
+			t.Errorf("%s: expected 2 reservations but got %d", e.name, len(all))
+		}
+	}
+}
+
+func testRepoCascades(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	//insert crea le restrizioni della stanza da start a end e restituisce come cancellare quello che le ha create
+	tests := []struct {
+		name   string
+		roomID int
+		start  string
+		end    string
+		insert func(roomID int, start, end time.Time) (func() error, error)
+	}{
+		{"reservation", 1, "2040-01-10", "2040-01-12", func(roomID int, start, end time.Time) (func() error, error) {
+			id, err := repo.BookRoom(ctx, models.Reservation{StartDate: start, EndDate: end, RoomID: roomID})
+			return func() error { return repo.DeleteReservation(ctx, id) }, err
+		}},
+		{"one day block", 1, "2040-02-10", "2040-02-10", func(roomID int, start, end time.Time) (func() error, error) {
+			//dal calendario si toglie la restrizione del giorno
+			if _, err := repo.InsertRoomBlock(ctx, models.RoomBlock{RoomID: roomID, StartDate: start, EndDate: end}); err != nil {
+				return nil, err
+			}
+			restrictions, err := repo.GetRestrictionForRoomByDate(ctx, roomID, start, end)
+			if err != nil || len(restrictions) != 1 {
+				return nil, fmt.Errorf("expected the restriction of the block but got %+v, %v", restrictions, err)
+			}
+			return func() error { return repo.DeleteBlockByID(ctx, restrictions[0].ID) }, nil
+		}},
+		{"repeated block", 1, "2040-03-02", "2040-03-17", func(roomID int, start, end time.Time) (func() error, error) {
+			id, err := repo.InsertRoomBlock(ctx, models.RoomBlock{RoomID: roomID, StartDate: start, EndDate: start.AddDate(0, 0, 1),
+				Recurrence: models.RecurrenceWeekly, RepeatUntil: end})
+			return func() error { return repo.DeleteRoomBlock(ctx, id) }, err
+		}},
+		{"calendar", 1, "2040-04-10", "2040-04-20", func(roomID int, start, end time.Time) (func() error, error) {
+			id, err := repo.InsertICalFeed(ctx, models.ICalFeed{RoomID: roomID, Name: "Airbnb", URL: "https://example.com/cal.ics"})
+			if err != nil {
+				return nil, err
+			}
+			periods := []models.Period{{Start: start, End: start.AddDate(0, 0, 2)}, {Start: end.AddDate(0, 0, -2), End: end}}
+			err = repo.ReplaceICalFeedRestrictions(ctx, models.ICalFeed{ID: id, RoomID: roomID}, periods)
+			return func() error { return repo.DeleteICalFeed(ctx, id) }, err
+		}},
+		{"room", 2, "2040-05-10", "2040-05-20", func(roomID int, start, end time.Time) (func() error, error) {
+			if _, err := repo.InsertRoomBlock(ctx, models.RoomBlock{RoomID: roomID, StartDate: start, EndDate: start}); err != nil {
+				return nil, err
+			}
+			_, err := repo.InsertRoomBlock(ctx, models.RoomBlock{RoomID: roomID, StartDate: end, EndDate: end})
+			return func() error { return repo.DeleteRoom(ctx, roomID) }, err
+		}},
+	}
+	for _, e := range tests {
+		start, end := date(e.start), date(e.end)
+		del, err := e.insert(e.roomID, start, end)
+		if err != nil {
+			t.Errorf("%s: %s", e.name, err)
+			continue
+		}
+		if available, _ := repo.SearchAvailabilityByDatesByRoomID(ctx, start, end, e.roomID); available {
+			t.Errorf("%s: room %d is available from %s to %s", e.name, e.roomID, e.start, e.end)
+		}
+
+		if err := del(); err != nil {
+			t.Errorf("%s: %s", e.name, err)
+			continue
+		}
+		restrictions, err := repo.GetRestrictionForRoomByDate(ctx, e.roomID, start, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(restrictions) != 0 {
+			t.Errorf("%s: the restrictions are still there after the delete: %+v", e.name, restrictions)
+		}
+	}
+
+	//le restrizioni delle altre stanze restano
+	if restrictions, _ := repo.GetRestrictionForRoomByDate(ctx, 1, date("2040-01-01"), date("2040-12-31")); len(restrictions) != 0 {
+		t.Errorf("expected no restrictions left in room 1 but got %+v", restrictions)
+	}
+}
+
+func testRepoAuthenticate(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	users := map[string]int{}
+	for _, u := range []struct {
+		email    string
+		password string
+		active   int
+	}{
+		{"admin@admin.com", "password", 1},
+		{"disabled@admin.com", "password", 0},
+		//invitato, non ha ancora scelto la password
+		{"invited@admin.com", "", 1},
+	} {
+		id, err := repo.InsertUser(ctx, models.User{Email: u.email, AccessLevel: 1, Active: u.active})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.password != "" {
+			repo.UpdatePassword(ctx, id, u.password)
+		}
+		users[u.email] = id
+	}
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		//id è l'utente che entra, 0 se non entra nessuno
+		id     int
+		noRows bool
+	}{
+		{"right password", "admin@admin.com", "password", users["admin@admin.com"], false},
+		{"email in capitals", "Admin@ADMIN.com", "password", users["admin@admin.com"], false},
+		{"wrong password", "admin@admin.com", "wrong", 0, false},
+		{"password in capitals", "admin@admin.com", "PASSWORD", 0, false},
+		{"empty password", "admin@admin.com", "", 0, false},
+		{"missing user", "nobody@admin.com", "password", 0, true},
+		{"empty email", "", "password", 0, true},
+		{"disabled user", "disabled@admin.com", "password", 0, false},
+		{"user without password", "invited@admin.com", "", 0, false},
+	}
+	for _, e := range tests {
+		id, hash, err := repo.Authenticate(ctx, e.email, e.password)
+		if e.id != 0 {
+			if err != nil || id != e.id || hash == "" {
+				t.Errorf("%s: expected user %d to log in but got %d, %v", e.name, e.id, id, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: user %d logged in", e.name, id)
+			continue
+		}
+		if id != 0 || hash != "" {
+			t.Errorf("%s: a failed login returned user %d", e.name, id)
+		}
+		if errors.Is(err, sql.ErrNoRows) != e.noRows {
+			t.Errorf("%s: expected sql.ErrNoRows %v but got %v", e.name, e.noRows, err)
+		}
+	}
+}
+
+func testRepoLoginFailures(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	since := time.Now().Add(-time.Minute)
+
+	repo.InsertLoginFailure(ctx, "Admin@Admin.com", "10.0.0.1")
+	repo.InsertLoginFailure(ctx, "admin@admin.com", "10.0.0.2")
+	repo.InsertLoginFailure(ctx, "other@admin.com", "10.0.0.1")
+
+	byEmail, byIP, err := repo.CountLoginFailures(ctx, "ADMIN@admin.com", "10.0.0.1", since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byEmail != 2 || byIP != 2 {
+		t.Errorf("expected 2 failures by email and 2 by ip but got %d and %d", byEmail, byIP)
+	}
+	if byEmail, byIP, _ := repo.CountLoginFailures(ctx, "admin@admin.com", "10.0.0.1", time.Now().Add(time.Minute)); byEmail != 0 || byIP != 0 {
+		t.Errorf("counted the failures before since: %d and %d", byEmail, byIP)
+	}
+
+	repo.ClearLoginFailures(ctx, "admin@admin.com")
+	if byEmail, byIP, _ := repo.CountLoginFailures(ctx, "admin@admin.com", "10.0.0.1", since); byEmail != 0 || byIP != 1 {
+		t.Errorf("expected only the failure of the other email but got %d and %d", byEmail, byIP)
+	}
+}
+
+func testRepoAPITokens(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	userID, err := repo.InsertUser(ctx, models.User{FirstName: "Admin", Email: "admin@admin.com", AccessLevel: 4, Active: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := repo.InsertAPIToken(ctx, models.APIToken{UserID: userID, Name: "channel manager", TokenHash: "hash", Scope: models.ScopeAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.InsertAPIToken(ctx, models.APIToken{UserID: userID, TokenHash: "hash"}); err == nil {
+		t.Error("inserted two tokens with the same hash")
+	}
+
+	//un token mai usato non ha la data
+	tokens, err := repo.AllAPITokens(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || !tokens[0].LastUsedAt.IsZero() || tokens[0].User.Email != "admin@admin.com" {
+		t.Fatalf("expected the token of admin@admin.com never used but got %+v", tokens)
+	}
+
+	token, err := repo.GetAPITokenByHash(ctx, "hash")
+	if err != nil || token.ID != id || token.Scope != models.ScopeAdmin {
+		t.Errorf("expected token %d but got %+v, %v", id, token, err)
+	}
+	//con il token c'è l'utente, per controllarne il ruolo
+	if token.User.ID != userID || token.User.Role() != models.RoleOwner || token.User.Active != 1 {
+		t.Errorf("expected the owner with the token but got %+v", token.User)
+	}
+	if tokens, _ := repo.AllAPITokens(ctx); len(tokens) != 1 || tokens[0].LastUsedAt.IsZero() {
+		t.Errorf("the token used has no last use: %+v", tokens)
+	}
+	if _, err := repo.GetAPITokenByHash(ctx, "other"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing token but got %v", err)
+	}
+
+	repo.DeleteAPIToken(ctx, id)
+	if _, err := repo.GetAPITokenByHash(ctx, "hash"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a deleted token but got %v", err)
+	}
+
+	//disabilitando l'utente i token si cancellano, e non tornano se lo riabilito
+	repo.InsertAPIToken(ctx, models.APIToken{UserID: userID, Name: "Partner", TokenHash: "hash", Scope: models.ScopeAdmin})
+	if err := repo.UpdateActiveForUser(ctx, userID, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetAPITokenByHash(ctx, "hash"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for the token of a disabled user but got %v", err)
+	}
+	repo.UpdateActiveForUser(ctx, userID, 1)
+	if tokens, _ := repo.AllAPITokens(ctx); len(tokens) != 0 {
+		t.Errorf("expected the tokens of the disabled user deleted but got %+v", tokens)
+	}
+
+	//lo stesso se non è più owner, mentre se resta owner li tiene
+	repo.InsertAPIToken(ctx, models.APIToken{UserID: userID, Name: "Partner", TokenHash: "hash", Scope: models.ScopeAdmin})
+	user, err := repo.GetUserByID(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.FirstName = "Owner"
+	if err := repo.UpdateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if tokens, _ := repo.AllAPITokens(ctx); len(tokens) != 1 {
+		t.Errorf("expected the token of the owner kept but got %+v", tokens)
+	}
+	user.AccessLevel = 3
+	if err := repo.UpdateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if tokens, _ := repo.AllAPITokens(ctx); len(tokens) != 0 {
+		t.Errorf("expected the tokens of the demoted user deleted but got %+v", tokens)
+	}
+}
+
+func testRepoICalFeeds(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id, err := repo.InsertICalFeed(ctx, models.ICalFeed{RoomID: 1, Name: "Airbnb", URL: "https://example.com/cal.ics"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	feeds, err := repo.GetICalFeedsForRoom(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feeds) != 1 || feeds[0].ID != id || !feeds[0].LastSyncedAt.IsZero() {
+		t.Fatalf("expected feed %d never synced but got %+v", id, feeds)
+	}
+
+	feed := feeds[0]
+	periods := []models.Period{
+		{Start: date("2040-01-10"), End: date("2040-01-12")},
+		{Start: date("2040-02-10"), End: date("2040-02-12")},
+	}
+	if err := repo.ReplaceICalFeedRestrictions(ctx, feed, periods); err != nil {
+		t.Fatal(err)
+	}
+	//una nuova sincronizzazione prende il posto della vecchia
+	if err := repo.ReplaceICalFeedRestrictions(ctx, feed, periods[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if available, _ := repo.SearchAvailabilityByDatesByRoomID(ctx, date("2040-01-10"), date("2040-01-12"), 1); !available {
+		t.Error("the dates of the last sync are still taken")
+	}
+	restrictions, _ := repo.GetRestrictionForRoomByDate(ctx, 1, date("2040-02-01"), date("2040-02-28"))
+	if len(restrictions) != 1 || restrictions[0].Feed.ID != id {
+		t.Errorf("expected a restriction of feed %d but got %+v", id, restrictions)
+	}
+
+	syncedAt := time.Date(2040, 1, 1, 12, 0, 0, 0, time.UTC)
+	repo.UpdateICalFeedSync(ctx, id, syncedAt, "")
+	if feeds, _ := repo.AllICalFeeds(ctx); len(feeds) != 1 || !feeds[0].LastSyncedAt.Equal(syncedAt) {
+		t.Errorf("expected the feed synced at %s but got %+v", syncedAt, feeds)
+	}
+
+	repo.DeleteICalFeed(ctx, id)
+	if restrictions, _ := repo.GetRestrictionForRoomByDate(ctx, 1, date("2040-02-01"), date("2040-02-28")); len(restrictions) != 0 {
+		t.Errorf("the restrictions of a deleted feed are still there: %+v", restrictions)
+	}
+}
+
+func testRepoOutbox(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	first, _ := repo.QueueMail(ctx, models.MailData{To: "john@smith.com", From: "me@here.com", Subject: "first"})
+	second, _ := repo.QueueMail(ctx, models.MailData{To: "john@smith.com", From: "me@here.com", Subject: "second"})
+
+	mails, err := repo.ClaimOutboxMail(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mails) != 1 || mails[0].ID != first || mails[0].Status != models.MailSending {
+		t.Fatalf("expected to claim mail %d but got %+v", first, mails)
+	}
+
+	//una mail presa non si prende di nuovo finché non è bloccata da troppo
+	mails, _ = repo.ClaimOutboxMail(ctx, 10)
+	if len(mails) != 1 || mails[0].ID != second {
+		t.Fatalf("expected to claim only mail %d but got %+v", second, mails)
+	}
+
+	retry := mails[0]
+	retry.Status = models.MailQueued
+	retry.Attempts = 1
+	retry.LastError = "connection refused"
+	retry.NextAttemptAt = time.Now().Add(time.Hour)
+	repo.UpdateOutboxMail(ctx, retry)
+	if mails, _ := repo.ClaimOutboxMail(ctx, 10); len(mails) != 0 {
+		t.Errorf("claimed a mail before its next attempt: %+v", mails)
+	}
+
+	retry.NextAttemptAt = time.Now().Add(-time.Second)
+	repo.UpdateOutboxMail(ctx, retry)
+	mails, _ = repo.ClaimOutboxMail(ctx, 10)
+	if len(mails) != 1 || mails[0].Attempts != 1 || mails[0].LastError != "connection refused" {
+		t.Errorf("expected mail %d to be claimed again after an attempt but got %+v", second, mails)
+	}
+}
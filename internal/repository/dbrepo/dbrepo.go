@@ -1,7 +1,10 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
+	"strings"
+	"time"
 
 	"github.com/Laura470/bookings/internal/config"
 	"github.com/Laura470/bookings/internal/repository"
@@ -11,22 +14,59 @@ import (
 type postgresDBRepo struct {
 	App *config.AppConfig
 	DB  *sql.DB
+	dialect
 }
 
-type testDBRepo struct {
-	App *config.AppConfig
-	DB  *sql.DB //chiamo una funzione che in realtà non ha dietro un db
+//dialect has the bits of sql that change from a database to another, the rest of the queries is standard sql
+type dialect struct {
+	//forUpdate locks the rows selected until the end of the transaction
+	forUpdate string
+	//skipLocked is forUpdate that skips the rows locked by another transaction instead of waiting for them
+	skipLocked string
+}
+
+var postgresDialect = dialect{
+	forUpdate:  "for update",
+	skipLocked: "for update skip locked",
 }
 
+//sqlite has no row locks: a transaction locks the whole database when it starts, the driver begins them immediate
+var sqliteDialect = dialect{}
+
 func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
 	return &postgresDBRepo{
-		App: a,
-		DB:  conn,
+		App:     a,
+		DB:      conn,
+		dialect: postgresDialect,
+	}
+}
+
+//defaultDBTimeout is the per-query timeout used when the app config doesn't set one
+const defaultDBTimeout = 3 * time.Second
+
+//withTimeout derives the context for a single query from the request context,
+//so the query is aborted when the client goes away or the request is cancelled
+func (m *postgresDBRepo) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := defaultDBTimeout
+	if m.App != nil && m.App.DBTimeout > 0 {
+		timeout = m.App.DBTimeout
 	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+//joinPhotos and splitPhotos convert the photos of a room to and from the photos column,
+//where they are stored one per line
+func joinPhotos(photos []string) string {
+	return strings.Join(photos, "\n")
 }
 
-func NewTestingRepo(a *config.AppConfig) repository.DatabaseRepo {
-	return &testDBRepo{
-		App: a,
+func splitPhotos(photos string) []string {
+	var result []string
+	for _, p := range strings.Split(photos, "\n") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			result = append(result, p)
+		}
 	}
+	return result
 }
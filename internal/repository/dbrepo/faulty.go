@@ -0,0 +1,539 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/Laura470/bookings/internal/models"
+	"github.com/Laura470/bookings/internal/repository"
+)
+
+//FaultyRepo wraps a repository and makes its methods fail on demand, so the tests can check
+//what happens when the database doesn't work without faking it with magic values
+type FaultyRepo struct {
+	repository.DatabaseRepo
+
+	mu     sync.Mutex
+	faults map[string][]fault
+}
+
+type fault struct {
+	err   error
+	match func(args ...interface{}) bool
+}
+
+//NewFaultyRepo returns repo wrapped in a FaultyRepo that doesn't fail until it's told to
+func NewFaultyRepo(repo repository.DatabaseRepo) *FaultyRepo {
+	return &FaultyRepo{DatabaseRepo: repo, faults: map[string][]fault{}}
+}
+
+//Fail makes every call of method return err, without calling the repository
+func (fr *FaultyRepo) Fail(method string, err error) {
+	fr.FailWhen(method, err, nil)
+}
+
+//FailWhen makes the calls of method return err when match is true for their arguments, the context excluded.
+//It panics if the repository has no such method, so a typo doesn't make a test pass
+func (fr *FaultyRepo) FailWhen(method string, err error, match func(args ...interface{}) bool) {
+	if _, ok := reflect.TypeOf((*repository.DatabaseRepo)(nil)).Elem().MethodByName(method); !ok {
+		panic(fmt.Sprintf("dbrepo: the repository has no method %s", method))
+	}
+
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	fr.faults[method] = append(fr.faults[method], fault{err: err, match: match})
+}
+
+//Reset removes all the faults
+func (fr *FaultyRepo) Reset() {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	fr.faults = map[string][]fault{}
+}
+
+//check returns the error of the first fault of method that matches args, if any
+func (fr *FaultyRepo) check(method string, args ...interface{}) error {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	for _, x := range fr.faults[method] {
+		if x.match == nil || x.match(args...) {
+			return x.err
+		}
+	}
+	return nil
+}
+
+//i metodi qui sotto controllano i guasti e poi passano la chiamata al repository
+
+func (fr *FaultyRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	if err := fr.check("InsertReservation", res); err != nil {
+		return 0, err
+	}
+	return fr.DatabaseRepo.InsertReservation(ctx, res)
+}
+
+func (fr *FaultyRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error {
+	if err := fr.check("InsertRoomRestriction", r); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.InsertRoomRestriction(ctx, r)
+}
+
+func (fr *FaultyRepo) BookRoom(ctx context.Context, res models.Reservation) (int, error) {
+	if err := fr.check("BookRoom", res); err != nil {
+		return 0, err
+	}
+	return fr.DatabaseRepo.BookRoom(ctx, res)
+}
+
+func (fr *FaultyRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
+	if err := fr.check("SearchAvailabilityByDatesByRoomID", start, end, roomID); err != nil {
+		return false, err
+	}
+	return fr.DatabaseRepo.SearchAvailabilityByDatesByRoomID(ctx, start, end, roomID)
+}
+
+func (fr *FaultyRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	if err := fr.check("SearchAvailabilityForAllRooms", start, end); err != nil {
+		return nil, err
+	}
+	return fr.DatabaseRepo.SearchAvailabilityForAllRooms(ctx, start, end)
+}
+
+func (fr *FaultyRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	if err := fr.check("GetRoomByID", id); err != nil {
+		return models.Room{}, err
+	}
+	return fr.DatabaseRepo.GetRoomByID(ctx, id)
+}
+
+func (fr *FaultyRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	if err := fr.check("GetUserByID", id); err != nil {
+		return models.User{}, err
+	}
+	return fr.DatabaseRepo.GetUserByID(ctx, id)
+}
+
+func (fr *FaultyRepo) UpdateUser(ctx context.Context, u models.User) error {
+	if err := fr.check("UpdateUser", u); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.UpdateUser(ctx, u)
+}
+
+func (fr *FaultyRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	if err := fr.check("Authenticate", email, testPassword); err != nil {
+		return 0, "", err
+	}
+	return fr.DatabaseRepo.Authenticate(ctx, email, testPassword)
+}
+
+func (fr *FaultyRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	if err := fr.check("AllUsers"); err != nil {
+		return nil, err
+	}
+	return fr.DatabaseRepo.AllUsers(ctx)
+}
+
+func (fr *FaultyRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	if err := fr.check("GetUserByEmail", email); err != nil {
+		return models.User{}, err
+	}
+	return fr.DatabaseRepo.GetUserByEmail(ctx, email)
+}
+
+func (fr *FaultyRepo) InsertUser(ctx context.Context, u models.User) (int, error) {
+	if err := fr.check("InsertUser", u); err != nil {
+		return 0, err
+	}
+	return fr.DatabaseRepo.InsertUser(ctx, u)
+}
+
+func (fr *FaultyRepo) UpdateActiveForUser(ctx context.Context, id, active int) error {
+	if err := fr.check("UpdateActiveForUser", id, active); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.UpdateActiveForUser(ctx, id, active)
+}
+
+func (fr *FaultyRepo) UpdatePassword(ctx context.Context, id int, password string) error {
+	if err := fr.check("UpdatePassword", id, password); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.UpdatePassword(ctx, id, password)
+}
+
+func (fr *FaultyRepo) InsertUserToken(ctx context.Context, t models.UserToken) (int, error) {
+	if err := fr.check("InsertUserToken", t); err != nil {
+		return 0, err
+	}
+	return fr.DatabaseRepo.InsertUserToken(ctx, t)
+}
+
+func (fr *FaultyRepo) GetUserTokenByHash(ctx context.Context, hash string) (models.UserToken, error) {
+	if err := fr.check("GetUserTokenByHash", hash); err != nil {
+		return models.UserToken{}, err
+	}
+	return fr.DatabaseRepo.GetUserTokenByHash(ctx, hash)
+}
+
+func (fr *FaultyRepo) UseUserToken(ctx context.Context, id int) error {
+	if err := fr.check("UseUserToken", id); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.UseUserToken(ctx, id)
+}
+
+func (fr *FaultyRepo) InsertLoginFailure(ctx context.Context, email, ip string) error {
+	if err := fr.check("InsertLoginFailure", email, ip); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.InsertLoginFailure(ctx, email, ip)
+}
+
+func (fr *FaultyRepo) CountLoginFailures(ctx context.Context, email, ip string, since time.Time) (int, int, error) {
+	if err := fr.check("CountLoginFailures", email, ip, since); err != nil {
+		return 0, 0, err
+	}
+	return fr.DatabaseRepo.CountLoginFailures(ctx, email, ip, since)
+}
+
+func (fr *FaultyRepo) ClearLoginFailures(ctx context.Context, email string) error {
+	if err := fr.check("ClearLoginFailures", email); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.ClearLoginFailures(ctx, email)
+}
+
+func (fr *FaultyRepo) LockUser(ctx context.Context, id int, until time.Time) error {
+	if err := fr.check("LockUser", id, until); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.LockUser(ctx, id, until)
+}
+
+func (fr *FaultyRepo) UnlockUser(ctx context.Context, id int) error {
+	if err := fr.check("UnlockUser", id); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.UnlockUser(ctx, id)
+}
+
+func (fr *FaultyRepo) EnableTOTP(ctx context.Context, id int, secret string, counter int64, codeHashes []string) error {
+	if err := fr.check("EnableTOTP", id, secret, counter, codeHashes); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.EnableTOTP(ctx, id, secret, counter, codeHashes)
+}
+
+func (fr *FaultyRepo) DisableTOTP(ctx context.Context, id int) error {
+	if err := fr.check("DisableTOTP", id); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.DisableTOTP(ctx, id)
+}
+
+func (fr *FaultyRepo) UseTOTPCounter(ctx context.Context, id int, counter int64) error {
+	if err := fr.check("UseTOTPCounter", id, counter); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.UseTOTPCounter(ctx, id, counter)
+}
+
+func (fr *FaultyRepo) UseRecoveryCode(ctx context.Context, id int, codeHash string) error {
+	if err := fr.check("UseRecoveryCode", id, codeHash); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.UseRecoveryCode(ctx, id, codeHash)
+}
+
+func (fr *FaultyRepo) CountRecoveryCodes(ctx context.Context, id int) (int, error) {
+	if err := fr.check("CountRecoveryCodes", id); err != nil {
+		return 0, err
+	}
+	return fr.DatabaseRepo.CountRecoveryCodes(ctx, id)
+}
+
+func (fr *FaultyRepo) AllReservations(ctx context.Context) ([]models.Reservation, error) {
+	if err := fr.check("AllReservations"); err != nil {
+		return nil, err
+	}
+	return fr.DatabaseRepo.AllReservations(ctx)
+}
+
+func (fr *FaultyRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
+	if err := fr.check("AllNewReservations"); err != nil {
+		return nil, err
+	}
+	return fr.DatabaseRepo.AllNewReservations(ctx)
+}
+
+func (fr *FaultyRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	if err := fr.check("GetReservationByID", id); err != nil {
+		return models.Reservation{}, err
+	}
+	return fr.DatabaseRepo.GetReservationByID(ctx, id)
+}
+
+func (fr *FaultyRepo) UpdateReservation(ctx context.Context, u models.Reservation) error {
+	if err := fr.check("UpdateReservation", u); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.UpdateReservation(ctx, u)
+}
+
+func (fr *FaultyRepo) UpdateReservationDates(ctx context.Context, res models.Reservation) error {
+	if err := fr.check("UpdateReservationDates", res); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.UpdateReservationDates(ctx, res)
+}
+
+func (fr *FaultyRepo) DeleteReservation(ctx context.Context, id int) error {
+	if err := fr.check("DeleteReservation", id); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.DeleteReservation(ctx, id)
+}
+
+func (fr *FaultyRepo) UpdateProcessedForReservation(ctx context.Context, id, processed int) error {
+	if err := fr.check("UpdateProcessedForReservation", id, processed); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.UpdateProcessedForReservation(ctx, id, processed)
+}
+
+func (fr *FaultyRepo) ReservationsToRemind(ctx context.Context, from, until time.Time) ([]models.Reservation, error) {
+	if err := fr.check("ReservationsToRemind", from, until); err != nil {
+		return nil, err
+	}
+	return fr.DatabaseRepo.ReservationsToRemind(ctx, from, until)
+}
+
+func (fr *FaultyRepo) SetReservationReminded(ctx context.Context, id int, remindedAt time.Time) error {
+	if err := fr.check("SetReservationReminded", id, remindedAt); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.SetReservationReminded(ctx, id, remindedAt)
+}
+
+func (fr *FaultyRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	if err := fr.check("AllRooms"); err != nil {
+		return nil, err
+	}
+	return fr.DatabaseRepo.AllRooms(ctx)
+}
+
+func (fr *FaultyRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	if err := fr.check("GetRoomBySlug", slug); err != nil {
+		return models.Room{}, err
+	}
+	return fr.DatabaseRepo.GetRoomBySlug(ctx, slug)
+}
+
+func (fr *FaultyRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	if err := fr.check("InsertRoom", room); err != nil {
+		return 0, err
+	}
+	return fr.DatabaseRepo.InsertRoom(ctx, room)
+}
+
+func (fr *FaultyRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	if err := fr.check("UpdateRoom", room); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.UpdateRoom(ctx, room)
+}
+
+func (fr *FaultyRepo) UpdateActiveForRoom(ctx context.Context, id, active int) error {
+	if err := fr.check("UpdateActiveForRoom", id, active); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.UpdateActiveForRoom(ctx, id, active)
+}
+
+func (fr *FaultyRepo) DeleteRoom(ctx context.Context, id int) error {
+	if err := fr.check("DeleteRoom", id); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.DeleteRoom(ctx, id)
+}
+
+func (fr *FaultyRepo) GetRatesForRoom(ctx context.Context, roomID int) ([]models.RoomRate, error) {
+	if err := fr.check("GetRatesForRoom", roomID); err != nil {
+		return nil, err
+	}
+	return fr.DatabaseRepo.GetRatesForRoom(ctx, roomID)
+}
+
+func (fr *FaultyRepo) InsertRoomRate(ctx context.Context, r models.RoomRate) (int, error) {
+	if err := fr.check("InsertRoomRate", r); err != nil {
+		return 0, err
+	}
+	return fr.DatabaseRepo.InsertRoomRate(ctx, r)
+}
+
+func (fr *FaultyRepo) DeleteRoomRate(ctx context.Context, id int) error {
+	if err := fr.check("DeleteRoomRate", id); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.DeleteRoomRate(ctx, id)
+}
+
+func (fr *FaultyRepo) QuotePrice(ctx context.Context, roomID int, start, end time.Time) (models.Quote, error) {
+	if err := fr.check("QuotePrice", roomID, start, end); err != nil {
+		return models.Quote{}, err
+	}
+	return fr.DatabaseRepo.QuotePrice(ctx, roomID, start, end)
+}
+
+func (fr *FaultyRepo) GetRestrictionForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	if err := fr.check("GetRestrictionForRoomByDate", roomID, start, end); err != nil {
+		return nil, err
+	}
+	return fr.DatabaseRepo.GetRestrictionForRoomByDate(ctx, roomID, start, end)
+}
+
+func (fr *FaultyRepo) DeleteBlockByID(ctx context.Context, id int) error {
+	if err := fr.check("DeleteBlockByID", id); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.DeleteBlockByID(ctx, id)
+}
+
+func (fr *FaultyRepo) InsertRoomBlock(ctx context.Context, b models.RoomBlock) (int, error) {
+	if err := fr.check("InsertRoomBlock", b); err != nil {
+		return 0, err
+	}
+	return fr.DatabaseRepo.InsertRoomBlock(ctx, b)
+}
+
+func (fr *FaultyRepo) AllRoomBlocks(ctx context.Context) ([]models.RoomBlock, error) {
+	if err := fr.check("AllRoomBlocks"); err != nil {
+		return nil, err
+	}
+	return fr.DatabaseRepo.AllRoomBlocks(ctx)
+}
+
+func (fr *FaultyRepo) DeleteRoomBlock(ctx context.Context, id int) error {
+	if err := fr.check("DeleteRoomBlock", id); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.DeleteRoomBlock(ctx, id)
+}
+
+func (fr *FaultyRepo) InsertAPIToken(ctx context.Context, t models.APIToken) (int, error) {
+	if err := fr.check("InsertAPIToken", t); err != nil {
+		return 0, err
+	}
+	return fr.DatabaseRepo.InsertAPIToken(ctx, t)
+}
+
+func (fr *FaultyRepo) GetAPITokenByHash(ctx context.Context, hash string) (models.APIToken, error) {
+	if err := fr.check("GetAPITokenByHash", hash); err != nil {
+		return models.APIToken{}, err
+	}
+	return fr.DatabaseRepo.GetAPITokenByHash(ctx, hash)
+}
+
+func (fr *FaultyRepo) AllAPITokens(ctx context.Context) ([]models.APIToken, error) {
+	if err := fr.check("AllAPITokens"); err != nil {
+		return nil, err
+	}
+	return fr.DatabaseRepo.AllAPITokens(ctx)
+}
+
+func (fr *FaultyRepo) DeleteAPIToken(ctx context.Context, id int) error {
+	if err := fr.check("DeleteAPIToken", id); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.DeleteAPIToken(ctx, id)
+}
+
+func (fr *FaultyRepo) AllICalFeeds(ctx context.Context) ([]models.ICalFeed, error) {
+	if err := fr.check("AllICalFeeds"); err != nil {
+		return nil, err
+	}
+	return fr.DatabaseRepo.AllICalFeeds(ctx)
+}
+
+func (fr *FaultyRepo) GetICalFeedsForRoom(ctx context.Context, roomID int) ([]models.ICalFeed, error) {
+	if err := fr.check("GetICalFeedsForRoom", roomID); err != nil {
+		return nil, err
+	}
+	return fr.DatabaseRepo.GetICalFeedsForRoom(ctx, roomID)
+}
+
+func (fr *FaultyRepo) InsertICalFeed(ctx context.Context, f models.ICalFeed) (int, error) {
+	if err := fr.check("InsertICalFeed", f); err != nil {
+		return 0, err
+	}
+	return fr.DatabaseRepo.InsertICalFeed(ctx, f)
+}
+
+func (fr *FaultyRepo) DeleteICalFeed(ctx context.Context, id int) error {
+	if err := fr.check("DeleteICalFeed", id); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.DeleteICalFeed(ctx, id)
+}
+
+func (fr *FaultyRepo) ReplaceICalFeedRestrictions(ctx context.Context, feed models.ICalFeed, periods []models.Period) error {
+	if err := fr.check("ReplaceICalFeedRestrictions", feed, periods); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.ReplaceICalFeedRestrictions(ctx, feed, periods)
+}
+
+func (fr *FaultyRepo) UpdateICalFeedSync(ctx context.Context, id int, syncedAt time.Time, lastError string) error {
+	if err := fr.check("UpdateICalFeedSync", id, syncedAt, lastError); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.UpdateICalFeedSync(ctx, id, syncedAt, lastError)
+}
+
+func (fr *FaultyRepo) QueueMail(ctx context.Context, m models.MailData) (int, error) {
+	if err := fr.check("QueueMail", m); err != nil {
+		return 0, err
+	}
+	return fr.DatabaseRepo.QueueMail(ctx, m)
+}
+
+func (fr *FaultyRepo) ClaimOutboxMail(ctx context.Context, limit int) ([]models.OutboxMail, error) {
+	if err := fr.check("ClaimOutboxMail", limit); err != nil {
+		return nil, err
+	}
+	return fr.DatabaseRepo.ClaimOutboxMail(ctx, limit)
+}
+
+func (fr *FaultyRepo) UpdateOutboxMail(ctx context.Context, m models.OutboxMail) error {
+	if err := fr.check("UpdateOutboxMail", m); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.UpdateOutboxMail(ctx, m)
+}
+
+func (fr *FaultyRepo) AllOutboxMail(ctx context.Context) ([]models.OutboxMail, error) {
+	if err := fr.check("AllOutboxMail"); err != nil {
+		return nil, err
+	}
+	return fr.DatabaseRepo.AllOutboxMail(ctx)
+}
+
+func (fr *FaultyRepo) ResendOutboxMail(ctx context.Context, id int) error {
+	if err := fr.check("ResendOutboxMail", id); err != nil {
+		return err
+	}
+	return fr.DatabaseRepo.ResendOutboxMail(ctx, id)
+}
+
+func (fr *FaultyRepo) CountQueuedMail(ctx context.Context) (int, error) {
+	if err := fr.check("CountQueuedMail"); err != nil {
+		return 0, err
+	}
+	return fr.DatabaseRepo.CountQueuedMail(ctx)
+}
@@ -0,0 +1,62 @@
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Laura470/bookings/internal/config"
+	"github.com/Laura470/bookings/internal/repository"
+)
+
+//TestFaultyRepoEveryMethod checks that every method of the repository can be made to fail,
+//a method added to the interface but not to FaultyRepo would call the repository anyway
+func TestFaultyRepoEveryMethod(t *testing.T) {
+	errFault := errors.New("fault")
+	repo := NewFaultyRepo(NewMemoryRepo(&config.AppConfig{}))
+
+	iface := reflect.TypeOf((*repository.DatabaseRepo)(nil)).Elem()
+	for i := 0; i < iface.NumMethod(); i++ {
+		name := iface.Method(i).Name
+		repo.Fail(name, errFault)
+
+		method := reflect.ValueOf(repo).MethodByName(name)
+		args := []reflect.Value{reflect.ValueOf(context.Background())}
+		for j := 1; j < method.Type().NumIn(); j++ {
+			args = append(args, reflect.Zero(method.Type().In(j)))
+		}
+		out := method.Call(args)
+		if err, _ := out[len(out)-1].Interface().(error); err != errFault {
+			t.Errorf("%s: expected the fault but got %v", name, err)
+		}
+	}
+}
+
+func TestFaultyRepo(t *testing.T) {
+	ctx := context.Background()
+	errFault := errors.New("fault")
+	repo := NewFaultyRepo(NewMemoryRepo(&config.AppConfig{}))
+
+	repo.FailWhen("GetRoomByID", errFault, func(args ...interface{}) bool {
+		return args[0].(int) == 2
+	})
+	if _, err := repo.GetRoomByID(ctx, 1); err != nil {
+		t.Errorf("room 1 should not fail: %v", err)
+	}
+	if _, err := repo.GetRoomByID(ctx, 2); err != errFault {
+		t.Errorf("expected the fault for room 2 but got %v", err)
+	}
+
+	repo.Reset()
+	if _, err := repo.GetRoomByID(ctx, 2); err != nil {
+		t.Errorf("the fault is still there after Reset: %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("a method that doesn't exist has been accepted")
+		}
+	}()
+	repo.Fail("GetRoomById", errFault)
+}
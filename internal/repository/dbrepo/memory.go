@@ -0,0 +1,1573 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Laura470/bookings/internal/config"
+	"github.com/Laura470/bookings/internal/logger"
+	"github.com/Laura470/bookings/internal/models"
+	"github.com/Laura470/bookings/internal/pricing"
+	"github.com/Laura470/bookings/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//memoryDBRepo keeps the tables in memory and behaves like postgresDBRepo: same overlaps of the dates,
+//same unique and foreign keys, same deletes on cascade. Every method holds the lock, so it's a transaction
+type memoryDBRepo struct {
+	App *config.AppConfig
+
+	mu            sync.Mutex
+	lastIDs       map[string]int
+	users         []models.User
+	userTokens    []models.UserToken
+	loginFailures []loginFailure
+	recoveryCodes []recoveryCode
+	rooms         []models.Room
+	restrictions  []models.Restriction
+	reservations  []reservationRow
+	roomRestricts []models.RoomRestriction
+	roomRates     []models.RoomRate
+	roomBlocks    []models.RoomBlock
+	apiTokens     []models.APIToken
+	icalFeeds     []models.ICalFeed
+	outbox        []models.OutboxMail
+}
+
+//reservationRow is a reservation with the columns the model doesn't have
+type reservationRow struct {
+	models.Reservation
+	remindedAt time.Time
+}
+
+type loginFailure struct {
+	email     string
+	ip        string
+	createdAt time.Time
+}
+
+type recoveryCode struct {
+	userID   int
+	codeHash string
+	usedAt   time.Time
+}
+
+//NewMemoryRepo returns a repository that keeps everything in memory, with the rooms and the restrictions
+//of the seed migrations. It's for trying the site without a database and for the tests, the data is lost at every restart
+func NewMemoryRepo(a *config.AppConfig) repository.DatabaseRepo {
+	m := &memoryDBRepo{App: a, lastIDs: map[string]int{}}
+
+	seeded := time.Date(2023, 4, 9, 0, 0, 0, 0, time.UTC)
+	description := "Your home away form home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember."
+	for _, r := range []models.Room{
+		{RoomName: "General's Quarters", Slug: "generals-quarters", Photos: []string{"/static/images/generals-quarters.png"},
+			BaseRate: 12000, WeekendRate: 15000},
+		{RoomName: "Major's Suite", Slug: "majors-suite", Photos: []string{"/static/images/marjors-suite.png"},
+			BaseRate: 9000, WeekendRate: 11000},
+	} {
+		r.ID = m.nextID("rooms")
+		r.Description = description
+		r.Capacity = 2
+		r.Active = 1
+		r.MinStay = 1
+		r.CreatedAt, r.UpdatedAt = seeded, seeded
+		m.rooms = append(m.rooms, r)
+	}
+	for _, name := range []string{"Reservation", "Owner Block", "External Calendar"} {
+		m.restrictions = append(m.restrictions, models.Restriction{ID: m.nextID("restrictions"), RestrictionName: name,
+			CreatedAt: seeded, UpdatedAt: seeded})
+	}
+	return m
+}
+
+//nextID returns the next id of table, like a serial column
+func (m *memoryDBRepo) nextID(table string) int {
+	m.lastIDs[table]++
+	return m.lastIDs[table]
+}
+
+//day returns the date of t without the time, like it's kept in a date column
+func day(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	y, mo, d := t.Date()
+	return time.Date(y, mo, d, 0, 0, 0, 0, time.UTC)
+}
+
+//overlaps tells if the days from start to end, both included, overlap the ones from otherStart to otherEnd
+func overlaps(start, end, otherStart, otherEnd time.Time) bool {
+	return !day(start).After(day(otherEnd)) && !day(end).Before(day(otherStart))
+}
+
+//errUnique and errForeignKey are what the database would say of a duplicate key or a missing row
+func errUnique(constraint string) error {
+	return fmt.Errorf("duplicate key value violates unique constraint %q", constraint)
+}
+
+func errForeignKey(constraint string) error {
+	return fmt.Errorf("insert or update violates foreign key constraint %q", constraint)
+}
+
+func (m *memoryDBRepo) room(id int) (int, bool) {
+	for i, r := range m.rooms {
+		if r.ID == id {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (m *memoryDBRepo) user(id int) (int, bool) {
+	for i, u := range m.users {
+		if u.ID == id {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (m *memoryDBRepo) reservation(id int) (int, bool) {
+	for i, r := range m.reservations {
+		if r.ID == id {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (m *memoryDBRepo) hasRestriction(id int) bool {
+	for _, r := range m.restrictions {
+		if r.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memoryDBRepo) hasBlock(id int) bool {
+	for _, b := range m.roomBlocks {
+		if b.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memoryDBRepo) hasFeed(id int) bool {
+	for _, f := range m.icalFeeds {
+		if f.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+//copyRoom returns the room with its own slice of photos, so the caller can't change the one kept here
+func copyRoom(r models.Room) models.Room {
+	r.Photos = splitPhotos(joinPhotos(r.Photos))
+	return r
+}
+
+//withRoom returns the reservation with the id and the name of its room, like the left join of the queries
+func (m *memoryDBRepo) withRoom(r models.Reservation) models.Reservation {
+	r.Room = models.Room{}
+	if i, ok := m.room(r.RoomID); ok {
+		r.Room = models.Room{ID: m.rooms[i].ID, RoomName: m.rooms[i].RoomName}
+	}
+	return r
+}
+
+//insertRoomRestriction checks the foreign keys of r and adds it
+func (m *memoryDBRepo) insertRoomRestriction(r models.RoomRestriction) error {
+	if _, ok := m.room(r.RoomID); !ok {
+		return errForeignKey("room_restrictions_rooms_id_fk")
+	}
+	if !m.hasRestriction(r.RestrictionID) {
+		return errForeignKey("room_restrictions_restrictions_id_fk")
+	}
+	if r.ReservationID != 0 {
+		if _, ok := m.reservation(r.ReservationID); !ok {
+			return errForeignKey("room_restrictions_reservations_id_fk")
+		}
+	}
+	if r.BlockID != 0 && !m.hasBlock(r.BlockID) {
+		return errForeignKey("room_restrictions_room_blocks_id_fk")
+	}
+	if r.FeedID != 0 && !m.hasFeed(r.FeedID) {
+		return errForeignKey("room_restrictions_room_ical_feeds_id_fk")
+	}
+
+	now := time.Now()
+	m.roomRestricts = append(m.roomRestricts, models.RoomRestriction{
+		ID:            m.nextID("room_restrictions"),
+		StartDate:     day(r.StartDate),
+		EndDate:       day(r.EndDate),
+		RoomID:        r.RoomID,
+		ReservationID: r.ReservationID,
+		RestrictionID: r.RestrictionID,
+		BlockID:       r.BlockID,
+		FeedID:        r.FeedID,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	})
+	return nil
+}
+
+//deleteRoomRestrictions deletes the room restrictions for which del is true, it's the on delete cascade
+func (m *memoryDBRepo) deleteRoomRestrictions(del func(r models.RoomRestriction) bool) {
+	kept := m.roomRestricts[:0]
+	for _, r := range m.roomRestricts {
+		if !del(r) {
+			kept = append(kept, r)
+		}
+	}
+	m.roomRestricts = kept
+}
+
+//countOverlapping returns how many restrictions of the room overlap the dates and are selected by which
+func (m *memoryDBRepo) countOverlapping(roomID int, start, end time.Time, which func(r models.RoomRestriction) bool) int {
+	n := 0
+	for _, r := range m.roomRestricts {
+		if r.RoomID == roomID && overlaps(start, end, r.StartDate, r.EndDate) && (which == nil || which(r)) {
+			n++
+		}
+	}
+	return n
+}
+
+//insertReservation checks the room of res and adds it
+func (m *memoryDBRepo) insertReservation(res models.Reservation) (int, error) {
+	if _, ok := m.room(res.RoomID); !ok {
+		return 0, errForeignKey("reservations_rooms_id_fk")
+	}
+
+	now := time.Now()
+	row := reservationRow{Reservation: models.Reservation{
+		ID:         m.nextID("reservations"),
+		FirstName:  res.FirstName,
+		LastName:   res.LastName,
+		Email:      res.Email,
+		Phone:      res.Phone,
+		StartDate:  day(res.StartDate),
+		EndDate:    day(res.EndDate),
+		RoomID:     res.RoomID,
+		TotalPrice: res.TotalPrice,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}}
+	m.reservations = append(m.reservations, row)
+	return row.ID, nil
+}
+
+//InsertReservation inserts a reservation
+func (m *memoryDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.insertReservation(res)
+}
+
+//InsertRoomRestriction inserts a room restriction
+func (m *memoryDBRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.insertRoomRestriction(models.RoomRestriction{
+		StartDate:     r.StartDate,
+		EndDate:       r.EndDate,
+		RoomID:        r.RoomID,
+		ReservationID: r.ReservationID,
+		RestrictionID: r.RestrictionID,
+	})
+}
+
+//BookRoom inserts a reservation and its room restriction, or returns repository.ErrRoomNotAvailable
+//if the room is not active or the dates are taken
+func (m *memoryDBRepo) BookRoom(ctx context.Context, res models.Reservation) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.room(res.RoomID)
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	if m.rooms[i].Active != 1 {
+		return 0, repository.ErrRoomNotAvailable
+	}
+	if m.countOverlapping(res.RoomID, res.StartDate, res.EndDate, nil) > 0 {
+		return 0, repository.ErrRoomNotAvailable
+	}
+
+	id, err := m.insertReservation(res)
+	if err != nil {
+		return 0, err
+	}
+	err = m.insertRoomRestriction(models.RoomRestriction{
+		StartDate:     res.StartDate,
+		EndDate:       res.EndDate,
+		RoomID:        res.RoomID,
+		ReservationID: id,
+		RestrictionID: models.RestrictionReservation,
+	})
+	if err != nil {
+		m.reservations = m.reservations[:len(m.reservations)-1]
+		return 0, err
+	}
+	return id, nil
+}
+
+//SearchAvailabilityByDatesByRoomID returns true if the room has no restrictions on the dates
+func (m *memoryDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.countOverlapping(roomID, start, end, nil) == 0, nil
+}
+
+//SearchAvailabilityForAllRooms returns the active rooms with no restrictions on the dates
+func (m *memoryDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rooms []models.Room
+	for _, r := range m.rooms {
+		if r.Active == 1 && m.countOverlapping(r.ID, start, end, nil) == 0 {
+			rooms = append(rooms, models.Room{ID: r.ID, RoomName: r.RoomName, Slug: r.Slug})
+		}
+	}
+	return rooms, nil
+}
+
+//GetRoomByID returns the room with the id
+func (m *memoryDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.room(id)
+	if !ok {
+		return models.Room{}, sql.ErrNoRows
+	}
+	return copyRoom(m.rooms[i]), nil
+}
+
+//GetRoomBySlug returns the room with the slug
+func (m *memoryDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range m.rooms {
+		if r.Slug == slug {
+			return copyRoom(r), nil
+		}
+	}
+	return models.Room{}, sql.ErrNoRows
+}
+
+//slugTaken tells if another room than id has the slug
+func (m *memoryDBRepo) slugTaken(slug string, id int) bool {
+	for _, r := range m.rooms {
+		if r.Slug == slug && r.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+//InsertRoom inserts a new room
+func (m *memoryDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.slugTaken(room.Slug, 0) {
+		return 0, errUnique("rooms_slug_idx")
+	}
+
+	now := time.Now()
+	room = copyRoom(room)
+	room.ID = m.nextID("rooms")
+	room.CreatedAt, room.UpdatedAt = now, now
+	m.rooms = append(m.rooms, room)
+	return room.ID, nil
+}
+
+//UpdateRoom updates a room
+func (m *memoryDBRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.room(room.ID)
+	if !ok {
+		return nil
+	}
+	if m.slugTaken(room.Slug, room.ID) {
+		return errUnique("rooms_slug_idx")
+	}
+
+	room = copyRoom(room)
+	room.CreatedAt = m.rooms[i].CreatedAt
+	room.UpdatedAt = time.Now()
+	m.rooms[i] = room
+	return nil
+}
+
+//UpdateActiveForRoom activates (1) or deactivates (0) a room
+func (m *memoryDBRepo) UpdateActiveForRoom(ctx context.Context, id, active int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if i, ok := m.room(id); ok {
+		m.rooms[i].Active = active
+		m.rooms[i].UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+//DeleteRoom deletes a room with its rates, blocks, calendars and restrictions,
+//or returns repository.ErrRoomHasReservations if it has reservations
+func (m *memoryDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range m.reservations {
+		if r.RoomID == id {
+			return repository.ErrRoomHasReservations
+		}
+	}
+
+	i, ok := m.room(id)
+	if !ok {
+		return nil
+	}
+	m.rooms = append(m.rooms[:i], m.rooms[i+1:]...)
+
+	m.deleteRoomRestrictions(func(r models.RoomRestriction) bool {
+		return r.RoomID == id
+	})
+	rates := m.roomRates[:0]
+	for _, r := range m.roomRates {
+		if r.RoomID != id {
+			rates = append(rates, r)
+		}
+	}
+	m.roomRates = rates
+	blocks := m.roomBlocks[:0]
+	for _, b := range m.roomBlocks {
+		if b.RoomID != id {
+			blocks = append(blocks, b)
+		}
+	}
+	m.roomBlocks = blocks
+	feeds := m.icalFeeds[:0]
+	for _, f := range m.icalFeeds {
+		if f.RoomID != id {
+			feeds = append(feeds, f)
+		}
+	}
+	m.icalFeeds = feeds
+	return nil
+}
+
+//GetUserByID returns the user with the id
+func (m *memoryDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.user(id)
+	if !ok {
+		return models.User{}, sql.ErrNoRows
+	}
+	return m.users[i], nil
+}
+
+//GetUserByEmail returns the user with the email, whatever its case
+func (m *memoryDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if strings.EqualFold(u.Email, email) {
+			return u, nil
+		}
+	}
+	return models.User{}, sql.ErrNoRows
+}
+
+//emailTaken tells if another user than id has the email
+func (m *memoryDBRepo) emailTaken(email string, id int) bool {
+	for _, u := range m.users {
+		if u.Email == email && u.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+//UpdateUser updates the name, the email and the access level of a user
+func (m *memoryDBRepo) UpdateUser(ctx context.Context, u models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.user(u.ID)
+	if !ok {
+		return nil
+	}
+	if m.emailTaken(u.Email, u.ID) {
+		return errUnique("users_email_idx")
+	}
+
+	m.users[i].FirstName = u.FirstName
+	m.users[i].LastName = u.LastName
+	m.users[i].Email = u.Email
+	m.users[i].AccessLevel = u.AccessLevel
+	m.users[i].UpdatedAt = time.Now()
+	if !u.Role().AtLeast(models.RoleOwner) {
+		m.deleteAPITokensOf(u.ID)
+	}
+	return nil
+}
+
+//Authenticate checks email and password of an active user
+func (m *memoryDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	m.mu.Lock()
+	var u models.User
+	found := false
+	for _, x := range m.users {
+		if strings.EqualFold(x.Email, email) {
+			u, found = x, true
+			break
+		}
+	}
+	m.mu.Unlock()
+
+	if !found {
+		return 0, "", sql.ErrNoRows
+	}
+	//un utente disattivato o che non ha ancora scelto la password non entra
+	if u.Active != 1 || u.Password == "" {
+		return 0, "", errors.New("user disabled or without password")
+	}
+
+	//bcrypt è lento, lo faccio senza tenere il lock
+	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(testPassword))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, "", errors.New("incorrect password")
+	} else if err != nil {
+		return 0, "", err
+	}
+	return u.ID, u.Password, nil
+}
+
+//AllUsers returns all the users, the active ones first
+func (m *memoryDBRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users := append([]models.User(nil), m.users...)
+	sort.SliceStable(users, func(i, j int) bool {
+		a, b := users[i], users[j]
+		if a.Active != b.Active {
+			return a.Active > b.Active
+		}
+		if a.LastName != b.LastName {
+			return a.LastName < b.LastName
+		}
+		return a.FirstName < b.FirstName
+	})
+	return users, nil
+}
+
+//InsertUser creates a user without password
+func (m *memoryDBRepo) InsertUser(ctx context.Context, u models.User) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.emailTaken(u.Email, 0) {
+		return 0, errUnique("users_email_idx")
+	}
+
+	now := time.Now()
+	m.users = append(m.users, models.User{
+		ID:          m.nextID("users"),
+		FirstName:   u.FirstName,
+		LastName:    u.LastName,
+		Email:       u.Email,
+		AccessLevel: u.AccessLevel,
+		Active:      u.Active,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	})
+	return m.users[len(m.users)-1].ID, nil
+}
+
+//UpdateActiveForUser enables (1) or disables (0) a user, a disabled user loses the api tokens
+func (m *memoryDBRepo) UpdateActiveForUser(ctx context.Context, id, active int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if i, ok := m.user(id); ok {
+		m.users[i].Active = active
+		m.users[i].UpdatedAt = time.Now()
+		if active == 0 {
+			m.deleteAPITokensOf(id)
+		}
+	}
+	return nil
+}
+
+//deleteAPITokensOf deletes the api tokens of a user, with m.mu already locked
+func (m *memoryDBRepo) deleteAPITokensOf(userID int) {
+	kept := m.apiTokens[:0]
+	for _, t := range m.apiTokens {
+		if t.UserID != userID {
+			kept = append(kept, t)
+		}
+	}
+	m.apiTokens = kept
+}
+
+//UpdatePassword stores the bcrypt hash of the new password of a user
+func (m *memoryDBRepo) UpdatePassword(ctx context.Context, id int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if i, ok := m.user(id); ok {
+		m.users[i].Password = string(hashedPassword)
+		m.users[i].UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+//InsertUserToken stores a one-time token of a user
+func (m *memoryDBRepo) InsertUserToken(ctx context.Context, t models.UserToken) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.user(t.UserID); !ok {
+		return 0, errForeignKey("user_tokens_users_id_fk")
+	}
+	for _, x := range m.userTokens {
+		if x.TokenHash == t.TokenHash {
+			return 0, errUnique("user_tokens_token_hash_idx")
+		}
+	}
+
+	now := time.Now()
+	m.userTokens = append(m.userTokens, models.UserToken{
+		ID:        m.nextID("user_tokens"),
+		UserID:    t.UserID,
+		TokenHash: t.TokenHash,
+		Purpose:   t.Purpose,
+		ExpiresAt: t.ExpiresAt,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	return m.userTokens[len(m.userTokens)-1].ID, nil
+}
+
+//GetUserTokenByHash returns the token with the hash, used or expired too
+func (m *memoryDBRepo) GetUserTokenByHash(ctx context.Context, hash string) (models.UserToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.userTokens {
+		if t.TokenHash == hash {
+			return t, nil
+		}
+	}
+	return models.UserToken{}, sql.ErrNoRows
+}
+
+//UseUserToken marks a token as used, it returns sql.ErrNoRows if it was used already
+func (m *memoryDBRepo) UseUserToken(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, t := range m.userTokens {
+		if t.ID == id && t.UsedAt.IsZero() {
+			now := time.Now()
+			m.userTokens[i].UsedAt = now
+			m.userTokens[i].UpdatedAt = now
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+//InsertLoginFailure records a wrong password for email from the address ip
+func (m *memoryDBRepo) InsertLoginFailure(ctx context.Context, email, ip string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.loginFailures = append(m.loginFailures, loginFailure{email: strings.ToLower(email), ip: ip, createdAt: time.Now()})
+	return nil
+}
+
+//CountLoginFailures returns how many wrong passwords there have been since since for email and from ip
+func (m *memoryDBRepo) CountLoginFailures(ctx context.Context, email, ip string, since time.Time) (int, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var byEmail, byIP int
+	for _, f := range m.loginFailures {
+		if !f.createdAt.After(since) {
+			continue
+		}
+		if f.email == strings.ToLower(email) {
+			byEmail++
+		}
+		if f.ip == ip {
+			byIP++
+		}
+	}
+	return byEmail, byIP, nil
+}
+
+//clearLoginFailures forgets the wrong passwords for email
+func (m *memoryDBRepo) clearLoginFailures(email string) {
+	kept := m.loginFailures[:0]
+	for _, f := range m.loginFailures {
+		if f.email != strings.ToLower(email) {
+			kept = append(kept, f)
+		}
+	}
+	m.loginFailures = kept
+}
+
+//ClearLoginFailures forgets the wrong passwords for email, after a login that worked
+func (m *memoryDBRepo) ClearLoginFailures(ctx context.Context, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.clearLoginFailures(email)
+	return nil
+}
+
+//LockUser stops the user from logging in until until
+func (m *memoryDBRepo) LockUser(ctx context.Context, id int, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if i, ok := m.user(id); ok {
+		m.users[i].LockedUntil = until
+		m.users[i].UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+//UnlockUser lets a locked user log in again and forgets its wrong passwords
+func (m *memoryDBRepo) UnlockUser(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if i, ok := m.user(id); ok {
+		m.users[i].LockedUntil = time.Time{}
+		m.users[i].UpdatedAt = time.Now()
+		m.clearLoginFailures(m.users[i].Email)
+	}
+	return nil
+}
+
+//deleteRecoveryCodes deletes the recovery codes of the user
+func (m *memoryDBRepo) deleteRecoveryCodes(userID int) {
+	kept := m.recoveryCodes[:0]
+	for _, c := range m.recoveryCodes {
+		if c.userID != userID {
+			kept = append(kept, c)
+		}
+	}
+	m.recoveryCodes = kept
+}
+
+//EnableTOTP saves the secret of the authenticator app of the user, with the hashes of new recovery codes
+func (m *memoryDBRepo) EnableTOTP(ctx context.Context, id int, secret string, counter int64, codeHashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.user(id)
+	if !ok {
+		if len(codeHashes) > 0 {
+			return errForeignKey("user_recovery_codes_users_id_fk")
+		}
+		return nil
+	}
+	seen := map[string]bool{}
+	for _, h := range codeHashes {
+		if seen[h] {
+			return errUnique("user_recovery_codes_user_id_code_hash_idx")
+		}
+		seen[h] = true
+	}
+
+	m.users[i].TOTPSecret = secret
+	m.users[i].TOTPCounter = counter
+	m.users[i].UpdatedAt = time.Now()
+	//i codici vecchi non valgono più
+	m.deleteRecoveryCodes(id)
+	for _, h := range codeHashes {
+		m.recoveryCodes = append(m.recoveryCodes, recoveryCode{userID: id, codeHash: h})
+	}
+	return nil
+}
+
+//DisableTOTP removes the two-factor authentication of the user, with its recovery codes
+func (m *memoryDBRepo) DisableTOTP(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if i, ok := m.user(id); ok {
+		m.users[i].TOTPSecret = ""
+		m.users[i].TOTPCounter = 0
+		m.users[i].UpdatedAt = time.Now()
+	}
+	m.deleteRecoveryCodes(id)
+	return nil
+}
+
+//UseTOTPCounter records that the code counter has been used, it fails if that code or a later one was used already
+func (m *memoryDBRepo) UseTOTPCounter(ctx context.Context, id int, counter int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.user(id)
+	if !ok || m.users[i].TOTPCounter >= counter {
+		return sql.ErrNoRows
+	}
+	m.users[i].TOTPCounter = counter
+	return nil
+}
+
+//UseRecoveryCode marks the recovery code with the hash as used, it fails if the user has no such code not used yet
+func (m *memoryDBRepo) UseRecoveryCode(ctx context.Context, id int, codeHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, c := range m.recoveryCodes {
+		if c.userID == id && c.codeHash == codeHash && c.usedAt.IsZero() {
+			m.recoveryCodes[i].usedAt = time.Now()
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+//CountRecoveryCodes returns how many recovery codes the user can still use
+func (m *memoryDBRepo) CountRecoveryCodes(ctx context.Context, id int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, c := range m.recoveryCodes {
+		if c.userID == id && c.usedAt.IsZero() {
+			n++
+		}
+	}
+	return n, nil
+}
+
+//reservationsWhere returns the reservations selected by which, ordered by start date
+func (m *memoryDBRepo) reservationsWhere(which func(r reservationRow) bool) []models.Reservation {
+	var reservations []models.Reservation
+	for _, r := range m.reservations {
+		if which(r) {
+			reservations = append(reservations, m.withRoom(r.Reservation))
+		}
+	}
+	sort.SliceStable(reservations, func(i, j int) bool {
+		return reservations[i].StartDate.Before(reservations[j].StartDate)
+	})
+	return reservations
+}
+
+//AllReservations returns all the reservations
+func (m *memoryDBRepo) AllReservations(ctx context.Context) ([]models.Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.reservationsWhere(func(r reservationRow) bool {
+		return true
+	}), nil
+}
+
+//AllNewReservations returns the reservations not processed yet
+func (m *memoryDBRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.reservationsWhere(func(r reservationRow) bool {
+		return r.Processed == 0
+	}), nil
+}
+
+//GetReservationByID returns the reservation with the id
+func (m *memoryDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.reservation(id)
+	if !ok {
+		return models.Reservation{}, sql.ErrNoRows
+	}
+	return m.withRoom(m.reservations[i].Reservation), nil
+}
+
+//UpdateReservation updates the guest of a reservation
+func (m *memoryDBRepo) UpdateReservation(ctx context.Context, u models.Reservation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if i, ok := m.reservation(u.ID); ok {
+		r := &m.reservations[i]
+		r.FirstName = u.FirstName
+		r.LastName = u.LastName
+		r.Email = u.Email
+		r.Phone = u.Phone
+		r.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+//UpdateReservationDates moves a reservation and its room restriction to new dates,
+//or returns repository.ErrRoomNotAvailable if the room is taken by something else
+func (m *memoryDBRepo) UpdateReservationDates(ctx context.Context, res models.Reservation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.room(res.RoomID); !ok {
+		return sql.ErrNoRows
+	}
+	taken := m.countOverlapping(res.RoomID, res.StartDate, res.EndDate, func(r models.RoomRestriction) bool {
+		return r.ReservationID != res.ID
+	})
+	if taken > 0 {
+		return repository.ErrRoomNotAvailable
+	}
+
+	now := time.Now()
+	if i, ok := m.reservation(res.ID); ok {
+		r := &m.reservations[i]
+		r.StartDate = day(res.StartDate)
+		r.EndDate = day(res.EndDate)
+		r.TotalPrice = res.TotalPrice
+		r.UpdatedAt = now
+	}
+	for i, r := range m.roomRestricts {
+		if r.ReservationID == res.ID {
+			m.roomRestricts[i].StartDate = day(res.StartDate)
+			m.roomRestricts[i].EndDate = day(res.EndDate)
+			m.roomRestricts[i].UpdatedAt = now
+		}
+	}
+	return nil
+}
+
+//DeleteReservation deletes a reservation with its room restriction
+func (m *memoryDBRepo) DeleteReservation(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.reservation(id)
+	if !ok {
+		return nil
+	}
+	m.reservations = append(m.reservations[:i], m.reservations[i+1:]...)
+	m.deleteRoomRestrictions(func(r models.RoomRestriction) bool {
+		return r.ReservationID == id
+	})
+	return nil
+}
+
+//UpdateProcessedForReservation marks a reservation as processed (1) or not (0)
+func (m *memoryDBRepo) UpdateProcessedForReservation(ctx context.Context, id, processed int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if i, ok := m.reservation(id); ok {
+		m.reservations[i].Processed = processed
+	}
+	return nil
+}
+
+//ReservationsToRemind returns the reservations starting between from and until whose guest didn't get a reminder yet
+func (m *memoryDBRepo) ReservationsToRemind(ctx context.Context, from, until time.Time) ([]models.Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.reservationsWhere(func(r reservationRow) bool {
+		return !r.StartDate.Before(day(from)) && !r.StartDate.After(day(until)) && r.remindedAt.IsZero()
+	}), nil
+}
+
+//SetReservationReminded records that the guest got the reminder
+func (m *memoryDBRepo) SetReservationReminded(ctx context.Context, id int, remindedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if i, ok := m.reservation(id); ok {
+		m.reservations[i].remindedAt = remindedAt
+	}
+	return nil
+}
+
+//AllRooms returns all the rooms, ordered by name
+func (m *memoryDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rooms []models.Room
+	for _, r := range m.rooms {
+		rooms = append(rooms, copyRoom(r))
+	}
+	sort.SliceStable(rooms, func(i, j int) bool {
+		return rooms[i].RoomName < rooms[j].RoomName
+	})
+	return rooms, nil
+}
+
+//GetRestrictionForRoomByDate returns the restrictions of the room on the dates, with the reason of the blocks
+//and the name of the external calendars
+func (m *memoryDBRepo) GetRestrictionForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var restrictions []models.RoomRestriction
+	for _, r := range m.roomRestricts {
+		if r.RoomID != roomID || !overlaps(start, end, r.StartDate, r.EndDate) {
+			continue
+		}
+		out := models.RoomRestriction{
+			ID:            r.ID,
+			ReservationID: r.ReservationID,
+			RestrictionID: r.RestrictionID,
+			RoomID:        r.RoomID,
+			StartDate:     r.StartDate,
+			EndDate:       r.EndDate,
+			BlockID:       r.BlockID,
+			FeedID:        r.FeedID,
+		}
+		out.Block.ID = r.BlockID
+		for _, b := range m.roomBlocks {
+			if b.ID == r.BlockID {
+				out.Block.Reason = b.Reason
+			}
+		}
+		out.Feed.ID = r.FeedID
+		for _, f := range m.icalFeeds {
+			if f.ID == r.FeedID {
+				out.Feed.Name = f.Name
+			}
+		}
+		restrictions = append(restrictions, out)
+	}
+	return restrictions, nil
+}
+
+//DeleteBlockByID deletes the room restriction with the id
+func (m *memoryDBRepo) DeleteBlockByID(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deleteRoomRestrictions(func(r models.RoomRestriction) bool {
+		return r.ID == id
+	})
+	return nil
+}
+
+//GetRatesForRoom returns the seasonal rates of a room, ordered by start date
+func (m *memoryDBRepo) GetRatesForRoom(ctx context.Context, roomID int) ([]models.RoomRate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.ratesForRoom(roomID), nil
+}
+
+func (m *memoryDBRepo) ratesForRoom(roomID int) []models.RoomRate {
+	var rates []models.RoomRate
+	for _, r := range m.roomRates {
+		if r.RoomID == roomID {
+			rates = append(rates, r)
+		}
+	}
+	sort.SliceStable(rates, func(i, j int) bool {
+		return rates[i].StartDate.Before(rates[j].StartDate)
+	})
+	return rates
+}
+
+//InsertRoomRate inserts a seasonal rate for a room
+func (m *memoryDBRepo) InsertRoomRate(ctx context.Context, r models.RoomRate) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.room(r.RoomID); !ok {
+		return 0, errForeignKey("room_rates_rooms_id_fk")
+	}
+
+	now := time.Now()
+	r.ID = m.nextID("room_rates")
+	r.StartDate = day(r.StartDate)
+	r.EndDate = day(r.EndDate)
+	r.CreatedAt, r.UpdatedAt = now, now
+	m.roomRates = append(m.roomRates, r)
+	return r.ID, nil
+}
+
+//DeleteRoomRate deletes a seasonal rate
+func (m *memoryDBRepo) DeleteRoomRate(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.roomRates[:0]
+	for _, r := range m.roomRates {
+		if r.ID != id {
+			kept = append(kept, r)
+		}
+	}
+	m.roomRates = kept
+	return nil
+}
+
+//QuotePrice returns the price of a stay in a room, night by night, using the rates of the room
+func (m *memoryDBRepo) QuotePrice(ctx context.Context, roomID int, start, end time.Time) (models.Quote, error) {
+	room, err := m.GetRoomByID(ctx, roomID)
+	if err != nil {
+		return models.Quote{}, err
+	}
+
+	rates, err := m.GetRatesForRoom(ctx, roomID)
+	if err != nil {
+		return models.Quote{}, err
+	}
+
+	return pricing.Quote(room, rates, start, end)
+}
+
+//InsertRoomBlock inserts an owner block and a room restriction for each of its occurrences.
+//If an occurrence overlaps a reservation nothing is inserted and repository.ErrBlockOverlapsReservation is returned
+func (m *memoryDBRepo) InsertRoomBlock(ctx context.Context, b models.RoomBlock) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.room(b.RoomID); !ok {
+		return 0, sql.ErrNoRows
+	}
+
+	b.StartDate = day(b.StartDate)
+	b.EndDate = day(b.EndDate)
+	b.RepeatUntil = day(b.RepeatUntil)
+	//un blocco senza ripetizioni finisce con la sua ultima occorrenza
+	if b.Recurrence == models.RecurrenceNone {
+		b.RepeatUntil = b.EndDate
+	}
+
+	//controllo tutto prima di inserire, così un errore non lascia niente a metà
+	occurrences := b.Occurrences()
+	for _, p := range occurrences {
+		reserved := m.countOverlapping(b.RoomID, p.Start, p.End, func(r models.RoomRestriction) bool {
+			return r.ReservationID != 0
+		})
+		if reserved > 0 {
+			return 0, repository.ErrBlockOverlapsReservation
+		}
+	}
+
+	now := time.Now()
+	b.ID = m.nextID("room_blocks")
+	b.CreatedAt, b.UpdatedAt = now, now
+	b.Room = models.Room{}
+	m.roomBlocks = append(m.roomBlocks, b)
+
+	for _, p := range occurrences {
+		err := m.insertRoomRestriction(models.RoomRestriction{
+			StartDate:     p.Start,
+			EndDate:       p.End,
+			RoomID:        b.RoomID,
+			BlockID:       b.ID,
+			RestrictionID: models.RestrictionBlock,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return b.ID, nil
+}
+
+//AllRoomBlocks returns the owner blocks of all the rooms, ordered by start date
+func (m *memoryDBRepo) AllRoomBlocks(ctx context.Context) ([]models.RoomBlock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var blocks []models.RoomBlock
+	for _, b := range m.roomBlocks {
+		if i, ok := m.room(b.RoomID); ok {
+			b.Room = models.Room{ID: m.rooms[i].ID, RoomName: m.rooms[i].RoomName}
+		}
+		blocks = append(blocks, b)
+	}
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].StartDate.Before(blocks[j].StartDate)
+	})
+	return blocks, nil
+}
+
+//DeleteRoomBlock deletes an owner block with its room restrictions
+func (m *memoryDBRepo) DeleteRoomBlock(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.roomBlocks[:0]
+	for _, b := range m.roomBlocks {
+		if b.ID != id {
+			kept = append(kept, b)
+		}
+	}
+	m.roomBlocks = kept
+	m.deleteRoomRestrictions(func(r models.RoomRestriction) bool {
+		return r.BlockID == id
+	})
+	return nil
+}
+
+//InsertAPIToken stores a new api token
+func (m *memoryDBRepo) InsertAPIToken(ctx context.Context, t models.APIToken) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.user(t.UserID); !ok {
+		return 0, errForeignKey("api_tokens_users_id_fk")
+	}
+	for _, x := range m.apiTokens {
+		if x.TokenHash == t.TokenHash {
+			return 0, errUnique("api_tokens_token_hash_idx")
+		}
+	}
+
+	now := time.Now()
+	m.apiTokens = append(m.apiTokens, models.APIToken{
+		ID:        m.nextID("api_tokens"),
+		UserID:    t.UserID,
+		Name:      t.Name,
+		TokenHash: t.TokenHash,
+		Scope:     t.Scope,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	return m.apiTokens[len(m.apiTokens)-1].ID, nil
+}
+
+//GetAPITokenByHash returns the api token with the given hash, with the user it belongs to, and records that it
+//has been used. The tokens of a disabled user are not found
+func (m *memoryDBRepo) GetAPITokenByHash(ctx context.Context, hash string) (models.APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, t := range m.apiTokens {
+		if t.TokenHash != hash {
+			continue
+		}
+		j, ok := m.user(t.UserID)
+		if !ok || m.users[j].Active != 1 {
+			break
+		}
+		u := m.users[j]
+		m.apiTokens[i].LastUsedAt = time.Now()
+		t = m.apiTokens[i]
+		t.User = models.User{ID: u.ID, FirstName: u.FirstName, LastName: u.LastName, Email: u.Email,
+			AccessLevel: u.AccessLevel, Active: u.Active}
+		return t, nil
+	}
+	return models.APIToken{}, sql.ErrNoRows
+}
+
+//AllAPITokens returns all the api tokens, the newest first, with the user they belong to
+func (m *memoryDBRepo) AllAPITokens(ctx context.Context) ([]models.APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var apiTokens []models.APIToken
+	for i := len(m.apiTokens) - 1; i >= 0; i-- {
+		t := m.apiTokens[i]
+		//l'hash non esce mai dalla lista
+		t.TokenHash = ""
+		if j, ok := m.user(t.UserID); ok {
+			u := m.users[j]
+			t.User = models.User{ID: u.ID, FirstName: u.FirstName, LastName: u.LastName, Email: u.Email}
+		}
+		apiTokens = append(apiTokens, t)
+	}
+	sort.SliceStable(apiTokens, func(i, j int) bool {
+		return apiTokens[i].CreatedAt.After(apiTokens[j].CreatedAt)
+	})
+	return apiTokens, nil
+}
+
+//DeleteAPIToken revokes an api token
+func (m *memoryDBRepo) DeleteAPIToken(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.apiTokens[:0]
+	for _, t := range m.apiTokens {
+		if t.ID != id {
+			kept = append(kept, t)
+		}
+	}
+	m.apiTokens = kept
+	return nil
+}
+
+//AllICalFeeds returns the external calendars of all the rooms
+func (m *memoryDBRepo) AllICalFeeds(ctx context.Context) ([]models.ICalFeed, error) {
+	return m.icalFeedsWhere(func(f models.ICalFeed) bool {
+		return true
+	}), nil
+}
+
+//GetICalFeedsForRoom returns the external calendars of a room
+func (m *memoryDBRepo) GetICalFeedsForRoom(ctx context.Context, roomID int) ([]models.ICalFeed, error) {
+	return m.icalFeedsWhere(func(f models.ICalFeed) bool {
+		return f.RoomID == roomID
+	}), nil
+}
+
+//icalFeedsWhere returns the external calendars selected by which, with the name of their room
+func (m *memoryDBRepo) icalFeedsWhere(which func(f models.ICalFeed) bool) []models.ICalFeed {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var feeds []models.ICalFeed
+	for _, f := range m.icalFeeds {
+		if !which(f) {
+			continue
+		}
+		if i, ok := m.room(f.RoomID); ok {
+			f.Room = models.Room{ID: m.rooms[i].ID, RoomName: m.rooms[i].RoomName}
+		}
+		feeds = append(feeds, f)
+	}
+	sort.SliceStable(feeds, func(i, j int) bool {
+		if feeds[i].Room.RoomName != feeds[j].Room.RoomName {
+			return feeds[i].Room.RoomName < feeds[j].Room.RoomName
+		}
+		return feeds[i].Name < feeds[j].Name
+	})
+	return feeds
+}
+
+//InsertICalFeed adds an external calendar to a room
+func (m *memoryDBRepo) InsertICalFeed(ctx context.Context, f models.ICalFeed) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.room(f.RoomID); !ok {
+		return 0, errForeignKey("room_ical_feeds_rooms_id_fk")
+	}
+
+	now := time.Now()
+	m.icalFeeds = append(m.icalFeeds, models.ICalFeed{
+		ID:        m.nextID("room_ical_feeds"),
+		RoomID:    f.RoomID,
+		Name:      f.Name,
+		URL:       f.URL,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	return m.icalFeeds[len(m.icalFeeds)-1].ID, nil
+}
+
+//DeleteICalFeed deletes an external calendar with its restrictions
+func (m *memoryDBRepo) DeleteICalFeed(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.icalFeeds[:0]
+	for _, f := range m.icalFeeds {
+		if f.ID != id {
+			kept = append(kept, f)
+		}
+	}
+	m.icalFeeds = kept
+	m.deleteRoomRestrictions(func(r models.RoomRestriction) bool {
+		return r.FeedID == id
+	})
+	return nil
+}
+
+//ReplaceICalFeedRestrictions replaces the restrictions of an external calendar with the periods it has now
+func (m *memoryDBRepo) ReplaceICalFeedRestrictions(ctx context.Context, feed models.ICalFeed, periods []models.Period) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(periods) > 0 {
+		if !m.hasFeed(feed.ID) {
+			return errForeignKey("room_restrictions_room_ical_feeds_id_fk")
+		}
+		if _, ok := m.room(feed.RoomID); !ok {
+			return errForeignKey("room_restrictions_rooms_id_fk")
+		}
+	}
+
+	m.deleteRoomRestrictions(func(r models.RoomRestriction) bool {
+		return r.FeedID == feed.ID
+	})
+	for _, p := range periods {
+		err := m.insertRoomRestriction(models.RoomRestriction{
+			StartDate:     p.Start,
+			EndDate:       p.End,
+			RoomID:        feed.RoomID,
+			FeedID:        feed.ID,
+			RestrictionID: models.RestrictionExternal,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//UpdateICalFeedSync records when an external calendar was read and the error, if any
+func (m *memoryDBRepo) UpdateICalFeedSync(ctx context.Context, id int, syncedAt time.Time, lastError string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, f := range m.icalFeeds {
+		if f.ID == id {
+			m.icalFeeds[i].LastSyncedAt = syncedAt
+			m.icalFeeds[i].LastError = lastError
+			m.icalFeeds[i].UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+//QueueMail puts a mail in the outbox
+func (m *memoryDBRepo) QueueMail(ctx context.Context, mail models.MailData) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.outbox = append(m.outbox, models.OutboxMail{
+		ID:            m.nextID("mail_outbox"),
+		Mail:          mail,
+		Status:        models.MailQueued,
+		NextAttemptAt: now,
+		RequestID:     logger.RequestID(ctx),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	})
+	return m.outbox[len(m.outbox)-1].ID, nil
+}
+
+//ClaimOutboxMail takes up to limit mails due to be sent and marks them as sending
+func (m *memoryDBRepo) ClaimOutboxMail(ctx context.Context, limit int) ([]models.OutboxMail, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var due []int
+	for i, o := range m.outbox {
+		queued := o.Status == models.MailQueued && !o.NextAttemptAt.After(now)
+		stuck := o.Status == models.MailSending && o.UpdatedAt.Before(now.Add(-stuckMailAfter))
+		if queued || stuck {
+			due = append(due, i)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return m.outbox[due[i]].NextAttemptAt.Before(m.outbox[due[j]].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	var mails []models.OutboxMail
+	for _, i := range due {
+		m.outbox[i].Status = models.MailSending
+		m.outbox[i].UpdatedAt = now
+		mails = append(mails, m.outbox[i])
+	}
+	return mails, nil
+}
+
+//UpdateOutboxMail saves how sending a mail went
+func (m *memoryDBRepo) UpdateOutboxMail(ctx context.Context, o models.OutboxMail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, x := range m.outbox {
+		if x.ID == o.ID {
+			m.outbox[i].Status = o.Status
+			m.outbox[i].Attempts = o.Attempts
+			m.outbox[i].NextAttemptAt = o.NextAttemptAt
+			m.outbox[i].LastError = o.LastError
+			m.outbox[i].SentAt = o.SentAt
+			m.outbox[i].UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+//AllOutboxMail returns the mails not sent yet, the failed ones first
+func (m *memoryDBRepo) AllOutboxMail(ctx context.Context) ([]models.OutboxMail, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var mails []models.OutboxMail
+	for _, o := range m.outbox {
+		if o.Status == models.MailSent {
+			continue
+		}
+		o.RequestID = ""
+		o.SentAt = time.Time{}
+		mails = append(mails, o)
+	}
+	sort.SliceStable(mails, func(i, j int) bool {
+		fi, fj := mails[i].Status == models.MailFailed, mails[j].Status == models.MailFailed
+		if fi != fj {
+			return fi
+		}
+		return mails[i].NextAttemptAt.Before(mails[j].NextAttemptAt)
+	})
+	return mails, nil
+}
+
+//ResendOutboxMail puts a mail not sent back in the queue, with all its attempts again
+func (m *memoryDBRepo) ResendOutboxMail(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, o := range m.outbox {
+		if o.ID == id && o.Status != models.MailSent {
+			now := time.Now()
+			m.outbox[i].Status = models.MailQueued
+			m.outbox[i].Attempts = 0
+			m.outbox[i].NextAttemptAt = now
+			m.outbox[i].UpdatedAt = now
+		}
+	}
+	return nil
+}
+
+//CountQueuedMail returns how many mails are waiting to be sent, the ones being sent included
+func (m *memoryDBRepo) CountQueuedMail(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, o := range m.outbox {
+		if o.Status == models.MailQueued || o.Status == models.MailSending {
+			n++
+		}
+	}
+	return n, nil
+}
@@ -2,27 +2,26 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
 	"errors"
-	"log"
 	"time"
 
+	"github.com/Laura470/bookings/internal/logger"
 	"github.com/Laura470/bookings/internal/models"
+	"github.com/Laura470/bookings/internal/pricing"
+	"github.com/Laura470/bookings/internal/repository"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func (m *postgresDBRepo) AllUsers() bool {
-	return true
-}
-
 //InsertReservation inserts a reservation into the database
-func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
+func (m *postgresDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
 
 	var newID int
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	stmt := `insert into reservations (first_name, last_name, email, phone, start_date, end_date, room_id, created_at, updated_at) 
-			values($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+	stmt := `insert into reservations (first_name, last_name, email, phone, start_date, end_date, room_id, total_price, created_at, updated_at) 
+			values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id`
 
 	err := m.DB.QueryRowContext(ctx, stmt,
 		res.FirstName,
@@ -32,6 +31,7 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		res.StartDate,
 		res.EndDate,
 		res.RoomID,
+		res.TotalPrice,
 		time.Now(),
 		time.Now(),
 	).Scan(&newID)
@@ -43,8 +43,8 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 }
 
 //§InsertRoomREstriction insert a room restriction into the ddataabase
-func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id) 
@@ -66,9 +66,96 @@ func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	return nil
 }
 
+//BookRoom inserts a reservation and its room restriction in a single transaction.
+//The room row is locked with select ... for update, so two guests booking the same room
+//at the same time are serialized and the second one gets repository.ErrRoomNotAvailable
+func (m *postgresDBRepo) BookRoom(ctx context.Context, res models.Reservation) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	//se faccio il commit il rollback non fa niente
+	defer tx.Rollback()
+
+	var active int
+	err = tx.QueryRowContext(ctx, "select active from rooms where id = $1 "+m.forUpdate, res.RoomID).Scan(&active)
+	if err != nil {
+		return 0, err
+	}
+	//una stanza disattivata non si può più prenotare
+	if active != 1 {
+		return 0, repository.ErrRoomNotAvailable
+	}
+
+	//ricontrollo la disponibilità ora che ho il lock sulla stanza
+	var numRows int
+	query := `
+	select
+		count(id)
+	from
+		room_restrictions
+	where
+		room_id = $1 and
+		$2 <= end_date and $3 >= start_date;`
+
+	err = tx.QueryRowContext(ctx, query, res.RoomID, res.StartDate, res.EndDate).Scan(&numRows)
+	if err != nil {
+		return 0, err
+	}
+	if numRows > 0 {
+		return 0, repository.ErrRoomNotAvailable
+	}
+
+	var newID int
+	stmt := `insert into reservations (first_name, last_name, email, phone, start_date, end_date, room_id, total_price,
+			created_at, updated_at)
+			values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		res.TotalPrice,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `insert into room_restrictions (start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id)
+	values($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		newID,
+		time.Now(),
+		time.Now(),
+		1,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
 //SearchAvailabilityByDatesByRoomID ritorna true se c'è disponibilità per un a particolare stanza, false se no c'è
-func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	var numRows int
@@ -98,17 +185,17 @@ func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time,
 }
 
 //SearchAvailabilityForAllRooms return a slice of available rooms, if any, for given range date
-func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	var rooms []models.Room
 	query := `
 	select 
-	r.id, r.room_name
+	r.id, r.room_name, r.slug
 	from
 		rooms r
-	where r.id not in 
+	where r.active = 1 and r.id not in 
 		(select room_id from room_restrictions rr where $1 <= rr.end_date and $2 >= rr.start_date);`
 
 	rows, err := m.DB.QueryContext(ctx, query, start, end)
@@ -121,6 +208,7 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 		err := rows.Scan(
 			&room.ID,
 			&room.RoomName,
+			&room.Slug,
 		)
 		if err != nil {
 			return rooms, err
@@ -135,15 +223,16 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 }
 
 //già cae ci sono ritorno tutto, no solo in nome della stanza
-func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	var room models.Room
+	var photos string
 
 	query := `
 	select
-		*
+		id, room_name, description, capacity, slug, photos, active, base_rate, weekend_rate, min_stay, created_at, updated_at
 	from
 		rooms
 	where
@@ -153,27 +242,212 @@ func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
 	err := row.Scan(
 		&room.ID,
 		&room.RoomName,
+		&room.Description,
+		&room.Capacity,
+		&room.Slug,
+		&photos,
+		&room.Active,
+		&room.BaseRate,
+		&room.WeekendRate,
+		&room.MinStay,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	)
+	if err != nil {
+		return room, err
+	}
+	room.Photos = splitPhotos(photos)
+	return room, nil
+}
+
+//GetRoomBySlug returns the room with the given slug, the one we use in the public url of the room
+func (m *postgresDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var room models.Room
+	var photos string
+
+	query := `
+	select
+		id, room_name, description, capacity, slug, photos, active, base_rate, weekend_rate, min_stay, created_at, updated_at
+	from
+		rooms
+	where
+		slug = $1;`
+
+	row := m.DB.QueryRowContext(ctx, query, slug)
+	err := row.Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.Description,
+		&room.Capacity,
+		&room.Slug,
+		&photos,
+		&room.Active,
+		&room.BaseRate,
+		&room.WeekendRate,
+		&room.MinStay,
 		&room.CreatedAt,
 		&room.UpdatedAt,
 	)
 	if err != nil {
 		return room, err
 	}
+	room.Photos = splitPhotos(photos)
 	return room, nil
 }
 
-func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
+//InsertRoom inserts a new room into the database
+func (m *postgresDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into rooms (room_name, description, capacity, slug, photos, active, base_rate, weekend_rate, min_stay,
+			created_at, updated_at)
+			values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		room.RoomName,
+		room.Description,
+		room.Capacity,
+		room.Slug,
+		joinPhotos(room.Photos),
+		room.Active,
+		room.BaseRate,
+		room.WeekendRate,
+		room.MinStay,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+//UpdateRoom updates a room in the database
+func (m *postgresDBRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	update
+		rooms set room_name = $1, description = $2, capacity = $3, slug = $4, photos = $5, active = $6,
+		base_rate = $7, weekend_rate = $8, min_stay = $9, updated_at = $10
+		where id = $11
+	`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		room.RoomName,
+		room.Description,
+		room.Capacity,
+		room.Slug,
+		joinPhotos(room.Photos),
+		room.Active,
+		room.BaseRate,
+		room.WeekendRate,
+		room.MinStay,
+		time.Now(),
+		room.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//UpdateActiveForRoom activates (1) or deactivates (0) a room, a deactivated room can't be booked
+func (m *postgresDBRepo) UpdateActiveForRoom(ctx context.Context, id, active int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := "update rooms set active = $1, updated_at = $2 where id = $3"
+
+	_, err := m.DB.ExecContext(ctx, query, active, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//DeleteRoom deletes a room with its blocks. Since reservations would be deleted too (on delete cascade),
+//a room with reservations can't be deleted and repository.ErrRoomHasReservations is returned
+func (m *postgresDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var numRows int
+	err = tx.QueryRowContext(ctx, "select count(id) from reservations where room_id = $1", id).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return repository.ErrRoomHasReservations
+	}
+
+	_, err = tx.ExecContext(ctx, "delete from rooms where id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m *postgresDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	query := `
 	select
-		id, first_name, last_name, email, password, access_level, created_at, updated_at
+		id, first_name, last_name, email, password, access_level, active, locked_until, totp_secret, totp_counter, created_at, updated_at
 	from users where id = $1`
 
 	row := m.DB.QueryRowContext(ctx, query, id)
 	var u models.User
+	var lockedUntil sql.NullTime
+	err := row.Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.Password,
+		&u.AccessLevel,
+		&u.Active,
+		&lockedUntil,
+		&u.TOTPSecret,
+		&u.TOTPCounter,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+	if err != nil {
+		return u, err
+	}
+	u.LockedUntil = lockedUntil.Time
+	return u, nil
+}
+
+//GetUserByEmail returns the user with the email, the emails are unique
+func (m *postgresDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	select
+		id, first_name, last_name, email, password, access_level, active, locked_until, totp_secret, totp_counter, created_at, updated_at
+	from users where lower(email) = lower($1)`
+
+	row := m.DB.QueryRowContext(ctx, query, email)
+	var u models.User
+	var lockedUntil sql.NullTime
 	err := row.Scan(
 		&u.ID,
 		&u.FirstName,
@@ -181,29 +455,39 @@ func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
 		&u.Email,
 		&u.Password,
 		&u.AccessLevel,
+		&u.Active,
+		&lockedUntil,
+		&u.TOTPSecret,
+		&u.TOTPCounter,
 		&u.CreatedAt,
 		&u.UpdatedAt,
 	)
 	if err != nil {
 		return u, err
 	}
+	u.LockedUntil = lockedUntil.Time
 	return u, nil
 }
 
-//UpdateUser updates a user in the database
-//non riesco a capire la struttura della query !!!!!!
-func (m *postgresDBRepo) UpdateUser(u models.User) error {
+//UpdateUser updates a user in the database, a user who is no longer an owner loses the api tokens
+func (m *postgresDBRepo) UpdateUser(ctx context.Context, u models.User) error {
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
 	update
-		user set first_name=$1, last_name = $2, email = $3, access_level = $4, updated_at = $5
+		users set first_name = $1, last_name = $2, email = $3, access_level = $4, updated_at = $5
 		where id = $6
 	`
 
-	_, err := m.DB.ExecContext(ctx, query,
+	_, err = tx.ExecContext(ctx, query,
 		u.FirstName,
 		u.LastName,
 		u.Email,
@@ -211,26 +495,41 @@ func (m *postgresDBRepo) UpdateUser(u models.User) error {
 		time.Now(),
 		u.ID,
 	)
-
 	if err != nil {
 		return err
 	}
-	return nil
+
+	//i token li crea solo un owner, chi non lo è più li perde
+	if !u.Role().AtLeast(models.RoleOwner) {
+		_, err = tx.ExecContext(ctx, "delete from api_tokens where user_id = $1", u.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
-func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+//Authenticate checks email and password of an active user
+func (m *postgresDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	var id int
 	var hashedPassword string
-	row := m.DB.QueryRowContext(ctx, "select id, password from users where email = $1", email)
+	var active int
+	row := m.DB.QueryRowContext(ctx, "select id, password, active from users where lower(email) = lower($1)", email)
 	//faccio lo scan per prelevare i due valori
-	err := row.Scan(&id, &hashedPassword)
+	err := row.Scan(&id, &hashedPassword, &active)
 	if err != nil {
 		return id, "", err
 	}
 
+	//un utente disattivato o che non ha ancora scelto la password non entra
+	if active != 1 || hashedPassword == "" {
+		return 0, "", errors.New("user disabled or without password")
+	}
+
 	//faccio l'encrypt della password fornita per vedere se match l'hashed nel mio db
 	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
 	if err == bcrypt.ErrMismatchedHashAndPassword {
@@ -242,305 +541,1512 @@ func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string,
 	return id, hashedPassword, nil
 }
 
-//AllReservations returns a slice of all reservations
-func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+//AllUsers returns all the staff users, the active ones first
+func (m *postgresDBRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
-	var reservations []models.Reservation
+
+	var users []models.User
 
 	query := `
-	select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at,
-	r.processed, rm.id, rm.room_name
-	from reservations r
-	left join rooms rm on (r.room_id = rm.id)
-	order by r.start_date asc
-	`
+	select
+		id, first_name, last_name, email, password, access_level, active, locked_until, totp_secret, totp_counter, created_at, updated_at
+	from users
+	order by active desc, last_name, first_name`
+
 	rows, err := m.DB.QueryContext(ctx, query)
 	if err != nil {
-		return reservations, err
+		return users, err
 	}
-
 	defer rows.Close()
-	//faccio lo scan delle rows
+
 	for rows.Next() {
-		var i models.Reservation
+		var u models.User
+		var lockedUntil sql.NullTime
 		err := rows.Scan(
-			&i.ID,
-			&i.FirstName,
-			&i.LastName,
-			&i.Email,
-			&i.Phone,
-			&i.StartDate,
-			&i.EndDate,
-			&i.RoomID,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-			&i.Processed,
-			&i.Room.ID,
-			&i.Room.RoomName,
+			&u.ID,
+			&u.FirstName,
+			&u.LastName,
+			&u.Email,
+			&u.Password,
+			&u.AccessLevel,
+			&u.Active,
+			&lockedUntil,
+			&u.TOTPSecret,
+			&u.TOTPCounter,
+			&u.CreatedAt,
+			&u.UpdatedAt,
 		)
 		if err != nil {
-			return reservations, err
+			return users, err
 		}
-		reservations = append(reservations, i)
+		u.LockedUntil = lockedUntil.Time
+		users = append(users, u)
 	}
 
-	//dopo un for sempre meglio controllare per errori
 	if err = rows.Err(); err != nil {
-		return reservations, err
+		return users, err
 	}
-	return reservations, nil
+	return users, nil
 }
 
-//AllNewReservations returns a slice of all reservations
-func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+//InsertUser creates a user without password, the password is set with UpdatePassword
+func (m *postgresDBRepo) InsertUser(ctx context.Context, u models.User) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
-	var reservations []models.Reservation
 
-	query := `
-	select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at,
-	rm.id, rm.room_name
-	from reservations r
-	left join rooms rm on (r.room_id = rm.id)
-	where r.processed = 0
-	order by r.start_date asc
-	`
-	rows, err := m.DB.QueryContext(ctx, query)
+	var newID int
+	stmt := `insert into users (first_name, last_name, email, password, access_level, active, created_at, updated_at)
+			values($1, $2, $3, '', $4, $5, $6, $6) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.AccessLevel,
+		u.Active,
+		time.Now(),
+	).Scan(&newID)
 	if err != nil {
-		return reservations, err
+		return 0, err
 	}
+	return newID, nil
+}
 
-	defer rows.Close()
-	//faccio lo scan delle rows
-	for rows.Next() {
-		var i models.Reservation
-		err := rows.Scan(
-			&i.ID,
-			&i.FirstName,
-			&i.LastName,
-			&i.Email,
-			&i.Phone,
-			&i.StartDate,
-			&i.EndDate,
-			&i.RoomID,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-			&i.Room.ID,
-			&i.Room.RoomName,
-		)
-		if err != nil {
-			return reservations, err
-		}
-		reservations = append(reservations, i)
-	}
+//UpdateActiveForUser enables (1) or disables (0) a user, a disabled user can't log in and loses the api tokens
+func (m *postgresDBRepo) UpdateActiveForUser(ctx context.Context, id, active int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "update users set active = $1, updated_at = $2 where id = $3"
+
+	_, err = tx.ExecContext(ctx, query, active, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	if active == 0 {
+		_, err = tx.ExecContext(ctx, "delete from api_tokens where user_id = $1", id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//UpdatePassword stores the bcrypt hash of the new password of a user
+func (m *postgresDBRepo) UpdatePassword(ctx context.Context, id int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := "update users set password = $1, updated_at = $2 where id = $3"
+
+	_, err = m.DB.ExecContext(ctx, query, string(hashedPassword), time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//InsertUserToken stores a one-time token of a user, only its hash
+func (m *postgresDBRepo) InsertUserToken(ctx context.Context, t models.UserToken) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into user_tokens (user_id, token_hash, purpose, expires_at, created_at, updated_at)
+			values($1, $2, $3, $4, $5, $5) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		t.UserID,
+		t.TokenHash,
+		t.Purpose,
+		t.ExpiresAt,
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+//GetUserTokenByHash returns the token with the hash, used or expired too
+func (m *postgresDBRepo) GetUserTokenByHash(ctx context.Context, hash string) (models.UserToken, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	select
+		id, user_id, token_hash, purpose, expires_at, used_at, created_at, updated_at
+	from user_tokens where token_hash = $1`
+
+	var t models.UserToken
+	var usedAt sql.NullTime
+	err := m.DB.QueryRowContext(ctx, query, hash).Scan(
+		&t.ID,
+		&t.UserID,
+		&t.TokenHash,
+		&t.Purpose,
+		&t.ExpiresAt,
+		&usedAt,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+	)
+	if err != nil {
+		return t, err
+	}
+	t.UsedAt = usedAt.Time
+	return t, nil
+}
+
+//UseUserToken marks a token as used, it returns sql.ErrNoRows if it was used already
+func (m *postgresDBRepo) UseUserToken(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now()
+	query := "update user_tokens set used_at = $1, updated_at = $1 where id = $2 and used_at is null"
+
+	result, err := m.DB.ExecContext(ctx, query, now, id)
+	if err != nil {
+		return err
+	}
+
+	//se nessuna riga è cambiata qualcun altro ha usato il token prima
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+//InsertLoginFailure records a wrong password for email from the address ip
+func (m *postgresDBRepo) InsertLoginFailure(ctx context.Context, email, ip string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `insert into login_failures (email, ip_address, created_at, updated_at) values (lower($1), $2, $3, $3)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, email, ip, time.Now())
+	return err
+}
+
+//CountLoginFailures returns how many wrong passwords there have been since since for email and from ip
+func (m *postgresDBRepo) CountLoginFailures(ctx context.Context, email, ip string, since time.Time) (int, int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var byEmail, byIP int
+	query := `
+	select
+		count(*) filter (where email = lower($1)),
+		count(*) filter (where ip_address = $2)
+	from login_failures
+	where created_at > $3 and (email = lower($1) or ip_address = $2)`
+
+	err := m.DB.QueryRowContext(ctx, query, email, ip, since).Scan(&byEmail, &byIP)
+	return byEmail, byIP, err
+}
+
+//ClearLoginFailures forgets the wrong passwords for email, after a login that worked
+func (m *postgresDBRepo) ClearLoginFailures(ctx context.Context, email string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from login_failures where email = lower($1)`, email)
+	return err
+}
+
+//LockUser stops the user from logging in until until
+func (m *postgresDBRepo) LockUser(ctx context.Context, id int, until time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update users set locked_until = $1, updated_at = $2 where id = $3`, until, time.Now(), id)
+	return err
+}
+
+//UnlockUser lets a locked user log in again and forgets its wrong passwords
+func (m *postgresDBRepo) UnlockUser(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `update users set locked_until = null, updated_at = $1 where id = $2`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from login_failures where email = (select lower(email) from users where id = $1)`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//EnableTOTP saves the secret of the authenticator app of the user, with the hashes of new recovery codes
+func (m *postgresDBRepo) EnableTOTP(ctx context.Context, id int, secret string, counter int64, codeHashes []string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `update users set totp_secret = $1, totp_counter = $2, updated_at = $3 where id = $4`,
+		secret, counter, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	//i codici vecchi non valgono più
+	_, err = tx.ExecContext(ctx, `delete from user_recovery_codes where user_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range codeHashes {
+		_, err = tx.ExecContext(ctx, `insert into user_recovery_codes (user_id, code_hash, created_at, updated_at) values ($1, $2, $3, $3)`,
+			id, h, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//DisableTOTP removes the two-factor authentication of the user, with its recovery codes
+func (m *postgresDBRepo) DisableTOTP(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `update users set totp_secret = '', totp_counter = 0, updated_at = $1 where id = $2`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from user_recovery_codes where user_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//UseTOTPCounter records that the code counter has been used, it fails if that code or a later one was used already
+func (m *postgresDBRepo) UseTOTPCounter(ctx context.Context, id int, counter int64) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `update users set totp_counter = $1 where id = $2 and totp_counter < $1`, counter, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+//UseRecoveryCode marks the recovery code with the hash as used, it fails if the user has no such code not used yet
+func (m *postgresDBRepo) UseRecoveryCode(ctx context.Context, id int, codeHash string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `update user_recovery_codes set used_at = $1, updated_at = $1
+		where user_id = $2 and code_hash = $3 and used_at is null`, time.Now(), id, codeHash)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+//CountRecoveryCodes returns how many recovery codes the user can still use
+func (m *postgresDBRepo) CountRecoveryCodes(ctx context.Context, id int) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var n int
+	err := m.DB.QueryRowContext(ctx, `select count(*) from user_recovery_codes where user_id = $1 and used_at is null`, id).Scan(&n)
+	return n, err
+}
+
+//AllReservations returns a slice of all reservations
+func (m *postgresDBRepo) AllReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	var reservations []models.Reservation
+
+	query := `
+	select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at,
+	r.processed, r.total_price, rm.id, rm.room_name
+	from reservations r
+	left join rooms rm on (r.room_id = rm.id)
+	order by r.start_date asc
+	`
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return reservations, err
+	}
+
+	defer rows.Close()
+	//faccio lo scan delle rows
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Processed,
+			&i.TotalPrice,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, i)
+	}
+
+	//dopo un for sempre meglio controllare per errori
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+	return reservations, nil
+}
+
+//AllNewReservations returns a slice of all reservations
+func (m *postgresDBRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	var reservations []models.Reservation
+
+	query := `
+	select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at,
+	r.total_price, rm.id, rm.room_name
+	from reservations r
+	left join rooms rm on (r.room_id = rm.id)
+	where r.processed = 0
+	order by r.start_date asc
+	`
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return reservations, err
+	}
+
+	defer rows.Close()
+	//faccio lo scan delle rows
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TotalPrice,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, i)
+	}
+
+	//dopo un for sempre meglio controllare per errori
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+	return reservations, nil
+}
+
+func (m *postgresDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var res models.Reservation
+
+	query := `
+	select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, 
+	r.end_date, r.room_id, r.created_at, r.updated_at,
+	r.processed, r.total_price, rm.id, rm.room_name
+	from reservations r
+	left join rooms rm on (r.room_id = rm.id)
+	where r.id = $1
+	`
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(
+		&res.ID,
+		&res.FirstName,
+		&res.LastName,
+		&res.Email,
+		&res.Phone,
+		&res.StartDate,
+		&res.EndDate,
+		&res.RoomID,
+		&res.CreatedAt,
+		&res.UpdatedAt,
+		&res.Processed,
+		&res.TotalPrice,
+		&res.Room.ID,
+		&res.Room.RoomName,
+	)
+
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+//UpdateReservation updates a reservation in the database
+func (m *postgresDBRepo) UpdateReservation(ctx context.Context, u models.Reservation) error {
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	update
+		reservations set first_name=$1, last_name = $2, email = $3, phone = $4, updated_at = $5
+		where id = $6
+	`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.Phone,
+		time.Now(),
+		u.ID,
+	)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//UpdateReservationDates moves a reservation and its room restriction to new dates.
+//Like BookRoom it locks the room and checks the availability again, ignoring the reservation itself
+func (m *postgresDBRepo) UpdateReservationDates(ctx context.Context, res models.Reservation) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	err = tx.QueryRowContext(ctx, "select id from rooms where id = $1 "+m.forUpdate, res.RoomID).Scan(&roomID)
+	if err != nil {
+		return err
+	}
+
+	var numRows int
+	query := `
+	select
+		count(id)
+	from
+		room_restrictions
+	where
+		room_id = $1 and
+		$2 <= end_date and $3 >= start_date and
+		coalesce(reservation_id, 0) <> $4;`
+
+	err = tx.QueryRowContext(ctx, query, res.RoomID, res.StartDate, res.EndDate, res.ID).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return repository.ErrRoomNotAvailable
+	}
+
+	_, err = tx.ExecContext(ctx, "update reservations set start_date = $1, end_date = $2, total_price = $3, updated_at = $4 where id = $5",
+		res.StartDate, res.EndDate, res.TotalPrice, time.Now(), res.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "update room_restrictions set start_date = $1, end_date = $2, updated_at = $3 where reservation_id = $4",
+		res.StartDate, res.EndDate, time.Now(), res.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//DeleteReservation deletes a reservation, its room restriction goes away with it (on delete cascade)
+func (m *postgresDBRepo) DeleteReservation(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := "delete from reservations where id = $1"
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	return nil
+
+}
+
+func (m *postgresDBRepo) UpdateProcessedForReservation(ctx context.Context, id, processed int) error {
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := "update reservations set processed = $1 where id = $2"
+
+	_, err := m.DB.ExecContext(ctx, query, processed, id)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//ReservationsToRemind returns the reservations starting between from and until whose guest didn't get a reminder yet
+func (m *postgresDBRepo) ReservationsToRemind(ctx context.Context, from, until time.Time) ([]models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	var reservations []models.Reservation
+
+	query := `
+	select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at,
+	r.processed, r.total_price, rm.id, rm.room_name
+	from reservations r
+	left join rooms rm on (r.room_id = rm.id)
+	where r.start_date >= $1 and r.start_date <= $2 and r.reminded_at is null
+	order by r.start_date asc
+	`
+	rows, err := m.DB.QueryContext(ctx, query, from, until)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Processed,
+			&i.TotalPrice,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+	return reservations, nil
+}
+
+//SetReservationReminded records that the guest got the reminder, so it's not sent again
+func (m *postgresDBRepo) SetReservationReminded(ctx context.Context, id int, remindedAt time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := "update reservations set reminded_at = $1 where id = $2"
+
+	_, err := m.DB.ExecContext(ctx, query, remindedAt, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *postgresDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var rooms []models.Room
+
+	query := `select id, room_name, description, capacity, slug, photos, active, base_rate, weekend_rate, min_stay, created_at, updated_at
+	from rooms order by room_name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rm models.Room
+		var photos string
+		err := rows.Scan(
+			&rm.ID,
+			&rm.RoomName,
+			&rm.Description,
+			&rm.Capacity,
+			&rm.Slug,
+			&photos,
+			&rm.Active,
+			&rm.BaseRate,
+			&rm.WeekendRate,
+			&rm.MinStay,
+			&rm.CreatedAt,
+			&rm.UpdatedAt,
+		)
+		if err != nil {
+			return rooms, err
+		}
+		rm.Photos = splitPhotos(photos)
+
+		rooms = append(rooms, rm)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+
+	return rooms, nil
+}
+
+//GetRestrictionForRoomByDate
+func (m *postgresDBRepo) GetRestrictionForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	var restrictions []models.RoomRestriction
+
+	//since the reservation id can be nul i use coalesce
+	//anche block_id può essere null, il motivo del blocco lo prendo da room_blocks
+	//e lo stesso per feed_id, il nome del calendario esterno è in room_ical_feeds
+	query := `select rr.id, coalesce(rr.reservation_id, 0), rr.restriction_id, rr.room_id, rr.start_date, rr.end_date,
+	coalesce(rr.block_id, 0), coalesce(rb.reason, ''), coalesce(rr.feed_id, 0), coalesce(f.name, '')
+	from room_restrictions rr
+	left join room_blocks rb on (rr.block_id = rb.id)
+	left join room_ical_feeds f on (rr.feed_id = f.id)
+	where $1 <= rr.end_date and $2 >= rr.start_date
+	and rr.room_id = $3`
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	//faccio passare i risultati della query e li metto nella variabile r (che è una strutc, quindi una collezione di room)
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(
+			&r.ID,
+			&r.ReservationID,
+			&r.RestrictionID,
+			&r.RoomID,
+			&r.StartDate,
+			&r.EndDate,
+			&r.BlockID,
+			&r.Block.Reason,
+			&r.FeedID,
+			&r.Feed.Name,
+		)
+		if err != nil {
+			return nil, err
+		}
+		r.Block.ID = r.BlockID
+		r.Feed.ID = r.FeedID
+		restrictions = append(restrictions, r)
+
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return restrictions, nil
+}
+
+func (m *postgresDBRepo) DeleteBlockByID(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `delete from room_restrictions
+				where id = $1
+				`
+	_, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		m.App.Logger.Ctx(ctx).Error("can't delete the block", "block_id", id, "error", err)
+		return err
+	}
+	return nil
+}
+
+//GetRatesForRoom returns the seasonal rates of a room, ordered by start date
+func (m *postgresDBRepo) GetRatesForRoom(ctx context.Context, roomID int) ([]models.RoomRate, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var rates []models.RoomRate
+
+	query := `
+	select
+		id, room_id, name, start_date, end_date, nightly_rate, weekend_rate, min_stay, created_at, updated_at
+	from
+		room_rates
+	where
+		room_id = $1
+	order by start_date, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return rates, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRate
+		err := rows.Scan(
+			&r.ID,
+			&r.RoomID,
+			&r.Name,
+			&r.StartDate,
+			&r.EndDate,
+			&r.NightlyRate,
+			&r.WeekendRate,
+			&r.MinStay,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+		)
+		if err != nil {
+			return rates, err
+		}
+		rates = append(rates, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rates, err
+	}
+	return rates, nil
+}
+
+//InsertRoomRate inserts a seasonal rate for a room
+func (m *postgresDBRepo) InsertRoomRate(ctx context.Context, r models.RoomRate) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into room_rates (room_id, name, start_date, end_date, nightly_rate, weekend_rate, min_stay, created_at, updated_at)
+			values($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		r.RoomID,
+		r.Name,
+		r.StartDate,
+		r.EndDate,
+		r.NightlyRate,
+		r.WeekendRate,
+		r.MinStay,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+//DeleteRoomRate deletes a seasonal rate, existing reservations keep the price they were booked at
+func (m *postgresDBRepo) DeleteRoomRate(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "delete from room_rates where id = $1", id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//QuotePrice returns the price of a stay in a room, night by night, using the rates of the room
+func (m *postgresDBRepo) QuotePrice(ctx context.Context, roomID int, start, end time.Time) (models.Quote, error) {
+	room, err := m.GetRoomByID(ctx, roomID)
+	if err != nil {
+		return models.Quote{}, err
+	}
+
+	rates, err := m.GetRatesForRoom(ctx, roomID)
+	if err != nil {
+		return models.Quote{}, err
+	}
+
+	return pricing.Quote(room, rates, start, end)
+}
+
+//InsertRoomBlock inserts an owner block and a room restriction for each of its occurrences, in a single transaction.
+//If an occurrence overlaps a reservation nothing is inserted and repository.ErrBlockOverlapsReservation is returned
+func (m *postgresDBRepo) InsertRoomBlock(ctx context.Context, b models.RoomBlock) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	//come in BookRoom, con il lock nessuno può prenotare la stanza mentre la blocco
+	var roomID int
+	err = tx.QueryRowContext(ctx, "select id from rooms where id = $1 "+m.forUpdate, b.RoomID).Scan(&roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	//un blocco senza ripetizioni finisce con la sua ultima occorrenza
+	if b.Recurrence == models.RecurrenceNone {
+		b.RepeatUntil = b.EndDate
+	}
+
+	var newID int
+	stmt := `insert into room_blocks (room_id, start_date, end_date, recurrence, repeat_until, reason, created_at, updated_at)
+			values($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		b.RoomID,
+		b.StartDate,
+		b.EndDate,
+		b.Recurrence,
+		b.RepeatUntil,
+		b.Reason,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, p := range b.Occurrences() {
+		var numRows int
+		query := `
+		select
+			count(id)
+		from
+			room_restrictions
+		where
+			room_id = $1 and reservation_id is not null and
+			$2 <= end_date and $3 >= start_date;`
+
+		err = tx.QueryRowContext(ctx, query, b.RoomID, p.Start, p.End).Scan(&numRows)
+		if err != nil {
+			return 0, err
+		}
+		if numRows > 0 {
+			return 0, repository.ErrBlockOverlapsReservation
+		}
+
+		stmt = `insert into room_restrictions (start_date, end_date, room_id, block_id, created_at, updated_at, restriction_id)
+		values($1, $2, $3, $4, $5, $6, $7)`
+
+		_, err = tx.ExecContext(ctx, stmt, p.Start, p.End, b.RoomID, newID, time.Now(), time.Now(), 2)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+//AllRoomBlocks returns the owner blocks of all the rooms, ordered by start date
+func (m *postgresDBRepo) AllRoomBlocks(ctx context.Context) ([]models.RoomBlock, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var blocks []models.RoomBlock
+
+	query := `
+	select
+		b.id, b.room_id, b.start_date, b.end_date, b.recurrence, b.repeat_until, b.reason,
+		b.created_at, b.updated_at, r.id, r.room_name
+	from
+		room_blocks b
+		left join rooms r on (b.room_id = r.id)
+	order by b.start_date, b.id`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return blocks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b models.RoomBlock
+		err := rows.Scan(
+			&b.ID,
+			&b.RoomID,
+			&b.StartDate,
+			&b.EndDate,
+			&b.Recurrence,
+			&b.RepeatUntil,
+			&b.Reason,
+			&b.CreatedAt,
+			&b.UpdatedAt,
+			&b.Room.ID,
+			&b.Room.RoomName,
+		)
+		if err != nil {
+			return blocks, err
+		}
+		blocks = append(blocks, b)
+	}
 
-	//dopo un for sempre meglio controllare per errori
 	if err = rows.Err(); err != nil {
-		return reservations, err
+		return blocks, err
 	}
-	return reservations, nil
+	return blocks, nil
 }
 
-func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+//DeleteRoomBlock deletes an owner block, its room restrictions go away with it (on delete cascade)
+func (m *postgresDBRepo) DeleteRoomBlock(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	var res models.Reservation
+	_, err := m.DB.ExecContext(ctx, "delete from room_blocks where id = $1", id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//InsertAPIToken stores a new api token, the token itself is never stored, only its hash
+func (m *postgresDBRepo) InsertAPIToken(ctx context.Context, t models.APIToken) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into api_tokens (user_id, name, token_hash, scope, created_at, updated_at)
+			values($1, $2, $3, $4, $5, $6) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		t.UserID,
+		t.Name,
+		t.TokenHash,
+		t.Scope,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+//GetAPITokenByHash returns the api token with the given hash, with the user it belongs to, and records that it
+//has been used. The tokens of a disabled user are not found
+func (m *postgresDBRepo) GetAPITokenByHash(ctx context.Context, hash string) (models.APIToken, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var t models.APIToken
 
 	query := `
-	select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, 
-	r.end_date, r.room_id, r.created_at, r.updated_at,
-	r.processed, rm.id, rm.room_name
-	from reservations r
-	left join rooms rm on (r.room_id = rm.id)
-	where r.id = $1
-	`
-	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(
-		&res.ID,
-		&res.FirstName,
-		&res.LastName,
-		&res.Email,
-		&res.Phone,
-		&res.StartDate,
-		&res.EndDate,
-		&res.RoomID,
-		&res.CreatedAt,
-		&res.UpdatedAt,
-		&res.Processed,
-		&res.Room.ID,
-		&res.Room.RoomName,
+	select
+		t.id, t.user_id, t.name, t.token_hash, t.scope, t.last_used_at, t.created_at, t.updated_at,
+		u.id, u.first_name, u.last_name, u.email, u.access_level, u.active
+	from
+		api_tokens t
+		join users u on (u.id = t.user_id)
+	where
+		t.token_hash = $1 and u.active = 1`
+
+	//un token mai usato ha last_used_at null, tanto lo aggiorno subito
+	var lastUsedAt sql.NullTime
+	err := m.DB.QueryRowContext(ctx, query, hash).Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Name,
+		&t.TokenHash,
+		&t.Scope,
+		&lastUsedAt,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+		&t.User.ID,
+		&t.User.FirstName,
+		&t.User.LastName,
+		&t.User.Email,
+		&t.User.AccessLevel,
+		&t.User.Active,
 	)
+	if err != nil {
+		return t, err
+	}
 
+	t.LastUsedAt = time.Now()
+	_, err = m.DB.ExecContext(ctx, "update api_tokens set last_used_at = $1 where id = $2", t.LastUsedAt, t.ID)
 	if err != nil {
-		return res, err
+		return t, err
 	}
+	return t, nil
+}
 
-	return res, nil
+//AllAPITokens returns all the api tokens, with the name of the user they belong to
+func (m *postgresDBRepo) AllAPITokens(ctx context.Context) ([]models.APIToken, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var apiTokens []models.APIToken
+
+	query := `
+	select
+		t.id, t.user_id, t.name, t.scope, t.last_used_at, t.created_at, t.updated_at,
+		u.id, u.first_name, u.last_name, u.email
+	from
+		api_tokens t
+		left join users u on (t.user_id = u.id)
+	order by t.created_at desc`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return apiTokens, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t models.APIToken
+		//un token mai usato ha last_used_at null
+		var lastUsedAt sql.NullTime
+		err := rows.Scan(
+			&t.ID,
+			&t.UserID,
+			&t.Name,
+			&t.Scope,
+			&lastUsedAt,
+			&t.CreatedAt,
+			&t.UpdatedAt,
+			&t.User.ID,
+			&t.User.FirstName,
+			&t.User.LastName,
+			&t.User.Email,
+		)
+		if err != nil {
+			return apiTokens, err
+		}
+		t.LastUsedAt = lastUsedAt.Time
+		apiTokens = append(apiTokens, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return apiTokens, err
+	}
+	return apiTokens, nil
 }
 
-//UpdateReservation updates a reservation in the database
-func (m *postgresDBRepo) UpdateReservation(u models.Reservation) error {
+//DeleteAPIToken revokes an api token
+func (m *postgresDBRepo) DeleteAPIToken(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "delete from api_tokens where id = $1", id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//AllICalFeeds returns the external calendars of all the rooms
+func (m *postgresDBRepo) AllICalFeeds(ctx context.Context) ([]models.ICalFeed, error) {
+	return m.queryICalFeeds(ctx, "", nil)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+//GetICalFeedsForRoom returns the external calendars of a room
+func (m *postgresDBRepo) GetICalFeedsForRoom(ctx context.Context, roomID int) ([]models.ICalFeed, error) {
+	return m.queryICalFeeds(ctx, "where f.room_id = $1", []interface{}{roomID})
+}
+
+//queryICalFeeds returns the external calendars selected by where, with the name of their room
+func (m *postgresDBRepo) queryICalFeeds(ctx context.Context, where string, args []interface{}) ([]models.ICalFeed, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
+	var feeds []models.ICalFeed
+
 	query := `
-	update
-		reservations set first_name=$1, last_name = $2, email = $3, phone = $4, updated_at = $5
-		where id = $6
-	`
+	select
+		f.id, f.room_id, f.name, f.url, f.last_synced_at, f.last_error,
+		f.created_at, f.updated_at, r.id, r.room_name
+	from
+		room_ical_feeds f
+		left join rooms r on (f.room_id = r.id)
+	` + where + `
+	order by r.room_name, f.name`
 
-	_, err := m.DB.ExecContext(ctx, query,
-		u.FirstName,
-		u.LastName,
-		u.Email,
-		u.Phone,
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return feeds, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f models.ICalFeed
+		//un calendario mai letto ha last_synced_at null
+		var lastSyncedAt sql.NullTime
+		err := rows.Scan(
+			&f.ID,
+			&f.RoomID,
+			&f.Name,
+			&f.URL,
+			&lastSyncedAt,
+			&f.LastError,
+			&f.CreatedAt,
+			&f.UpdatedAt,
+			&f.Room.ID,
+			&f.Room.RoomName,
+		)
+		if err != nil {
+			return feeds, err
+		}
+		f.LastSyncedAt = lastSyncedAt.Time
+		feeds = append(feeds, f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return feeds, err
+	}
+	return feeds, nil
+}
+
+//InsertICalFeed adds an external calendar to a room
+func (m *postgresDBRepo) InsertICalFeed(ctx context.Context, f models.ICalFeed) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into room_ical_feeds (room_id, name, url, created_at, updated_at)
+			values($1, $2, $3, $4, $5) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		f.RoomID,
+		f.Name,
+		f.URL,
 		time.Now(),
-		u.ID,
-	)
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+//DeleteICalFeed deletes an external calendar, its restrictions go away with it
+func (m *postgresDBRepo) DeleteICalFeed(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
 
+	_, err := m.DB.ExecContext(ctx, "delete from room_ical_feeds where id = $1", id)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-//DeleteReservation
-func (m *postgresDBRepo) DeleteReservation(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+//ReplaceICalFeedRestrictions replaces the restrictions of an external calendar with the periods it has now
+func (m *postgresDBRepo) ReplaceICalFeedRestrictions(ctx context.Context, feed models.ICalFeed, periods []models.Period) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	query := "delete from reservations where id = $1"
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	_, err := m.DB.ExecContext(ctx, query, id)
+	//il calendario esterno è sempre completo, quindi cancello tutto e rimetto quello che c'è
+	_, err = tx.ExecContext(ctx, "delete from room_restrictions where feed_id = $1", feed.ID)
 	if err != nil {
 		return err
 	}
-	return nil
 
-}
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, feed_id, created_at, updated_at, restriction_id)
+		values($1, $2, $3, $4, $5, $6, $7)`
 
-func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) error {
+	for _, p := range periods {
+		_, err = tx.ExecContext(ctx, stmt, p.Start, p.End, feed.RoomID, feed.ID, time.Now(), time.Now(), models.RestrictionExternal)
+		if err != nil {
+			return err
+		}
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	return tx.Commit()
+}
 
-	query := "update reservations set processed = $1 where id = $2"
+//UpdateICalFeedSync records when an external calendar was read and the error, if any
+func (m *postgresDBRepo) UpdateICalFeedSync(ctx context.Context, id int, syncedAt time.Time, lastError string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, processed, id)
+	stmt := `update room_ical_feeds set last_synced_at = $1, last_error = $2, updated_at = $3 where id = $4`
 
+	_, err := m.DB.ExecContext(ctx, stmt, syncedAt, lastError, time.Now(), id)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+//stuckMailAfter is when a mail still sending is taken again, the worker sending it has surely died
+const stuckMailAfter = 10 * time.Minute
+
+//QueueMail puts a mail in the outbox, the mail worker sends it as soon as it can
+func (m *postgresDBRepo) QueueMail(ctx context.Context, mail models.MailData) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	var rooms []models.Room
+	var newID int
+	stmt := `insert into mail_outbox (to_address, from_address, subject, content, text_content, status, attempts,
+			next_attempt_at, last_error, request_id, created_at, updated_at)
+			values($1, $2, $3, $4, $5, $6, 0, $7, '', $8, $7, $7) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		mail.To,
+		mail.From,
+		mail.Subject,
+		mail.Content,
+		mail.Text,
+		models.MailQueued,
+		time.Now(),
+		logger.RequestID(ctx),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
 
-	query := `select id, room_name, created_at, updated_at from rooms order by room_name`
+//ClaimOutboxMail takes up to limit mails due to be sent and marks them as sending,
+//two workers never take the same mail
+func (m *postgresDBRepo) ClaimOutboxMail(ctx context.Context, limit int) ([]models.OutboxMail, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	var mails []models.OutboxMail
+	now := time.Now()
+
+	//skip locked: le mail prese da un altro worker non le aspetto, le salto
+	query := `
+	update mail_outbox set status = $2, updated_at = $3
+	where id in (
+		select id from mail_outbox
+		where (status = $4 and next_attempt_at <= $3) or (status = $2 and updated_at < $5)
+		order by next_attempt_at
+		limit $1
+		` + m.skipLocked + `)
+	returning id, to_address, from_address, subject, content, text_content, status, attempts, next_attempt_at,
+		last_error, request_id, created_at, updated_at`
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, models.MailSending, now, models.MailQueued, now.Add(-stuckMailAfter))
 	if err != nil {
-		return rooms, err
+		return mails, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var rm models.Room
+		var o models.OutboxMail
 		err := rows.Scan(
-			&rm.ID,
-			&rm.RoomName,
-			&rm.CreatedAt,
-			&rm.UpdatedAt,
+			&o.ID,
+			&o.Mail.To,
+			&o.Mail.From,
+			&o.Mail.Subject,
+			&o.Mail.Content,
+			&o.Mail.Text,
+			&o.Status,
+			&o.Attempts,
+			&o.NextAttemptAt,
+			&o.LastError,
+			&o.RequestID,
+			&o.CreatedAt,
+			&o.UpdatedAt,
 		)
 		if err != nil {
-			return rooms, err
+			return mails, err
 		}
-
-		rooms = append(rooms, rm)
+		mails = append(mails, o)
 	}
 
 	if err = rows.Err(); err != nil {
-		return rooms, err
+		return mails, err
 	}
+	return mails, nil
+}
 
-	return rooms, nil
+//UpdateOutboxMail saves how sending a mail went
+func (m *postgresDBRepo) UpdateOutboxMail(ctx context.Context, o models.OutboxMail) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	//una mail non ancora mandata ha sent_at null
+	var sentAt interface{}
+	if !o.SentAt.IsZero() {
+		sentAt = o.SentAt
+	}
+
+	stmt := `update mail_outbox set status = $1, attempts = $2, next_attempt_at = $3, last_error = $4, sent_at = $5,
+			updated_at = $6 where id = $7`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		o.Status,
+		o.Attempts,
+		o.NextAttemptAt,
+		o.LastError,
+		sentAt,
+		time.Now(),
+		o.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
 }
 
-//GetRestrictionForRoomByDate
-func (m *postgresDBRepo) GetRestrictionForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+//AllOutboxMail returns the mails not sent yet, the failed ones first
+func (m *postgresDBRepo) AllOutboxMail(ctx context.Context) ([]models.OutboxMail, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
-	var restrictions []models.RoomRestriction
 
-	//since the reservation id can be nul i use coalesce
-	query := `select id, coalesce(reservation_id, 0), restriction_id, room_id, start_date, end_date
-	from room_restrictions where $1 <= end_date and $2 >= start_date
-	and room_id = $3`
+	var mails []models.OutboxMail
 
-	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID)
+	query := `
+	select
+		id, to_address, from_address, subject, content, text_content, status, attempts, next_attempt_at,
+		last_error, created_at, updated_at
+	from
+		mail_outbox
+	where
+		status <> $1
+	order by status = $2 desc, next_attempt_at`
+
+	rows, err := m.DB.QueryContext(ctx, query, models.MailSent, models.MailFailed)
 	if err != nil {
-		return nil, err
+		return mails, err
 	}
 	defer rows.Close()
 
-	//faccio passare i risultati della query e li metto nella variabile r (che è una strutc, quindi una collezione di room)
 	for rows.Next() {
-		var r models.RoomRestriction
+		var o models.OutboxMail
 		err := rows.Scan(
-			&r.ID,
-			&r.ReservationID,
-			&r.RestrictionID,
-			&r.RoomID,
-			&r.StartDate,
-			&r.EndDate,
+			&o.ID,
+			&o.Mail.To,
+			&o.Mail.From,
+			&o.Mail.Subject,
+			&o.Mail.Content,
+			&o.Mail.Text,
+			&o.Status,
+			&o.Attempts,
+			&o.NextAttemptAt,
+			&o.LastError,
+			&o.CreatedAt,
+			&o.UpdatedAt,
 		)
 		if err != nil {
-			return nil, err
+			return mails, err
 		}
-		restrictions = append(restrictions, r)
-
+		mails = append(mails, o)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return mails, err
 	}
-	return restrictions, nil
+	return mails, nil
 }
 
-func (m *postgresDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+//ResendOutboxMail puts a mail back in the queue, with all its attempts again
+func (m *postgresDBRepo) ResendOutboxMail(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	query := `insert into room_restrictions 
-				(start_date, end_date, room_id, restriction_id, created_at, updated_at)
-				values ($1, $2, $3, $4, $5, $6)
-				`
-	_, err := m.DB.ExecContext(ctx, query, startDate, startDate, id, 2, time.Now(), time.Now())
+	stmt := `update mail_outbox set status = $1, attempts = 0, next_attempt_at = $2, updated_at = $2
+			where id = $3 and status <> $4`
+
+	_, err := m.DB.ExecContext(ctx, stmt, models.MailQueued, time.Now(), id, models.MailSent)
 	if err != nil {
-		log.Println(err)
 		return err
 	}
 	return nil
 }
 
-func (m *postgresDBRepo) DeleteBlockByID(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+//CountQueuedMail returns how many mails are waiting to be sent, the ones being sent included
+func (m *postgresDBRepo) CountQueuedMail(ctx context.Context) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	query := `delete from room_restrictions
-				where id = $1
-				`
-	_, err := m.DB.ExecContext(ctx, query, id)
+	var n int
+	err := m.DB.QueryRowContext(ctx, `select count(*) from mail_outbox where status in ($1, $2)`,
+		models.MailQueued, models.MailSending).Scan(&n)
 	if err != nil {
-		log.Println(err)
-		return err
+		return 0, err
 	}
-	return nil
+	return n, nil
 }
@@ -0,0 +1,24 @@
+package dbrepo
+
+import (
+	"database/sql"
+
+	"github.com/Laura470/bookings/internal/config"
+	"github.com/Laura470/bookings/internal/repository"
+)
+
+//sqliteDBRepo runs the queries of postgresDBRepo on a sqlite database, with the sqlite dialect.
+//The database must be opened with driver.NewDatabase and the dsn of driver.SQLiteDSN, that keep the times in utc
+//so that they compare as text in the same order as in time
+type sqliteDBRepo struct {
+	postgresDBRepo
+}
+
+//NewSQLiteRepo returns the repository on the sqlite database conn
+func NewSQLiteRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
+	return &sqliteDBRepo{postgresDBRepo{
+		App:     a,
+		DB:      conn,
+		dialect: sqliteDialect,
+	}}
+}
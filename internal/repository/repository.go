@@ -1,34 +1,99 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/Laura470/bookings/internal/models"
 )
 
+//ErrRoomNotAvailable is returned by BookRoom and UpdateReservationDates when the room has been booked by someone else in the meantime
+var ErrRoomNotAvailable = errors.New("room no longer available for the selected dates")
+
+//ErrRoomHasReservations is returned by DeleteRoom when the room still has reservations
+var ErrRoomHasReservations = errors.New("room has reservations")
+
+//ErrBlockOverlapsReservation is returned by InsertRoomBlock when the block would cover a reservation
+var ErrBlockOverlapsReservation = errors.New("block overlaps a reservation")
+
 //ricordare che la interface è un contract devo formire a postgres.go le funzioni
 type DatabaseRepo interface {
-	AllUsers() bool
-	InsertReservation(res models.Reservation) (int, error)
-	InsertRoomRestriction(r models.RoomRestriction) error
-	SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
-	GetRoomByID(id int) (models.Room, error)
-
-	GetUserByID(id int) (models.User, error)
-	UpdateUser(u models.User) error
-	Authenticate(email, testPassword string) (int, string, error)
-
-	AllReservations() ([]models.Reservation, error)
-	AllNewReservations() ([]models.Reservation, error)
-
-	GetReservationByID(id int) (models.Reservation, error)
-	UpdateReservation(u models.Reservation) error
-	DeleteReservation(id int) error
-	UpdateProcessedForReservation(id, processed int) error
-
-	AllRooms() ([]models.Room, error)
-	GetRestrictionForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
-	InsertBlockForRoom(id int, startDate time.Time) error
-	DeleteBlockByID(id int) error
+	InsertReservation(ctx context.Context, res models.Reservation) (int, error)
+	InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error
+	BookRoom(ctx context.Context, res models.Reservation) (int, error)
+	SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error)
+	SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error)
+	GetRoomByID(ctx context.Context, id int) (models.Room, error)
+
+	GetUserByID(ctx context.Context, id int) (models.User, error)
+	UpdateUser(ctx context.Context, u models.User) error
+	Authenticate(ctx context.Context, email, testPassword string) (int, string, error)
+	AllUsers(ctx context.Context) ([]models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (models.User, error)
+	InsertUser(ctx context.Context, u models.User) (int, error)
+	UpdateActiveForUser(ctx context.Context, id, active int) error
+	UpdatePassword(ctx context.Context, id int, password string) error
+	InsertUserToken(ctx context.Context, t models.UserToken) (int, error)
+	GetUserTokenByHash(ctx context.Context, hash string) (models.UserToken, error)
+	UseUserToken(ctx context.Context, id int) error
+	InsertLoginFailure(ctx context.Context, email, ip string) error
+	CountLoginFailures(ctx context.Context, email, ip string, since time.Time) (int, int, error)
+	ClearLoginFailures(ctx context.Context, email string) error
+	LockUser(ctx context.Context, id int, until time.Time) error
+	UnlockUser(ctx context.Context, id int) error
+	EnableTOTP(ctx context.Context, id int, secret string, counter int64, codeHashes []string) error
+	DisableTOTP(ctx context.Context, id int) error
+	UseTOTPCounter(ctx context.Context, id int, counter int64) error
+	UseRecoveryCode(ctx context.Context, id int, codeHash string) error
+	CountRecoveryCodes(ctx context.Context, id int) (int, error)
+
+	AllReservations(ctx context.Context) ([]models.Reservation, error)
+	AllNewReservations(ctx context.Context) ([]models.Reservation, error)
+
+	GetReservationByID(ctx context.Context, id int) (models.Reservation, error)
+	UpdateReservation(ctx context.Context, u models.Reservation) error
+	UpdateReservationDates(ctx context.Context, res models.Reservation) error
+	DeleteReservation(ctx context.Context, id int) error
+	UpdateProcessedForReservation(ctx context.Context, id, processed int) error
+	ReservationsToRemind(ctx context.Context, from, until time.Time) ([]models.Reservation, error)
+	SetReservationReminded(ctx context.Context, id int, remindedAt time.Time) error
+
+	AllRooms(ctx context.Context) ([]models.Room, error)
+	GetRoomBySlug(ctx context.Context, slug string) (models.Room, error)
+	InsertRoom(ctx context.Context, room models.Room) (int, error)
+	UpdateRoom(ctx context.Context, room models.Room) error
+	UpdateActiveForRoom(ctx context.Context, id, active int) error
+	DeleteRoom(ctx context.Context, id int) error
+
+	GetRatesForRoom(ctx context.Context, roomID int) ([]models.RoomRate, error)
+	InsertRoomRate(ctx context.Context, r models.RoomRate) (int, error)
+	DeleteRoomRate(ctx context.Context, id int) error
+	QuotePrice(ctx context.Context, roomID int, start, end time.Time) (models.Quote, error)
+
+	GetRestrictionForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error)
+	DeleteBlockByID(ctx context.Context, id int) error
+
+	InsertRoomBlock(ctx context.Context, b models.RoomBlock) (int, error)
+	AllRoomBlocks(ctx context.Context) ([]models.RoomBlock, error)
+	DeleteRoomBlock(ctx context.Context, id int) error
+
+	InsertAPIToken(ctx context.Context, t models.APIToken) (int, error)
+	GetAPITokenByHash(ctx context.Context, hash string) (models.APIToken, error)
+	AllAPITokens(ctx context.Context) ([]models.APIToken, error)
+	DeleteAPIToken(ctx context.Context, id int) error
+
+	AllICalFeeds(ctx context.Context) ([]models.ICalFeed, error)
+	GetICalFeedsForRoom(ctx context.Context, roomID int) ([]models.ICalFeed, error)
+	InsertICalFeed(ctx context.Context, f models.ICalFeed) (int, error)
+	DeleteICalFeed(ctx context.Context, id int) error
+	ReplaceICalFeedRestrictions(ctx context.Context, feed models.ICalFeed, periods []models.Period) error
+	UpdateICalFeedSync(ctx context.Context, id int, syncedAt time.Time, lastError string) error
+
+	QueueMail(ctx context.Context, m models.MailData) (int, error)
+	ClaimOutboxMail(ctx context.Context, limit int) ([]models.OutboxMail, error)
+	UpdateOutboxMail(ctx context.Context, m models.OutboxMail) error
+	AllOutboxMail(ctx context.Context) ([]models.OutboxMail, error)
+	ResendOutboxMail(ctx context.Context, id int) error
+	CountQueuedMail(ctx context.Context) (int, error)
 }
@@ -0,0 +1,103 @@
+//Package sessionstore keeps the scs sessions in the database, so they survive a restart
+//and the instances of the site share them
+package sessionstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Laura470/bookings/internal/logger"
+	"github.com/alexedwards/scs/v2"
+)
+
+var _ scs.Store = &DBStore{}
+
+//DBStore is a scs.Store on the sessions table, the queries are the same on Postgres and SQLite
+type DBStore struct {
+	db          *sql.DB
+	timeout     time.Duration
+	log         *logger.Logger
+	stopCleanup chan bool
+}
+
+//New returns a store on db, every query waits at most timeout. If cleanupInterval isn't 0
+//the expired sessions are deleted every cleanupInterval, until StopCleanup, and the errors go to log
+func New(db *sql.DB, timeout, cleanupInterval time.Duration, log *logger.Logger) *DBStore {
+	p := &DBStore{db: db, timeout: timeout, log: log}
+	if cleanupInterval > 0 {
+		p.stopCleanup = make(chan bool)
+		go p.startCleanup(cleanupInterval)
+	}
+	return p
+}
+
+//Find returns the data of the session token, found is false if there is no such session or it's expired
+func (p *DBStore) Find(token string) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	var b []byte
+	err := p.db.QueryRowContext(ctx, `select data from sessions where token = $1 and expiry > $2`, token, time.Now()).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+//Commit saves the data of the session token, replacing the data it had before
+func (p *DBStore) Commit(token string, b []byte, expiry time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	stmt := `insert into sessions (token, data, expiry) values ($1, $2, $3)
+		on conflict (token) do update set data = excluded.data, expiry = excluded.expiry`
+
+	_, err := p.db.ExecContext(ctx, stmt, token, b, expiry)
+	return err
+}
+
+//Delete removes the session token, a session that doesn't exist is not an error
+func (p *DBStore) Delete(token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, `delete from sessions where token = $1`, token)
+	return err
+}
+
+//StopCleanup stops the goroutine that deletes the expired sessions
+func (p *DBStore) StopCleanup() {
+	if p.stopCleanup != nil {
+		p.stopCleanup <- true
+	}
+}
+
+func (p *DBStore) startCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := p.deleteExpired()
+			if err != nil {
+				p.log.Error("can't delete the expired sessions", "error", err)
+			}
+		case <-p.stopCleanup:
+			return
+		}
+	}
+}
+
+//deleteExpired deletes the expired sessions, Find doesn't return them anyway
+func (p *DBStore) deleteExpired() error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, `delete from sessions where expiry < $1`, time.Now())
+	return err
+}
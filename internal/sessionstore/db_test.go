@@ -0,0 +1,176 @@
+package sessionstore
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Laura470/bookings/internal/driver"
+	"github.com/Laura470/bookings/internal/logger"
+	"github.com/Laura470/bookings/internal/migrate"
+	"github.com/Laura470/bookings/migrations"
+)
+
+//postgresDSNEnv is the variable with the dsn of a Postgres database to run the tests on too,
+//the same of the tests of dbrepo
+const postgresDSNEnv = "BOOKINGS_TEST_DSN"
+
+type backend struct {
+	name string
+	new  func(t *testing.T) *sql.DB
+}
+
+//backends returns the databases the store must work on, SQLite always and Postgres only when postgresDSNEnv is set
+func backends() []backend {
+	all := []backend{{"sqlite", newSQLite}}
+	if dsn := os.Getenv(postgresDSNEnv); dsn != "" {
+		all = append(all, backend{"postgres", func(t *testing.T) *sql.DB {
+			return newPostgres(t, dsn)
+		}})
+	}
+	return all
+}
+
+//newSQLite returns a sqlite database in a temporary file, with the migrations applied
+func newSQLite(t *testing.T) *sql.DB {
+	db, err := driver.NewDatabase(driver.SQLite, driver.SQLiteDSN(filepath.Join(t.TempDir(), "bookings.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	migrateUp(t, db, driver.SQLite, migrations.SQLite)
+	return db
+}
+
+//newPostgres returns a new schema of the Postgres database of dsn, with the migrations applied
+func newPostgres(t *testing.T, dsn string) *sql.DB {
+	admin, err := driver.NewDatabase(driver.Postgres, dsn)
+	if err != nil {
+		t.Fatalf("can't connect to %s: %s", postgresDSNEnv, err)
+	}
+	schema := fmt.Sprintf("sessions_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("create schema " + schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("drop schema " + schema + " cascade"); err != nil {
+			t.Errorf("can't drop the schema %s: %s", schema, err)
+		}
+		admin.Close()
+	})
+
+	sep := " "
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		sep = "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+	}
+	db, err := driver.NewDatabase(driver.Postgres, dsn+sep+"search_path="+schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//chiuso prima di admin, le cleanup vanno al contrario
+	t.Cleanup(func() {
+		db.Close()
+	})
+	migrateUp(t, db, driver.Postgres, migrations.Postgres)
+	return db
+}
+
+func migrateUp(t *testing.T, db *sql.DB, driverName, dir string) {
+	all, err := migrate.Load(migrations.FS, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrate.New(db, driverName, all, logger.Discard()).Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDBStore(t *testing.T) {
+	for _, b := range backends() {
+		t.Run(b.name, func(t *testing.T) {
+			store := New(b.new(t), time.Second, 0, logger.Discard())
+			expiry := time.Now().Add(time.Hour)
+
+			if _, found, err := store.Find("missing"); err != nil || found {
+				t.Errorf("expected no session but got %v %v", found, err)
+			}
+
+			if err := store.Commit("token", []byte("first"), expiry); err != nil {
+				t.Fatal(err)
+			}
+			//la seconda commit dello stesso token sostituisce i dati
+			if err := store.Commit("token", []byte("second"), expiry); err != nil {
+				t.Fatal(err)
+			}
+			b, found, err := store.Find("token")
+			if err != nil || !found || !bytes.Equal(b, []byte("second")) {
+				t.Errorf("expected the data of the last commit but got %q %v %v", b, found, err)
+			}
+
+			if err := store.Delete("token"); err != nil {
+				t.Fatal(err)
+			}
+			if _, found, _ := store.Find("token"); found {
+				t.Error("session found after the delete")
+			}
+			if err := store.Delete("token"); err != nil {
+				t.Errorf("deleting a missing session: %s", err)
+			}
+
+			if err := store.Commit("expired", []byte("old"), time.Now().Add(-time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+			if _, found, err := store.Find("expired"); err != nil || found {
+				t.Errorf("expected the expired session not to be found but got %v %v", found, err)
+			}
+		})
+	}
+}
+
+func TestDBStoreCleanup(t *testing.T) {
+	for _, b := range backends() {
+		t.Run(b.name, func(t *testing.T) {
+			db := b.new(t)
+			store := New(db, time.Second, 10*time.Millisecond, logger.Discard())
+
+			if err := store.Commit("expired", []byte("old"), time.Now().Add(-time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Commit("live", []byte("new"), time.Now().Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+
+			count := func() int {
+				var n int
+				if err := db.QueryRow(`select count(*) from sessions`).Scan(&n); err != nil {
+					t.Fatal(err)
+				}
+				return n
+			}
+			for deadline := time.Now().Add(5 * time.Second); count() != 1; {
+				if time.Now().After(deadline) {
+					store.StopCleanup()
+					t.Fatalf("expected only the live session after the cleanup but there are %d", count())
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			store.StopCleanup()
+
+			if _, found, err := store.Find("live"); err != nil || !found {
+				t.Errorf("the cleanup deleted the live session: %v %v", found, err)
+			}
+		})
+	}
+}
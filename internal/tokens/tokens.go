@@ -0,0 +1,87 @@
+package tokens
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+//ErrInvalidToken is returned when a token is malformed or its signature doesn't match
+var ErrInvalidToken = errors.New("invalid token")
+
+//Signer signs values with HMAC-SHA256, so the links we send by email can't be guessed or tampered with
+type Signer struct {
+	key []byte
+}
+
+//NewSigner creates a signer with the given secret key
+func NewSigner(key []byte) *Signer {
+	return &Signer{
+		key: key,
+	}
+}
+
+//Sign returns a url safe token containing the value and its signature
+func (s *Signer) Sign(value string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(value))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(s.mac(payload))
+}
+
+//Verify checks the signature of a token made by Sign and returns the value inside it
+func (s *Signer) Verify(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", ErrInvalidToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	//confronto in tempo costante, per non dare indizi a chi prova a indovinare la firma
+	if !hmac.Equal(sig, s.mac(parts[0])) {
+		return "", ErrInvalidToken
+	}
+
+	value, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	return string(value), nil
+}
+
+func (s *Signer) mac(payload string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+//RandomKey returns n random bytes, to be used as a secret key
+func RandomKey(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+//Generate returns a new random token, to give to the user once. We only store its Hash
+func Generate() (string, error) {
+	b, err := RandomKey(32)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+//Hash returns the sha256 of a token, in hex. A random token is long enough that it doesn't need a salt
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
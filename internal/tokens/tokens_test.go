@@ -0,0 +1,84 @@
+package tokens
+
+import (
+	"testing"
+)
+
+func TestSigner_SignAndVerify(t *testing.T) {
+	s := NewSigner([]byte("some secret"))
+
+	token := s.Sign("reservation:42")
+
+	value, err := s.Verify(token)
+	if err != nil {
+		t.Error("valid token not verified:", err)
+	}
+	if value != "reservation:42" {
+		t.Errorf("expected value reservation:42 but got %s", value)
+	}
+}
+
+var invalidTokens = []struct {
+	name  string
+	token string
+}{
+	{"empty", ""},
+	{"no signature", "cmVzZXJ2YXRpb246NDI"},
+	{"too many parts", "a.b.c"},
+	{"bad signature", "cmVzZXJ2YXRpb246NDI.aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"},
+	{"not base64", "!!!.???"},
+}
+
+func TestSigner_VerifyInvalid(t *testing.T) {
+	s := NewSigner([]byte("some secret"))
+
+	for _, e := range invalidTokens {
+		_, err := s.Verify(e.token)
+		if err != ErrInvalidToken {
+			t.Errorf("%s: expected ErrInvalidToken but got %v", e.name, err)
+		}
+	}
+
+	//un token firmato con un'altra chiave non deve essere valido
+	other := NewSigner([]byte("another secret"))
+	_, err := s.Verify(other.Sign("reservation:42"))
+	if err != ErrInvalidToken {
+		t.Error("token signed with another key was verified")
+	}
+}
+
+func TestRandomKey(t *testing.T) {
+	k1, err := RandomKey(32)
+	if err != nil {
+		t.Error(err)
+	}
+	k2, _ := RandomKey(32)
+
+	if len(k1) != 32 {
+		t.Errorf("expected 32 bytes but got %d", len(k1))
+	}
+	if string(k1) == string(k2) {
+		t.Error("two random keys are equal")
+	}
+}
+
+func TestGenerateAndHash(t *testing.T) {
+	t1, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t2, _ := Generate()
+
+	if t1 == t2 {
+		t.Error("two generated tokens are equal")
+	}
+	if Hash(t1) != Hash(t1) {
+		t.Error("the hash of a token is not always the same")
+	}
+	if Hash(t1) == Hash(t2) {
+		t.Error("two tokens have the same hash")
+	}
+	if len(Hash(t1)) != 64 {
+		t.Errorf("expected a 64 characters hash but got %d", len(Hash(t1)))
+	}
+}
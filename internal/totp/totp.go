@@ -0,0 +1,125 @@
+//Package totp makes and checks the one-time codes of RFC 6238, the ones shown by the authenticator apps
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//the settings every authenticator app knows: 6 digits, a new code every 30 seconds, SHA-1
+const (
+	Digits = 6
+	Period = 30 * time.Second
+)
+
+//skew is how many codes before and after the current one are accepted, for the clocks not in sync
+const skew = 1
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+//GenerateSecret returns a new random secret, in base32 like the apps want it
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+//Counter returns the number of the code valid at t
+func Counter(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+//Code returns the code number counter of secret
+func Code(secret string, counter int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	h := hmac.New(sha1.New, key)
+	h.Write(msg[:])
+	sum := h.Sum(nil)
+
+	//dynamic truncation, RFC 4226 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%mod), nil
+}
+
+//Validate checks code against the codes of secret around t, and returns the counter of the code.
+//A code with a counter not after last is refused, so a code can be used only once
+func Validate(secret, code string, t time.Time, last int64) (int64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	now := Counter(t)
+	for c := now - skew; c <= now+skew; c++ {
+		if c <= last {
+			continue
+		}
+		expected, err := Code(secret, c)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+//URI returns the otpauth address to put in the QR code the apps scan
+func URI(secret, issuer, account string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period/time.Second)))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+//GenerateRecoveryCodes returns n codes to log in without the app, each one can be used once
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 5)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		s := strings.ToLower(encoding.EncodeToString(b))
+		codes[i] = s[:4] + "-" + s[4:]
+	}
+	return codes, nil
+}
+
+//NormalizeRecoveryCode returns code the way GenerateRecoveryCodes writes it, whatever the user typed
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+	if len(code) != 8 {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
@@ -0,0 +1,87 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+//il secret dei vettori di test dell'RFC 6238 per SHA-1
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	//i codici dell'RFC hanno 8 cifre, le ultime 6 sono quelle dei codici a 6 cifre
+	tests := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, expected := range tests {
+		code, err := Code(rfcSecret, Counter(time.Unix(unix, 0)))
+		if err != nil || code != expected {
+			t.Errorf("%d: expected %s but got %s (%v)", unix, expected, code, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	counter, ok := Validate(rfcSecret, "081804", now, 0)
+	if !ok || counter != Counter(now) {
+		t.Fatalf("the current code is not valid")
+	}
+
+	//il codice di 30 secondi prima va ancora bene
+	if _, ok := Validate(rfcSecret, "081804", now.Add(Period), 0); !ok {
+		t.Error("the previous code is not valid")
+	}
+
+	if _, ok := Validate(rfcSecret, "081804", now.Add(3*Period), 0); ok {
+		t.Error("an old code is valid")
+	}
+
+	if _, ok := Validate(rfcSecret, "081804", now, counter); ok {
+		t.Error("a code has been used twice")
+	}
+
+	if _, ok := Validate(rfcSecret, "12345", now, 0); ok {
+		t.Error("a short code is valid")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Code(secret, 1); err != nil {
+		t.Errorf("the new secret can't make codes: %s", err)
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("JBSWY3DPEHPK3PXP", "Fort Smythe", "me@here.ca")
+	for _, s := range []string{"otpauth://totp/Fort%20Smythe:me@here.ca?", "secret=JBSWY3DPEHPK3PXP", "issuer=Fort+Smythe", "digits=6", "period=30"} {
+		if !strings.Contains(uri, s) {
+			t.Errorf("%q not in %s", s, uri)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 10 || codes[0] == codes[1] {
+		t.Fatalf("wrong codes: %v", codes)
+	}
+
+	if NormalizeRecoveryCode(strings.ToUpper(strings.ReplaceAll(codes[0], "-", " "))) != codes[0] {
+		t.Errorf("%s not found again", codes[0])
+	}
+}
@@ -1,3 +0,0 @@
-INSERT INTO public.rooms (room_name,created_at,updated_at) VALUES
-	 ('General''s Quarters','2023-04-09 00:00:00.000','2023-04-09 00:00:00.000'),
-	 ('Major''s Suite','2023-04-09 00:00:00.000','2023-04-09 00:00:00.000');
\ No newline at end of file
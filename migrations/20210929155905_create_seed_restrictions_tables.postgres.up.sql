@@ -1,3 +0,0 @@
-INSERT INTO public.restrictions (restriction_name,created_at,updated_at) VALUES
-	 ('Reservation','2023-04-09 00:00:00.000','2023-04-09 00:00:00.000'),
-	 ('Owner Block','2023-04-09 00:00:00.000','2023-04-09 00:00:00.000');
\ No newline at end of file
@@ -0,0 +1,17 @@
+//Package migrations holds the sql migrations of the database, built in the binary so
+//that it can migrate the database without other tools. Every migration is a pair of files
+//<version>_<name>.up.sql and <version>_<name>.down.sql, the versions are applied in order
+package migrations
+
+import "embed"
+
+//FS has the migrations of every database in its own folder
+//
+//go:embed postgres/*.sql sqlite/*.sql
+var FS embed.FS
+
+//Postgres is the folder of FS with the migrations of Postgres
+const Postgres = "postgres"
+
+//SQLite is the folder of FS with the migrations of SQLite
+const SQLite = "sqlite"
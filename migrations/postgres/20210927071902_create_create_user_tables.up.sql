@@ -0,0 +1,10 @@
+CREATE TABLE "users" (
+  "id" SERIAL PRIMARY KEY,
+  "first_name" VARCHAR (255) NOT NULL DEFAULT '',
+  "last_name" VARCHAR (255) NOT NULL DEFAULT '',
+  "email" VARCHAR (255) NOT NULL,
+  "password" VARCHAR (60) NOT NULL,
+  "access_level" integer NOT NULL DEFAULT 1,
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL
+);
@@ -0,0 +1,12 @@
+CREATE TABLE "reservations" (
+  "id" SERIAL PRIMARY KEY,
+  "first_name" VARCHAR (255) NOT NULL DEFAULT '',
+  "last_name" VARCHAR (255) NOT NULL DEFAULT '',
+  "email" VARCHAR (255) NOT NULL,
+  "phone" VARCHAR (255) NOT NULL DEFAULT '',
+  "start_date" date NOT NULL,
+  "end_date" date NOT NULL,
+  "room_id" integer NOT NULL,
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL
+);
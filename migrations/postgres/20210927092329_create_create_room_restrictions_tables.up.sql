@@ -0,0 +1,10 @@
+CREATE TABLE "room_restrictions" (
+  "id" SERIAL PRIMARY KEY,
+  "start_date" date NOT NULL,
+  "end_date" date NOT NULL,
+  "room_id" integer NOT NULL,
+  "reservation_id" integer NOT NULL,
+  "restriction_id" integer NOT NULL,
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL
+);
@@ -0,0 +1 @@
+ALTER TABLE "room_restrictions" DROP CONSTRAINT "room_restrictions_reservations_id_fk";
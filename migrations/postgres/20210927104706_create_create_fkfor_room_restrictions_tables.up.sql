@@ -0,0 +1 @@
+ALTER TABLE "room_restrictions" ADD CONSTRAINT "room_restrictions_reservations_id_fk" FOREIGN KEY ("reservation_id") REFERENCES "reservations" ("id") ON DELETE CASCADE ON UPDATE CASCADE;
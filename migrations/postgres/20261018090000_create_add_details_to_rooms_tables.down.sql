@@ -0,0 +1,5 @@
+ALTER TABLE "rooms" DROP COLUMN "active";
+ALTER TABLE "rooms" DROP COLUMN "photos";
+ALTER TABLE "rooms" DROP COLUMN "slug";
+ALTER TABLE "rooms" DROP COLUMN "capacity";
+ALTER TABLE "rooms" DROP COLUMN "description";
@@ -0,0 +1,13 @@
+UPDATE rooms SET
+  slug = 'generals-quarters',
+  capacity = 2,
+  photos = '/static/images/generals-quarters.png',
+  description = 'Your home away form home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.'
+WHERE room_name = 'General''s Quarters';
+
+UPDATE rooms SET
+  slug = 'majors-suite',
+  capacity = 2,
+  photos = '/static/images/marjors-suite.png',
+  description = 'Your home away form home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.'
+WHERE room_name = 'Major''s Suite';
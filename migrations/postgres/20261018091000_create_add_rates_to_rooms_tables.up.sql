@@ -0,0 +1,3 @@
+ALTER TABLE "rooms" ADD COLUMN "base_rate" integer NOT NULL DEFAULT 0;
+ALTER TABLE "rooms" ADD COLUMN "weekend_rate" integer NOT NULL DEFAULT 0;
+ALTER TABLE "rooms" ADD COLUMN "min_stay" integer NOT NULL DEFAULT 1;
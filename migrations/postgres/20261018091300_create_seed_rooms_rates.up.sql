@@ -0,0 +1,5 @@
+UPDATE rooms SET base_rate = 12000, weekend_rate = 15000, min_stay = 1
+WHERE slug = 'generals-quarters';
+
+UPDATE rooms SET base_rate = 9000, weekend_rate = 11000, min_stay = 1
+WHERE slug = 'majors-suite';
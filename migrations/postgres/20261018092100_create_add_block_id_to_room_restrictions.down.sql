@@ -0,0 +1,2 @@
+ALTER TABLE "room_restrictions" DROP CONSTRAINT "room_restrictions_room_blocks_id_fk";
+ALTER TABLE "room_restrictions" DROP COLUMN "block_id";
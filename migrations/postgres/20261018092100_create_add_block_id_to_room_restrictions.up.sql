@@ -0,0 +1,5 @@
+ALTER TABLE "room_restrictions" ADD COLUMN "block_id" integer;
+
+ALTER TABLE "room_restrictions" ADD CONSTRAINT "room_restrictions_room_blocks_id_fk" FOREIGN KEY ("block_id") REFERENCES "room_blocks" ("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+CREATE INDEX "room_restrictions_block_id_idx" ON "room_restrictions" ("block_id");
@@ -0,0 +1,14 @@
+CREATE TABLE "api_tokens" (
+  "id" SERIAL PRIMARY KEY,
+  "user_id" integer NOT NULL,
+  "name" VARCHAR (255) NOT NULL DEFAULT '',
+  "token_hash" VARCHAR (255) NOT NULL,
+  "scope" VARCHAR (255) NOT NULL DEFAULT 'public',
+  "last_used_at" timestamp,
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL
+);
+
+ALTER TABLE "api_tokens" ADD CONSTRAINT "api_tokens_users_id_fk" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+CREATE UNIQUE INDEX "api_tokens_token_hash_idx" ON "api_tokens" ("token_hash");
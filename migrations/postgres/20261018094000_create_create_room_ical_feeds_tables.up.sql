@@ -0,0 +1,14 @@
+CREATE TABLE "room_ical_feeds" (
+  "id" SERIAL PRIMARY KEY,
+  "room_id" integer NOT NULL,
+  "name" VARCHAR (255) NOT NULL DEFAULT '',
+  "url" text NOT NULL,
+  "last_synced_at" timestamp,
+  "last_error" text NOT NULL DEFAULT '',
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL
+);
+
+ALTER TABLE "room_ical_feeds" ADD CONSTRAINT "room_ical_feeds_rooms_id_fk" FOREIGN KEY ("room_id") REFERENCES "rooms" ("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+CREATE INDEX "room_ical_feeds_room_id_idx" ON "room_ical_feeds" ("room_id");
@@ -0,0 +1,2 @@
+ALTER TABLE "room_restrictions" DROP CONSTRAINT "room_restrictions_room_ical_feeds_id_fk";
+ALTER TABLE "room_restrictions" DROP COLUMN "feed_id";
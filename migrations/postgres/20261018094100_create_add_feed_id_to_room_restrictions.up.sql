@@ -0,0 +1,5 @@
+ALTER TABLE "room_restrictions" ADD COLUMN "feed_id" integer;
+
+ALTER TABLE "room_restrictions" ADD CONSTRAINT "room_restrictions_room_ical_feeds_id_fk" FOREIGN KEY ("feed_id") REFERENCES "room_ical_feeds" ("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+CREATE INDEX "room_restrictions_feed_id_idx" ON "room_restrictions" ("feed_id");
@@ -0,0 +1 @@
+DELETE FROM restrictions WHERE restriction_name = 'External Calendar';
@@ -0,0 +1,2 @@
+INSERT INTO restrictions (restriction_name, created_at, updated_at) VALUES
+  ('External Calendar', '2026-10-18 00:00:00', '2026-10-18 00:00:00');
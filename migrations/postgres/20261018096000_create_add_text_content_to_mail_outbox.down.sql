@@ -0,0 +1,2 @@
+ALTER TABLE "mail_outbox" DROP COLUMN "text_content";
+ALTER TABLE "mail_outbox" ADD COLUMN "template" VARCHAR (255) NOT NULL DEFAULT '';
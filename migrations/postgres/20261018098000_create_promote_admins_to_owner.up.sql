@@ -0,0 +1 @@
+UPDATE users SET access_level = 4 WHERE access_level >= 3;
@@ -0,0 +1,12 @@
+CREATE TABLE "login_failures" (
+  "id" SERIAL PRIMARY KEY,
+  "email" VARCHAR (255) NOT NULL,
+  "ip_address" VARCHAR (255) NOT NULL,
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL
+);
+
+CREATE INDEX "login_failures_email_idx" ON "login_failures" ("email");
+CREATE INDEX "login_failures_ip_address_idx" ON "login_failures" ("ip_address");
+
+ALTER TABLE "users" ADD COLUMN "locked_until" timestamp;
@@ -0,0 +1,18 @@
+-- sqlite non toglie le foreign key, la tabella si rifà senza
+CREATE TABLE "reservations_new" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "first_name" VARCHAR (255) NOT NULL DEFAULT '',
+  "last_name" VARCHAR (255) NOT NULL DEFAULT '',
+  "email" VARCHAR (255) NOT NULL,
+  "phone" VARCHAR (255) NOT NULL DEFAULT '',
+  "start_date" date NOT NULL,
+  "end_date" date NOT NULL,
+  "room_id" integer NOT NULL,
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL
+);
+
+INSERT INTO "reservations_new" ("id", "first_name", "last_name", "email", "phone", "start_date", "end_date", "room_id", "created_at", "updated_at")
+  SELECT "id", "first_name", "last_name", "email", "phone", "start_date", "end_date", "room_id", "created_at", "updated_at" FROM "reservations";
+DROP TABLE "reservations";
+ALTER TABLE "reservations_new" RENAME TO "reservations";
@@ -0,0 +1,19 @@
+-- sqlite non aggiunge le foreign key alle tabelle che ci sono già, la tabella si rifà con la foreign key
+CREATE TABLE "reservations_new" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "first_name" VARCHAR (255) NOT NULL DEFAULT '',
+  "last_name" VARCHAR (255) NOT NULL DEFAULT '',
+  "email" VARCHAR (255) NOT NULL,
+  "phone" VARCHAR (255) NOT NULL DEFAULT '',
+  "start_date" date NOT NULL,
+  "end_date" date NOT NULL,
+  "room_id" integer NOT NULL,
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL,
+  CONSTRAINT "reservations_rooms_id_fk" FOREIGN KEY ("room_id") REFERENCES "rooms" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+INSERT INTO "reservations_new" ("id", "first_name", "last_name", "email", "phone", "start_date", "end_date", "room_id", "created_at", "updated_at")
+  SELECT "id", "first_name", "last_name", "email", "phone", "start_date", "end_date", "room_id", "created_at", "updated_at" FROM "reservations";
+DROP TABLE "reservations";
+ALTER TABLE "reservations_new" RENAME TO "reservations";
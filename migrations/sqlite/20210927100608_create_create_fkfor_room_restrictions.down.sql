@@ -0,0 +1,16 @@
+-- sqlite non toglie le foreign key, la tabella si rifà senza
+CREATE TABLE "room_restrictions_new" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "start_date" date NOT NULL,
+  "end_date" date NOT NULL,
+  "room_id" integer NOT NULL,
+  "reservation_id" integer NOT NULL,
+  "restriction_id" integer NOT NULL,
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL
+);
+
+INSERT INTO "room_restrictions_new" ("id", "start_date", "end_date", "room_id", "reservation_id", "restriction_id", "created_at", "updated_at")
+  SELECT "id", "start_date", "end_date", "room_id", "reservation_id", "restriction_id", "created_at", "updated_at" FROM "room_restrictions";
+DROP TABLE "room_restrictions";
+ALTER TABLE "room_restrictions_new" RENAME TO "room_restrictions";
@@ -0,0 +1,23 @@
+-- sqlite non aggiunge le foreign key alle tabelle che ci sono già, la tabella si rifà con la foreign key e i suoi indici
+CREATE TABLE "room_restrictions_new" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "start_date" date NOT NULL,
+  "end_date" date NOT NULL,
+  "room_id" integer NOT NULL,
+  "reservation_id" integer NOT NULL,
+  "restriction_id" integer NOT NULL,
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL,
+  CONSTRAINT "room_restrictions_rooms_id_fk" FOREIGN KEY ("room_id") REFERENCES "rooms" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+  CONSTRAINT "room_restrictions_restrictions_id_fk" FOREIGN KEY ("restriction_id") REFERENCES "restrictions" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+  CONSTRAINT "room_restrictions_reservations_id_fk" FOREIGN KEY ("reservation_id") REFERENCES "reservations" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+INSERT INTO "room_restrictions_new" ("id", "start_date", "end_date", "room_id", "reservation_id", "restriction_id", "created_at", "updated_at")
+  SELECT "id", "start_date", "end_date", "room_id", "reservation_id", "restriction_id", "created_at", "updated_at" FROM "room_restrictions";
+DROP TABLE "room_restrictions";
+ALTER TABLE "room_restrictions_new" RENAME TO "room_restrictions";
+
+CREATE INDEX "room_restrictions_start_date_end_date_idx" ON "room_restrictions" ("start_date", "end_date");
+CREATE INDEX "room_restrictions_room_id_idx" ON "room_restrictions" ("room_id");
+CREATE INDEX "room_restrictions_reservation_id_idx" ON "room_restrictions" ("reservation_id");
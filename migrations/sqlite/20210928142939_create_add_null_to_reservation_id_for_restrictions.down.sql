@@ -0,0 +1 @@
+-- le restrizioni senza prenotazione non possono tornare obbligatorie, come nella migration fizz non si fa niente
@@ -0,0 +1,3 @@
+INSERT INTO restrictions (restriction_name, created_at, updated_at) VALUES
+  ('Reservation', '2023-04-09 00:00:00', '2023-04-09 00:00:00'),
+  ('Owner Block', '2023-04-09 00:00:00', '2023-04-09 00:00:00');
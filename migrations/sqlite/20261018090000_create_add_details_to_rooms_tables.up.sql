@@ -0,0 +1,5 @@
+ALTER TABLE "rooms" ADD COLUMN "description" text NOT NULL DEFAULT '';
+ALTER TABLE "rooms" ADD COLUMN "capacity" integer NOT NULL DEFAULT 2;
+ALTER TABLE "rooms" ADD COLUMN "slug" VARCHAR (255) NOT NULL DEFAULT '';
+ALTER TABLE "rooms" ADD COLUMN "photos" text NOT NULL DEFAULT '';
+ALTER TABLE "rooms" ADD COLUMN "active" integer NOT NULL DEFAULT 1;
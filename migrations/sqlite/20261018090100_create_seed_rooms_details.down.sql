@@ -0,0 +1 @@
+UPDATE rooms SET slug = '', photos = '', description = '';
@@ -0,0 +1 @@
+DROP INDEX "rooms_slug_idx";
@@ -0,0 +1 @@
+CREATE UNIQUE INDEX "rooms_slug_idx" ON "rooms" ("slug");
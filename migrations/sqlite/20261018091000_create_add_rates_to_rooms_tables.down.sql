@@ -0,0 +1,3 @@
+ALTER TABLE "rooms" DROP COLUMN "min_stay";
+ALTER TABLE "rooms" DROP COLUMN "weekend_rate";
+ALTER TABLE "rooms" DROP COLUMN "base_rate";
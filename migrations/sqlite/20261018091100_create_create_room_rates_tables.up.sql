@@ -0,0 +1,15 @@
+CREATE TABLE "room_rates" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "room_id" integer NOT NULL,
+  "name" VARCHAR (255) NOT NULL DEFAULT '',
+  "start_date" date NOT NULL,
+  "end_date" date NOT NULL,
+  "nightly_rate" integer NOT NULL DEFAULT 0,
+  "weekend_rate" integer NOT NULL DEFAULT 0,
+  "min_stay" integer NOT NULL DEFAULT 0,
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL,
+  CONSTRAINT "room_rates_rooms_id_fk" FOREIGN KEY ("room_id") REFERENCES "rooms" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX "room_rates_room_id_start_date_end_date_idx" ON "room_rates" ("room_id", "start_date", "end_date");
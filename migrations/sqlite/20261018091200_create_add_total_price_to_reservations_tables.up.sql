@@ -0,0 +1 @@
+ALTER TABLE "reservations" ADD COLUMN "total_price" integer NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+UPDATE rooms SET base_rate = 0, weekend_rate = 0, min_stay = 1;
@@ -0,0 +1,14 @@
+CREATE TABLE "room_blocks" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "room_id" integer NOT NULL,
+  "start_date" date NOT NULL,
+  "end_date" date NOT NULL,
+  "recurrence" VARCHAR (255) NOT NULL DEFAULT '',
+  "repeat_until" date NOT NULL,
+  "reason" text NOT NULL DEFAULT '',
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL,
+  CONSTRAINT "room_blocks_rooms_id_fk" FOREIGN KEY ("room_id") REFERENCES "rooms" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX "room_blocks_room_id_idx" ON "room_blocks" ("room_id");
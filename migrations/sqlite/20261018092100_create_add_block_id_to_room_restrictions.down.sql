@@ -0,0 +1,3 @@
+-- sqlite toglie la foreign key con la colonna, ma non toglie una colonna che ha ancora il suo indice
+DROP INDEX "room_restrictions_block_id_idx";
+ALTER TABLE "room_restrictions" DROP COLUMN "block_id";
@@ -0,0 +1,4 @@
+-- sqlite aggiunge la foreign key solo insieme alla colonna
+ALTER TABLE "room_restrictions" ADD COLUMN "block_id" integer CONSTRAINT "room_restrictions_room_blocks_id_fk" REFERENCES "room_blocks" ("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+CREATE INDEX "room_restrictions_block_id_idx" ON "room_restrictions" ("block_id");
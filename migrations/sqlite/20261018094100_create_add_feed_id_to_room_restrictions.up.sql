@@ -0,0 +1,4 @@
+-- sqlite aggiunge la foreign key solo insieme alla colonna
+ALTER TABLE "room_restrictions" ADD COLUMN "feed_id" integer CONSTRAINT "room_restrictions_room_ical_feeds_id_fk" REFERENCES "room_ical_feeds" ("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+CREATE INDEX "room_restrictions_feed_id_idx" ON "room_restrictions" ("feed_id");
@@ -0,0 +1,17 @@
+CREATE TABLE "mail_outbox" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "to_address" VARCHAR (255) NOT NULL,
+  "from_address" VARCHAR (255) NOT NULL,
+  "subject" VARCHAR (255) NOT NULL DEFAULT '',
+  "content" text NOT NULL DEFAULT '',
+  "template" VARCHAR (255) NOT NULL DEFAULT '',
+  "status" VARCHAR (255) NOT NULL DEFAULT 'queued',
+  "attempts" integer NOT NULL DEFAULT 0,
+  "next_attempt_at" timestamp NOT NULL,
+  "last_error" text NOT NULL DEFAULT '',
+  "sent_at" timestamp,
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL
+);
+
+CREATE INDEX "mail_outbox_status_next_attempt_at_idx" ON "mail_outbox" ("status", "next_attempt_at");
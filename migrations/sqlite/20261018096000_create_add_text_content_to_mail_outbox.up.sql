@@ -0,0 +1,2 @@
+ALTER TABLE "mail_outbox" DROP COLUMN "template";
+ALTER TABLE "mail_outbox" ADD COLUMN "text_content" text NOT NULL DEFAULT '';
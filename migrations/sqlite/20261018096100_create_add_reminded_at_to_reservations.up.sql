@@ -0,0 +1 @@
+ALTER TABLE "reservations" ADD COLUMN "reminded_at" timestamp;
@@ -0,0 +1,13 @@
+CREATE TABLE "user_tokens" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "user_id" integer NOT NULL,
+  "token_hash" VARCHAR (255) NOT NULL,
+  "purpose" VARCHAR (255) NOT NULL,
+  "expires_at" timestamp NOT NULL,
+  "used_at" timestamp,
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL,
+  CONSTRAINT "user_tokens_users_id_fk" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE UNIQUE INDEX "user_tokens_token_hash_idx" ON "user_tokens" ("token_hash");
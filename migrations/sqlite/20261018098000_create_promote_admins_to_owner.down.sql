@@ -0,0 +1,2 @@
+-- non fa niente apposta: dopo la up non si sa più quali owner erano admin, e rimettere
+-- a 3 tutti gli owner toglierebbe i permessi anche a quelli creati dopo la migration
@@ -0,0 +1,14 @@
+ALTER TABLE "users" ADD COLUMN "totp_secret" VARCHAR (255) NOT NULL DEFAULT '';
+ALTER TABLE "users" ADD COLUMN "totp_counter" integer NOT NULL DEFAULT 0;
+
+CREATE TABLE "user_recovery_codes" (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "user_id" integer NOT NULL,
+  "code_hash" VARCHAR (255) NOT NULL,
+  "used_at" timestamp,
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL,
+  CONSTRAINT "user_recovery_codes_users_id_fk" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE UNIQUE INDEX "user_recovery_codes_user_id_code_hash_idx" ON "user_recovery_codes" ("user_id", "code_hash");
@@ -0,0 +1,7 @@
+CREATE TABLE "sessions" (
+  "token" VARCHAR (255) PRIMARY KEY,
+  "data" blob NOT NULL,
+  "expiry" timestamp NOT NULL
+);
+
+CREATE INDEX "sessions_expiry_idx" ON "sessions" ("expiry");
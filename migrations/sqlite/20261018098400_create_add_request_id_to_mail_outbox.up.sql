@@ -0,0 +1 @@
+ALTER TABLE "mail_outbox" ADD COLUMN "request_id" VARCHAR (255) NOT NULL DEFAULT '';